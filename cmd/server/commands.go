@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/server"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/badger"
+)
+
+// runCommand runs a maintenance subcommand (e.g. `tinyobs repair-stats`)
+// and returns the process exit code. Subcommands open the data directory
+// directly, so the server must be stopped first (BadgerDB holds a lock).
+func runCommand(args []string) int {
+	switch args[0] {
+	case "repair-stats":
+		return repairStats()
+	case "check":
+		return check(args[1:])
+	case "help", "-h", "--help":
+		printUsage()
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		printUsage()
+		return 2
+	}
+}
+
+// printUsage lists the available subcommands
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: tinyobs [command]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Without a command, starts the server.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  repair-stats   Recompute storage statistics from a full scan")
+	fmt.Fprintln(os.Stderr, "  check          Scan storage for corruption (--repair=quarantine|delete to fix)")
+}
+
+// repairStats rebuilds the incrementally maintained storage statistics
+func repairStats() int {
+	cfg := server.LoadConfig()
+
+	store, err := badger.New(badger.Config{Path: cfg.DataDir, MaxMemoryMB: cfg.MaxMemoryMB})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage (is the server still running?): %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	start := time.Now()
+	stats, err := store.RepairStats(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Stats repaired in %v: %d samples, %d series, %d metrics\n",
+		time.Since(start).Round(time.Millisecond), stats.TotalMetrics, stats.TotalSeries, len(stats.Metrics))
+	if stats.TotalMetrics > 0 {
+		fmt.Printf("Time range: %s to %s\n", stats.OldestMetric.Format(time.RFC3339), stats.NewestMetric.Format(time.RFC3339))
+	}
+	return 0
+}
+
+// check scans the store for corruption and optionally repairs it.
+// Exits 0 when healthy (or fully repaired), 1 when problems remain.
+func check(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	repair := flags.String("repair", "", "quarantine or delete bad entries")
+	examples := flags.Int("examples", 20, "problems to list")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg := server.LoadConfig()
+	store, err := badger.New(badger.Config{Path: cfg.DataDir, MaxMemoryMB: cfg.MaxMemoryMB})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage (is the server still running?): %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	report, err := store.Check(context.Background(), storage.CheckOptions{
+		Repair:      storage.RepairMode(*repair),
+		MaxExamples: *examples,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Scanned %d keys in %s\n", report.KeysScanned, report.Duration)
+	if report.Healthy() {
+		fmt.Println("No problems found")
+		return 0
+	}
+
+	categories := make([]string, 0, len(report.Problems))
+	for category := range report.Problems {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		fmt.Printf("  %-20s %d\n", category, report.Problems[category])
+	}
+	for _, p := range report.Examples {
+		fmt.Printf("  [%s] %s: %s\n", p.Category, p.Key, p.Detail)
+	}
+
+	if report.Repaired > 0 {
+		fmt.Printf("Repaired %d entries (%s)\n", report.Repaired, report.Repair)
+	}
+	// Collisions can't be repaired by quarantine or delete
+	if report.Repair != storage.RepairNone && report.Problems[storage.ProblemHashCollision] == 0 {
+		return 0
+	}
+	return 1
+}
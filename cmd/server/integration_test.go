@@ -13,6 +13,7 @@ import (
 	"github.com/nicktill/tinyobs/pkg/compaction"
 	"github.com/nicktill/tinyobs/pkg/ingest"
 	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
 	"github.com/nicktill/tinyobs/pkg/storage/badger"
 	"github.com/nicktill/tinyobs/pkg/storage/memory"
 
@@ -164,7 +165,7 @@ func TestE2E_CompactionWithBadger(t *testing.T) {
 
 	// Run compaction
 	compactor := compaction.New(store)
-	err = compactor.Compact5m(ctx, now.Add(-1*time.Hour), now.Add(1*time.Hour))
+	err = compactor.CompactTier(ctx, storage.Resolution5m, now.Add(-1*time.Hour), now.Add(1*time.Hour))
 	if err != nil {
 		t.Fatalf("Compaction failed: %v", err)
 	}
@@ -285,7 +286,7 @@ func TestE2E_FullPipeline(t *testing.T) {
 	compactor := compaction.New(store)
 
 	// Compact 5min resolution
-	err = compactor.Compact5m(ctx, now.Add(-1*time.Hour), now.Add(2*time.Hour))
+	err = compactor.CompactTier(ctx, storage.Resolution5m, now.Add(-1*time.Hour), now.Add(2*time.Hour))
 	if err != nil {
 		t.Fatalf("5m compaction failed: %v", err)
 	}
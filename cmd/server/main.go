@@ -23,6 +23,11 @@ const (
 )
 
 func main() {
+	// Maintenance subcommands (e.g. `tinyobs repair-stats`)
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	log.Println("Starting TinyObs Server...")
 
 	// Load configuration
@@ -49,15 +54,46 @@ func main() {
 	storageMonitor := monitor.NewStorageMonitor(cfg.DataDir, maxStorageBytes)
 	log.Printf("Storage limit enforcement enabled: %.2f GB max", float64(maxStorageBytes)/(1024*1024*1024))
 
+	// Replication (leader by default, read-only follower with TINYOBS_LEADER_URL)
+	replicator := server.InitializeReplication(cfg, store)
+
 	// Initialize handlers
-	ingestHandler, queryHandler, exportHandler, hub := server.InitializeHandlers(store, storageMonitor)
+	ingestHandler, queryHandler, exportHandler, adminHandler, hub := server.InitializeHandlers(cfg, store, replicator, storageMonitor)
 
-	// Initialize compactor
-	compactor, compactionMonitor := server.InitializeCompactor(store)
+	// Metric metadata (type, unit, help) from ingest and SDK descriptions
+	registry, err := server.InitializeMetadata(cfg, ingestHandler, replicator)
+	if err != nil {
+		log.Fatalf("Failed to initialize metric metadata: %v", err)
+	}
+
+	// Initialize compactor (and retention policy)
+	compactor, compactionMonitor, err := server.InitializeCompactor(store, cfg, registry)
+	if err != nil {
+		log.Fatalf("Failed to initialize compactor: %v", err)
+	}
+	adminHandler.SetRetention(compactor.Retention())
+	adminHandler.SetCompaction(compactor, compactionMonitor)
+
+	// Online snapshots (POST /api/v1/admin/snapshot)
+	if err := server.InitializeSnapshots(cfg, store, adminHandler, registry); err != nil {
+		log.Fatalf("Failed to initialize snapshots: %v", err)
+	}
+
+	// Recording rules (TINYOBS_RULES_FILE, listed at /api/v1/rules)
+	ruleManager, err := server.InitializeRules(cfg, replicator)
+	if err != nil {
+		log.Fatalf("Failed to load recording rules: %v", err)
+	}
+
+	// Self-metrics (tinyobs_* series written into storage)
+	selfMetrics := server.InitializeSelfMetrics(store, ingestHandler, replicator)
+
+	// Size-based eviction (optional, replaces 507 at the storage limit)
+	evictor := server.InitializeEviction(cfg, store, storageMonitor, ingestHandler, compactor)
 
 	// Create router
 	router := mux.NewRouter()
-	server.SetupRoutes(router, ingestHandler, queryHandler, exportHandler, storageMonitor, compactionMonitor, hub, cfg.Port)
+	server.SetupRoutes(router, ingestHandler, queryHandler, exportHandler, adminHandler, storageMonitor, compactionMonitor, replicator, ruleManager, hub, cfg.Port, cfg.APIKeys)
 
 	// Create HTTP server
 	httpServer := &http.Server{
@@ -89,6 +125,20 @@ func main() {
 	}()
 	log.Println("Metrics broadcaster started (updates every 5s)")
 
+	// Replication (follow loop, returns at once on a leader)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		replicator.Run(ctx)
+	}()
+
+	// Recording rules (one loop per group)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ruleManager.Run(ctx)
+	}()
+
 	// Compaction
 	stopCompaction := make(chan bool)
 	wg.Add(1)
@@ -99,6 +149,28 @@ func main() {
 	wg.Add(1)
 	go server.RunBadgerGC(store, stopGC, &wg)
 
+	// Tombstone cleanup (purges series deleted via the admin API)
+	stopTombstones := make(chan bool)
+	wg.Add(1)
+	go server.RunTombstoneCleanup(store, stopTombstones, &wg)
+
+	// Cold tier sealing (only when TINYOBS_COLD_STORAGE_DIR is set)
+	stopSealing := make(chan bool)
+	wg.Add(1)
+	go server.RunBlockSealing(store, stopSealing, &wg)
+
+	// Self-metrics
+	stopSelfMetrics := make(chan bool)
+	wg.Add(1)
+	go server.RunSelfMetrics(selfMetrics, stopSelfMetrics, &wg)
+
+	// Eviction
+	stopEviction := make(chan bool)
+	if evictor != nil {
+		wg.Add(1)
+		go server.RunEviction(evictor, stopEviction, &wg)
+	}
+
 	// Start server in goroutine
 	go func() {
 		log.Printf("Server starting on http://localhost:%s", cfg.Port)
@@ -112,6 +184,11 @@ func main() {
 		log.Println("   GET  /v1/export         - Export metrics (JSON/CSV)")
 		log.Println("   POST /v1/import         - Import metrics from backup")
 		log.Println("   GET  /v1/health         - Health check")
+		log.Println("   POST /api/v1/admin/tsdb/delete_series - Delete series by selector")
+		log.Println("   POST /api/v1/admin/snapshot           - Snapshot storage (full or incremental)")
+		log.Println("   GET  /v1/admin/retention/dry-run      - Preview retention deletions")
+		log.Println("   GET  /v1/replication/status           - Replication role and lag")
+		log.Println("   GET  /api/v1/rules                    - Recording rule health")
 		log.Println("Server ready to accept requests")
 
 		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -131,6 +208,10 @@ func main() {
 	cancel() // Stop WebSocket hub and broadcaster
 	close(stopCompaction)
 	close(stopGC)
+	close(stopTombstones)
+	close(stopEviction)
+	close(stopSealing)
+	close(stopSelfMetrics)
 
 	// Graceful shutdown
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
@@ -0,0 +1,476 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/compaction"
+	"github.com/nicktill/tinyobs/pkg/config"
+	"github.com/nicktill/tinyobs/pkg/httpx"
+	"github.com/nicktill/tinyobs/pkg/query"
+	"github.com/nicktill/tinyobs/pkg/retention"
+	"github.com/nicktill/tinyobs/pkg/server/monitor"
+	"github.com/nicktill/tinyobs/pkg/snapshot"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// Handler handles administrative storage endpoints (/api/v1/admin/*).
+// These operations are destructive, so they are kept out of the regular query API.
+type Handler struct {
+	storage    storage.Storage
+	deleter    storage.SeriesDeleter // Optional: replaces the store's for delete_series
+	retention  *retention.Policy
+	snapshots  *snapshot.Manager
+	compactor  *compaction.Compactor
+	compaction *monitor.CompactionMonitor
+}
+
+// NewHandler creates a new admin handler for the given storage backend.
+func NewHandler(store storage.Storage) *Handler {
+	return &Handler{storage: store}
+}
+
+// SetSeriesDeleter routes delete_series through deleter instead of the
+// store, e.g. the replicated view, so followers delete the same series.
+func (h *Handler) SetSeriesDeleter(deleter storage.SeriesDeleter) {
+	h.deleter = deleter
+}
+
+// SetRetention configures the retention policy reported by HandleRetentionDryRun.
+func (h *Handler) SetRetention(policy *retention.Policy) {
+	h.retention = policy
+}
+
+// SetSnapshots configures where HandleSnapshot writes snapshot files.
+func (h *Handler) SetSnapshots(manager *snapshot.Manager) {
+	h.snapshots = manager
+}
+
+// SetCompaction configures the compactor and the monitor its scheduler
+// takes run requests and pauses from (/v1/admin/compaction/*).
+func (h *Handler) SetCompaction(compactor *compaction.Compactor, compactionMonitor *monitor.CompactionMonitor) {
+	h.compactor = compactor
+	h.compaction = compactionMonitor
+}
+
+// DeleteSeriesResponse represents the response payload for delete_series.
+type DeleteSeriesResponse struct {
+	Status     string              `json:"status"`
+	Tombstones []storage.Tombstone `json:"tombstones"`
+}
+
+// CleanTombstonesResponse represents the response payload for clean_tombstones.
+type CleanTombstonesResponse struct {
+	Status  string `json:"status"`
+	Cleaned int    `json:"cleaned"`
+}
+
+// HandleDeleteSeries handles POST /api/v1/admin/tsdb/delete_series.
+// Query or form params (Prometheus-compatible):
+//   - match[]: series selector, repeatable (required)
+//   - start: Unix timestamp or RFC3339 (default: beginning of time)
+//   - end: Unix timestamp or RFC3339 (default: now)
+//
+// Matching data is hidden immediately via tombstones and purged in the background.
+// The tombstone ends at the request time by default, so samples ingested
+// afterwards are not hidden. Like Prometheus, each selector needs a matcher
+// that doesn't match the empty string: {job=~".*"} would delete everything.
+func (h *Handler) HandleDeleteSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpx.RespondErrorString(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	deleter, ok := h.storage.(storage.SeriesDeleter)
+	if h.deleter != nil {
+		deleter, ok = h.deleter, true
+	}
+	if !ok {
+		httpx.RespondErrorString(w, http.StatusNotImplemented, "storage backend does not support series deletion")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		httpx.RespondError(w, http.StatusBadRequest, fmt.Errorf("invalid form: %w", err))
+		return
+	}
+
+	selectors := r.Form["match[]"]
+	if len(selectors) == 0 {
+		httpx.RespondErrorString(w, http.StatusBadRequest, "at least one match[] selector is required")
+		return
+	}
+
+	start, err := parseTimeParam(r.Form.Get("start"), time.Unix(0, 0))
+	if err != nil {
+		httpx.RespondError(w, http.StatusBadRequest, fmt.Errorf("invalid start: %w", err))
+		return
+	}
+	end, err := parseTimeParam(r.Form.Get("end"), time.Now())
+	if err != nil {
+		httpx.RespondError(w, http.StatusBadRequest, fmt.Errorf("invalid end: %w", err))
+		return
+	}
+	if !start.Before(end) {
+		httpx.RespondErrorString(w, http.StatusBadRequest, "start must be before end")
+		return
+	}
+
+	// Parse every selector before deleting anything (all-or-nothing validation)
+	matcherSets := make([][]storage.Matcher, 0, len(selectors))
+	for _, sel := range selectors {
+		matchers, err := query.ParseSelector(sel)
+		if err != nil {
+			httpx.RespondError(w, http.StatusBadRequest, fmt.Errorf("invalid match[] %q: %w", sel, err))
+			return
+		}
+		if matchesEverything(matchers) {
+			httpx.RespondError(w, http.StatusBadRequest, fmt.Errorf("invalid match[] %q: needs at least one matcher that doesn't match the empty string", sel))
+			return
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.AdminTimeout)
+	defer cancel()
+
+	// Each selector gets its own tombstone (selectors are OR'ed, like Prometheus)
+	response := DeleteSeriesResponse{Status: "success"}
+	for i, matchers := range matcherSets {
+		t, err := deleter.DeleteSeries(ctx, matchers, start, end)
+		if err != nil {
+			httpx.RespondError(w, http.StatusInternalServerError, fmt.Errorf("failed to delete series %q: %w", selectors[i], err))
+			return
+		}
+		log.Printf("Tombstoned series %s (tombstone %s)", selectors[i], t.ID)
+		response.Tombstones = append(response.Tombstones, *t)
+	}
+
+	httpx.RespondJSON(w, http.StatusAccepted, response)
+}
+
+// HandleCleanTombstones handles POST /api/v1/admin/tsdb/clean_tombstones.
+// Purges tombstoned data right away instead of waiting for the background job.
+func (h *Handler) HandleCleanTombstones(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpx.RespondErrorString(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	deleter, ok := h.storage.(storage.SeriesDeleter)
+	if !ok {
+		httpx.RespondErrorString(w, http.StatusNotImplemented, "storage backend does not support series deletion")
+		return
+	}
+
+	cleaned, err := deleter.CleanTombstones(r.Context())
+	if err != nil {
+		httpx.RespondError(w, http.StatusInternalServerError, fmt.Errorf("failed to clean tombstones: %w", err))
+		return
+	}
+
+	httpx.RespondJSON(w, http.StatusOK, CleanTombstonesResponse{Status: "success", Cleaned: cleaned})
+}
+
+// HandleRetentionDryRun handles GET /v1/admin/retention/dry-run.
+// Reports how many samples and series each retention rule would delete right now,
+// with cutoffs held back to the compaction watermarks like a real run.
+func (h *Handler) HandleRetentionDryRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpx.RespondErrorString(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if h.retention == nil {
+		httpx.RespondErrorString(w, http.StatusNotFound, "no retention policy configured")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.AdminTimeout)
+	defer cancel()
+
+	// Hold cutoffs back to the compaction watermarks, as scheduled runs do
+	var limits map[storage.Resolution]time.Time
+	if h.compactor != nil {
+		limits = h.compactor.RolledUp()
+	}
+
+	report, err := h.retention.DryRunUntil(ctx, h.storage, time.Now(), limits)
+	if err != nil {
+		httpx.RespondError(w, http.StatusInternalServerError, fmt.Errorf("retention dry run failed: %w", err))
+		return
+	}
+
+	httpx.RespondJSON(w, http.StatusOK, report)
+}
+
+// HandleCheck handles GET and POST /v1/admin/check.
+// Scans storage and reports corruption by category. Query params:
+//   - repair: "quarantine" or "delete" bad entries (POST only)
+//   - examples: how many problems to list (default: 100)
+func (h *Handler) HandleCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		httpx.RespondErrorString(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	checker, ok := h.storage.(storage.Checker)
+	if !ok {
+		httpx.RespondErrorString(w, http.StatusNotImplemented, "storage backend does not support consistency checks")
+		return
+	}
+
+	params := r.URL.Query()
+	opts := storage.CheckOptions{Repair: storage.RepairMode(params.Get("repair"))}
+	switch opts.Repair {
+	case storage.RepairNone, storage.RepairQuarantine, storage.RepairDelete:
+	default:
+		httpx.RespondErrorString(w, http.StatusBadRequest, fmt.Sprintf("invalid repair: %q (want quarantine or delete)", opts.Repair))
+		return
+	}
+	if opts.Repair != storage.RepairNone && r.Method != http.MethodPost {
+		httpx.RespondErrorString(w, http.StatusMethodNotAllowed, "repair requires POST")
+		return
+	}
+	if s := params.Get("examples"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			httpx.RespondErrorString(w, http.StatusBadRequest, fmt.Sprintf("invalid examples: %q", s))
+			return
+		}
+		opts.MaxExamples = n
+	}
+
+	// Full scans of large stores outlive the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Check: could not lift write deadline: %v", err)
+	}
+
+	report, err := checker.Check(r.Context(), opts)
+	if err != nil {
+		httpx.RespondError(w, http.StatusInternalServerError, fmt.Errorf("check failed: %w", err))
+		return
+	}
+	if !report.Healthy() {
+		log.Printf("Storage check found problems: %v (repaired %d)", report.Problems, report.Repaired)
+	}
+
+	httpx.RespondJSON(w, http.StatusOK, report)
+}
+
+// SnapshotResponse represents the response payload for snapshot.
+type SnapshotResponse struct {
+	Status string         `json:"status"`
+	Data   *snapshot.Info `json:"data"`
+}
+
+// Snapshot stream headers. The version is only known once the stream is
+// complete, so it is sent as a trailer.
+const (
+	snapshotSinceHeader   = "X-Tinyobs-Snapshot-Since"
+	snapshotVersionHeader = "X-Tinyobs-Snapshot-Version"
+)
+
+// HandleSnapshot handles POST /api/v1/admin/snapshot.
+// Takes a consistent backup while ingestion continues. Query params:
+//   - incremental: only include changes since the latest snapshot (default: false)
+//   - stream: return the backup as the response body instead of writing a file
+//   - since: first version to include when streaming (version+1 of the previous backup)
+//
+// Restore snapshot files on startup with TINYOBS_RESTORE_FROM.
+func (h *Handler) HandleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpx.RespondErrorString(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	snapshotter, ok := h.storage.(storage.Snapshotter)
+	if !ok {
+		httpx.RespondErrorString(w, http.StatusNotImplemented, "storage backend does not support snapshots")
+		return
+	}
+
+	params := r.URL.Query()
+	incremental, err := parseBoolParam(params.Get("incremental"))
+	if err != nil {
+		httpx.RespondError(w, http.StatusBadRequest, fmt.Errorf("invalid incremental: %w", err))
+		return
+	}
+	stream, err := parseBoolParam(params.Get("stream"))
+	if err != nil {
+		httpx.RespondError(w, http.StatusBadRequest, fmt.Errorf("invalid stream: %w", err))
+		return
+	}
+
+	// Snapshots of large stores outlive the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Snapshot: could not lift write deadline: %v", err)
+	}
+
+	if stream {
+		var since uint64
+		if s := params.Get("since"); s != "" {
+			since, err = strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				httpx.RespondError(w, http.StatusBadRequest, fmt.Errorf("invalid since: %w", err))
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(snapshotSinceHeader, strconv.FormatUint(since, 10))
+		w.Header().Set("Trailer", snapshotVersionHeader)
+		w.WriteHeader(http.StatusOK)
+
+		version, err := snapshotter.Snapshot(r.Context(), w, since)
+		if err != nil {
+			// Headers are gone; a missing version trailer tells the client the stream is incomplete
+			log.Printf("Snapshot stream failed: %v", err)
+			return
+		}
+		w.Header().Set(snapshotVersionHeader, strconv.FormatUint(version, 10))
+		return
+	}
+
+	if h.snapshots == nil {
+		httpx.RespondErrorString(w, http.StatusNotFound, "no snapshot directory configured")
+		return
+	}
+
+	info, err := h.snapshots.Create(r.Context(), incremental)
+	if err != nil {
+		httpx.RespondError(w, http.StatusInternalServerError, fmt.Errorf("snapshot failed: %w", err))
+		return
+	}
+	log.Printf("Snapshot %s written (%d bytes, version %d)", info.Name, info.SizeBytes, info.Version)
+
+	httpx.RespondJSON(w, http.StatusOK, SnapshotResponse{Status: "success", Data: info})
+}
+
+// CompactionResponse represents the response payload for the compaction endpoints.
+type CompactionResponse struct {
+	Status string `json:"status"`
+}
+
+// HandleCompactionRun handles POST /v1/admin/compaction/run.
+// Queues a compaction run for the scheduler; results show up in /v1/health.
+// Query or form params:
+//   - tier: only compact this tier, e.g. 5m (default: every tier)
+//   - start: re-compact buckets from this time, Unix timestamp or RFC3339
+//     (default: resume at the watermarks and enforce retention); 400 if
+//     source data from then on has been deleted
+//   - end: end of the re-compacted range (default: now)
+func (h *Handler) HandleCompactionRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpx.RespondErrorString(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.compactor == nil || h.compaction == nil {
+		httpx.RespondErrorString(w, http.StatusNotFound, "compaction is not configured")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		httpx.RespondError(w, http.StatusBadRequest, fmt.Errorf("invalid form: %w", err))
+		return
+	}
+	opts := compaction.RunOptions{Tier: storage.Resolution(r.Form.Get("tier"))}
+	var err error
+	if opts.Start, err = parseTimeParam(r.Form.Get("start"), time.Time{}); err != nil {
+		httpx.RespondError(w, http.StatusBadRequest, fmt.Errorf("invalid start: %w", err))
+		return
+	}
+	if opts.End, err = parseTimeParam(r.Form.Get("end"), time.Time{}); err != nil {
+		httpx.RespondError(w, http.StatusBadRequest, fmt.Errorf("invalid end: %w", err))
+		return
+	}
+	if err := h.compactor.ValidateRun(r.Context(), opts); err != nil {
+		httpx.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !h.compaction.RequestRun(opts) {
+		httpx.RespondErrorString(w, http.StatusConflict, "a requested compaction run is already pending")
+		return
+	}
+	log.Printf("Compaction run requested (tier=%q start=%v end=%v)", opts.Tier, opts.Start, opts.End)
+
+	httpx.RespondJSON(w, http.StatusAccepted, CompactionResponse{Status: "queued"})
+}
+
+// HandleCompactionPause handles POST /v1/admin/compaction/pause.
+// Scheduled compaction (and retention) stops until resumed; a run in
+// progress finishes.
+func (h *Handler) HandleCompactionPause(w http.ResponseWriter, r *http.Request) {
+	h.setCompactionPaused(w, r, true)
+}
+
+// HandleCompactionResume handles POST /v1/admin/compaction/resume.
+func (h *Handler) HandleCompactionResume(w http.ResponseWriter, r *http.Request) {
+	h.setCompactionPaused(w, r, false)
+}
+
+func (h *Handler) setCompactionPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if r.Method != http.MethodPost {
+		httpx.RespondErrorString(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.compaction == nil {
+		httpx.RespondErrorString(w, http.StatusNotFound, "compaction is not configured")
+		return
+	}
+
+	status := "resumed"
+	if paused {
+		h.compaction.Pause()
+		status = "paused"
+	} else {
+		h.compaction.Resume()
+	}
+	log.Printf("Compaction %s", status)
+
+	httpx.RespondJSON(w, http.StatusOK, CompactionResponse{Status: status})
+}
+
+// parseBoolParam parses an optional boolean query parameter (default: false)
+func parseBoolParam(param string) (bool, error) {
+	if param == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(param)
+}
+
+// matchesEverything reports whether every matcher matches the empty
+// string, so the selector would match every series
+func matchesEverything(matchers []storage.Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches("") {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTimeParam parses a Unix timestamp (seconds, may be fractional) or RFC3339 time.
+// Unlike the query API, admin endpoints reject bad input instead of falling back to a default.
+func parseTimeParam(param string, defaultTime time.Time) (time.Time, error) {
+	if param == "" {
+		return defaultTime, nil
+	}
+
+	if unix, err := strconv.ParseFloat(param, 64); err == nil {
+		sec := int64(unix)
+		nsec := int64((unix - float64(sec)) * 1e9)
+		return time.Unix(sec, nsec), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a Unix timestamp nor RFC3339", param)
+	}
+	return t, nil
+}
@@ -0,0 +1,207 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/compaction"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/server/monitor"
+	"github.com/nicktill/tinyobs/pkg/snapshot"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/badger"
+	"github.com/nicktill/tinyobs/pkg/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleDeleteSeries(t *testing.T) {
+	store := memory.New()
+	handler := NewHandler(store)
+
+	ctx := context.Background()
+	now := time.Now()
+	require.NoError(t, store.Write(ctx, []metrics.Metric{
+		{Name: "requests", Value: 1, Labels: map[string]string{"job": "api"}, Timestamp: now},
+		{Name: "requests", Value: 2, Labels: map[string]string{"job": "batch"}, Timestamp: now},
+	}))
+
+	form := url.Values{"match[]": {`requests{job="batch"}`}}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/tsdb/delete_series?"+form.Encode(), nil)
+	rr := httptest.NewRecorder()
+
+	handler.HandleDeleteSeries(rr, req)
+
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+	var resp DeleteSeriesResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Len(t, resp.Tombstones, 1)
+
+	results, err := store.Query(ctx, storage.QueryRequest{Start: now.Add(-time.Hour), End: now.Add(time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, "api", results[0].Labels["job"])
+
+	// Without an end, the tombstone stops at the request: later samples of
+	// the deleted series are kept
+	later := metrics.Metric{Name: "requests", Value: 3, Labels: map[string]string{"job": "batch"}, Timestamp: time.Now().Add(time.Minute)}
+	require.NoError(t, store.Write(ctx, []metrics.Metric{later}))
+	results, err = store.Query(ctx, storage.QueryRequest{Start: now.Add(-time.Hour), End: now.Add(time.Hour), Labels: map[string]string{"job": "batch"}})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, 3.0, results[0].Value)
+}
+
+func TestHandleDeleteSeries_Validation(t *testing.T) {
+	handler := NewHandler(memory.New())
+
+	tests := []struct {
+		name    string
+		query   string
+		message string
+	}{
+		{"missing selector", "", "match[] selector is required"},
+		{"bad selector", "match[]=" + url.QueryEscape("sum(x)"), "invalid match[]"},
+		{"bad time", "match[]=x&start=yesterday", "invalid start"},
+		{"inverted range", "match[]=x&start=2000&end=1000", "start must be before end"},
+		{"matches everything", "match[]=" + url.QueryEscape(`{job=~".*"}`), "doesn't match the empty string"},
+		{"one of several matches everything", "match[]=x&match[]=" + url.QueryEscape(`{job!="api"}`), "doesn't match the empty string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/tsdb/delete_series?"+tt.query, nil)
+			rr := httptest.NewRecorder()
+
+			handler.HandleDeleteSeries(rr, req)
+
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			var resp map[string]string
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Contains(t, resp["message"], tt.message)
+		})
+	}
+}
+
+func TestHandleSnapshot(t *testing.T) {
+	store, err := badger.New(badger.Config{InMemory: true})
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx := context.Background()
+	require.NoError(t, store.Write(ctx, []metrics.Metric{{Name: "cpu", Value: 1, Timestamp: time.Now()}}))
+
+	handler := NewHandler(store)
+	manager, err := snapshot.NewManager(t.TempDir(), store)
+	require.NoError(t, err)
+	handler.SetSnapshots(manager)
+
+	// File snapshot
+	rr := httptest.NewRecorder()
+	handler.HandleSnapshot(rr, httptest.NewRequest(http.MethodPost, "/api/v1/admin/snapshot", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var resp SnapshotResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.False(t, resp.Data.Incremental)
+	require.NotZero(t, resp.Data.SizeBytes)
+
+	// Streamed incremental snapshot, restorable on top of the file snapshot
+	require.NoError(t, store.Write(ctx, []metrics.Metric{{Name: "mem", Value: 2, Timestamp: time.Now()}}))
+	since := strconv.FormatUint(resp.Data.Version+1, 10)
+	rr = httptest.NewRecorder()
+	handler.HandleSnapshot(rr, httptest.NewRequest(http.MethodPost, "/api/v1/admin/snapshot?stream=true&since="+since, nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, since, rr.Header().Get(snapshotSinceHeader))
+	require.NotEmpty(t, rr.Header().Get(snapshotVersionHeader))
+
+	restored, err := badger.New(badger.Config{InMemory: true})
+	require.NoError(t, err)
+	defer restored.Close()
+	_, err = snapshot.Restore(ctx, manager.Dir(), resp.Data.Name, restored)
+	require.NoError(t, err)
+	require.NoError(t, restored.Restore(ctx, rr.Body))
+
+	stats, err := restored.Stats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), stats.TotalMetrics)
+}
+
+func TestHandleSnapshot_Unsupported(t *testing.T) {
+	handler := NewHandler(memory.New())
+	rr := httptest.NewRecorder()
+	handler.HandleSnapshot(rr, httptest.NewRequest(http.MethodPost, "/api/v1/admin/snapshot", nil))
+	require.Equal(t, http.StatusNotImplemented, rr.Code)
+}
+
+func TestHandleCheck(t *testing.T) {
+	store, err := badger.New(badger.Config{InMemory: true})
+	require.NoError(t, err)
+	defer store.Close()
+	require.NoError(t, store.Write(context.Background(), []metrics.Metric{{Name: "cpu", Value: 1, Timestamp: time.Now()}}))
+
+	handler := NewHandler(store)
+
+	rr := httptest.NewRecorder()
+	handler.HandleCheck(rr, httptest.NewRequest(http.MethodGet, "/v1/admin/check", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var report storage.CheckReport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	require.True(t, report.Healthy())
+	require.Equal(t, 1, report.KeysScanned)
+
+	// Repairs must be explicit POSTs
+	rr = httptest.NewRecorder()
+	handler.HandleCheck(rr, httptest.NewRequest(http.MethodGet, "/v1/admin/check?repair=delete", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.HandleCheck(rr, httptest.NewRequest(http.MethodPost, "/v1/admin/check?repair=bogus", nil))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestHandleCompaction(t *testing.T) {
+	handler := NewHandler(memory.New())
+
+	rr := httptest.NewRecorder()
+	handler.HandleCompactionRun(rr, httptest.NewRequest(http.MethodPost, "/v1/admin/compaction/run", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	compactionMonitor := &monitor.CompactionMonitor{}
+	handler.SetCompaction(compaction.New(memory.New()), compactionMonitor)
+
+	// Bad options are rejected before anything is queued
+	for _, query := range []string{"tier=raw", "tier=1d", "start=yesterday", "end=1000", "start=2000&end=1000"} {
+		rr = httptest.NewRecorder()
+		handler.HandleCompactionRun(rr, httptest.NewRequest(http.MethodPost, "/v1/admin/compaction/run?"+query, nil))
+		require.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleCompactionRun(rr, httptest.NewRequest(http.MethodPost, "/v1/admin/compaction/run?tier=5m&start=1000&end=2000", nil))
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+
+	// Only one request waits for the scheduler
+	rr = httptest.NewRecorder()
+	handler.HandleCompactionRun(rr, httptest.NewRequest(http.MethodPost, "/v1/admin/compaction/run", nil))
+	require.Equal(t, http.StatusConflict, rr.Code)
+
+	opts := <-compactionMonitor.Requested()
+	require.Equal(t, storage.Resolution5m, opts.Tier)
+	require.True(t, opts.Start.Equal(time.Unix(1000, 0)))
+	require.True(t, opts.End.Equal(time.Unix(2000, 0)))
+
+	rr = httptest.NewRecorder()
+	handler.HandleCompactionPause(rr, httptest.NewRequest(http.MethodPost, "/v1/admin/compaction/pause", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.True(t, compactionMonitor.Paused())
+
+	rr = httptest.NewRecorder()
+	handler.HandleCompactionResume(rr, httptest.NewRequest(http.MethodPost, "/v1/admin/compaction/resume", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.False(t, compactionMonitor.Paused())
+}
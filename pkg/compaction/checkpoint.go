@@ -0,0 +1,103 @@
+package compaction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// Checkpoint records how far compaction into each tier has got. A tier's
+// watermark is the end of the last bucket written: every bucket before it
+// is compacted, so runs resume there instead of re-aggregating a fixed
+// window. It is saved as a small JSON file after each step.
+type Checkpoint struct {
+	path string // "" = not persisted
+
+	mu         sync.RWMutex
+	watermarks map[storage.Resolution]time.Time
+
+	saveMu sync.Mutex // Serializes file writes
+}
+
+// OpenCheckpoint loads the watermarks saved at path, or starts with none
+// if there is no file. With an empty path they are kept in memory only.
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	cp := &Checkpoint{path: path, watermarks: make(map[storage.Resolution]time.Time)}
+	if path == "" {
+		return cp, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compaction checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &cp.watermarks); err != nil {
+		return nil, fmt.Errorf("failed to parse compaction checkpoint %s: %w", path, err)
+	}
+	return cp, nil
+}
+
+// Watermark returns the time before which a tier is fully compacted
+// (zero if compaction into it has never run)
+func (cp *Checkpoint) Watermark(res storage.Resolution) time.Time {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+	return cp.watermarks[res]
+}
+
+// advance moves a tier's watermark forward and saves it. Watermarks never
+// move back: buckets before them may already have had their sources deleted.
+func (cp *Checkpoint) advance(res storage.Resolution, t time.Time) error {
+	cp.mu.Lock()
+	if !t.After(cp.watermarks[res]) {
+		cp.mu.Unlock()
+		return nil
+	}
+	cp.watermarks[res] = t
+	cp.mu.Unlock()
+
+	return cp.save()
+}
+
+// save writes the watermarks to their file (temp file + rename)
+func (cp *Checkpoint) save() error {
+	if cp.path == "" {
+		return nil
+	}
+	cp.saveMu.Lock()
+	defer cp.saveMu.Unlock()
+
+	cp.mu.RLock()
+	data, err := json.MarshalIndent(cp.watermarks, "", "  ")
+	cp.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode compaction checkpoint: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cp.path), ".compaction-*")
+	if err != nil {
+		return fmt.Errorf("failed to save compaction checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cp.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save compaction checkpoint: %w", err)
+	}
+	return nil
+}
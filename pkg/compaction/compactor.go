@@ -3,262 +3,486 @@ package compaction
 import (
 	"context"
 	"fmt"
-	"math"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/nicktill/tinyobs/pkg/metadata"
+	"github.com/nicktill/tinyobs/pkg/retention"
 	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
 	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/tenant"
 )
 
-const (
-	// Compaction timing windows
-	compact5mDelay    = 6 * time.Hour      // Wait before compacting raw data
-	compact5mLookback = 12 * time.Hour     // How far back to compact
-	compact1hDelay    = 2 * 24 * time.Hour // Wait before compacting 5m aggregates
-	compact1hLookback = 7 * 24 * time.Hour // How far back to compact 5m->1h
-	rawDataRetention  = 6 * time.Hour      // Keep raw data for 6 hours
-	fiveMinRetention  = 7 * 24 * time.Hour // Keep 5m aggregates for 7 days
-)
+// compactStepBuckets is how many buckets of a tier are compacted (and
+// checkpointed) at a time: 6 hours of 5m buckets, 3 days of 1h buckets
+const compactStepBuckets = 72
+
+// compactWriteBatch is how many aggregates are written at a time: small
+// enough for one BadgerDB transaction even with quantile sketches
+const compactWriteBatch = 1000
 
 // Compactor handles downsampling of metrics
 type Compactor struct {
-	storage storage.Storage
+	storage    storage.Storage
+	tiers      []Tier
+	retention  *retention.Policy
+	metadata   *metadata.Registry // Optional: metric types for series ingested without one
+	checkpoint *Checkpoint
+	workers    int // Parallel shards per compaction step (1 = serial)
+
+	runMu sync.Mutex // Serializes runs
 }
 
-// New creates a new compactor
+// New creates a new compactor with the default tiers and retention policy.
+// Its watermarks are kept in memory until SetCheckpoint.
 func New(store storage.Storage) *Compactor {
+	checkpoint, _ := OpenCheckpoint("") // Cannot fail without a file
 	return &Compactor{
-		storage: store,
+		storage:    store,
+		tiers:      DefaultTiers(),
+		retention:  retention.DefaultPolicy(),
+		checkpoint: checkpoint,
+		workers:    1,
+	}
+}
+
+// SetTiers replaces the downsampling ladder. RetentionPolicy builds the
+// retention policy that goes with it.
+func (c *Compactor) SetTiers(tiers []Tier) error {
+	if err := ValidateTiers(tiers); err != nil {
+		return err
+	}
+	c.tiers = tiers
+	return nil
+}
+
+// Tiers returns the downsampling ladder, raw data first
+func (c *Compactor) Tiers() []Tier {
+	return c.tiers
+}
+
+// Resolutions returns the ladder's resolutions, finest first
+func (c *Compactor) Resolutions() []storage.Resolution {
+	return resolutions(c.tiers)
+}
+
+// RolledUp returns, for every tier but the last, the time before which its
+// data has been compacted into the next tier: the next tier's watermark.
+// Deleting a tier's data before then loses nothing that isn't kept at a
+// coarser resolution.
+func (c *Compactor) RolledUp() map[storage.Resolution]time.Time {
+	limits := make(map[storage.Resolution]time.Time, len(c.tiers))
+	for i := 1; i < len(c.tiers); i++ {
+		limits[c.tiers[i-1].Resolution] = c.checkpoint.Watermark(c.tiers[i].Resolution)
 	}
+	return limits
+}
+
+// SetCheckpoint replaces where the compactor records its per-tier progress
+func (c *Compactor) SetCheckpoint(checkpoint *Checkpoint) {
+	c.checkpoint = checkpoint
 }
 
-// Compact5m aggregates raw metrics into 5-minute buckets
+// Checkpoint returns the compactor's per-tier watermarks
+func (c *Compactor) Checkpoint() *Checkpoint {
+	return c.checkpoint
+}
+
+// SetRetention replaces the retention policy enforced after each compaction
+func (c *Compactor) SetRetention(policy *retention.Policy) {
+	c.retention = policy
+}
+
+// Retention returns the retention policy enforced after each compaction
+func (c *Compactor) Retention() *retention.Policy {
+	return c.retention
+}
+
+// SetMetadata lets compaction look up the type of series whose samples
+// don't carry it
+func (c *Compactor) SetMetadata(registry *metadata.Registry) {
+	c.metadata = registry
+}
+
+// CompactTier compacts the tier below res into res-sized buckets
 //
-// This reduces storage by ~20x:
-// - Raw samples every 15s = 240 samples/hour
-// - 5m aggregates = 12 aggregates/hour
+// Raw samples are folded into the first tier. This reduces storage by
+// ~20x at 5m: raw samples every 15s = 240 samples/hour, 5m aggregates =
+// 12 aggregates/hour. Every coarser tier merges the aggregates of the tier
+// below it (another ~12x from 5m to 1h).
 //
 // We store sum, count, min, max so we can still calculate:
 // - Average (sum/count)
-// - Rate of change
 // - Min/max bounds
-func (c *Compactor) Compact5m(ctx context.Context, start, end time.Time) error {
+//
+// Counters also keep their first and last value and their reset-adjusted
+// increase, so rate() and increase() still work once raw data is gone.
+// Histogram series (_bucket, _sum, _count) hold per-flush counts, so their
+// aggregates sum them: histogram_quantile() over a bucket stays exact.
+//
+// Source samples in [start, end) are compacted; with start and end on
+// bucket boundaries, every bucket written is complete. The range is read
+// one series at a time (storage.ScanSeries) and aggregates are written in
+// batches, so memory use doesn't grow with the number of series.
+func (c *Compactor) CompactTier(ctx context.Context, res storage.Resolution, start, end time.Time) error {
+	_, err := c.compactRange(ctx, res, start, end)
+	return err
+}
+
+// compactRange is CompactTier, returning the number of buckets written
+func (c *Compactor) compactRange(ctx context.Context, res storage.Resolution, start, end time.Time) (int, error) {
 	// Validate time range
 	if !end.After(start) {
-		return fmt.Errorf("invalid time range: end (%v) must be after start (%v)", end, start)
+		return 0, fmt.Errorf("invalid time range: end (%v) must be after start (%v)", end, start)
+	}
+	source, ok := c.sourceOf(res)
+	if !ok {
+		return 0, fmt.Errorf("resolution %q is not an aggregate tier", res)
 	}
 
-	// Query raw metrics in the time range
-	rawMetrics, err := c.storage.Query(ctx, storage.QueryRequest{
-		Start: start,
-		End:   end,
+	// Histogram families are recognized by name; shards are metric names
+	var stats *storage.Stats
+	if source == storage.ResolutionRaw || c.workers > 1 {
+		var err error
+		if stats, err = c.storage.Stats(ctx); err != nil {
+			return 0, fmt.Errorf("failed to list metrics: %w", err)
+		}
+	}
+	job := compactJob{source: source, res: res, end: end}
+	if source == storage.ResolutionRaw {
+		job.histograms = histogramFamilies(stats)
+	}
+
+	req := storage.QueryRequest{Start: start, End: end}
+	if c.workers > 1 {
+		return c.compactShards(ctx, job, req, stats.NamesBetween(start, end))
+	}
+	return c.compactSeries(ctx, job, req)
+}
+
+// compactJob is one tier's compaction of a range
+type compactJob struct {
+	source, res storage.Resolution
+	end         time.Time       // Source data at or after end is left for the next range
+	histograms  map[string]bool // Histogram families, for raw sources
+}
+
+// compactSeries compacts the source series matching req
+func (c *Compactor) compactSeries(ctx context.Context, job compactJob, req storage.QueryRequest) (int, error) {
+	// Stream the source tier a series at a time, writing aggregates in
+	// batches: memory is bounded by one series and one batch, not the range
+	var batch []metrics.Metric
+	var written int
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := c.storage.Write(ctx, batch); err != nil {
+			return fmt.Errorf("failed to write %s aggregates: %w", job.res, err)
+		}
+		written += len(batch)
+		batch = nil // The store may keep the slice
+		return nil
+	}
+
+	err := storage.ScanSeries(ctx, c.storage, req, func(series []metrics.Metric) error {
+		var buckets []*storage.Aggregate
+		if job.source == storage.ResolutionRaw {
+			buckets = c.foldRaw(series, job.res, job.end, job.histograms)
+		} else {
+			var err error
+			if buckets, err = mergeAggregates(series, job.source, job.res, job.end); err != nil {
+				return err
+			}
+		}
+		for _, agg := range buckets {
+			batch = append(batch, agg.ToMetric())
+			if len(batch) >= compactWriteBatch {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("failed to query raw metrics: %w", err)
+		return written, fmt.Errorf("failed to compact %s data: %w", tierName(job.source), err)
 	}
+	err = flush()
+	return written, err
+}
 
-	// Group metrics by series and 5-minute buckets
-	buckets := make(map[string]*Aggregate)
-
-	for _, m := range rawMetrics {
-		// Skip existing aggregates - only compact raw metrics
-		if m.Labels != nil && m.Labels["__resolution__"] != "" {
-			continue
+// sourceOf returns the tier a resolution is compacted from
+func (c *Compactor) sourceOf(res storage.Resolution) (storage.Resolution, bool) {
+	for i := 1; i < len(c.tiers); i++ {
+		if c.tiers[i].Resolution == res {
+			return c.tiers[i-1].Resolution, true
 		}
+	}
+	return "", false
+}
 
-		// Round timestamp to 5-minute bucket
-		bucketTime := roundTo5Minutes(m.Timestamp)
+// histogramFamilies returns the stored names of histogram families (those
+// with a _bucket metric). The suffix is matched on the name without its
+// tenant prefix, and families stay keyed per tenant.
+func histogramFamilies(stats *storage.Stats) map[string]bool {
+	families := make(map[string]bool)
+	for stored := range stats.Metrics {
+		id, name := tenant.SplitMetricName(stored)
+		if base, ok := strings.CutSuffix(name, "_bucket"); ok {
+			families[tenant.MetricName(id, base)] = true
+		}
+	}
+	return families
+}
 
-		// Create unique key for this series + bucket
-		// Make defensive copy of labels to avoid mutation bugs
-		labelsCopy := make(map[string]string, len(m.Labels))
-		for k, v := range m.Labels {
-			labelsCopy[k] = v
+// foldRaw folds one raw series' samples (in time order) before end into
+// res buckets. Aggregate series are skipped.
+func (c *Compactor) foldRaw(series []metrics.Metric, res storage.Resolution, end time.Time, histograms map[string]bool) []*storage.Aggregate {
+	var buckets []*storage.Aggregate
+	var agg *storage.Aggregate
+	for _, m := range series {
+		// Skip existing aggregates - only compact raw metrics
+		if m.Labels["__resolution__"] != "" {
+			return nil
+		}
+		if !m.Timestamp.Before(end) {
+			break // Belongs to the next run's first bucket
 		}
-		key := aggregateKey(m.Name, labelsCopy, bucketTime)
 
-		agg, exists := buckets[key]
-		if !exists {
-			agg = &Aggregate{
+		bucketTime := bucketStart(m.Timestamp, res)
+		if agg == nil || !agg.Timestamp.Equal(bucketTime) {
+			agg = &storage.Aggregate{
 				Name:       m.Name,
-				Labels:     labelsCopy,
+				Labels:     m.Labels, // ToMetric copies them
 				Timestamp:  bucketTime,
-				Resolution: Resolution5m,
-				Min:        m.Value,
-				Max:        m.Value,
+				Resolution: res,
+				Type:       c.seriesType(m, histograms),
 			}
-			buckets[key] = agg
+			buckets = append(buckets, agg)
 		}
+		agg.Add(m.Value)
+	}
+	return buckets
+}
 
-		// Update aggregate
-		agg.Sum += m.Value
-		agg.Count++
-		if m.Value < agg.Min {
-			agg.Min = m.Value
+// mergeAggregates merges one series' source tier aggregates (in time
+// order) starting before end into res buckets. A source aggregate that
+// doesn't parse fails the merge: the step is retried rather than its
+// series compacted without it, and retention keeps the source data.
+func mergeAggregates(series []metrics.Metric, source, res storage.Resolution, end time.Time) ([]*storage.Aggregate, error) {
+	var buckets []*storage.Aggregate
+	var agg *storage.Aggregate
+	for _, m := range series {
+		if storage.ResolutionOf(m.Labels) != source {
+			return nil, nil // Only re-aggregate the tier below
 		}
-		if m.Value > agg.Max {
-			agg.Max = m.Value
+		if !m.Timestamp.Before(end) {
+			break
+		}
+		sourceAgg := storage.ParseAggregate(m)
+		if sourceAgg == nil {
+			return nil, fmt.Errorf("malformed %s aggregate of %s at %v (see /v1/admin/check)", tierName(source), m.Name, m.Timestamp)
 		}
-	}
-
-	// Convert aggregates to metrics and write
-	aggregateMetrics := make([]metrics.Metric, 0, len(buckets))
-	for _, agg := range buckets {
-		aggregateMetrics = append(aggregateMetrics, agg.ToMetric())
-	}
 
-	if len(aggregateMetrics) > 0 {
-		if err := c.storage.Write(ctx, aggregateMetrics); err != nil {
-			return fmt.Errorf("failed to write 5m aggregates: %w", err)
+		bucketTime := bucketStart(sourceAgg.Timestamp, res)
+		if agg == nil || !agg.Timestamp.Equal(bucketTime) {
+			agg = &storage.Aggregate{
+				Name:       sourceAgg.Name,
+				Labels:     sourceAgg.Labels,
+				Timestamp:  bucketTime,
+				Resolution: res,
+				Type:       sourceAgg.Type,
+			}
+			buckets = append(buckets, agg)
 		}
+		agg.Merge(sourceAgg)
 	}
-
-	return nil
+	return buckets, nil
 }
 
-// Compact1h aggregates 5-minute buckets into 1-hour buckets
+// CompactAndCleanup compacts every completed bucket since the last run and
+// then removes expired data. This is the main compaction job that should
+// run periodically.
 //
-// This reduces storage by another ~12x:
-// - 5m aggregates = 12 per hour
-// - 1h aggregate = 1 per hour
-//
-// Total reduction: ~240x from raw samples
-func (c *Compactor) Compact1h(ctx context.Context, start, end time.Time) error {
-	// Validate time range
-	if !end.After(start) {
-		return fmt.Errorf("invalid time range: end (%v) must be after start (%v)", end, start)
-	}
+// Each tier resumes at its watermark, so overlapping or repeated runs don't
+// rewrite buckets and a failed run is picked up where it stopped. Retention
+// never deletes data the next tier's watermark hasn't passed: raw samples
+// stay until they are in the first aggregate tier, and each aggregate tier
+// until it is in the next one.
+func (c *Compactor) CompactAndCleanup(ctx context.Context) error {
+	_, err := c.Run(ctx, RunOptions{})
+	return err
+}
 
-	// Query 5-minute aggregates
-	// In production, would filter by resolution
-	rawMetrics, err := c.storage.Query(ctx, storage.QueryRequest{
-		Start: start,
-		End:   end,
-	})
+// compactTier compacts the ith tier's completed buckets from its watermark
+// on, a step at a time, advancing the watermark after each step. Ranges
+// without source data, per the per-tier stats, are skipped. Returns the
+// number of buckets written.
+func (c *Compactor) compactTier(ctx context.Context, i int, now time.Time) (int, error) {
+	t := c.tiers[i]
+	source := c.tiers[i-1].Resolution
+	end := c.compactableUntil(i, now)
+	if end.IsZero() {
+		return 0, nil // The tier below hasn't compacted anything yet
+	}
+	stats, err := c.storage.Stats(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to query 5m aggregates: %w", err)
+		return 0, fmt.Errorf("failed to find %s data: %w", tierName(source), err)
 	}
-
-	// Group by series and 1-hour buckets
-	buckets := make(map[string]*Aggregate)
-
-	for _, m := range rawMetrics {
-		// Parse as aggregate (skip if not an aggregate or wrong resolution)
-		sourceAgg := FromMetric(m)
-		if sourceAgg == nil || sourceAgg.Resolution != Resolution5m {
-			continue // Only re-aggregate 5m aggregates
+	start := c.checkpoint.Watermark(t.Resolution)
+	if start.IsZero() {
+		// First run: start at the source tier's oldest data, so nothing
+		// stored before checkpoints existed is deleted uncompacted
+		oldest := stats.Resolutions[source].Oldest
+		if oldest.IsZero() {
+			return 0, nil // Nothing to compact yet
 		}
+		start = bucketStart(oldest, t.Resolution)
+	}
+	if t.Lookback > 0 {
+		if floor := bucketStart(now.Add(-t.Lookback), t.Resolution); start.Before(floor) {
+			start = floor
+		}
+	}
 
-		bucketTime := roundTo1Hour(m.Timestamp)
-		key := aggregateKey(m.Name, sourceAgg.Labels, bucketTime)
-
-		agg, exists := buckets[key]
-		if !exists {
-			agg = &Aggregate{
-				Name:       m.Name,
-				Labels:     sourceAgg.Labels,
-				Timestamp:  bucketTime,
-				Resolution: Resolution1h,
-				Min:        sourceAgg.Min,
-				Max:        sourceAgg.Max,
+	var buckets int
+	step := t.Resolution.Duration() * compactStepBuckets
+	for start.Before(end) {
+		if err := ctx.Err(); err != nil {
+			return buckets, err
+		}
+		if next := nextSourceBucket(stats, source, t.Resolution, start, end); next.After(start) {
+			// Nothing stored at the source tier before next
+			if err := c.checkpoint.advance(t.Resolution, next); err != nil {
+				return buckets, err
 			}
-			buckets[key] = agg
+			start = next
+			continue
 		}
-
-		// Re-aggregate by combining Sum and Count (preserves original data)
-		agg.Sum += sourceAgg.Sum
-		agg.Count += sourceAgg.Count
-		if sourceAgg.Min < agg.Min {
-			agg.Min = sourceAgg.Min
+		stepEnd := start.Add(step)
+		if stepEnd.After(end) {
+			stepEnd = end
+		}
+		n, err := c.compactRange(ctx, t.Resolution, start, stepEnd)
+		buckets += n
+		if err != nil {
+			return buckets, err
 		}
-		if sourceAgg.Max > agg.Max {
-			agg.Max = sourceAgg.Max
+		if err := c.checkpoint.advance(t.Resolution, stepEnd); err != nil {
+			return buckets, err
 		}
+		start = stepEnd
 	}
+	return buckets, nil
+}
 
-	// Write 1h aggregates
-	aggregateMetrics := make([]metrics.Metric, 0, len(buckets))
-	for _, agg := range buckets {
-		aggregateMetrics = append(aggregateMetrics, agg.ToMetric())
+// nextSourceBucket returns the start of the first res bucket in [start,
+// end) that may hold source data, from the per-metric, per-tier stats, or
+// end if none does
+func nextSourceBucket(stats *storage.Stats, source, res storage.Resolution, start, end time.Time) time.Time {
+	next := end
+	for _, ms := range stats.Metrics {
+		tier := ms.Resolutions[source]
+		if tier.Samples == 0 || tier.Newest.Before(start) {
+			continue
+		}
+		if from := bucketStart(tier.Oldest, res); from.Before(next) {
+			next = from
+		}
+	}
+	if next.Before(start) {
+		return start
 	}
+	return next
+}
 
-	if len(aggregateMetrics) > 0 {
-		if err := c.storage.Write(ctx, aggregateMetrics); err != nil {
-			return fmt.Errorf("failed to write 1h aggregates: %w", err)
+// compactableUntil returns the end of the ith tier's last completed bucket:
+// older than the tier's delay and, above the first aggregate tier, already
+// covered by the tier it reads from
+func (c *Compactor) compactableUntil(i int, now time.Time) time.Time {
+	t := c.tiers[i]
+	end := bucketStart(now.Add(-t.Delay), t.Resolution)
+	if source := c.tiers[i-1].Resolution; source != storage.ResolutionRaw {
+		covered := c.checkpoint.Watermark(source)
+		if covered.IsZero() {
+			return time.Time{}
+		}
+		if covered = bucketStart(covered, t.Resolution); covered.Before(end) {
+			end = covered
 		}
 	}
-
-	return nil
+	return end
 }
 
-// CompactAndCleanup performs downsampling and removes old raw data
-// This is the main compaction job that should run periodically
-func (c *Compactor) CompactAndCleanup(ctx context.Context) error {
-	now := time.Now()
-
-	// Step 1: Compact raw data from 6-12 hours ago into 5m aggregates
-	// (Wait to ensure all data has arrived)
-	compact5mStart := now.Add(-compact5mLookback)
-	compact5mEnd := now.Add(-compact5mDelay)
+// TierProgress reports how far compaction into a tier has got
+type TierProgress struct {
+	Tier           string `json:"tier"`
+	Watermark      string `json:"watermark,omitempty"` // Every bucket before this is compacted
+	Lag            string `json:"lag,omitempty"`       // How far the watermark trails the last completed bucket
+	PendingBuckets int    `json:"pending_buckets"`     // Completed buckets not compacted yet
+}
 
-	if err := c.Compact5m(ctx, compact5mStart, compact5mEnd); err != nil {
-		return fmt.Errorf("5m compaction failed: %w", err)
+// Progress reports each tier's watermark and the completed buckets still
+// waiting for compaction
+func (c *Compactor) Progress(now time.Time) []TierProgress {
+	progress := make([]TierProgress, 0, len(c.tiers))
+	for i := 1; i < len(c.tiers); i++ {
+		t := c.tiers[i]
+		p := TierProgress{Tier: string(t.Resolution)}
+		if watermark := c.checkpoint.Watermark(t.Resolution); !watermark.IsZero() {
+			p.Watermark = watermark.Format(time.RFC3339)
+			if end := c.compactableUntil(i, now); end.After(watermark) {
+				p.Lag = end.Sub(watermark).String()
+				p.PendingBuckets = int(end.Sub(watermark) / t.Resolution.Duration())
+			}
+		}
+		progress = append(progress, p)
 	}
+	return progress
+}
 
-	// Step 2: Delete raw data older than retention period (keep aggregates)
-	rawRes := storage.ResolutionRaw
-	if err := c.storage.Delete(ctx, storage.DeleteOptions{
-		Before:     now.Add(-rawDataRetention),
-		Resolution: &rawRes,
-	}); err != nil {
-		return fmt.Errorf("failed to delete old raw data: %w", err)
+// seriesType decides how a raw series is aggregated. Samples typed as
+// counters are counters (cumulative histogram buckets included). Otherwise
+// histogram series are recognized by type, by belonging to a family with
+// a stored _bucket metric (its _bucket, _sum and _count), or by the
+// metadata registry. Tenant-prefixed names are matched without their
+// prefix and looked up among their own tenant's families and metadata.
+func (c *Compactor) seriesType(m metrics.Metric, histograms map[string]bool) metrics.MetricType {
+	if m.Type == metrics.CounterType || m.Type == metrics.HistogramType {
+		return m.Type
 	}
-
-	// Step 3: Compact 5m aggregates from 2-7 days ago into 1h aggregates
-	compact1hStart := now.Add(-compact1hLookback)
-	compact1hEnd := now.Add(-compact1hDelay)
-
-	if err := c.Compact1h(ctx, compact1hStart, compact1hEnd); err != nil {
-		return fmt.Errorf("1h compaction failed: %w", err)
+	id, name := tenant.SplitMetricName(m.Name)
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if base, ok := strings.CutSuffix(name, suffix); ok && histograms[tenant.MetricName(id, base)] {
+			return metrics.HistogramType
+		}
 	}
-
-	// Step 4: Delete 5m aggregates older than retention period (keep 1h aggregates)
-	fiveMinRes := storage.Resolution5m
-	if err := c.storage.Delete(ctx, storage.DeleteOptions{
-		Before:     now.Add(-fiveMinRetention),
-		Resolution: &fiveMinRes,
-	}); err != nil {
-		return fmt.Errorf("failed to delete old 5m aggregates: %w", err)
+	if c.metadata != nil {
+		if md, ok := c.metadata.Lookup(tenant.MetricName(id, name)); ok && md.Type != "" {
+			return md.Type
+		}
 	}
-
-	return nil
+	return m.Type
 }
 
-// roundTo5Minutes rounds a timestamp down to the nearest 5-minute bucket
-func roundTo5Minutes(t time.Time) time.Time {
-	minutes := t.Minute()
-	roundedMinutes := (minutes / 5) * 5
-
-	return time.Date(
-		t.Year(), t.Month(), t.Day(),
-		t.Hour(), roundedMinutes, 0, 0,
-		t.Location(),
-	)
-}
-
-// roundTo1Hour rounds a timestamp down to the nearest hour
-func roundTo1Hour(t time.Time) time.Time {
-	return time.Date(
-		t.Year(), t.Month(), t.Day(),
-		t.Hour(), 0, 0, 0,
-		t.Location(),
-	)
+// bucketStart returns the start of the res bucket holding t. Buckets are
+// aligned in UTC, so daily buckets start at midnight UTC and every tier's
+// buckets nest in the next one's.
+func bucketStart(t time.Time, res storage.Resolution) time.Time {
+	return t.Truncate(res.Duration())
 }
 
 // aggregateKey creates a unique key for an aggregate
 func aggregateKey(name string, labels map[string]string, timestamp time.Time) string {
-	key := name + "@" + timestamp.Format(time.RFC3339)
+	return seriesKey(name, labels) + "@" + timestamp.Format(time.RFC3339)
+}
+
+// seriesKey creates a unique key for a series
+func seriesKey(name string, labels map[string]string) string {
+	key := name
 
 	// Add sorted labels for deterministic key
 	if len(labels) > 0 {
@@ -277,25 +501,7 @@ func aggregateKey(name string, labels map[string]string, timestamp time.Time) st
 }
 
 // CalculatePercentile computes percentile from raw values
-// Used when precise percentiles are needed (vs approximation from min/max)
+// Used when precise percentiles are needed (vs the sketches aggregates keep)
 func CalculatePercentile(values []float64, p float64) float64 {
-	if len(values) == 0 {
-		return 0
-	}
-
-	sorted := make([]float64, len(values))
-	copy(sorted, values)
-	sort.Float64s(sorted)
-
-	index := p * float64(len(sorted)-1)
-	lower := int(math.Floor(index))
-	upper := int(math.Ceil(index))
-
-	if lower == upper {
-		return sorted[lower]
-	}
-
-	// Linear interpolation
-	weight := index - float64(lower)
-	return sorted[lower]*(1-weight) + sorted[upper]*weight
+	return storage.Percentile(values, p)
 }
@@ -2,11 +2,17 @@ package compaction
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/nicktill/tinyobs/pkg/metadata"
 	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
 	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/badger"
 	"github.com/nicktill/tinyobs/pkg/storage/memory"
 )
 
@@ -30,7 +36,7 @@ func TestCompact5m_BasicAggregation(t *testing.T) {
 	store.Write(ctx, rawMetrics)
 
 	// Compact
-	err := compactor.Compact5m(ctx, baseTime.Add(-1*time.Hour), baseTime.Add(1*time.Hour))
+	err := compactor.CompactTier(ctx, storage.Resolution5m, baseTime.Add(-1*time.Hour), baseTime.Add(1*time.Hour))
 	if err != nil {
 		t.Fatalf("Compaction failed: %v", err)
 	}
@@ -97,7 +103,7 @@ func TestCompact5m_MultipleSeries(t *testing.T) {
 
 	store.Write(ctx, rawMetrics)
 
-	err := compactor.Compact5m(ctx, baseTime.Add(-1*time.Hour), baseTime.Add(1*time.Hour))
+	err := compactor.CompactTier(ctx, storage.Resolution5m, baseTime.Add(-1*time.Hour), baseTime.Add(1*time.Hour))
 	if err != nil {
 		t.Fatalf("Compaction failed: %v", err)
 	}
@@ -135,7 +141,7 @@ func TestCompact5m_AcrossBuckets(t *testing.T) {
 
 	store.Write(ctx, rawMetrics)
 
-	err := compactor.Compact5m(ctx, baseTime.Add(-1*time.Hour), baseTime.Add(1*time.Hour))
+	err := compactor.CompactTier(ctx, storage.Resolution5m, baseTime.Add(-1*time.Hour), baseTime.Add(1*time.Hour))
 	if err != nil {
 		t.Fatalf("Compaction failed: %v", err)
 	}
@@ -165,16 +171,16 @@ func TestCompact1h(t *testing.T) {
 
 	// Create proper 5-minute aggregates (with metadata labels)
 	fiveMinAggregates := []metrics.Metric{
-		(&Aggregate{Name: "metric", Resolution: Resolution5m, Sum: 10, Count: 1, Min: 10, Max: 10, Timestamp: baseTime}).ToMetric(),
-		(&Aggregate{Name: "metric", Resolution: Resolution5m, Sum: 15, Count: 1, Min: 15, Max: 15, Timestamp: baseTime.Add(5 * time.Minute)}).ToMetric(),
-		(&Aggregate{Name: "metric", Resolution: Resolution5m, Sum: 20, Count: 1, Min: 20, Max: 20, Timestamp: baseTime.Add(10 * time.Minute)}).ToMetric(),
-		(&Aggregate{Name: "metric", Resolution: Resolution5m, Sum: 25, Count: 1, Min: 25, Max: 25, Timestamp: baseTime.Add(15 * time.Minute)}).ToMetric(),
-		(&Aggregate{Name: "metric", Resolution: Resolution5m, Sum: 30, Count: 1, Min: 30, Max: 30, Timestamp: baseTime.Add(60 * time.Minute)}).ToMetric(),
+		(&storage.Aggregate{Name: "metric", Resolution: storage.Resolution5m, Sum: 10, Count: 1, Min: 10, Max: 10, Timestamp: baseTime}).ToMetric(),
+		(&storage.Aggregate{Name: "metric", Resolution: storage.Resolution5m, Sum: 15, Count: 1, Min: 15, Max: 15, Timestamp: baseTime.Add(5 * time.Minute)}).ToMetric(),
+		(&storage.Aggregate{Name: "metric", Resolution: storage.Resolution5m, Sum: 20, Count: 1, Min: 20, Max: 20, Timestamp: baseTime.Add(10 * time.Minute)}).ToMetric(),
+		(&storage.Aggregate{Name: "metric", Resolution: storage.Resolution5m, Sum: 25, Count: 1, Min: 25, Max: 25, Timestamp: baseTime.Add(15 * time.Minute)}).ToMetric(),
+		(&storage.Aggregate{Name: "metric", Resolution: storage.Resolution5m, Sum: 30, Count: 1, Min: 30, Max: 30, Timestamp: baseTime.Add(60 * time.Minute)}).ToMetric(),
 	}
 
 	store.Write(ctx, fiveMinAggregates)
 
-	err := compactor.Compact1h(ctx, baseTime.Add(-1*time.Hour), baseTime.Add(2*time.Hour))
+	err := compactor.CompactTier(ctx, storage.Resolution1h, baseTime.Add(-1*time.Hour), baseTime.Add(2*time.Hour))
 	if err != nil {
 		t.Fatalf("Compaction failed: %v", err)
 	}
@@ -193,6 +199,250 @@ func TestCompact1h(t *testing.T) {
 	}
 }
 
+func TestCompact_Counter(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+
+	compactor := New(store)
+	ctx := context.Background()
+
+	baseTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// A counter that resets to zero in the second 5-minute bucket
+	var rawMetrics []metrics.Metric
+	for i, v := range []float64{10, 20, 30, 40, 5, 15, 25} {
+		rawMetrics = append(rawMetrics, metrics.Metric{
+			Name:      "requests_total",
+			Type:      metrics.CounterType,
+			Value:     v,
+			Timestamp: baseTime.Add(time.Duration(i) * 2 * time.Minute),
+		})
+	}
+	store.Write(ctx, rawMetrics)
+
+	if err := compactor.CompactTier(ctx, storage.Resolution5m, baseTime.Add(-time.Hour), baseTime.Add(time.Hour)); err != nil {
+		t.Fatalf("5m compaction failed: %v", err)
+	}
+	if err := compactor.CompactTier(ctx, storage.Resolution1h, baseTime.Add(-time.Hour), baseTime.Add(time.Hour)); err != nil {
+		t.Fatalf("1h compaction failed: %v", err)
+	}
+
+	results, err := store.Query(ctx, storage.QueryRequest{
+		Start: baseTime.Add(-1 * time.Hour),
+		End:   baseTime.Add(1 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	type counterStats struct{ first, last, increase float64 }
+	got := make(map[string]counterStats)
+	for _, m := range results {
+		agg := storage.ParseAggregate(m)
+		if agg == nil {
+			continue
+		}
+		if m.Type != metrics.CounterType || m.Value != agg.Last {
+			t.Errorf("Counter aggregate stored as %s with value %v, want counter with last value %v", m.Type, m.Value, agg.Last)
+		}
+		got[string(agg.Resolution)+"@"+agg.Timestamp.Format("15:04")] = counterStats{agg.First, agg.Last, agg.Increase}
+	}
+
+	want := map[string]counterStats{
+		"5m@12:00": {10, 30, 20},                    // 10, 20, 30
+		"5m@12:05": {40, 5, 5},                      // 40, reset to 5
+		"5m@12:10": {15, 25, 10},                    // 15, 25
+		"1h@12:00": {10, 25, 20 + 10 + 5 + 10 + 10}, // Includes increases between buckets
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Got aggregates %v, want %v", got, want)
+	}
+	for key, w := range want {
+		if got[key] != w {
+			t.Errorf("Aggregate %s = %+v, want %+v", key, got[key], w)
+		}
+	}
+}
+
+func TestCompact_Histogram(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+
+	compactor := New(store)
+	ctx := context.Background()
+
+	baseTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Per-flush histogram series, one flush per minute. The buckets were
+	// written by an older client that didn't send a type.
+	var rawMetrics []metrics.Metric
+	for i := 0; i < 10; i++ {
+		ts := baseTime.Add(time.Duration(i) * time.Minute)
+		rawMetrics = append(rawMetrics,
+			metrics.Metric{Name: "latency_bucket", Type: metrics.GaugeType, Value: 2, Labels: map[string]string{"le": "0.5"}, Timestamp: ts},
+			metrics.Metric{Name: "latency_bucket", Type: metrics.GaugeType, Value: 3, Labels: map[string]string{"le": "+Inf"}, Timestamp: ts},
+			metrics.Metric{Name: "latency_sum", Type: metrics.GaugeType, Value: 1.5, Timestamp: ts},
+			metrics.Metric{Name: "latency_count", Type: metrics.GaugeType, Value: 3, Timestamp: ts},
+		)
+	}
+	store.Write(ctx, rawMetrics)
+
+	if err := compactor.CompactTier(ctx, storage.Resolution5m, baseTime.Add(-time.Hour), baseTime.Add(time.Hour)); err != nil {
+		t.Fatalf("5m compaction failed: %v", err)
+	}
+	if err := compactor.CompactTier(ctx, storage.Resolution1h, baseTime.Add(-time.Hour), baseTime.Add(time.Hour)); err != nil {
+		t.Fatalf("1h compaction failed: %v", err)
+	}
+
+	results, err := store.Query(ctx, storage.QueryRequest{
+		Start: baseTime.Add(-1 * time.Hour),
+		End:   baseTime.Add(1 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	got := make(map[string]float64)
+	for _, m := range results {
+		agg := storage.ParseAggregate(m)
+		if agg == nil {
+			continue
+		}
+		if m.Type != metrics.HistogramType || m.Value != agg.Sum {
+			t.Errorf("%s aggregate stored as %s with value %v, want histogram with sum %v", m.Name, m.Type, m.Value, agg.Sum)
+		}
+		got[string(agg.Resolution)+"@"+agg.Timestamp.Format("15:04")+" "+m.Name+m.Labels["le"]] = m.Value
+	}
+
+	want := map[string]float64{
+		"5m@12:00 latency_bucket0.5":  10,
+		"5m@12:00 latency_bucket+Inf": 15,
+		"5m@12:00 latency_sum":        7.5,
+		"5m@12:00 latency_count":      15,
+		"5m@12:05 latency_bucket0.5":  10,
+		"5m@12:05 latency_bucket+Inf": 15,
+		"5m@12:05 latency_sum":        7.5,
+		"5m@12:05 latency_count":      15,
+		"1h@12:00 latency_bucket0.5":  20,
+		"1h@12:00 latency_bucket+Inf": 30,
+		"1h@12:00 latency_sum":        15,
+		"1h@12:00 latency_count":      30,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Got aggregates %v, want %v", got, want)
+	}
+	for key, w := range want {
+		if got[key] != w {
+			t.Errorf("%s = %v, want %v", key, got[key], w)
+		}
+	}
+}
+
+func TestCompact_TenantSeriesTypes(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	registry, err := metadata.Open(filepath.Join(t.TempDir(), "metadata.json"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	registry.Update([]metrics.Metadata{{Name: "team-a/requests_total", Type: metrics.CounterType}})
+	compactor := New(store)
+	compactor.SetMetadata(registry)
+
+	// A tenant's untyped histogram and a counter typed only by metadata
+	baseTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store.Write(ctx, []metrics.Metric{
+		{Name: "team-a/latency_bucket", Type: metrics.GaugeType, Value: 2, Labels: map[string]string{"le": "+Inf"}, Timestamp: baseTime},
+		{Name: "team-a/latency_sum", Type: metrics.GaugeType, Value: 1.5, Timestamp: baseTime},
+		{Name: "team-a/requests_total", Type: metrics.GaugeType, Value: 10, Timestamp: baseTime},
+	})
+
+	if err := compactor.CompactTier(ctx, storage.Resolution5m, baseTime.Add(-time.Hour), baseTime.Add(time.Hour)); err != nil {
+		t.Fatalf("5m compaction failed: %v", err)
+	}
+
+	results, err := store.Query(ctx, storage.QueryRequest{Start: baseTime.Add(-time.Hour), End: baseTime.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	want := map[string]metrics.MetricType{
+		"team-a/latency_bucket": metrics.HistogramType,
+		"team-a/latency_sum":    metrics.HistogramType,
+		"team-a/requests_total": metrics.CounterType,
+	}
+	got := make(map[string]metrics.MetricType)
+	for _, m := range results {
+		if storage.ParseAggregate(m) != nil {
+			got[m.Name] = m.Type
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Got aggregates %v, want %v", got, want)
+	}
+	for name, typ := range want {
+		if got[name] != typ {
+			t.Errorf("%s aggregate stored as %s, want %s", name, got[name], typ)
+		}
+	}
+}
+
+func TestCompact_GaugeSketch(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+
+	compactor := New(store)
+	ctx := context.Background()
+
+	baseTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// A gauge sampled every 30s for an hour: 1, 2, ..., 120
+	var rawMetrics []metrics.Metric
+	for i := 0; i < 120; i++ {
+		rawMetrics = append(rawMetrics, metrics.Metric{
+			Name:      "latency_ms",
+			Type:      metrics.GaugeType,
+			Value:     float64(i + 1),
+			Timestamp: baseTime.Add(time.Duration(i) * 30 * time.Second),
+		})
+	}
+	store.Write(ctx, rawMetrics)
+
+	if err := compactor.CompactTier(ctx, storage.Resolution5m, baseTime.Add(-time.Hour), baseTime.Add(2*time.Hour)); err != nil {
+		t.Fatalf("5m compaction failed: %v", err)
+	}
+	if err := compactor.CompactTier(ctx, storage.Resolution1h, baseTime.Add(-time.Hour), baseTime.Add(2*time.Hour)); err != nil {
+		t.Fatalf("1h compaction failed: %v", err)
+	}
+
+	results, err := store.Query(ctx, storage.QueryRequest{
+		Start: baseTime.Add(-1 * time.Hour),
+		End:   baseTime.Add(2 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	var hourly *storage.Aggregate
+	for _, m := range results {
+		if agg := storage.ParseAggregate(m); agg != nil && agg.Resolution == storage.Resolution1h {
+			hourly = agg
+		}
+	}
+	if hourly == nil || hourly.Sketch == nil {
+		t.Fatalf("Expected a 1h aggregate with a sketch, got %+v", hourly)
+	}
+	if hourly.Sketch.Count() != 120 {
+		t.Errorf("1h sketch counts %d values, want 120", hourly.Sketch.Count())
+	}
+	for p, want := range map[float64]float64{0.5: 60, 0.99: 119} {
+		if got := hourly.Percentile(p); math.Abs(got-want) > want*storage.SketchAccuracy {
+			t.Errorf("Percentile(%v) = %v, want %v within 1%%", p, got, want)
+		}
+	}
+}
+
 func TestCompactAndCleanup(t *testing.T) {
 	store := memory.New()
 	defer store.Close()
@@ -257,7 +507,290 @@ func TestCompactAndCleanup(t *testing.T) {
 	}
 }
 
-func TestRoundTo5Minutes(t *testing.T) {
+// flakyStore fails writes while failWrites is set and counts the others
+type flakyStore struct {
+	storage.Storage
+	failWrites bool
+	writes     int
+}
+
+func (s *flakyStore) Write(ctx context.Context, batch []metrics.Metric) error {
+	if s.failWrites {
+		return errors.New("disk full")
+	}
+	s.writes++
+	return s.Storage.Write(ctx, batch)
+}
+
+func TestCompactAndCleanup_Checkpoint(t *testing.T) {
+	store := &flakyStore{Storage: memory.New()}
+	defer store.Close()
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "compaction.json")
+	checkpoint, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatalf("OpenCheckpoint failed: %v", err)
+	}
+	compactor := New(store)
+	compactor.SetCheckpoint(checkpoint)
+
+	now := time.Now()
+	oldest := now.Add(-20 * time.Hour)
+	var raw []metrics.Metric
+	for ts := oldest; ts.Before(now); ts = ts.Add(10 * time.Minute) {
+		raw = append(raw, metrics.Metric{Name: "cpu", Value: 1, Timestamp: ts})
+	}
+	store.Storage.Write(ctx, raw)
+
+	// A failed run deletes nothing
+	store.failWrites = true
+	if err := compactor.CompactAndCleanup(ctx); err == nil {
+		t.Fatal("Expected CompactAndCleanup to fail")
+	}
+	if !checkpoint.Watermark(storage.Resolution5m).IsZero() {
+		t.Error("Watermark advanced without a successful write")
+	}
+	results, _ := store.Query(ctx, storage.QueryRequest{End: now})
+	if len(results) != len(raw) {
+		t.Fatalf("Failed run left %d samples, want all %d raw samples", len(results), len(raw))
+	}
+
+	// The next run catches up from the oldest data
+	store.failWrites = false
+	if err := compactor.CompactAndCleanup(ctx); err != nil {
+		t.Fatalf("CompactAndCleanup failed: %v", err)
+	}
+	watermark := checkpoint.Watermark(storage.Resolution5m)
+	if want := bucketStart(now.Add(-DefaultTiers()[1].Delay), storage.Resolution5m); !watermark.Equal(want) {
+		t.Errorf("5m watermark = %v, want %v", watermark, want)
+	}
+
+	results, _ = store.Query(ctx, storage.QueryRequest{End: now})
+	var aggregates int
+	for _, m := range results {
+		if agg := storage.ParseAggregate(m); agg != nil {
+			aggregates++
+			if agg.Timestamp.Before(bucketStart(oldest, storage.Resolution5m)) || !agg.Timestamp.Before(watermark) {
+				t.Errorf("Aggregate at %v outside [oldest, watermark)", agg.Timestamp)
+			}
+		} else if m.Timestamp.Before(watermark) {
+			t.Errorf("Raw sample at %v kept although it was compacted and expired", m.Timestamp)
+		}
+	}
+	if want := int(watermark.Sub(bucketStart(oldest, storage.Resolution5m))/(10*time.Minute)) - 1; aggregates < want {
+		t.Errorf("Got %d aggregates, want at least %d", aggregates, want)
+	}
+
+	// Watermarks survive a restart; a repeated run has nothing to do
+	reopened, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatalf("Reopening checkpoint failed: %v", err)
+	}
+	if !reopened.Watermark(storage.Resolution5m).Equal(watermark) {
+		t.Errorf("Reopened watermark = %v, want %v", reopened.Watermark(storage.Resolution5m), watermark)
+	}
+	compactor.SetCheckpoint(reopened)
+	writes := store.writes
+	if err := compactor.CompactAndCleanup(ctx); err != nil {
+		t.Fatalf("Second CompactAndCleanup failed: %v", err)
+	}
+	if store.writes != writes {
+		t.Errorf("Second run wrote %d batches, want none", store.writes-writes)
+	}
+
+	progress := compactor.Progress(now)
+	if len(progress) != 2 || progress[0].Tier != "5m" || progress[0].Watermark == "" || progress[0].PendingBuckets != 0 {
+		t.Errorf("Progress = %+v, want 5m caught up", progress)
+	}
+}
+
+// scanStore records the largest series streamed to compaction and the
+// largest aggregate batch written, and fails on full range queries
+type scanStore struct {
+	*badger.Storage
+	queries      int
+	maxSeries    int
+	maxBatch     int
+	seriesCalled int
+}
+
+func (s *scanStore) Query(ctx context.Context, req storage.QueryRequest) ([]metrics.Metric, error) {
+	s.queries++
+	return s.Storage.Query(ctx, req)
+}
+
+func (s *scanStore) ScanSeries(ctx context.Context, req storage.QueryRequest, fn func([]metrics.Metric) error) error {
+	return s.Storage.ScanSeries(ctx, req, func(series []metrics.Metric) error {
+		s.seriesCalled++
+		s.maxSeries = max(s.maxSeries, len(series))
+		return fn(series)
+	})
+}
+
+func (s *scanStore) Write(ctx context.Context, batch []metrics.Metric) error {
+	s.maxBatch = max(s.maxBatch, len(batch))
+	return s.Storage.Write(ctx, batch)
+}
+
+func TestCompactTier_BoundedMemory(t *testing.T) {
+	db, err := badger.New(badger.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to open badger: %v", err)
+	}
+	store := &scanStore{Storage: db}
+	defer store.Close()
+	ctx := context.Background()
+
+	// 1000 series with a sample a minute for 6 hours: 360k samples, and
+	// 72k 5m aggregates (many write batches)
+	const numSeries, numSamples = 1000, 360
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	batch := make([]metrics.Metric, 0, numSeries)
+	for i := 0; i < numSamples; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		for s := 0; s < numSeries; s++ {
+			batch = append(batch, metrics.Metric{
+				Name:      fmt.Sprintf("metric_%d", s%20),
+				Labels:    map[string]string{"pod": fmt.Sprintf("pod-%d", s)},
+				Value:     1,
+				Timestamp: ts,
+			})
+		}
+		if err := db.Write(ctx, batch); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		batch = batch[:0]
+	}
+
+	compactor := New(store)
+	end := start.Add(numSamples * time.Minute)
+	if err := compactor.CompactTier(ctx, storage.Resolution5m, start, end); err != nil {
+		t.Fatalf("Compaction failed: %v", err)
+	}
+
+	if store.queries != 0 {
+		t.Errorf("Compaction ran %d range queries, want the series to be streamed", store.queries)
+	}
+	if store.seriesCalled != numSeries || store.maxSeries != numSamples {
+		t.Errorf("Streamed %d series of up to %d samples, want %d series of %d", store.seriesCalled, store.maxSeries, numSeries, numSamples)
+	}
+	if store.maxBatch > compactWriteBatch {
+		t.Errorf("Wrote a batch of %d aggregates, want at most %d", store.maxBatch, compactWriteBatch)
+	}
+
+	stats, err := db.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if want := uint64(numSeries*numSamples + numSeries*numSamples/5); stats.TotalMetrics != want {
+		t.Errorf("Stored %d samples, want %d raw + %d aggregates", stats.TotalMetrics, numSeries*numSamples, numSeries*numSamples/5)
+	}
+	aggregates, err := db.Query(ctx, storage.QueryRequest{Start: start, End: end, MetricNames: []string{"metric_7"}, Labels: map[string]string{"__resolution__": "5m"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	for _, m := range aggregates {
+		if agg := storage.ParseAggregate(m); agg == nil || agg.Count != 5 || agg.Sum != 5 {
+			t.Fatalf("Aggregate %+v, want 5 samples summing to 5", m)
+		}
+	}
+	if len(aggregates) != numSeries/20*numSamples/5 {
+		t.Errorf("Got %d aggregates for metric_7, want %d", len(aggregates), numSeries/20*numSamples/5)
+	}
+}
+
+// rangeCounter counts the ranges compaction scans
+type rangeCounter struct {
+	storage.Storage
+	scans int
+}
+
+func (s *rangeCounter) ScanSeries(ctx context.Context, req storage.QueryRequest, fn func([]metrics.Metric) error) error {
+	s.scans++
+	return storage.ScanSeries(ctx, s.Storage, req, fn)
+}
+
+func TestCompactTier_SkipsEmptyRanges(t *testing.T) {
+	store := &rangeCounter{Storage: memory.New()}
+	defer store.Close()
+	ctx := context.Background()
+
+	// A year-old 1h aggregate of cpu, raw mem a month ago and raw cpu in
+	// the last 10 hours: the 5m tier has nothing to read before the
+	// month-old sample, nor between it and the last hours
+	now := time.Now()
+	hourly := &storage.Aggregate{Name: "cpu", Timestamp: bucketStart(now.AddDate(-1, 0, 0), storage.Resolution1h), Resolution: storage.Resolution1h, Type: metrics.GaugeType, Sum: 1, Count: 1, Min: 1, Max: 1}
+	monthAgo := now.AddDate(0, -1, 0)
+	batch := []metrics.Metric{hourly.ToMetric(), {Name: "mem", Value: 1, Timestamp: monthAgo}}
+	for ts := now.Add(-10 * time.Hour); ts.Before(now); ts = ts.Add(time.Minute) {
+		batch = append(batch, metrics.Metric{Name: "cpu", Value: 1, Timestamp: ts})
+	}
+	store.Write(ctx, batch)
+
+	compactor := New(store)
+	if _, err := compactor.compactTier(ctx, 1, now); err != nil {
+		t.Fatalf("compactTier failed: %v", err)
+	}
+
+	if want := compactor.compactableUntil(1, now); !compactor.checkpoint.Watermark(storage.Resolution5m).Equal(want) {
+		t.Errorf("5m watermark = %v, want %v", compactor.checkpoint.Watermark(storage.Resolution5m), want)
+	}
+	// One step for the month-old sample and one for the last hours (72
+	// buckets each), instead of a year of empty steps
+	if store.scans > 2 {
+		t.Errorf("Compaction scanned %d ranges, want empty ranges skipped", store.scans)
+	}
+	results, err := store.Query(ctx, storage.QueryRequest{MetricNames: []string{"mem"}, Start: bucketStart(monthAgo, storage.Resolution5m), End: monthAgo, Labels: map[string]string{"__resolution__": "5m"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("Got %d 5m aggregates for the month-old sample, want 1", len(results))
+	}
+}
+
+func TestCompactTier_MalformedSourceAggregate(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	// Three 5m aggregates of one series, the middle one with a corrupt sketch
+	now := time.Now()
+	base := bucketStart(now.Add(-72*time.Hour), storage.Resolution1h)
+	var batch []metrics.Metric
+	for i := 0; i < 3; i++ {
+		agg := &storage.Aggregate{Name: "cpu", Timestamp: base.Add(time.Duration(i) * 5 * time.Minute), Resolution: storage.Resolution5m, Type: metrics.GaugeType, Sum: 1, Count: 1, Min: 1, Max: 1}
+		m := agg.ToMetric()
+		if i == 1 {
+			m.Aggregate.Sketch = []byte{0xff}
+		}
+		batch = append(batch, m)
+	}
+	store.Write(ctx, batch)
+
+	compactor := New(store)
+	if err := compactor.checkpoint.advance(storage.Resolution5m, now); err != nil {
+		t.Fatalf("advance failed: %v", err)
+	}
+	if _, err := compactor.compactTier(ctx, 2, now); err == nil {
+		t.Fatal("Expected compaction to fail on the malformed aggregate")
+	}
+
+	// Nothing was compacted without it, and the watermark stays put
+	if wm := compactor.checkpoint.Watermark(storage.Resolution1h); !wm.IsZero() && !wm.Before(base.Add(time.Hour)) {
+		t.Errorf("1h watermark moved past the malformed aggregate's bucket: %v", wm)
+	}
+	results, err := store.Query(ctx, storage.QueryRequest{Start: base, End: base.Add(time.Hour), Labels: map[string]string{"__resolution__": "1h"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Got %d 1h aggregates, want none written without the malformed source", len(results))
+	}
+}
+
+func TestBucketStart_5m(t *testing.T) {
 	tests := []struct {
 		input    time.Time
 		expected time.Time
@@ -281,15 +814,15 @@ func TestRoundTo5Minutes(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result := roundTo5Minutes(test.input)
+		result := bucketStart(test.input, storage.Resolution5m)
 		if !result.Equal(test.expected) {
-			t.Errorf("roundTo5Minutes(%v) = %v, expected %v",
+			t.Errorf("bucketStart(%v, 5m) = %v, expected %v",
 				test.input, result, test.expected)
 		}
 	}
 }
 
-func TestRoundTo1Hour(t *testing.T) {
+func TestBucketStart_1h(t *testing.T) {
 	tests := []struct {
 		input    time.Time
 		expected time.Time
@@ -309,9 +842,9 @@ func TestRoundTo1Hour(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result := roundTo1Hour(test.input)
+		result := bucketStart(test.input, storage.Resolution1h)
 		if !result.Equal(test.expected) {
-			t.Errorf("roundTo1Hour(%v) = %v, expected %v",
+			t.Errorf("bucketStart(%v, 1h) = %v, expected %v",
 				test.input, result, test.expected)
 		}
 	}
@@ -14,7 +14,8 @@ Downsampling aggregates old data into larger time buckets, reducing storage by 2
 
 # How TinyObs Compaction Works
 
-TinyObs uses a three-tier retention strategy:
+By default TinyObs uses a three-tier retention strategy (the ladder is
+configurable, see Tier and LoadTiers):
 
 	┌─────────────────────────────────────────────────────────────┐
 	│ Raw Data (0-14 days)                                        │
@@ -55,12 +56,26 @@ Why all five? Different metrics need different aggregates:
   - Error rate: Use Max (peak errors in window)
   - Response time: Use Min/Max (best/worst case latency)
 
+Counters additionally store their first and last value in the bucket and
+their increase in between, adjusted for counter resets, so rate() and
+increase() keep working once raw data is deleted.
+
+Gauges additionally store a quantile sketch (storage.Sketch, a DDSketch)
+of their values. Sketches merge exactly, so 1h aggregates keep the
+distribution of the whole hour, and quantile_over_time() answers within 1%
+over months of compacted data.
+
+Histogram series (name_bucket, name_sum and name_count) hold per-flush
+counts, so their aggregates are read back as the bucket's Sum. Summing the
+buckets over a window still gives histogram_quantile() the right input.
+
 # Usage Example
 
 	import (
 	    "context"
 	    "time"
 	    "github.com/nicktill/tinyobs/pkg/compaction"
+	    "github.com/nicktill/tinyobs/pkg/storage"
 	    "github.com/nicktill/tinyobs/pkg/storage/badger"
 	)
 
@@ -70,15 +85,26 @@ Why all five? Different metrics need different aggregates:
 
 	// Compact raw data into 5-minute aggregates
 	// This runs hourly in production
-	start := time.Now().Add(-24 * time.Hour)
-	end := time.Now()
-	err := compactor.Compact5m(context.Background(), start, end)
+	start := time.Now().Add(-24 * time.Hour).Truncate(time.Hour)
+	end := time.Now().Truncate(time.Hour)
+	err := compactor.CompactTier(context.Background(), storage.Resolution5m, start, end)
 
 	// Compact 5-minute aggregates into 1-hour aggregates
 	// This also runs hourly for older data
-	err = compactor.Compact1h(context.Background(), start, end)
+	err = compactor.CompactTier(context.Background(), storage.Resolution1h, start, end)
+
+A custom ladder adds tiers, e.g. daily rollups kept for a year:
 
-# How Compact5m Works
+	tiers := append(compaction.DefaultTiers(), compaction.Tier{
+	    Resolution: "1d",
+	    Delay:      2 * 24 * time.Hour,
+	    Retention:  365 * 24 * time.Hour,
+	})
+	err = compactor.SetTiers(tiers)
+	policy, err := compaction.RetentionPolicy(tiers, "")
+	compactor.SetRetention(policy)
+
+# Compacting Raw Data (5m)
 
 Input (raw metrics, 1-second intervals):
 
@@ -91,12 +117,12 @@ Input (raw metrics, 1-second intervals):
 
 Output (5-minute aggregate):
 
-	2024-11-19 10:00:00  cpu_sum=13,575  cpu_count=300  cpu_min=42.0  cpu_max=48.1  cpu_avg=45.25
-	__resolution__=5m
+	2024-11-19 10:00:00  cpu=45.25 {__resolution__="5m"}
+	aggregate: sum=13,575  count=300  min=42.0  max=48.1
 
 Result: 300 data points → 1 aggregate (99.7% reduction)
 
-# How Compact1h Works
+# Compacting Aggregates (1h)
 
 Input (5-minute aggregates):
 
@@ -107,8 +133,8 @@ Input (5-minute aggregates):
 
 Output (1-hour aggregate):
 
-	2024-11-19 10:00:00  cpu_sum=162,900  cpu_count=3,600  cpu_min=42.0  cpu_max=52.3  cpu_avg=45.25
-	__resolution__=1h
+	2024-11-19 10:00:00  cpu=45.25 {__resolution__="1h"}
+	aggregate: sum=162,900  count=3,600  min=42.0  max=52.3
 
 Result: 12 aggregates → 1 aggregate (92% further reduction)
 
@@ -134,6 +160,35 @@ Example timeline for a metric written at 10:00 AM on Nov 19:
   - Feb 17 10:00 AM: 5m aggregate deleted (90 days)
   - Nov 19 next year: 1h aggregate deleted (1 year)
 
+# Checkpoints
+
+CompactAndCleanup keeps a watermark per tier (see Checkpoint): the end of
+the last bucket it wrote. Each run compacts the completed buckets from the
+watermark on, in steps, and moves the watermark after every step. A run
+that fails part way is picked up where it stopped, and repeated runs don't
+rewrite buckets that are already done.
+
+Retention is held back to the watermarks: raw samples are only deleted once
+they are in the first aggregate tier, and each tier once it is in the next.
+
+	checkpoint, err := compaction.OpenCheckpoint("data/compaction.json")
+	compactor.SetCheckpoint(checkpoint)
+
+# Runs
+
+CompactAndCleanup is a full Run. Run also takes RunOptions to compact a
+single tier, or to re-compact a range without moving the watermarks, and
+reports each tier's buckets written and the samples retention deleted:
+
+	run, err := compactor.Run(ctx, compaction.RunOptions{
+	    Tier:  storage.Resolution5m,
+	    Start: time.Now().Add(-24 * time.Hour),
+	})
+	log.Printf("wrote %d buckets in %s", run.Tiers[0].BucketsWritten, run.Duration)
+
+Runs are serialized, so a run requested through the admin API never
+overlaps the scheduled one.
+
 # Performance Impact
 
 Compaction is expensive (CPU + I/O), so TinyObs runs it hourly, not continuously.
@@ -144,6 +199,18 @@ Typical compaction metrics (10,000 series):
   - Storage I/O: 100-500 MB read + 50-200 MB write
   - Memory: <100 MB
 
+Memory doesn't grow with the number of series: the source tier is read one
+series at a time (storage.ScanSeries) and aggregates are written in batches
+of 1000.
+
+SetWorkers shards each step by metric name across a worker pool. Every
+series belongs to one shard and aggregates don't depend on which worker
+wrote them, so the result matches a serial run. Memory grows with the
+workers, each holding one series and one batch. A failed shard doesn't
+stop the others, but fails the step (its watermark stays put) and is
+listed in Run.FailedShards as a ShardError; cancelling ctx stops the
+workers after their current shards.
+
 # Best Practices
 
 1. Run compaction during low-traffic periods if possible
@@ -161,13 +228,14 @@ Compaction can fail for several reasons:
 
 Always check errors and log them:
 
-	err := compactor.Compact5m(ctx, start, end)
+	err := compactor.CompactTier(ctx, storage.Resolution5m, start, end)
 	if err != nil {
 	    log.Errorf("5m compaction failed: %v", err)
 	    // Don't panic - compaction will retry next hour
 	}
 
 Compaction is idempotent: running it twice on the same time range is safe.
+CompactAndCleanup also skips ranges its watermarks have already passed.
 
 # See Also
 
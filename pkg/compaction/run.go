@@ -0,0 +1,224 @@
+package compaction
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// RunOptions narrows a compaction run. The zero value is a full run: every
+// tier resumes at its watermark, then retention is enforced.
+type RunOptions struct {
+	// Tier compacts only this tier ("" = every tier)
+	Tier storage.Resolution
+
+	// Start and End re-compact the buckets overlapping [Start, End) instead
+	// of resuming at the watermarks (zero End = now). Watermarks don't move
+	// and retention isn't enforced, so a range can be re-run safely.
+	Start time.Time
+	End   time.Time
+}
+
+// Run describes one compaction run
+type Run struct {
+	Trigger        string    `json:"trigger,omitempty"` // Set by the caller ("scheduled", "manual")
+	StartedAt      time.Time `json:"started_at"`
+	Duration       string    `json:"duration"`
+	Tiers          []TierRun `json:"tiers"`
+	SamplesDeleted int       `json:"samples_deleted"` // By retention, after compaction
+	Error          string    `json:"error,omitempty"`
+
+	FailedShards []ShardFailure `json:"failed_shards,omitempty"` // Parallel runs only
+}
+
+// TierRun describes what a run compacted into one tier
+type TierRun struct {
+	Tier           string `json:"tier"`
+	Duration       string `json:"duration"`
+	BucketsWritten int    `json:"buckets_written"`
+}
+
+// ValidateRun checks run options against the compactor's ladder and, for a
+// ranged run, that the source data of the buckets in range is still stored:
+// re-compacting a bucket whose source data was partly deleted would
+// overwrite its aggregate with a partial one.
+func (c *Compactor) ValidateRun(ctx context.Context, opts RunOptions) error {
+	if err := c.validateOptions(opts); err != nil {
+		return err
+	}
+	if opts.Start.IsZero() {
+		return nil
+	}
+	stats, err := c.storage.Stats(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to find source data: %w", err)
+	}
+	now := time.Now()
+	for i := 1; i < len(c.tiers); i++ {
+		t := c.tiers[i]
+		if opts.Tier != "" && t.Resolution != opts.Tier {
+			continue
+		}
+		floor := c.sourceFloor(stats, i, now)
+		if !floor.IsZero() && bucketStart(opts.Start, t.Resolution).Before(floor) {
+			return fmt.Errorf("%s data before %s has been deleted: re-compact %s from %s on",
+				tierName(c.tiers[i-1].Resolution), floor.UTC().Format(time.RFC3339), t.Resolution, floor.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// validateOptions checks run options against the compactor's ladder
+func (c *Compactor) validateOptions(opts RunOptions) error {
+	if opts.Tier != "" {
+		if _, ok := c.sourceOf(opts.Tier); !ok {
+			return fmt.Errorf("resolution %q is not an aggregate tier", opts.Tier)
+		}
+	}
+	if opts.Start.IsZero() && !opts.End.IsZero() {
+		return fmt.Errorf("end requires start")
+	}
+	if !opts.Start.IsZero() && !opts.End.IsZero() && !opts.End.After(opts.Start) {
+		return fmt.Errorf("invalid time range: end (%v) must be after start (%v)", opts.End, opts.Start)
+	}
+	return nil
+}
+
+// Run compacts the tiers selected by opts and reports what it did. Runs are
+// serialized: a run started while another is in progress waits for it. The
+// report is returned even when the run fails, covering the tiers it got to.
+// A ranged run skips the buckets whose source data has been deleted since it
+// was requested.
+func (c *Compactor) Run(ctx context.Context, opts RunOptions) (*Run, error) {
+	if err := c.validateOptions(opts); err != nil {
+		return nil, err
+	}
+
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	now := time.Now()
+	run := &Run{StartedAt: now, Tiers: []TierRun{}}
+	err := c.run(ctx, opts, now, run)
+	run.Duration = time.Since(now).Round(time.Millisecond).String()
+	if err != nil {
+		run.Error = err.Error()
+		run.FailedShards = shardFailures(err)
+	}
+	return run, err
+}
+
+// run compacts each selected tier in order and, for a full run, enforces
+// retention, recording the results in run
+func (c *Compactor) run(ctx context.Context, opts RunOptions, now time.Time, run *Run) error {
+	for i := 1; i < len(c.tiers); i++ {
+		t := c.tiers[i]
+		if opts.Tier != "" && t.Resolution != opts.Tier {
+			continue
+		}
+
+		tierStart := time.Now()
+		var buckets int
+		var err error
+		if opts.Start.IsZero() {
+			buckets, err = c.compactTier(ctx, i, now)
+		} else {
+			buckets, err = c.recompactTier(ctx, i, opts.Start, opts.End, now)
+		}
+		run.Tiers = append(run.Tiers, TierRun{
+			Tier:           string(t.Resolution),
+			Duration:       time.Since(tierStart).Round(time.Millisecond).String(),
+			BucketsWritten: buckets,
+		})
+		if err != nil {
+			return fmt.Errorf("%s compaction failed: %w", t.Resolution, err)
+		}
+	}
+
+	if opts.Tier != "" || !opts.Start.IsZero() {
+		return nil // Partial runs leave retention to the next full run
+	}
+
+	// Enforce retention, held back to what has been rolled up
+	report, err := c.retention.EnforceUntil(ctx, c.storage, now, c.RolledUp())
+	if report != nil {
+		for _, d := range report.Deletions {
+			run.SamplesDeleted += d.Samples
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("retention enforcement failed: %w", err)
+	}
+	return nil
+}
+
+// recompactTier re-compacts the ith tier's completed buckets overlapping
+// [start, end), a step at a time, without moving its watermark. Buckets
+// before the source tier's intact data are left alone.
+func (c *Compactor) recompactTier(ctx context.Context, i int, start, end, now time.Time) (int, error) {
+	t := c.tiers[i]
+	if end.IsZero() {
+		end = now
+	}
+	stats, err := c.storage.Stats(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find %s data: %w", tierName(c.tiers[i-1].Resolution), err)
+	}
+	start = bucketStart(start, t.Resolution)
+	if floor := c.sourceFloor(stats, i, now); start.Before(floor) {
+		start = floor
+	}
+	if aligned := bucketStart(end, t.Resolution); aligned.Before(end) {
+		end = aligned.Add(t.Resolution.Duration())
+	}
+	if until := c.compactableUntil(i, now); until.Before(end) {
+		end = until // Never write incomplete buckets
+	}
+
+	var buckets int
+	step := t.Resolution.Duration() * compactStepBuckets
+	for start.Before(end) {
+		if err := ctx.Err(); err != nil {
+			return buckets, err
+		}
+		stepEnd := start.Add(step)
+		if stepEnd.After(end) {
+			stepEnd = end
+		}
+		n, err := c.compactRange(ctx, t.Resolution, start, stepEnd)
+		buckets += n
+		if err != nil {
+			return buckets, err
+		}
+		start = stepEnd
+	}
+	return buckets, nil
+}
+
+// sourceFloor returns the start of the ith tier's oldest bucket whose source
+// data is intact, or zero if the source tier is empty: the first bucket
+// starting at or after both the source tier's oldest surviving data (which
+// may be what's left of a partly deleted bucket) and its retention cutoff,
+// held back like retention to what the tier has rolled up.
+func (c *Compactor) sourceFloor(stats *storage.Stats, i int, now time.Time) time.Time {
+	t, source := c.tiers[i], c.tiers[i-1]
+	floor := stats.Resolutions[source.Resolution].Oldest
+	if floor.IsZero() {
+		return time.Time{}
+	}
+	if source.Retention > 0 {
+		cutoff := now.Add(-source.Retention)
+		if watermark := c.checkpoint.Watermark(t.Resolution); watermark.Before(cutoff) {
+			cutoff = watermark
+		}
+		if cutoff.After(floor) {
+			floor = cutoff
+		}
+	}
+	if aligned := bucketStart(floor, t.Resolution); aligned.Before(floor) {
+		floor = aligned.Add(t.Resolution.Duration())
+	}
+	return floor
+}
@@ -0,0 +1,147 @@
+package compaction
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/memory"
+)
+
+func TestRun_Report(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	// 20 hours of a gauge, one sample every 10 minutes
+	now := time.Now()
+	oldest := now.Add(-20 * time.Hour)
+	var raw []metrics.Metric
+	for ts := oldest; ts.Before(now); ts = ts.Add(10 * time.Minute) {
+		raw = append(raw, metrics.Metric{Name: "cpu", Value: 1, Timestamp: ts})
+	}
+	store.Write(ctx, raw)
+
+	compactor := New(store)
+
+	// A ranged run re-compacts completed buckets only, without moving the
+	// watermark or deleting anything
+	start := bucketStart(oldest, storage.Resolution5m).Add(time.Hour)
+	run, err := compactor.Run(ctx, RunOptions{Tier: storage.Resolution5m, Start: start, End: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Ranged run failed: %v", err)
+	}
+	if len(run.Tiers) != 1 || run.Tiers[0].BucketsWritten != 6 || run.SamplesDeleted != 0 {
+		t.Errorf("Ranged run = %+v, want the 6 buckets of one hour", run)
+	}
+	if !compactor.Checkpoint().Watermark(storage.Resolution5m).IsZero() {
+		t.Error("Ranged run moved the watermark")
+	}
+
+	// A full run resumes at the watermarks and enforces retention
+	run, err = compactor.Run(ctx, RunOptions{})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(run.Tiers) != 2 || run.Tiers[0].Tier != "5m" || run.Tiers[1].Tier != "1h" {
+		t.Fatalf("Tiers = %+v, want 5m and 1h", run.Tiers)
+	}
+	if run.Tiers[0].BucketsWritten == 0 {
+		t.Error("No 5m buckets reported")
+	}
+	if run.Tiers[1].BucketsWritten != 0 {
+		t.Errorf("1h buckets = %d, want none before the 1h delay", run.Tiers[1].BucketsWritten)
+	}
+	if run.SamplesDeleted == 0 || run.Duration == "" || run.Error != "" {
+		t.Errorf("Run = %+v, want raw samples deleted by retention", run)
+	}
+	results, _ := store.Query(ctx, storage.QueryRequest{End: now})
+	var aggregates int
+	for _, m := range results {
+		if storage.ParseAggregate(m) != nil {
+			aggregates++
+		}
+	}
+	if aggregates != run.Tiers[0].BucketsWritten || len(raw)-run.SamplesDeleted+aggregates != len(results) {
+		t.Errorf("Report %+v doesn't match the %d aggregates and %d samples stored", run, aggregates, len(results))
+	}
+}
+
+func TestRun_SourcePartlyDeleted(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	// 20 hours of a gauge, one sample a minute, from a bucket boundary
+	now := time.Now()
+	oldest := bucketStart(now.Add(-20*time.Hour), storage.Resolution5m)
+	var raw []metrics.Metric
+	for ts := oldest; ts.Before(now); ts = ts.Add(time.Minute) {
+		raw = append(raw, metrics.Metric{Name: "cpu", Value: 1, Timestamp: ts})
+	}
+	store.Write(ctx, raw)
+
+	compactor := New(store)
+	opts := RunOptions{Tier: storage.Resolution5m, Start: oldest, End: oldest.Add(time.Hour)}
+	if _, err := compactor.Run(ctx, opts); err != nil {
+		t.Fatalf("Ranged run failed: %v", err)
+	}
+
+	// The first bucket loses its first 2 samples
+	rawTier := storage.ResolutionRaw
+	if err := store.Delete(ctx, storage.DeleteOptions{Before: oldest.Add(2 * time.Minute), Resolution: &rawTier}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if err := compactor.ValidateRun(ctx, opts); err == nil {
+		t.Error("Re-run over deleted source data accepted")
+	}
+	later := opts
+	later.Start = oldest.Add(5 * time.Minute)
+	if err := compactor.ValidateRun(ctx, later); err != nil {
+		t.Errorf("Re-run over intact source data rejected: %v", err)
+	}
+
+	// A run requested before the deletion leaves the first bucket alone
+	run, err := compactor.Run(ctx, opts)
+	if err != nil {
+		t.Fatalf("Re-run failed: %v", err)
+	}
+	if run.Tiers[0].BucketsWritten != 11 {
+		t.Errorf("Re-run wrote %d buckets, want the 11 after the first", run.Tiers[0].BucketsWritten)
+	}
+	results, err := store.Query(ctx, storage.QueryRequest{Start: oldest, End: oldest.Add(time.Minute), Labels: map[string]string{"__resolution__": "5m"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Got %d aggregates for the first bucket, want 1", len(results))
+	}
+	if agg := storage.ParseAggregate(results[0]); agg == nil || agg.Count != 5 {
+		t.Errorf("First bucket = %+v, want its 5 original samples", agg)
+	}
+}
+
+func TestValidateRun(t *testing.T) {
+	compactor := New(memory.New())
+	now := time.Now()
+	tests := []struct {
+		name string
+		opts RunOptions
+	}{
+		{"raw tier", RunOptions{Tier: "raw"}},
+		{"unknown tier", RunOptions{Tier: "1d"}},
+		{"end without start", RunOptions{End: now}},
+		{"inverted range", RunOptions{Start: now, End: now.Add(-time.Hour)}},
+	}
+	for _, test := range tests {
+		if err := compactor.ValidateRun(context.Background(), test.opts); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+	if err := compactor.ValidateRun(context.Background(), RunOptions{Tier: storage.Resolution1h, Start: now.Add(-time.Hour)}); err != nil {
+		t.Errorf("Valid options rejected: %v", err)
+	}
+}
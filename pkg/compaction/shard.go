@@ -0,0 +1,125 @@
+package compaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// SetWorkers sets how many shards (metric names) each compaction step
+// works on in parallel. 1, the default, compacts serially. Memory grows
+// with the workers: each holds one series and one write batch.
+func (c *Compactor) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	c.workers = workers
+}
+
+// Workers returns how many shards are compacted in parallel
+func (c *Compactor) Workers() int {
+	return c.workers
+}
+
+// ShardError is the failure of one shard (metric name) of a parallel
+// compaction step. The other shards are still compacted, but the step
+// fails, so its watermark doesn't move and the next run retries it.
+type ShardError struct {
+	Tier  storage.Resolution
+	Shard string
+	Err   error
+}
+
+func (e *ShardError) Error() string {
+	return fmt.Sprintf("%s shard %s: %v", e.Tier, e.Shard, e.Err)
+}
+
+func (e *ShardError) Unwrap() error {
+	return e.Err
+}
+
+// ShardFailure reports a failed shard in a run
+type ShardFailure struct {
+	Tier  string `json:"tier"`
+	Shard string `json:"shard"`
+	Error string `json:"error"`
+}
+
+// compactShards compacts the source series matching req on c.workers
+// workers, one metric name at a time. Aggregates don't depend on which
+// worker wrote them, so the result is the same as a serial run. Cancelling
+// ctx stops the workers after the shards they are on.
+func (c *Compactor) compactShards(ctx context.Context, job compactJob, req storage.QueryRequest, names []string) (int, error) {
+	shards := make(chan string)
+	var mu sync.Mutex
+	var written int
+	var failed []*ShardError
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(c.workers, len(names)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range shards {
+				shard := req
+				shard.MetricNames = []string{name}
+				n, err := c.compactSeries(ctx, job, shard)
+
+				mu.Lock()
+				written += n
+				if err != nil && ctx.Err() == nil {
+					failed = append(failed, &ShardError{Tier: job.res, Shard: name, Err: err})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, name := range names {
+		select {
+		case shards <- name:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(shards)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return written, err
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Shard < failed[j].Shard })
+	errs := make([]error, len(failed))
+	for i, err := range failed {
+		errs[i] = err
+	}
+	return written, errors.Join(errs...)
+}
+
+// shardFailures lists the shard errors wrapped in err
+func shardFailures(err error) []ShardFailure {
+	var out []ShardFailure
+	var walk func(err error)
+	walk = func(err error) {
+		switch e := err.(type) {
+		case nil:
+		case *ShardError:
+			out = append(out, ShardFailure{Tier: string(e.Tier), Shard: e.Shard, Error: e.Err.Error()})
+		case interface{ Unwrap() []error }:
+			for _, err := range e.Unwrap() {
+				walk(err)
+			}
+		case interface{ Unwrap() error }:
+			walk(e.Unwrap())
+		}
+	}
+	walk(err)
+	return out
+}
@@ -0,0 +1,171 @@
+package compaction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/memory"
+)
+
+// shardTestData is 6 hours of gauges, counters and a histogram across
+// several metric names and label sets
+func shardTestData(base time.Time) []metrics.Metric {
+	var raw []metrics.Metric
+	for i := 0; i < 360; i++ {
+		ts := base.Add(time.Duration(i) * time.Minute)
+		for n := 0; n < 8; n++ {
+			for _, host := range []string{"a", "b", "c"} {
+				raw = append(raw, metrics.Metric{
+					Name: fmt.Sprintf("gauge_%d", n), Type: metrics.GaugeType,
+					Value: float64((i*7+n)%50) + 0.25, Labels: map[string]string{"host": host}, Timestamp: ts,
+				})
+			}
+			raw = append(raw, metrics.Metric{
+				Name: fmt.Sprintf("requests_%d_total", n), Type: metrics.CounterType,
+				Value: float64(i % 100 * (n + 1)), Timestamp: ts, // Resets every 100 minutes
+			})
+		}
+		raw = append(raw,
+			metrics.Metric{Name: "latency_bucket", Value: float64(i), Labels: map[string]string{"le": "0.5"}, Timestamp: ts},
+			metrics.Metric{Name: "latency_bucket", Value: float64(2 * i), Labels: map[string]string{"le": "+Inf"}, Timestamp: ts},
+		)
+	}
+	return raw
+}
+
+// dump returns everything stored, canonically encoded and sorted
+func dump(t *testing.T, store storage.Storage, start, end time.Time) []string {
+	t.Helper()
+	results, err := store.Query(context.Background(), storage.QueryRequest{Start: start, End: end})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	out := make([]string, len(results))
+	for i, m := range results {
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		out[i] = string(b)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestCompactShards_MatchesSerial(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	start, end := base.Add(-time.Hour), base.Add(7*time.Hour)
+
+	compact := func(workers int) []string {
+		store := memory.New()
+		defer store.Close()
+		store.Write(ctx, shardTestData(base))
+
+		compactor := New(store)
+		compactor.SetWorkers(workers)
+		for _, res := range []storage.Resolution{storage.Resolution5m, storage.Resolution1h} {
+			if err := compactor.CompactTier(ctx, res, start, end); err != nil {
+				t.Fatalf("%s compaction with %d workers failed: %v", res, workers, err)
+			}
+		}
+		return dump(t, store, start, end)
+	}
+
+	serial := compact(1)
+	for _, workers := range []int{2, 4, 32} {
+		parallel := compact(workers)
+		if len(parallel) != len(serial) {
+			t.Fatalf("%d workers stored %d samples, serial run %d", workers, len(parallel), len(serial))
+		}
+		for i := range serial {
+			if parallel[i] != serial[i] {
+				t.Fatalf("%d workers differ from the serial run:\n got %s\nwant %s", workers, parallel[i], serial[i])
+			}
+		}
+	}
+}
+
+// shardStore fails writes for one metric name and cancels a context after
+// the first write, recording the names written
+type shardStore struct {
+	storage.Storage
+	fail   string
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	written map[string]bool
+}
+
+func (s *shardStore) Write(ctx context.Context, batch []metrics.Metric) error {
+	if len(batch) > 0 && batch[0].Name == s.fail {
+		return errors.New("disk full")
+	}
+	s.mu.Lock()
+	for _, m := range batch {
+		s.written[m.Name] = true
+	}
+	s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return s.Storage.Write(ctx, batch)
+}
+
+func TestCompactShards_FailedShard(t *testing.T) {
+	ctx := context.Background()
+	base := time.Now().Add(-12 * time.Hour).Truncate(time.Hour)
+	store := &shardStore{Storage: memory.New(), fail: "gauge_3", written: make(map[string]bool)}
+	defer store.Close()
+	store.Write(ctx, shardTestData(base))
+	store.written = make(map[string]bool)
+
+	compactor := New(store)
+	compactor.SetWorkers(4)
+	run, err := compactor.Run(ctx, RunOptions{Tier: storage.Resolution5m, Start: base, End: base.Add(6 * time.Hour)})
+	if err == nil {
+		t.Fatal("Expected the failed shard to fail the run")
+	}
+	var shardErr *ShardError
+	if !errors.As(err, &shardErr) || shardErr.Shard != "gauge_3" || shardErr.Tier != storage.Resolution5m {
+		t.Errorf("Error = %v, want a ShardError for gauge_3", err)
+	}
+	if len(run.FailedShards) != 1 || run.FailedShards[0].Shard != "gauge_3" || run.FailedShards[0].Tier != "5m" {
+		t.Errorf("FailedShards = %+v, want gauge_3", run.FailedShards)
+	}
+
+	// The other shards were still compacted
+	if len(store.written) != 16 || store.written["gauge_3"] {
+		t.Errorf("Wrote %d metric names (%v), want all 16 others", len(store.written), store.written)
+	}
+}
+
+func TestCompactShards_Cancel(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &shardStore{Storage: memory.New(), written: make(map[string]bool)}
+	defer store.Close()
+	store.Write(context.Background(), shardTestData(base))
+	store.written = make(map[string]bool)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store.cancel = cancel
+
+	compactor := New(store)
+	compactor.SetWorkers(2)
+	err := compactor.CompactTier(ctx, storage.Resolution5m, base, base.Add(6*time.Hour))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Error = %v, want context.Canceled", err)
+	}
+	if len(store.written) >= 17 {
+		t.Errorf("Wrote %d metric names after cancellation, want fewer than all", len(store.written))
+	}
+}
@@ -0,0 +1,178 @@
+package compaction
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/nicktill/tinyobs/pkg/retention"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// Tier is one rung of the downsampling ladder. The first tier is raw data;
+// every other tier is compacted from the one before it.
+type Tier struct {
+	// Resolution is the tier's bucket length (raw for the first tier)
+	Resolution storage.Resolution
+
+	// Delay is how long after a bucket ends it is compacted, so late
+	// samples have arrived
+	Delay time.Duration
+
+	// Lookback limits how far back compaction reaches: after a long outage,
+	// older buckets are skipped instead of caught up (0 = from the oldest data)
+	Lookback time.Duration
+
+	// Retention is how long the tier is kept (0 = forever)
+	Retention time.Duration
+}
+
+// DefaultTiers returns the built-in ladder: raw data for 6 hours, 5m
+// aggregates for 7 days and 1h aggregates forever
+func DefaultTiers() []Tier {
+	return []Tier{
+		{Resolution: storage.ResolutionRaw, Retention: retention.DefaultRawRetention},
+		{Resolution: storage.Resolution5m, Delay: 6 * time.Hour, Retention: retention.Default5mRetention},
+		{Resolution: storage.Resolution1h, Delay: 2 * 24 * time.Hour, Retention: retention.Default1hRetention},
+	}
+}
+
+// ValidateTiers checks a ladder: raw data first, then coarser and coarser
+// resolutions, each a multiple of the one before so buckets nest
+func ValidateTiers(tiers []Tier) error {
+	if len(tiers) == 0 || tiers[0].Resolution != storage.ResolutionRaw {
+		return fmt.Errorf("the first tier must be raw")
+	}
+	for i, t := range tiers {
+		name := tierName(t.Resolution)
+		if t.Delay < 0 || t.Lookback < 0 || t.Retention < 0 {
+			return fmt.Errorf("tier %s: durations must not be negative", name)
+		}
+		if i == 0 {
+			continue
+		}
+		d, prev := t.Resolution.Duration(), tiers[i-1].Resolution.Duration()
+		if d == 0 {
+			return fmt.Errorf("tier %d: invalid resolution %q", i+1, t.Resolution)
+		}
+		if d <= prev {
+			return fmt.Errorf("tier %s must be coarser than %s", name, tierName(tiers[i-1].Resolution))
+		}
+		if prev > 0 && d%prev != 0 {
+			return fmt.Errorf("tier %s is not a multiple of %s", name, tiers[i-1].Resolution)
+		}
+	}
+	return nil
+}
+
+// tiersFile is the YAML layout of a tier ladder:
+//
+//	tiers:
+//	  - resolution: raw
+//	    retention: 2d
+//	  - resolution: 1m
+//	    delay: 1h
+//	    retention: 7d
+//	  - resolution: 15m
+//	    delay: 6h
+//	    retention: 90d
+//	  - resolution: 1d
+//	    delay: 2d
+//	    lookback: 1y
+//	    retention: 1y
+type tiersFile struct {
+	Tiers []struct {
+		Resolution string `yaml:"resolution"`
+		Delay      string `yaml:"delay"`
+		Lookback   string `yaml:"lookback"`
+		Retention  string `yaml:"retention"`
+	} `yaml:"tiers"`
+}
+
+// LoadTiers reads a YAML tier ladder. Durations accept the retention
+// syntax ("90d", "1y", "forever" for retention).
+func LoadTiers(path string) ([]Tier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tiers: %w", err)
+	}
+
+	var cfg tiersFile
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse tiers %s: %w", path, err)
+	}
+
+	// optional parses a duration that defaults to 0
+	optional := func(s string) (time.Duration, error) {
+		if s == "" {
+			return 0, nil
+		}
+		return retention.ParseDuration(s)
+	}
+
+	tiers := make([]Tier, 0, len(cfg.Tiers))
+	for i, t := range cfg.Tiers {
+		res, err := storage.ParseResolution(t.Resolution)
+		if err != nil {
+			return nil, fmt.Errorf("tier %d: %w", i+1, err)
+		}
+		tier := Tier{Resolution: res}
+		if tier.Delay, err = optional(t.Delay); err != nil {
+			return nil, fmt.Errorf("tier %s: delay: %w", t.Resolution, err)
+		}
+		if tier.Lookback, err = optional(t.Lookback); err != nil {
+			return nil, fmt.Errorf("tier %s: lookback: %w", t.Resolution, err)
+		}
+		if tier.Retention, err = retention.ParseDuration(t.Retention); err != nil {
+			return nil, fmt.Errorf("tier %s: retention: %w", t.Resolution, err)
+		}
+		tiers = append(tiers, tier)
+	}
+
+	if err := ValidateTiers(tiers); err != nil {
+		return nil, fmt.Errorf("invalid tiers %s: %w", path, err)
+	}
+	return tiers, nil
+}
+
+// RetentionPolicy builds the retention policy for a ladder: each tier is
+// kept for its retention, and deletion runs over the ladder's tiers. A
+// policy file (optional) adds rules, and its defaults override the tiers'.
+func RetentionPolicy(tiers []Tier, policyFile string) (*retention.Policy, error) {
+	defaults := make(map[storage.Resolution]time.Duration, len(tiers))
+	for _, t := range tiers {
+		defaults[t.Resolution] = t.Retention
+	}
+
+	var policy *retention.Policy
+	var err error
+	if policyFile != "" {
+		policy, err = retention.LoadFileWithDefaults(policyFile, defaults)
+	} else {
+		policy, err = retention.NewPolicy(defaults, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy.Resolutions = resolutions(tiers)
+	return policy, nil
+}
+
+// resolutions lists a ladder's resolutions, finest first
+func resolutions(tiers []Tier) []storage.Resolution {
+	out := make([]storage.Resolution, len(tiers))
+	for i, t := range tiers {
+		out[i] = t.Resolution
+	}
+	return out
+}
+
+// tierName formats a resolution for errors and logs ("raw" instead of "")
+func tierName(res storage.Resolution) string {
+	if res == storage.ResolutionRaw {
+		return "raw"
+	}
+	return string(res)
+}
@@ -0,0 +1,162 @@
+package compaction
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/memory"
+)
+
+func TestLoadTiers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tiers.yaml")
+	os.WriteFile(path, []byte(`
+tiers:
+  - resolution: raw
+    retention: 2d
+  - resolution: 1m
+    delay: 1h
+    retention: 7d
+  - resolution: 15m
+    delay: 6h
+    retention: 90d
+  - resolution: 1d
+    delay: 2d
+    lookback: 1y
+    retention: 1y
+`), 0o644)
+
+	tiers, err := LoadTiers(path)
+	if err != nil {
+		t.Fatalf("LoadTiers failed: %v", err)
+	}
+	day := 24 * time.Hour
+	want := []Tier{
+		{Resolution: storage.ResolutionRaw, Retention: 2 * day},
+		{Resolution: "1m", Delay: time.Hour, Retention: 7 * day},
+		{Resolution: "15m", Delay: 6 * time.Hour, Retention: 90 * day},
+		{Resolution: "1d", Delay: 2 * day, Lookback: 365 * day, Retention: 365 * day},
+	}
+	if len(tiers) != len(want) {
+		t.Fatalf("Got %d tiers, want %d", len(tiers), len(want))
+	}
+	for i := range want {
+		if tiers[i] != want[i] {
+			t.Errorf("Tier %d = %+v, want %+v", i, tiers[i], want[i])
+		}
+	}
+
+	// Tier retention is the default, the policy file overrides it
+	policyPath := filepath.Join(t.TempDir(), "retention.yaml")
+	os.WriteFile(policyPath, []byte("defaults:\n  15m: 30d\n"), 0o644)
+	policy, err := RetentionPolicy(tiers, policyPath)
+	if err != nil {
+		t.Fatalf("RetentionPolicy failed: %v", err)
+	}
+	if policy.Defaults["1d"] != 365*day || policy.Defaults["15m"] != 30*day {
+		t.Errorf("Defaults = %v, want 1d from the tiers and 15m from the file", policy.Defaults)
+	}
+	if len(policy.Resolutions) != 4 || policy.Resolutions[3] != "1d" {
+		t.Errorf("Resolutions = %v, want the ladder's", policy.Resolutions)
+	}
+}
+
+func TestValidateTiers(t *testing.T) {
+	raw := Tier{Resolution: storage.ResolutionRaw}
+	tests := []struct {
+		name  string
+		tiers []Tier
+	}{
+		{"empty", nil},
+		{"raw not first", []Tier{{Resolution: "5m"}}},
+		{"not coarser", []Tier{raw, {Resolution: "1h"}, {Resolution: "60m"}}},
+		{"not nested", []Tier{raw, {Resolution: "10m"}, {Resolution: "15m"}}},
+		{"malformed", []Tier{raw, {Resolution: "daily"}}},
+		{"negative delay", []Tier{raw, {Resolution: "5m", Delay: -time.Hour}}},
+	}
+	for _, test := range tests {
+		if err := ValidateTiers(test.tiers); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+	if err := ValidateTiers(DefaultTiers()); err != nil {
+		t.Errorf("Default tiers invalid: %v", err)
+	}
+}
+
+func TestCompactAndCleanup_DailyRollups(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	compactor := New(store)
+	tiers := []Tier{
+		{Resolution: storage.ResolutionRaw, Retention: 6 * time.Hour},
+		{Resolution: "1m", Retention: 24 * time.Hour},
+		{Resolution: "15m", Retention: 48 * time.Hour},
+		{Resolution: "1d", Retention: 365 * 24 * time.Hour},
+	}
+	if err := compactor.SetTiers(tiers); err != nil {
+		t.Fatalf("SetTiers failed: %v", err)
+	}
+	policy, err := RetentionPolicy(tiers, "")
+	if err != nil {
+		t.Fatalf("RetentionPolicy failed: %v", err)
+	}
+	compactor.SetRetention(policy)
+
+	// Three full days of a gauge, one sample every 5 minutes
+	now := time.Now()
+	first := now.Truncate(24 * time.Hour).Add(-3 * 24 * time.Hour)
+	var raw []metrics.Metric
+	for ts := first; ts.Before(now); ts = ts.Add(5 * time.Minute) {
+		raw = append(raw, metrics.Metric{Name: "disk_used", Value: 2, Timestamp: ts})
+	}
+	store.Write(ctx, raw)
+
+	if err := compactor.CompactAndCleanup(ctx); err != nil {
+		t.Fatalf("CompactAndCleanup failed: %v", err)
+	}
+
+	results, err := store.Query(ctx, storage.QueryRequest{End: now})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	days := 0
+	for _, m := range results {
+		agg := storage.ParseAggregate(m)
+		if agg == nil {
+			if m.Timestamp.Before(now.Add(-6 * time.Hour).Add(-time.Minute)) {
+				t.Errorf("Raw sample at %v outlived its retention", m.Timestamp)
+			}
+			continue
+		}
+		switch agg.Resolution {
+		case "1d":
+			days++
+			if agg.Count != 288 || agg.Sum != 576 || !agg.Timestamp.Equal(agg.Timestamp.Truncate(24*time.Hour)) {
+				t.Errorf("Daily rollup %+v, want 288 samples summing to 576 from midnight UTC", agg)
+			}
+		case "15m":
+			if agg.Timestamp.Before(now.Add(-48 * time.Hour).Add(-15 * time.Minute)) {
+				t.Errorf("15m aggregate at %v outlived its retention", agg.Timestamp)
+			}
+		}
+	}
+	if days != 3 {
+		t.Errorf("Got %d daily rollups, want 3", days)
+	}
+
+	for _, res := range []storage.Resolution{"1m", "15m", "1d"} {
+		if compactor.Checkpoint().Watermark(res).IsZero() {
+			t.Errorf("No watermark for %s", res)
+		}
+	}
+	if !compactor.Checkpoint().Watermark("1d").Equal(now.Truncate(24 * time.Hour)) {
+		t.Errorf("1d watermark = %v, want today's midnight", compactor.Checkpoint().Watermark("1d"))
+	}
+}
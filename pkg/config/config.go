@@ -7,19 +7,36 @@ const (
 	DefaultPort         = "8080"
 	DefaultMaxStorageGB = 1
 	DefaultMaxMemoryMB  = 48
+	DefaultSnapshotDir  = "./data/snapshots" // Outside the data dir, so restores can target an empty one
+
+	DefaultDataDir        = "./data/tinyobs"
+	DefaultStorageBackend = "badger"
+	MetadataFile          = "metadata.json"   // Metric metadata registry, in the data dir
+	CompactionStateFile   = "compaction.json" // Per-tier compaction watermarks, in the data dir
 )
 
 // Compaction intervals
 const (
-	CompactionInterval = 1 * time.Hour
-	BadgerGCInterval   = 10 * time.Minute
+	CompactionInterval       = 1 * time.Hour
+	CompactionHistory        = 20 // Recent compaction runs listed in /v1/health
+	DefaultCompactionWorkers = 1  // Shards compacted in parallel; 1 = serial
+	BadgerGCInterval         = 10 * time.Minute
+	TombstoneCleanupInterval = 15 * time.Minute
+	BlockSealInterval        = 1 * time.Hour
+	SelfMetricsInterval      = 15 * time.Second
+)
+
+// Recording rules
+const (
+	DefaultRuleInterval = 1 * time.Minute // For groups without an interval
 )
 
 // Query timeouts and defaults
 const (
-	QueryDefaultStep   = 15 * time.Second
-	QueryDefaultWindow = 1 * time.Hour
-	QueryTimeout       = 30 * time.Second
+	QueryDefaultStep     = 15 * time.Second
+	QueryDefaultWindow   = 1 * time.Hour
+	QueryTimeout         = 30 * time.Second
+	QueryInstantLookback = 5 * time.Minute // How far back instant queries look for a series' latest sample
 )
 
 // Ingest timeouts and limits
@@ -34,6 +51,7 @@ const (
 	IngestMetricsListLimit      = 10000
 	IngestMetricsListTimeWindow = 24 * time.Hour
 	IngestMaxQueryWindow        = 90 * 24 * time.Hour
+	TenantUsageRefresh          = 10 * time.Second // How stale a tenant's storage usage may be
 )
 
 // Export defaults and limits
@@ -42,6 +60,11 @@ const (
 	MaxExportWindow     = 30 * 24 * time.Hour
 )
 
+// Admin API timeouts
+const (
+	AdminTimeout = 30 * time.Second
+)
+
 // WebSocket configuration
 const (
 	WSReadBufferSize  = 1024
@@ -57,3 +80,26 @@ const (
 const (
 	DefaultMaxMetrics = 50000
 )
+
+// Ingest sample policy defaults
+const (
+	DefaultOutOfOrderWindow = 1 * time.Hour    // Late samples up to 1h behind their series are accepted
+	DefaultFutureTolerance  = 10 * time.Minute // Clock skew allowed for client timestamps
+)
+
+// Size-based eviction (optional alternative to rejecting ingest at the storage limit)
+const (
+	DefaultEvictionLowWatermark = 0.8             // Evict until usage is below 80% of the limit
+	EvictionCheckInterval       = 1 * time.Minute // Periodic usage check between ingest triggers
+	EvictionMinAge              = 1 * time.Hour   // Never evict data newer than this
+	EvictionRounds              = 10              // Deletion steps per tier, oldest first
+)
+
+// Replication (leader-follower)
+const (
+	ReplicationLogSamples    = 100000           // Samples the leader keeps for followers to catch up from
+	ReplicationBatchSamples  = 10000            // Samples per log response
+	ReplicationPollTimeout   = 5 * time.Second  // How long a log request waits for new writes (below the server write timeout)
+	ReplicationRetryInterval = 2 * time.Second  // Follower backoff after a failed request
+	ReplicationTimeout       = 30 * time.Second // Follower request timeout (snapshots are only bounded by cancellation)
+)
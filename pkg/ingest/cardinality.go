@@ -28,6 +28,9 @@ type CardinalityTracker struct {
 
 	// lastCleanup tracks when we last cleaned up old series
 	lastCleanup time.Time
+
+	// maxSeries bounds totalSeries
+	maxSeries int
 }
 
 // Constants for memory safety
@@ -41,10 +44,20 @@ const (
 
 // NewCardinalityTracker creates a new cardinality tracker
 func NewCardinalityTracker() *CardinalityTracker {
+	return NewCardinalityTrackerWithLimit(MaxUniqueSeries)
+}
+
+// NewCardinalityTrackerWithLimit creates a cardinality tracker allowing
+// maxSeries unique series (e.g. a tenant's share of the server)
+func NewCardinalityTrackerWithLimit(maxSeries int) *CardinalityTracker {
+	if maxSeries <= 0 {
+		maxSeries = MaxUniqueSeries
+	}
 	return &CardinalityTracker{
 		seriesCount: make(map[string]int),
 		seriesSeen:  make(map[string]time.Time),
 		lastCleanup: time.Now(),
+		maxSeries:   maxSeries,
 	}
 }
 
@@ -66,8 +79,8 @@ func (c *CardinalityTracker) Check(m metrics.Metric) error {
 	}
 
 	// Check total series limit
-	if c.totalSeries >= MaxUniqueSeries {
-		return ErrCardinalityLimit
+	if c.totalSeries >= c.maxSeries {
+		return fmt.Errorf("%w (max %d unique series)", ErrCardinalityLimit, c.maxSeries)
 	}
 
 	// Check per-metric cardinality limit
@@ -170,9 +183,9 @@ func (c *CardinalityTracker) Stats() CardinalityStats {
 		UniqueMetrics:   len(c.seriesCount),
 		MaxSeriesMetric: maxMetric,
 		MaxSeriesCount:  maxCount,
-		SeriesLimit:     MaxUniqueSeries,
+		SeriesLimit:     c.maxSeries,
 		PerMetricLimit:  MaxSeriesPerMetric,
-		UtilizationPct:  float64(c.totalSeries) / float64(MaxUniqueSeries) * 100,
+		UtilizationPct:  float64(c.totalSeries) / float64(c.maxSeries) * 100,
 	}
 }
 
@@ -10,13 +10,16 @@ import (
 
 	"github.com/nicktill/tinyobs/pkg/config"
 	"github.com/nicktill/tinyobs/pkg/httpx"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
 	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/tenant"
 )
 
 // MetricsListResponse returns available metric names
 type MetricsListResponse struct {
-	Metrics []string `json:"metrics"`
-	Count   int      `json:"count"`
+	Metrics  []string                    `json:"metrics"`
+	Count    int                         `json:"count"`
+	Metadata map[string]metrics.Metadata `json:"metadata,omitempty"` // Type, unit and help of listed metrics, where known
 }
 
 // RangeQueryResponse returns time-series data optimized for charting
@@ -70,15 +73,24 @@ func (h *Handler) HandleMetricsList(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Convert to sorted slice
-	metrics := make([]string, 0, len(metricSet))
+	names := make([]string, 0, len(metricSet))
 	for name := range metricSet {
-		metrics = append(metrics, name)
+		names = append(names, name)
 	}
-	sort.Strings(metrics)
+	sort.Strings(names)
 
 	response := MetricsListResponse{
-		Metrics: metrics,
-		Count:   len(metrics),
+		Metrics: names,
+		Count:   len(names),
+	}
+	tenantID := tenant.FromContext(r.Context())
+	for _, name := range names {
+		if md, ok := h.lookupMetadata(tenantID, name); ok {
+			if response.Metadata == nil {
+				response.Metadata = make(map[string]metrics.Metadata)
+			}
+			response.Metadata[name] = md
+		}
 	}
 	httpx.RespondJSON(w, http.StatusOK, response)
 }
@@ -137,14 +149,29 @@ func (h *Handler) HandleRangeQuery(w http.ResponseWriter, r *http.Request) {
 		maxPoints = parsed
 	}
 
+	aggregation, err := storage.ParseAggregation(query.Get("agg"))
+	if err != nil {
+		httpx.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// One point per step keeps each series within maxPoints; the backend
+	// aggregates while scanning instead of returning every raw sample
+	step := (queryWindow + time.Duration(maxPoints) - 1) / time.Duration(maxPoints)
+	if step < time.Millisecond {
+		step = time.Millisecond // Points are returned with millisecond timestamps
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), config.IngestQueryTimeout)
 	defer cancel()
 
 	// Query metrics
-	results, err := h.storage.Query(ctx, storage.QueryRequest{
+	results, err := storage.QueryDownsampled(ctx, h.storage, storage.QueryRequest{
 		Start:       start,
 		End:         end,
 		MetricNames: []string{metricName},
+		Step:        step,
+		Aggregation: aggregation,
 	})
 	if err != nil {
 		httpx.RespondError(w, http.StatusInternalServerError, fmt.Errorf("query failed: %w", err))
@@ -197,17 +224,15 @@ func (h *Handler) HandleRangeQuery(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Downsample if needed and sort points
+	// Sort points
 	for _, series := range seriesMap {
 		// Sort by timestamp
-		sort.Slice(series.Points, func(i, j int) bool {
+		sort.SliceStable(series.Points, func(i, j int) bool {
 			return series.Points[i].Timestamp < series.Points[j].Timestamp
 		})
 
-		// Downsample if too many points
-		if len(series.Points) > maxPoints {
-			series.Points = downsamplePoints(series.Points, maxPoints)
-		}
+		// Raw data and aggregates of the same step land on the same timestamp
+		series.Points = dedupePoints(series.Points)
 	}
 
 	// Convert map to slice
@@ -240,45 +265,14 @@ func makeSeriesKey(metric string, labels map[string]string) string {
 	return key
 }
 
-// downsamplePoints reduces points using average bucketing
-func downsamplePoints(points []Point, maxPoints int) []Point {
-	if len(points) <= maxPoints {
-		return points
-	}
-
-	bucketSize := len(points) / maxPoints
-	if bucketSize < 1 {
-		bucketSize = 1
-	}
-
-	downsampled := make([]Point, 0, maxPoints)
-
-	for i := 0; i < len(points); i += bucketSize {
-		end := i + bucketSize
-		if end > len(points) {
-			end = len(points)
-		}
-
-		// Average the bucket
-		var sumValue float64
-		var timestamp int64
-		count := 0
-
-		for j := i; j < end; j++ {
-			sumValue += points[j].Value
-			if j == i {
-				timestamp = points[j].Timestamp
-			}
-			count++
-		}
-
-		if count > 0 {
-			downsampled = append(downsampled, Point{
-				Timestamp: timestamp,
-				Value:     sumValue / float64(count),
-			})
+// dedupePoints keeps the first of sorted points sharing a timestamp
+func dedupePoints(points []Point) []Point {
+	deduped := points[:0]
+	for _, p := range points {
+		if n := len(deduped); n > 0 && deduped[n-1].Timestamp == p.Timestamp {
+			continue
 		}
+		deduped = append(deduped, p)
 	}
-
-	return downsampled
+	return deduped
 }
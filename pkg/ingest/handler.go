@@ -6,20 +6,31 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/nicktill/tinyobs/pkg/config"
 	"github.com/nicktill/tinyobs/pkg/httpx"
+	"github.com/nicktill/tinyobs/pkg/metadata"
 	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
 	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/tenant"
 )
 
 // Handler handles metric ingestion via HTTP endpoints.
 // Validates metrics, enforces cardinality limits, and stores them.
 type Handler struct {
 	storage        storage.Storage
-	cardinality    *CardinalityTracker
+	cardinality    *CardinalityTracker // Unique series across all tenants
+	limits         TenantLimits
+	tenantsMu      sync.Mutex
+	tenants        map[string]*tenantState // Tenant ID -> cardinality and usage
 	storageChecker StorageLimitChecker
+	evictor        EvictionTrigger
+	policy         SamplePolicy
+	samples        *sampleTracker
+	counters       ingestCounters
+	metadata       *metadata.Registry
 }
 
 // StorageLimitChecker provides storage usage information for limit enforcement.
@@ -30,31 +41,74 @@ type StorageLimitChecker interface {
 	GetLimit() int64
 }
 
+// EvictionTrigger frees space by evicting old data when storage is full.
+type EvictionTrigger interface {
+	// TriggerEviction requests an eviction run without blocking.
+	TriggerEviction()
+}
+
 // NewHandler creates a new ingest handler with the given storage backend.
 func NewHandler(store storage.Storage) *Handler {
 	return &Handler{
 		storage:        store,
 		cardinality:    NewCardinalityTracker(),
+		tenants:        make(map[string]*tenantState),
 		storageChecker: nil, // Optional - can be set via SetStorageChecker
+		policy:         DefaultSamplePolicy(),
+		samples:        newSampleTracker(),
 	}
 }
 
+// SetSamplePolicy configures out-of-order, future and duplicate sample handling.
+func (h *Handler) SetSamplePolicy(policy SamplePolicy) {
+	h.policy = policy
+}
+
+// SetSeriesLimit configures the number of unique series across all tenants
+// (0 = MaxUniqueSeries), on top of each tenant's own limit.
+// Must be called before the handler serves requests.
+func (h *Handler) SetSeriesLimit(maxSeries int) {
+	h.cardinality = NewCardinalityTrackerWithLimit(maxSeries)
+}
+
+// SetTenantLimits configures per-tenant cardinality and storage limits.
+// Must be called before the handler serves requests.
+func (h *Handler) SetTenantLimits(limits TenantLimits) {
+	h.limits = limits
+}
+
+// SetMetadata records metric types and client-provided metadata in the
+// registry behind /api/v1/metadata.
+func (h *Handler) SetMetadata(registry *metadata.Registry) {
+	h.metadata = registry
+}
+
 // SetStorageChecker configures storage limit checking for the handler.
 // If set, HandleIngest will reject metrics when storage limit is exceeded.
 func (h *Handler) SetStorageChecker(checker StorageLimitChecker) {
 	h.storageChecker = checker
 }
 
+// SetEvictionTrigger switches the storage limit from rejecting to evicting.
+// If set, HandleIngest keeps accepting metrics at the limit and asks the
+// evictor to delete the oldest data instead of returning 507.
+func (h *Handler) SetEvictionTrigger(evictor EvictionTrigger) {
+	h.evictor = evictor
+}
+
 // IngestRequest represents the request payload for POST /v1/ingest.
 type IngestRequest struct {
-	Metrics []metrics.Metric `json:"metrics"` // Array of metrics to ingest
+	Metrics  []metrics.Metric   `json:"metrics"`            // Array of metrics to ingest
+	Metadata []metrics.Metadata `json:"metadata,omitempty"` // Optional unit and help text per metric
 }
 
 // IngestResponse represents the response payload for ingestion endpoints.
 type IngestResponse struct {
-	Status  string `json:"status"`           // "success" or "error"
-	Count   int    `json:"count"`            // Number of metrics ingested
-	Message string `json:"message,omitempty"` // Optional error or info message
+	Status      string         `json:"status"`                // "success" or "error"
+	Count       int            `json:"count"`                 // Number of metrics ingested
+	Overwritten int            `json:"overwritten,omitempty"` // Ingested metrics that replaced a stored sample
+	Rejected    map[string]int `json:"rejected,omitempty"`    // Metrics dropped by the sample policy, by reason
+	Message     string         `json:"message,omitempty"`     // Optional error or info message
 }
 
 // HandleIngest handles POST /v1/ingest.
@@ -72,10 +126,16 @@ func (h *Handler) HandleIngest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check request size limit
-	if len(req.Metrics) > MaxMetricsPerRequest {
+	if len(req.Metrics) > MaxMetricsPerRequest || len(req.Metadata) > MaxMetricsPerRequest {
 		httpx.RespondError(w, http.StatusBadRequest, ErrTooManyMetrics)
 		return
 	}
+	for i, md := range req.Metadata {
+		if err := ValidateMetadata(md); err != nil {
+			httpx.RespondError(w, http.StatusBadRequest, fmt.Errorf("invalid metadata at index %d: %w", i, err))
+			return
+		}
+	}
 
 	// Check storage limits BEFORE processing (fail fast to prevent disk overflow)
 	if h.storageChecker != nil {
@@ -85,7 +145,10 @@ func (h *Handler) HandleIngest(w http.ResponseWriter, r *http.Request) {
 			// Continue anyway - don't block ingestion on monitoring failure
 		} else {
 			limit := h.storageChecker.GetLimit()
-			if currentUsage >= limit {
+			if currentUsage >= limit && h.evictor != nil {
+				// Keep the newest data: accept the write and evict old data in the background
+				h.evictor.TriggerEviction()
+			} else if currentUsage >= limit {
 				// 507 Insufficient Storage (WebDAV standard, appropriate for storage limits)
 				message := fmt.Sprintf("Storage limit exceeded: %d/%d bytes used (%.1f%%). Please free up space or increase limit.",
 					currentUsage, limit, float64(currentUsage)/float64(limit)*100)
@@ -97,6 +160,20 @@ func (h *Handler) HandleIngest(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Check the tenant's own storage limit
+	tenantID := tenant.FromContext(r.Context())
+	ts := h.tenant(tenantID)
+	if h.limits.MaxStorageBytes > 0 {
+		usage, err := h.storageUsage(r.Context(), ts)
+		if err != nil {
+			log.Printf("Failed to check storage usage of tenant %q: %v", tenantID, err)
+		} else if usage >= h.limits.MaxStorageBytes {
+			httpx.RespondErrorString(w, 507, fmt.Sprintf("Tenant storage limit exceeded: %d/%d bytes used. Please free up space or raise the tenant limit.",
+				usage, h.limits.MaxStorageBytes))
+			return
+		}
+	}
+
 	// Validate metrics and check cardinality
 	now := time.Now()
 	for i := range req.Metrics {
@@ -111,36 +188,77 @@ func (h *Handler) HandleIngest(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Check cardinality limits
-		if err := h.cardinality.Check(req.Metrics[i]); err != nil {
+		// Check cardinality limits: the tenant's own, then the server's
+		if err := ts.cardinality.Check(req.Metrics[i]); err != nil {
 			httpx.RespondError(w, http.StatusTooManyRequests, fmt.Errorf("cardinality limit exceeded for metric %q: %w", req.Metrics[i].Name, err))
 			return
 		}
+		if err := h.cardinality.Check(scopedMetric(tenantID, req.Metrics[i])); err != nil {
+			httpx.RespondError(w, http.StatusTooManyRequests, fmt.Errorf("server cardinality limit exceeded for metric %q: %w", req.Metrics[i].Name, err))
+			return
+		}
 	}
 
-	// Store metrics
 	ctx, cancel := context.WithTimeout(r.Context(), config.IngestTimeout)
 	defer cancel()
 
-	if err := h.storage.Write(ctx, req.Metrics); err != nil {
+	// Drop out-of-order and far-future samples (the rest of the batch is still stored)
+	if err := h.samples.seed(ctx, h.storage, tenantID, req.Metrics, h.policy, now); err != nil {
+		log.Printf("Failed to seed out-of-order checks: %v", err)
+		// Continue anyway - unknown series are checked against the batch only
+	}
+	accepted, rejected := h.samples.filter(tenantID, req.Metrics, h.policy, now)
+
+	// Store metrics
+	result, err := h.write(ctx, accepted)
+	if err != nil {
 		httpx.RespondError(w, http.StatusInternalServerError, fmt.Errorf("failed to store metrics: %w", err))
 		return
 	}
+	h.samples.commit(tenantID, accepted)
+	if result.Dropped > 0 {
+		if rejected == nil {
+			rejected = make(map[string]int)
+		}
+		rejected[ReasonDuplicate] += result.Dropped
+	}
 
 	// Record successfully written metrics for cardinality tracking
-	for _, m := range req.Metrics {
-		h.cardinality.Record(m)
+	for _, m := range accepted {
+		ts.cardinality.Record(m)
+		h.cardinality.Record(scopedMetric(tenantID, m))
 	}
 
+	ingested := len(accepted) - result.Dropped
+	h.counters.record(ingested, result.Overwritten, rejected)
+	h.recordMetadata(tenantID, accepted, req.Metadata)
+
 	// Respond
 	response := IngestResponse{
-		Status: "success",
-		Count:  len(req.Metrics),
+		Status:      "success",
+		Count:       ingested,
+		Overwritten: result.Overwritten,
+		Rejected:    rejected,
 	}
 
 	httpx.RespondJSON(w, http.StatusOK, response)
 }
 
+// write stores metrics with the policy's duplicate handling when the backend supports it
+func (h *Handler) write(ctx context.Context, batch []metrics.Metric) (storage.WriteResult, error) {
+	if len(batch) == 0 {
+		return storage.WriteResult{}, nil
+	}
+	if writer, ok := h.storage.(storage.PolicyWriter); ok {
+		return writer.WriteWithPolicy(ctx, batch, h.policy.Duplicates)
+	}
+	// Backend can't detect duplicates: every sample counts as added
+	if err := h.storage.Write(ctx, batch); err != nil {
+		return storage.WriteResult{}, err
+	}
+	return storage.WriteResult{Added: len(batch)}, nil
+}
+
 // QueryResponse represents the response for a query request.
 type QueryResponse struct {
 	Metrics []metrics.Metric `json:"metrics"`
@@ -206,9 +324,9 @@ func (h *Handler) HandleStats(w http.ResponseWriter, r *http.Request) {
 }
 
 // HandleCardinalityStats handles the /v1/cardinality endpoint.
-// Returns cardinality usage statistics for monitoring.
+// Returns the tenant's cardinality usage statistics for monitoring.
 func (h *Handler) HandleCardinalityStats(w http.ResponseWriter, r *http.Request) {
-	stats := h.cardinality.Stats()
+	stats := h.tenant(tenant.FromContext(r.Context())).cardinality.Stats()
 	httpx.RespondJSON(w, http.StatusOK, stats)
 }
 
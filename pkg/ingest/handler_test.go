@@ -2,13 +2,18 @@ package ingest
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/nicktill/tinyobs/pkg/metadata"
 	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
 	"github.com/nicktill/tinyobs/pkg/storage/memory"
+	"github.com/nicktill/tinyobs/pkg/tenant"
 	"github.com/stretchr/testify/require"
 )
 
@@ -56,3 +61,206 @@ func TestHandleIngest_InvalidMetric(t *testing.T) {
 	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
 	require.Contains(t, resp["message"], "invalid metric")
 }
+
+func TestHandleIngest_RejectsAggregates(t *testing.T) {
+	store := memory.New()
+	handler := NewHandler(store)
+
+	for _, m := range []metrics.Metric{
+		{Name: "cpu", Value: 1, Labels: map[string]string{"__resolution__": "5m"}},
+		{Name: "cpu", Value: 1, Aggregate: &metrics.AggregateStats{Sum: 1, Count: 1}},
+	} {
+		body, err := json.Marshal(IngestRequest{Metrics: []metrics.Metric{m}})
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		handler.HandleIngest(rr, httptest.NewRequest(http.MethodPost, "/v1/ingest", bytes.NewReader(body)))
+
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "__resolution__")
+	}
+
+	stats, err := store.Stats(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, stats.TotalMetrics)
+}
+
+type fullStorage struct{}
+
+func (fullStorage) GetUsage() (int64, error) { return 200, nil }
+func (fullStorage) GetLimit() int64          { return 100 }
+
+type countingEvictor struct{ triggered int }
+
+func (e *countingEvictor) TriggerEviction() { e.triggered++ }
+
+func TestHandleIngest_StorageLimit(t *testing.T) {
+	body, err := json.Marshal(IngestRequest{Metrics: []metrics.Metric{{Name: "test_metric", Value: 1}}})
+	require.NoError(t, err)
+
+	// Without eviction: reject with 507
+	handler := NewHandler(memory.New())
+	handler.SetStorageChecker(fullStorage{})
+
+	rr := httptest.NewRecorder()
+	handler.HandleIngest(rr, httptest.NewRequest(http.MethodPost, "/v1/ingest", bytes.NewReader(body)))
+	require.Equal(t, http.StatusInsufficientStorage, rr.Code)
+
+	// With eviction: accept and trigger eviction
+	evictor := &countingEvictor{}
+	handler.SetEvictionTrigger(evictor)
+
+	rr = httptest.NewRecorder()
+	handler.HandleIngest(rr, httptest.NewRequest(http.MethodPost, "/v1/ingest", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, 1, evictor.triggered)
+}
+
+func TestHandleRangeQuery_MaxPoints(t *testing.T) {
+	store := memory.New()
+	handler := NewHandler(store)
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	batch := make([]metrics.Metric, 100)
+	for i := range batch {
+		batch[i] = metrics.Metric{Name: "cpu", Value: float64(i), Timestamp: start.Add(time.Duration(i) * 10 * time.Second)}
+	}
+	require.NoError(t, store.Write(context.Background(), batch))
+
+	// 1000s of data in 10 steps of 100s: 10 samples each
+	url := fmt.Sprintf("/v1/query/range?metric=cpu&start=%s&end=%s&maxPoints=10&agg=max",
+		start.Format(time.RFC3339), start.Add(1000*time.Second).Format(time.RFC3339))
+	rr := httptest.NewRecorder()
+	handler.HandleRangeQuery(rr, httptest.NewRequest(http.MethodGet, url, nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var resp RangeQueryResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Len(t, resp.Data, 1)
+	require.Len(t, resp.Data[0].Points, 10)
+	for i, p := range resp.Data[0].Points {
+		require.Equal(t, float64(10*i+9), p.Value)
+		require.Equal(t, start.Add(time.Duration(i)*100*time.Second).UnixMilli(), p.Timestamp)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleRangeQuery(rr, httptest.NewRequest(http.MethodGet, url+"x", nil))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestHandleIngest_TenantLimits(t *testing.T) {
+	handler := NewHandler(tenant.NewStorage(memory.New()))
+	handler.SetTenantLimits(TenantLimits{MaxSeries: 2})
+
+	ingest := func(id string, hosts ...string) int {
+		payload := IngestRequest{}
+		for _, host := range hosts {
+			payload.Metrics = append(payload.Metrics, metrics.Metric{Name: "cpu", Value: 1, Labels: map[string]string{"host": host}})
+		}
+		body, err := json.Marshal(payload)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/ingest", bytes.NewReader(body))
+		req = req.WithContext(tenant.WithID(req.Context(), id))
+		rr := httptest.NewRecorder()
+		handler.HandleIngest(rr, req)
+		return rr.Code
+	}
+
+	require.Equal(t, http.StatusOK, ingest("team-a", "a", "b"))
+	require.Equal(t, http.StatusTooManyRequests, ingest("team-a", "c"))
+
+	// Each tenant has its own series budget
+	require.Equal(t, http.StatusOK, ingest("team-b", "a", "b"))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/cardinality", nil)
+	req = req.WithContext(tenant.WithID(req.Context(), "team-a"))
+	rr := httptest.NewRecorder()
+	handler.HandleCardinalityStats(rr, req)
+	var stats CardinalityStats
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+	require.Equal(t, 2, stats.TotalSeries)
+	require.Equal(t, 2, stats.SeriesLimit)
+
+	// Tenant storage limit: any stored data exceeds one byte
+	handler.SetTenantLimits(TenantLimits{MaxStorageBytes: 1})
+	require.Equal(t, http.StatusInsufficientStorage, ingest("team-a", "a"))
+	require.Equal(t, http.StatusOK, ingest("team-c", "a"))
+}
+
+func TestHandleIngest_ServerSeriesLimit(t *testing.T) {
+	handler := NewHandler(tenant.NewStorage(memory.New()))
+	handler.SetSeriesLimit(3)
+	handler.SetTenantLimits(TenantLimits{MaxSeries: 2})
+
+	ingest := func(id, host string) int {
+		body, err := json.Marshal(IngestRequest{Metrics: []metrics.Metric{{Name: "cpu", Value: 1, Labels: map[string]string{"host": host}}}})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/v1/ingest", bytes.NewReader(body))
+		req = req.WithContext(tenant.WithID(req.Context(), id))
+		rr := httptest.NewRecorder()
+		handler.HandleIngest(rr, req)
+		return rr.Code
+	}
+
+	require.Equal(t, http.StatusOK, ingest("team-a", "a"))
+	require.Equal(t, http.StatusOK, ingest("team-a", "b"))
+	// The same labels in another tenant are another series
+	require.Equal(t, http.StatusOK, ingest("team-b", "a"))
+
+	// team-b is within its own limit, but the server is full
+	require.Equal(t, http.StatusTooManyRequests, ingest("team-b", "b"))
+	require.Equal(t, http.StatusOK, ingest("team-a", "b"), "known series are still accepted")
+}
+
+func TestHandleMetadata(t *testing.T) {
+	handler := NewHandler(tenant.NewStorage(memory.New()))
+	registry, err := metadata.Open("")
+	require.NoError(t, err)
+	handler.SetMetadata(registry)
+
+	ingest := func(id string, payload IngestRequest) int {
+		body, err := json.Marshal(payload)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/v1/ingest", bytes.NewReader(body))
+		req = req.WithContext(tenant.WithID(req.Context(), id))
+		rr := httptest.NewRecorder()
+		handler.HandleIngest(rr, req)
+		return rr.Code
+	}
+	now := time.Now()
+	require.Equal(t, http.StatusOK, ingest("team-a", IngestRequest{
+		Metrics: []metrics.Metric{
+			{Name: "requests_total", Type: metrics.CounterType, Value: 1, Timestamp: now},
+			{Name: "heap_bytes", Type: metrics.GaugeType, Value: 1, Timestamp: now},
+		},
+		Metadata: []metrics.Metadata{{Name: "heap_bytes", Unit: "bytes", Help: "Heap in use"}},
+	}))
+	require.Equal(t, http.StatusOK, ingest("team-b", IngestRequest{
+		Metrics: []metrics.Metric{{Name: "requests_total", Type: metrics.GaugeType, Value: 1, Timestamp: now}},
+	}))
+	require.Equal(t, http.StatusBadRequest, ingest("team-a", IngestRequest{
+		Metrics:  []metrics.Metric{{Name: "x", Value: 1, Timestamp: now}},
+		Metadata: []metrics.Metadata{{Name: "x", Type: "summary"}},
+	}))
+
+	query := func(url string) MetadataResponse {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req = req.WithContext(tenant.WithID(req.Context(), "team-a"))
+		rr := httptest.NewRecorder()
+		handler.HandleMetadata(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp MetadataResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := query("/api/v1/metadata")
+	require.Equal(t, "success", resp.Status)
+	require.Equal(t, map[string][]MetricMetadata{
+		"heap_bytes":     {{Type: metrics.GaugeType, Unit: "bytes", Help: "Heap in use"}},
+		"requests_total": {{Type: metrics.CounterType}},
+	}, resp.Data, "only the tenant's own metrics, with the tenant's types")
+
+	require.Len(t, query("/api/v1/metadata?metric=heap_bytes").Data, 1)
+	require.Len(t, query("/api/v1/metadata?limit=1").Data, 1)
+}
@@ -2,8 +2,11 @@ package ingest
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/nicktill/tinyobs/pkg/metadata"
 	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/tenant"
 )
 
 // Cardinality and validation limits
@@ -15,7 +18,7 @@ const (
 	MaxMetricNameLength = 256  // Maximum metric name length
 
 	// Global limits
-	MaxUniqueSeries      = 100000 // Maximum unique time series (configurable in future)
+	MaxUniqueSeries      = 100000 // Maximum unique time series, server-wide and per tenant by default
 	MaxSeriesPerMetric   = 10000  // Maximum series per metric name
 	MaxMetricsPerRequest = 1000   // Maximum metrics in single ingest request
 )
@@ -36,8 +39,15 @@ var (
 	// ErrMetricNameEmpty is returned when a metric name is empty
 	ErrMetricNameEmpty = fmt.Errorf("metric name cannot be empty")
 
+	// ErrMetricNameSeparator is returned when a metric name contains the tenant separator
+	ErrMetricNameSeparator = fmt.Errorf("metric name cannot contain %q (it separates tenant IDs in storage)", tenant.Separator)
+
+	// ErrAggregateIngested is returned when a metric carries compaction's
+	// aggregate statistics or resolution label
+	ErrAggregateIngested = fmt.Errorf("aggregates and the __resolution__ label are written by compaction and cannot be ingested")
+
 	// ErrCardinalityLimit is returned when the total series limit is exceeded
-	ErrCardinalityLimit = fmt.Errorf("cardinality limit exceeded (max %d unique series)", MaxUniqueSeries)
+	ErrCardinalityLimit = fmt.Errorf("cardinality limit exceeded")
 
 	// ErrMetricCardinalityLimit is returned when a single metric's series limit is exceeded
 	ErrMetricCardinalityLimit = fmt.Errorf("metric cardinality limit exceeded (max %d series per metric)", MaxSeriesPerMetric)
@@ -55,6 +65,14 @@ func ValidateMetric(m metrics.Metric) error {
 	if len(m.Name) > MaxMetricNameLength {
 		return fmt.Errorf("%w: %q has %d chars", ErrMetricNameTooLong, m.Name, len(m.Name))
 	}
+	if strings.Contains(m.Name, tenant.Separator) {
+		return fmt.Errorf("%w: %q", ErrMetricNameSeparator, m.Name)
+	}
+
+	// Aggregates would be read as compacted data of their tier
+	if _, ok := m.Labels["__resolution__"]; ok || m.Aggregate != nil {
+		return fmt.Errorf("%w: metric %q", ErrAggregateIngested, m.Name)
+	}
 
 	// Validate number of labels
 	if len(m.Labels) > MaxLabelsPerMetric {
@@ -73,3 +91,11 @@ func ValidateMetric(m metrics.Metric) error {
 
 	return nil
 }
+
+// ValidateMetadata validates client-provided metric metadata
+func ValidateMetadata(md metrics.Metadata) error {
+	if err := ValidateMetric(metrics.Metric{Name: md.Name}); err != nil {
+		return err
+	}
+	return metadata.Validate(md)
+}
@@ -0,0 +1,101 @@
+package ingest
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/nicktill/tinyobs/pkg/httpx"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/tenant"
+)
+
+// MetadataResponse is the Prometheus-compatible response of /api/v1/metadata
+type MetadataResponse struct {
+	Status string                      `json:"status"`
+	Data   map[string][]MetricMetadata `json:"data"`
+}
+
+// MetricMetadata is one metric's metadata, as Prometheus reports it
+type MetricMetadata struct {
+	Type metrics.MetricType `json:"type"`
+	Help string             `json:"help"`
+	Unit string             `json:"unit"`
+}
+
+// recordMetadata learns the types of written samples and stores
+// client-provided metadata for the tenant. Failing to save the registry
+// doesn't fail the ingest.
+func (h *Handler) recordMetadata(tenantID string, written []metrics.Metric, described []metrics.Metadata) {
+	if h.metadata == nil {
+		return
+	}
+	scoped := func(name string) string { return tenant.MetricName(tenantID, name) }
+
+	if err := h.metadata.Observe(written, scoped); err != nil {
+		log.Printf("Failed to record metric metadata: %v", err)
+	}
+	if len(described) == 0 {
+		return
+	}
+	updates := make([]metrics.Metadata, len(described))
+	for i, md := range described {
+		md.Name = scoped(md.Name)
+		updates[i] = md
+	}
+	if err := h.metadata.Update(updates); err != nil {
+		log.Printf("Failed to record metric metadata: %v", err)
+	}
+}
+
+// lookupMetadata returns the metadata of one of the tenant's metrics
+func (h *Handler) lookupMetadata(tenantID, name string) (metrics.Metadata, bool) {
+	if h.metadata == nil {
+		return metrics.Metadata{}, false
+	}
+	md, ok := h.metadata.Lookup(tenant.MetricName(tenantID, name))
+	if ok {
+		md.Name = name
+	}
+	return md, ok
+}
+
+// HandleMetadata handles GET /api/v1/metadata (Prometheus-compatible).
+// Query params:
+//   - metric: only this metric (optional)
+//   - limit: maximum number of metrics (optional)
+func (h *Handler) HandleMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpx.RespondErrorString(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	limit := 0
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			httpx.RespondErrorString(w, http.StatusBadRequest, "limit must be a non-negative integer")
+			return
+		}
+		limit = n
+	}
+	metric := query.Get("metric")
+
+	tenantID := tenant.FromContext(r.Context())
+	data := make(map[string][]MetricMetadata)
+	if h.metadata != nil {
+		for _, md := range h.metadata.List() {
+			owner, name := tenant.SplitMetricName(md.Name)
+			if owner != tenantID || metric != "" && name != metric {
+				continue
+			}
+			if limit > 0 && len(data) >= limit {
+				break
+			}
+			data[name] = []MetricMetadata{{Type: md.Type, Help: md.Help, Unit: md.Unit}}
+		}
+	}
+
+	httpx.RespondJSON(w, http.StatusOK, MetadataResponse{Status: "success", Data: data})
+}
@@ -0,0 +1,233 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/config"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/tenant"
+)
+
+// Reasons a sample is rejected or dropped by the sample policy
+const (
+	ReasonOutOfOrder  = "out_of_order" // Older than the series' newest sample by more than the window
+	ReasonTooFarAhead = "too_far_in_future"
+	ReasonDuplicate   = "duplicate" // Timestamp already stored (first write wins)
+)
+
+// SamplePolicy decides which samples ingest accepts based on their timestamps.
+// Samples are keyed by series and timestamp, so without a policy a duplicate
+// silently overwrites and late samples are accepted at any age.
+type SamplePolicy struct {
+	// OutOfOrderWindow is how far behind a series' newest sample a late
+	// sample may be (0 = out-of-order samples are rejected)
+	OutOfOrderWindow time.Duration
+
+	// FutureTolerance is how far ahead of the server clock a sample may be
+	FutureTolerance time.Duration
+
+	// Duplicates resolves samples whose series and timestamp are already stored
+	Duplicates storage.DuplicatePolicy
+}
+
+// DefaultSamplePolicy tolerates SDK retries and clock skew, and keeps the
+// latest write for duplicates
+func DefaultSamplePolicy() SamplePolicy {
+	return SamplePolicy{
+		OutOfOrderWindow: config.DefaultOutOfOrderWindow,
+		FutureTolerance:  config.DefaultFutureTolerance,
+		Duplicates:       storage.LastWriteWins,
+	}
+}
+
+// ParseDuplicatePolicy parses "first" or "last" (write wins)
+func ParseDuplicatePolicy(s string) (storage.DuplicatePolicy, bool) {
+	switch s {
+	case "first", "first-write-wins":
+		return storage.FirstWriteWins, true
+	case "last", "last-write-wins":
+		return storage.LastWriteWins, true
+	}
+	return storage.LastWriteWins, false
+}
+
+// sampleTracker remembers each series' newest timestamp for out-of-order checks.
+// Series it doesn't know (after a restart, or once forgotten) are seeded
+// from storage before their samples are checked.
+// SAFETY: Series not written for seriesRetentionPeriod are forgotten
+type sampleTracker struct {
+	mu          sync.Mutex
+	newest      map[string]time.Time // Tenant-prefixed seriesKey(name, labels) -> newest sample
+	lastCleanup time.Time
+}
+
+func newSampleTracker() *sampleTracker {
+	return &sampleTracker{
+		newest:      make(map[string]time.Time),
+		lastCleanup: time.Now(),
+	}
+}
+
+// seed reads the newest stored sample of the batch's series the tracker
+// doesn't know yet. Only samples that could make one of the batch late are
+// read: those more than the window after its oldest sample of the metric.
+// store must be the tenant's view (ctx carries the tenant).
+func (t *sampleTracker) seed(ctx context.Context, store storage.Storage, tenantID string, batch []metrics.Metric, policy SamplePolicy, now time.Time) error {
+	from := make(map[string]time.Time) // Metric name -> oldest sample of an unknown series
+	t.mu.Lock()
+	for _, m := range batch {
+		if _, seen := t.newest[tenant.MetricName(tenantID, seriesKey(m.Name, m.Labels))]; seen {
+			continue
+		}
+		if ts, ok := from[m.Name]; !ok || m.Timestamp.Before(ts) {
+			from[m.Name] = m.Timestamp
+		}
+	}
+	t.mu.Unlock()
+
+	end := now.Add(policy.FutureTolerance)
+	for name, ts := range from {
+		start := ts.Add(policy.OutOfOrderWindow)
+		if start.After(end) {
+			continue
+		}
+		stored, err := store.Query(ctx, storage.QueryRequest{MetricNames: []string{name}, Start: start, End: end})
+		if err != nil {
+			return fmt.Errorf("failed to read newest samples of %s: %w", name, err)
+		}
+
+		t.mu.Lock()
+		for _, m := range stored {
+			if storage.ResolutionOf(m.Labels) != storage.ResolutionRaw {
+				continue
+			}
+			key := tenant.MetricName(tenantID, seriesKey(m.Name, m.Labels))
+			if newest, seen := t.newest[key]; !seen || m.Timestamp.After(newest) {
+				t.newest[key] = m.Timestamp
+			}
+		}
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+// filter splits a tenant's batch into accepted samples and rejection counts
+// per reason. A batch is checked against itself as well as earlier batches,
+// but only commit advances the series' newest timestamps.
+func (t *sampleTracker) filter(tenantID string, batch []metrics.Metric, policy SamplePolicy, now time.Time) ([]metrics.Metric, map[string]int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.cleanupLocked(now)
+
+	accepted := batch[:0:0]
+	var rejected map[string]int
+	reject := func(reason string) {
+		if rejected == nil {
+			rejected = make(map[string]int)
+		}
+		rejected[reason]++
+	}
+
+	inBatch := make(map[string]time.Time) // Newest accepted sample per series in this batch
+	maxTime := now.Add(policy.FutureTolerance)
+	for _, m := range batch {
+		if m.Timestamp.After(maxTime) {
+			reject(ReasonTooFarAhead)
+			continue
+		}
+
+		key := tenant.MetricName(tenantID, seriesKey(m.Name, m.Labels))
+		newest, seen := t.newest[key]
+		if ts, ok := inBatch[key]; ok && (!seen || ts.After(newest)) {
+			newest, seen = ts, true
+		}
+		if seen && m.Timestamp.Before(newest.Add(-policy.OutOfOrderWindow)) {
+			reject(ReasonOutOfOrder)
+			continue
+		}
+		if !seen || m.Timestamp.After(newest) {
+			inBatch[key] = m.Timestamp
+		}
+		accepted = append(accepted, m)
+	}
+	return accepted, rejected
+}
+
+// commit advances the newest timestamps of a tenant's stored samples
+func (t *sampleTracker) commit(tenantID string, stored []metrics.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, m := range stored {
+		key := tenant.MetricName(tenantID, seriesKey(m.Name, m.Labels))
+		if newest, seen := t.newest[key]; !seen || m.Timestamp.After(newest) {
+			t.newest[key] = m.Timestamp
+		}
+	}
+}
+
+// cleanupLocked forgets series whose newest sample is older than seriesRetentionPeriod
+// MUST be called with lock held
+func (t *sampleTracker) cleanupLocked(now time.Time) {
+	if now.Sub(t.lastCleanup) < cleanupInterval {
+		return
+	}
+	t.lastCleanup = now
+
+	cutoff := now.Add(-seriesRetentionPeriod)
+	for key, newest := range t.newest {
+		if newest.Before(cutoff) {
+			delete(t.newest, key)
+		}
+	}
+}
+
+// ingestCounters accumulates sample outcomes for self-metrics
+type ingestCounters struct {
+	mu          sync.Mutex
+	accepted    uint64
+	overwritten uint64
+	rejected    map[string]uint64
+}
+
+func (c *ingestCounters) record(accepted, overwritten int, rejected map[string]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.accepted += uint64(accepted)
+	c.overwritten += uint64(overwritten)
+	for reason, n := range rejected {
+		if c.rejected == nil {
+			c.rejected = make(map[string]uint64)
+		}
+		c.rejected[reason] += uint64(n)
+	}
+}
+
+// SelfMetrics reports ingest outcomes as counters:
+// tinyobs_ingest_samples_total, tinyobs_ingest_samples_overwritten_total and
+// tinyobs_ingest_samples_rejected_total{reason}
+func (h *Handler) SelfMetrics() []metrics.Metric {
+	c := &h.counters
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := []metrics.Metric{
+		{Name: "tinyobs_ingest_samples_total", Type: metrics.CounterType, Value: float64(c.accepted)},
+		{Name: "tinyobs_ingest_samples_overwritten_total", Type: metrics.CounterType, Value: float64(c.overwritten)},
+	}
+	for _, reason := range []string{ReasonOutOfOrder, ReasonTooFarAhead, ReasonDuplicate} {
+		out = append(out, metrics.Metric{
+			Name:   "tinyobs_ingest_samples_rejected_total",
+			Type:   metrics.CounterType,
+			Value:  float64(c.rejected[reason]),
+			Labels: map[string]string{"reason": reason},
+		})
+	}
+	return out
+}
@@ -0,0 +1,176 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/badger"
+	"github.com/nicktill/tinyobs/pkg/storage/memory"
+	"github.com/nicktill/tinyobs/pkg/tenant"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleTracker_Filter(t *testing.T) {
+	tracker := newSampleTracker()
+	policy := SamplePolicy{OutOfOrderWindow: 10 * time.Minute, FutureTolerance: time.Minute}
+	now := time.Now()
+	host := map[string]string{"host": "a"}
+
+	accepted, rejected := tracker.filter(tenant.Default, []metrics.Metric{
+		{Name: "cpu", Labels: host, Timestamp: now},
+		{Name: "cpu", Labels: host, Timestamp: now.Add(-5 * time.Minute)},  // Late, within the window
+		{Name: "cpu", Labels: host, Timestamp: now.Add(-15 * time.Minute)}, // Too late for its series
+		{Name: "mem", Timestamp: now.Add(-15 * time.Minute)},               // First sample of a series is never late
+		{Name: "cpu", Labels: host, Timestamp: now.Add(5 * time.Minute)},   // Far future
+	}, policy, now)
+
+	require.Len(t, accepted, 3)
+	require.Equal(t, map[string]int{ReasonOutOfOrder: 1, ReasonTooFarAhead: 1}, rejected)
+
+	// Until the batch is stored, later batches aren't checked against it
+	late := []metrics.Metric{{Name: "mem", Timestamp: now.Add(-30 * time.Minute)}}
+	_, rejected = tracker.filter(tenant.Default, late, policy, now)
+	require.Empty(t, rejected)
+
+	// Once stored, later batches are checked against the newest sample so far
+	tracker.commit(tenant.Default, accepted)
+	_, rejected = tracker.filter(tenant.Default, late, policy, now)
+	require.Equal(t, 1, rejected[ReasonOutOfOrder])
+}
+
+func TestSampleTracker_Seed(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+	policy := SamplePolicy{OutOfOrderWindow: 10 * time.Minute, FutureTolerance: time.Minute}
+	now := time.Now()
+	host := map[string]string{"host": "a"}
+
+	// Stored before a restart: the new tracker knows nothing of it
+	require.NoError(t, store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Labels: host, Timestamp: now.Add(-time.Minute)},
+		{Name: "cpu", Labels: map[string]string{"host": "b"}, Timestamp: now.Add(-time.Minute)},
+	}))
+	tracker := newSampleTracker()
+
+	batch := []metrics.Metric{
+		{Name: "cpu", Labels: host, Timestamp: now.Add(-30 * time.Minute)},                           // Late for its stored series
+		{Name: "cpu", Labels: map[string]string{"host": "c"}, Timestamp: now.Add(-30 * time.Minute)}, // New series
+	}
+	require.NoError(t, tracker.seed(ctx, store, tenant.Default, batch, policy, now))
+	accepted, rejected := tracker.filter(tenant.Default, batch, policy, now)
+	require.Equal(t, map[string]int{ReasonOutOfOrder: 1}, rejected)
+	require.Len(t, accepted, 1)
+	require.Equal(t, "c", accepted[0].Labels["host"])
+}
+
+func TestHandleIngest_SamplePolicy(t *testing.T) {
+	store, err := badger.New(badger.Config{InMemory: true})
+	require.NoError(t, err)
+	defer store.Close()
+
+	handler := NewHandler(store)
+	handler.SetSamplePolicy(SamplePolicy{
+		OutOfOrderWindow: time.Minute,
+		FutureTolerance:  time.Minute,
+		Duplicates:       storage.FirstWriteWins,
+	})
+
+	ts := time.Now().Add(-time.Second).Truncate(time.Millisecond)
+	ingest := func(batch []metrics.Metric) IngestResponse {
+		body, err := json.Marshal(IngestRequest{Metrics: batch})
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		handler.HandleIngest(rr, httptest.NewRequest(http.MethodPost, "/v1/ingest", bytes.NewReader(body)))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp IngestResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := ingest([]metrics.Metric{{Name: "cpu", Value: 1, Timestamp: ts}})
+	require.Equal(t, 1, resp.Count)
+	require.Empty(t, resp.Rejected)
+
+	resp = ingest([]metrics.Metric{
+		{Name: "cpu", Value: 2, Timestamp: ts},                        // Duplicate: first write wins
+		{Name: "cpu", Value: 3, Timestamp: ts.Add(-time.Hour)},        // Out of order
+		{Name: "cpu", Value: 4, Timestamp: ts.Add(24 * time.Hour)},    // Far future
+		{Name: "cpu", Value: 5, Timestamp: ts.Add(-30 * time.Second)}, // Late but within the window
+	})
+	require.Equal(t, 1, resp.Count)
+	require.Equal(t, map[string]int{ReasonDuplicate: 1, ReasonOutOfOrder: 1, ReasonTooFarAhead: 1}, resp.Rejected)
+
+	results, err := store.Query(context.Background(), storage.QueryRequest{Start: ts.Add(-time.Minute), End: ts.Add(time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, m := range results {
+		if m.Timestamp.Equal(ts) {
+			require.Equal(t, 1.0, m.Value, "first write should win")
+		}
+	}
+
+	// Last write wins reports overwrites
+	handler.SetSamplePolicy(SamplePolicy{OutOfOrderWindow: time.Minute, FutureTolerance: time.Minute})
+	resp = ingest([]metrics.Metric{{Name: "cpu", Value: 6, Timestamp: ts}})
+	require.Equal(t, 1, resp.Count)
+	require.Equal(t, 1, resp.Overwritten)
+
+	selfMetrics := make(map[string]float64)
+	for _, m := range handler.SelfMetrics() {
+		selfMetrics[m.Name+m.Labels["reason"]] = m.Value
+	}
+	require.Equal(t, 3.0, selfMetrics["tinyobs_ingest_samples_total"])
+	require.Equal(t, 1.0, selfMetrics["tinyobs_ingest_samples_overwritten_total"])
+	require.Equal(t, 1.0, selfMetrics["tinyobs_ingest_samples_rejected_total"+ReasonDuplicate])
+	require.Equal(t, 1.0, selfMetrics["tinyobs_ingest_samples_rejected_total"+ReasonOutOfOrder])
+}
+
+// failingStore fails writes while fail is set
+type failingStore struct {
+	storage.Storage
+	fail bool
+}
+
+func (s *failingStore) Write(ctx context.Context, batch []metrics.Metric) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return s.Storage.Write(ctx, batch)
+}
+
+func TestHandleIngest_FailedWriteKeepsSeriesBehind(t *testing.T) {
+	mem := memory.New()
+	defer mem.Close()
+	store := &failingStore{Storage: mem, fail: true}
+	handler := NewHandler(store)
+	handler.SetSamplePolicy(SamplePolicy{OutOfOrderWindow: time.Minute, FutureTolerance: time.Minute})
+
+	now := time.Now()
+	ingest := func(ts time.Time) *httptest.ResponseRecorder {
+		body, err := json.Marshal(IngestRequest{Metrics: []metrics.Metric{{Name: "cpu", Value: 1, Timestamp: ts}}})
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		handler.HandleIngest(rr, httptest.NewRequest(http.MethodPost, "/v1/ingest", bytes.NewReader(body)))
+		return rr
+	}
+
+	require.Equal(t, http.StatusInternalServerError, ingest(now).Code)
+
+	// The failed sample was never stored, so an older one isn't late
+	store.fail = false
+	rr := ingest(now.Add(-time.Hour))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp IngestResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, 1, resp.Count)
+	require.Empty(t, resp.Rejected)
+}
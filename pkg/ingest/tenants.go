@@ -0,0 +1,70 @@
+package ingest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/config"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/tenant"
+)
+
+// TenantLimits bounds each tenant separately, so one team can't use up the
+// server's series or disk for everyone else. The server-wide series limit
+// (Handler.SetSeriesLimit) still applies to all tenants together.
+type TenantLimits struct {
+	// MaxSeries is the number of unique series per tenant (0 = MaxUniqueSeries)
+	MaxSeries int
+
+	// MaxStorageBytes is the storage per tenant, estimated from its share
+	// of samples (0 = only the server-wide limit applies)
+	MaxStorageBytes int64
+}
+
+// tenantState holds per-tenant ingest state
+type tenantState struct {
+	cardinality *CardinalityTracker
+
+	// Storage usage, refreshed at most every config.TenantUsageRefresh
+	usageMu   sync.Mutex
+	usage     int64
+	usageTime time.Time
+}
+
+// tenant returns the state of a tenant, creating it on first use
+func (h *Handler) tenant(id string) *tenantState {
+	h.tenantsMu.Lock()
+	defer h.tenantsMu.Unlock()
+
+	t, ok := h.tenants[id]
+	if !ok {
+		t = &tenantState{cardinality: NewCardinalityTrackerWithLimit(h.limits.MaxSeries)}
+		h.tenants[id] = t
+	}
+	return t
+}
+
+// scopedMetric returns a metric under the name it is stored as, so the
+// server-wide cardinality tracker tells tenants' series apart
+func scopedMetric(id string, m metrics.Metric) metrics.Metric {
+	m.Name = tenant.MetricName(id, m.Name)
+	return m
+}
+
+// storageUsage returns the tenant's estimated storage usage in bytes.
+// ctx must carry the tenant, so Stats is scoped to it.
+func (h *Handler) storageUsage(ctx context.Context, t *tenantState) (int64, error) {
+	t.usageMu.Lock()
+	defer t.usageMu.Unlock()
+
+	if time.Since(t.usageTime) < config.TenantUsageRefresh {
+		return t.usage, nil
+	}
+	stats, err := h.storage.Stats(ctx)
+	if err != nil {
+		return 0, err
+	}
+	t.usage, t.usageTime = int64(stats.SizeBytes), time.Now()
+	return t.usage, nil
+}
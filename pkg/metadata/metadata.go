@@ -0,0 +1,238 @@
+// Package metadata keeps one record per metric name: its type, unit and
+// help text. Types are learned from ingested samples; units and help text
+// come from clients that describe their metrics. The registry is small
+// (one entry per metric name, not per series) and saved as a JSON file.
+// Changes can be observed (SetOnUpdate), so replication can send them to
+// followers, which Replay them.
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+)
+
+// Limits on client-provided metadata
+const (
+	MaxUnitLength = 64
+	MaxHelpLength = 1024
+)
+
+// histogramSuffixes are the series a histogram is sent as
+var histogramSuffixes = []string{"_bucket", "_sum", "_count"}
+
+// Registry holds metadata by metric name
+type Registry struct {
+	path string // "" = not persisted
+
+	mu       sync.RWMutex
+	entries  map[string]metrics.Metadata
+	onUpdate func([]metrics.Metadata) // Optional: called with changed entries
+
+	saveMu sync.Mutex // Serializes file writes
+}
+
+// Open loads the registry saved at path, or starts an empty one if there
+// is none. With an empty path the registry is kept in memory only.
+func Open(path string) (*Registry, error) {
+	r := &Registry{path: path, entries: make(map[string]metrics.Metadata)}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	var saved []metrics.Metadata
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata %s: %w", path, err)
+	}
+	for _, md := range saved {
+		r.entries[md.Name] = md
+	}
+	return r, nil
+}
+
+// Validate checks client-provided metadata
+func Validate(md metrics.Metadata) error {
+	switch md.Type {
+	case "", metrics.CounterType, metrics.GaugeType, metrics.HistogramType:
+	default:
+		return fmt.Errorf("unknown type %q for metric %q", md.Type, md.Name)
+	}
+	if len(md.Unit) > MaxUnitLength {
+		return fmt.Errorf("unit of metric %q too long (max %d chars)", md.Name, MaxUnitLength)
+	}
+	if len(md.Help) > MaxHelpLength {
+		return fmt.Errorf("help of metric %q too long (max %d chars)", md.Name, MaxHelpLength)
+	}
+	return nil
+}
+
+// family returns the name a sample's metadata is kept under: histogram
+// series share their base name
+func family(name string, typ metrics.MetricType) string {
+	if typ == metrics.HistogramType {
+		for _, suffix := range histogramSuffixes {
+			if base, ok := strings.CutSuffix(name, suffix); ok {
+				return base
+			}
+		}
+	}
+	return name
+}
+
+// Observe records the types of ingested samples. name maps a sample's
+// metric name to the name it is kept under (e.g. prefixed by tenant).
+func (r *Registry) Observe(batch []metrics.Metric, name func(string) string) error {
+	var changed []metrics.Metadata
+	r.mu.RLock()
+	for _, m := range batch {
+		if m.Type == "" {
+			continue
+		}
+		key := name(family(m.Name, m.Type))
+		if md, ok := r.entries[key]; !ok || md.Type != m.Type {
+			changed = append(changed, metrics.Metadata{Name: key, Type: m.Type})
+		}
+	}
+	r.mu.RUnlock()
+
+	if len(changed) == 0 {
+		return nil // The common case: every metric already known
+	}
+	return r.Update(changed)
+}
+
+// Update merges metadata into the registry: set fields replace stored
+// ones, empty fields keep them. Saves the registry if anything changed,
+// and reports the changed entries to the update hook.
+func (r *Registry) Update(updates []metrics.Metadata) error {
+	changed := r.merge(updates)
+	if len(changed) == 0 {
+		return nil
+	}
+	r.mu.RLock()
+	onUpdate := r.onUpdate
+	r.mu.RUnlock()
+	if onUpdate != nil {
+		onUpdate(changed)
+	}
+	return r.save()
+}
+
+// Replay merges updates made on another node, like Update but without
+// calling the update hook
+func (r *Registry) Replay(updates []metrics.Metadata) error {
+	if len(r.merge(updates)) == 0 {
+		return nil
+	}
+	return r.save()
+}
+
+// SetOnUpdate sets a function called with the entries each Update changes,
+// as they are after the update
+func (r *Registry) SetOnUpdate(fn func([]metrics.Metadata)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onUpdate = fn
+}
+
+// merge applies updates and returns the entries that changed
+func (r *Registry) merge(updates []metrics.Metadata) []metrics.Metadata {
+	var changed []metrics.Metadata
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range updates {
+		md, ok := r.entries[u.Name]
+		merged := md
+		merged.Name = u.Name
+		if u.Type != "" {
+			merged.Type = u.Type
+		}
+		if u.Unit != "" {
+			merged.Unit = u.Unit
+		}
+		if u.Help != "" {
+			merged.Help = u.Help
+		}
+		if !ok || merged != md {
+			r.entries[u.Name] = merged
+			changed = append(changed, merged)
+		}
+	}
+	return changed
+}
+
+// Lookup returns the metadata of a metric. Histogram series (_bucket,
+// _sum, _count) resolve to their histogram's metadata.
+func (r *Registry) Lookup(name string) (metrics.Metadata, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if md, ok := r.entries[name]; ok {
+		return md, true
+	}
+	if base := family(name, metrics.HistogramType); base != name {
+		if md, ok := r.entries[base]; ok && md.Type == metrics.HistogramType {
+			return md, true
+		}
+	}
+	return metrics.Metadata{}, false
+}
+
+// List returns all metadata sorted by name
+func (r *Registry) List() []metrics.Metadata {
+	r.mu.RLock()
+	out := make([]metrics.Metadata, 0, len(r.entries))
+	for _, md := range r.entries {
+		out = append(out, md)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// save writes the registry to its file (temp file + rename)
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	data, err := json.MarshalIndent(r.List(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), ".metadata-*")
+	if err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), r.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package metadata
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+)
+
+func TestRegistry_ObserveAndUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.json")
+	r, err := Open(path)
+	require.NoError(t, err)
+
+	same := func(name string) string { return name }
+	require.NoError(t, r.Observe([]metrics.Metric{
+		{Name: "requests_total", Type: metrics.CounterType},
+		{Name: "latency_seconds_bucket", Type: metrics.HistogramType},
+		{Name: "latency_seconds_sum", Type: metrics.HistogramType},
+		{Name: "untyped"},
+	}, same))
+	require.NoError(t, r.Update([]metrics.Metadata{
+		{Name: "latency_seconds", Unit: "seconds", Help: "Request latency"},
+	}))
+
+	md, ok := r.Lookup("requests_total")
+	require.True(t, ok)
+	require.Equal(t, metrics.CounterType, md.Type)
+
+	// Histogram series resolve to the histogram, which keeps its type
+	md, ok = r.Lookup("latency_seconds_count")
+	require.True(t, ok)
+	require.Equal(t, metrics.Metadata{Name: "latency_seconds", Type: metrics.HistogramType, Unit: "seconds", Help: "Request latency"}, md)
+
+	_, ok = r.Lookup("untyped")
+	require.False(t, ok)
+
+	// Saved and loaded back
+	reopened, err := Open(path)
+	require.NoError(t, err)
+	require.Equal(t, r.List(), reopened.List())
+	require.Len(t, reopened.List(), 2)
+}
+
+func TestRegistry_OnUpdateAndReplay(t *testing.T) {
+	leader, err := Open("")
+	require.NoError(t, err)
+	var sent []metrics.Metadata
+	leader.SetOnUpdate(func(changed []metrics.Metadata) { sent = append(sent, changed...) })
+
+	require.NoError(t, leader.Update([]metrics.Metadata{{Name: "cpu", Type: metrics.GaugeType}}))
+	require.NoError(t, leader.Update([]metrics.Metadata{{Name: "cpu", Unit: "percent"}}))
+	require.NoError(t, leader.Update([]metrics.Metadata{{Name: "cpu", Unit: "percent"}})) // No change, not sent
+	require.Equal(t, []metrics.Metadata{
+		{Name: "cpu", Type: metrics.GaugeType},
+		{Name: "cpu", Type: metrics.GaugeType, Unit: "percent"},
+	}, sent)
+
+	// A follower replaying the changes ends up with the same entries
+	follower, err := Open("")
+	require.NoError(t, err)
+	follower.SetOnUpdate(func([]metrics.Metadata) { t.Error("Replay called the update hook") })
+	require.NoError(t, follower.Replay(sent))
+	require.Equal(t, leader.List(), follower.List())
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate(metrics.Metadata{Name: "x", Type: metrics.GaugeType, Unit: "bytes"}))
+	require.Error(t, Validate(metrics.Metadata{Name: "x", Type: "summary"}))
+	require.Error(t, Validate(metrics.Metadata{Name: "x", Help: string(make([]byte, MaxHelpLength+1))}))
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"strconv"
 	"time"
 
+	"github.com/nicktill/tinyobs/pkg/config"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
 	"github.com/nicktill/tinyobs/pkg/storage"
 )
 
@@ -40,6 +43,8 @@ type Executor struct {
 	config  ExecutorConfig
 	// Track samples loaded per query for limit enforcement
 	samplesLoaded int
+	// Evaluation time of an instant query (zero for range queries)
+	at time.Time
 }
 
 // NewExecutor creates a new query executor with default config
@@ -78,6 +83,46 @@ func (e *Executor) Execute(ctx context.Context, query *Query) (*Result, error) {
 	return result, nil
 }
 
+// Instant evaluates expr at ts, as Prometheus instant queries do: each
+// selector and function takes the latest value of its series within
+// config.QueryInstantLookback of ts, stamped at ts, so series sampled at
+// different times line up for aggregations and binary operators. Every
+// series in the result has one point.
+// The returned Result should be closed with result.Close() to free memory
+func (e *Executor) Instant(ctx context.Context, expr Expr, ts time.Time) (*Result, error) {
+	e.samplesLoaded = 0
+	e.at = ts
+	defer func() { e.at = time.Time{} }()
+
+	lookback := config.QueryInstantLookback
+	result, err := e.executeExpr(ctx, expr, ts.Add(-lookback), ts, lookback)
+	if err != nil {
+		return nil, err
+	}
+	result, _ = e.instantVector(result, nil)
+	result.TotalSamples = len(result.Series)
+	return result, nil
+}
+
+// instantVector reduces each series of a result to its latest point,
+// stamped at the evaluation time, when evaluating an instant query.
+// Series without points are dropped.
+func (e *Executor) instantVector(result *Result, err error) (*Result, error) {
+	if err != nil || e.at.IsZero() {
+		return result, err
+	}
+	series := result.Series[:0]
+	for _, ts := range result.Series {
+		if len(ts.Points) == 0 {
+			continue
+		}
+		ts.Points = []Point{{Time: e.at, Value: ts.Points[len(ts.Points)-1].Value}}
+		series = append(series, ts)
+	}
+	result.Series = series
+	return result, nil
+}
+
 // Result represents the result of a query execution
 // Always call Close() when done to free memory
 type Result struct {
@@ -119,7 +164,7 @@ type Point struct {
 func (e *Executor) executeExpr(ctx context.Context, expr Expr, start, end time.Time, step time.Duration) (*Result, error) {
 	switch ex := expr.(type) {
 	case *VectorSelector:
-		return e.executeVectorSelector(ctx, ex, start, end)
+		return e.instantVector(e.executeVectorSelector(ctx, ex, start, end))
 	case *RangeSelector:
 		return e.executeRangeSelector(ctx, ex, start, end, step)
 	case *BinaryExpr:
@@ -127,9 +172,9 @@ func (e *Executor) executeExpr(ctx context.Context, expr Expr, start, end time.T
 	case *AggregateExpr:
 		return e.executeAggregateExpr(ctx, ex, start, end, step)
 	case *FunctionCall:
-		return e.executeFunctionCall(ctx, ex, start, end, step)
+		return e.instantVector(e.executeFunctionCall(ctx, ex, start, end, step))
 	case *NumberLiteral:
-		return e.executeNumberLiteral(ctx, ex, start, end, step)
+		return e.instantVector(e.executeNumberLiteral(ctx, ex, start, end, step))
 	case *UnaryExpr:
 		return e.executeUnaryExpr(ctx, ex, start, end, step)
 	case *ParenExpr:
@@ -141,18 +186,58 @@ func (e *Executor) executeExpr(ctx context.Context, expr Expr, start, end time.T
 
 // executeVectorSelector executes a vector selector query
 func (e *Executor) executeVectorSelector(ctx context.Context, vec *VectorSelector, start, end time.Time) (*Result, error) {
+	stored, err := e.fetchSeries(ctx, vec, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read each time range from the finest resolution stored
+	series := make([]TimeSeries, 0, len(stored))
+	for _, ss := range stored {
+		samples := storage.MergeResolutions(ss.metrics)
+		ts := TimeSeries{
+			Labels: ss.labels,
+			Points: make([]Point, 0, len(samples)),
+		}
+		for _, m := range samples {
+			ts.Points = append(ts.Points, Point{Time: m.Timestamp, Value: m.Value})
+		}
+		series = append(series, ts)
+	}
+
+	return &Result{Series: series}, nil
+}
+
+// storedSeries is what storage holds for one series: raw samples and
+// compacted aggregates
+type storedSeries struct {
+	labels  map[string]string
+	metrics []metrics.Metric
+}
+
+// fetchSeries queries storage for a vector selector and groups the results
+// into series. Equality matchers narrow the storage query; every matcher is
+// then applied to the results. Without a metric name pinned, series are
+// told apart by name, which is kept as the __name__ label.
+func (e *Executor) fetchSeries(ctx context.Context, vec *VectorSelector, start, end time.Time) ([]storedSeries, error) {
+	matchers, err := vec.storageMatchers()
+	if err != nil {
+		return nil, err
+	}
+
 	// Build query request
 	req := storage.QueryRequest{
-		Start:       start,
-		End:         end,
-		MetricNames: []string{vec.Name},
-		Labels:      make(map[string]string),
+		Start:  start,
+		End:    end,
+		Labels: make(map[string]string),
 	}
-
-	// Add label filters (only exact matches for now, regex later)
-	for _, matcher := range vec.Matchers {
-		if matcher.Op == TokenEqual {
-			req.Labels[matcher.Name] = matcher.Value
+	name, pinned := storage.MetricNameFromMatchers(matchers)
+	if pinned {
+		req.MetricNames = []string{name}
+	}
+	for _, m := range matchers {
+		if m.Type == storage.MatchEqual && m.Name != storage.MetricNameLabel {
+			req.Labels[m.Name] = m.Value
 		}
 	}
 
@@ -169,33 +254,34 @@ func (e *Executor) executeVectorSelector(ctx context.Context, vec *VectorSelecto
 			e.samplesLoaded, e.config.MaxSamples)
 	}
 
-	// Group metrics by label set into time series
-	seriesMap := make(map[string]*TimeSeries)
+	// Group metrics by label set into time series. Raw samples and
+	// compacted aggregates of a series share its user labels.
+	index := make(map[string]int)
+	var series []storedSeries
 	for _, m := range metricsData {
-		key := e.seriesKey(m.Labels)
-		if _, exists := seriesMap[key]; !exists {
-			seriesMap[key] = &TimeSeries{
-				Labels: m.Labels,
-				Points: []Point{},
+		labels := storage.UserLabels(m.Labels)
+		if !storage.MatchesSeries(matchers, m.Name, labels) {
+			continue
+		}
+		if !pinned {
+			named := make(map[string]string, len(labels)+1)
+			for k, v := range labels {
+				named[k] = v
 			}
+			named[storage.MetricNameLabel] = m.Name
+			labels = named
 		}
-		seriesMap[key].Points = append(seriesMap[key].Points, Point{
-			Time:  m.Timestamp,
-			Value: m.Value,
-		})
-	}
-
-	// Convert map to slice
-	series := make([]TimeSeries, 0, len(seriesMap))
-	for _, ts := range seriesMap {
-		// Sort points by time
-		sort.Slice(ts.Points, func(i, j int) bool {
-			return ts.Points[i].Time.Before(ts.Points[j].Time)
-		})
-		series = append(series, *ts)
+		key := e.seriesKey(labels)
+		i, exists := index[key]
+		if !exists {
+			i = len(series)
+			index[key] = i
+			series = append(series, storedSeries{labels: labels})
+		}
+		series[i].metrics = append(series[i].metrics, m)
 	}
 
-	return &Result{Series: series}, nil
+	return series, nil
 }
 
 // executeRangeSelector executes a range selector (returns raw data for functions like rate)
@@ -498,6 +584,12 @@ func (e *Executor) executeFunctionCall(ctx context.Context, fn *FunctionCall, st
 		return e.executeRate(ctx, fn, start, end, step)
 	case "increase":
 		return e.executeIncrease(ctx, fn, start, end, step)
+	case "sum_over_time":
+		return e.executeSumOverTime(ctx, fn, start, end, step)
+	case "histogram_quantile":
+		return e.executeHistogramQuantile(ctx, fn, start, end, step)
+	case "quantile_over_time":
+		return e.executeQuantileOverTime(ctx, fn, start, end, step)
 	default:
 		return nil, fmt.Errorf("unsupported function: %s", fn.Name)
 	}
@@ -531,6 +623,18 @@ func (e *Executor) executeRate(ctx context.Context, fn *FunctionCall, start, end
 			Points: make([]Point, 0, len(ts.Points)), // Pre-allocate
 		}
 
+		// Running increase of the counter, adjusted for resets: a drop
+		// means it restarted from zero
+		total := make([]float64, len(ts.Points))
+		for i := 1; i < len(ts.Points); i++ {
+			prev, cur := ts.Points[i-1].Value, ts.Points[i].Value
+			if cur < prev {
+				total[i] = total[i-1] + cur
+			} else {
+				total[i] = total[i-1] + cur - prev
+			}
+		}
+
 		// For each point, calculate rate using the range duration
 		duration := rangeExpr.Duration.Seconds()
 		// Use two-pointer technique to avoid O(n²) - maintain sliding window
@@ -545,11 +649,8 @@ func (e *Executor) executeRate(ctx context.Context, fn *FunctionCall, start, end
 			}
 
 			if startIdx < i && (ts.Points[startIdx].Time.Before(rangeStart) || ts.Points[startIdx].Time.Equal(rangeStart)) {
-				// Calculate rate: (current - start) / duration
-				rate := (ts.Points[i].Value - ts.Points[startIdx].Value) / duration
-				if rate < 0 {
-					rate = 0 // Counter reset, treat as 0
-				}
+				// Calculate rate: increase since start / duration
+				rate := (total[i] - total[startIdx]) / duration
 				rateSeries.Points = append(rateSeries.Points, Point{
 					Time:  ts.Points[i].Time,
 					Value: rate,
@@ -591,6 +692,235 @@ func (e *Executor) executeIncrease(ctx context.Context, fn *FunctionCall, start,
 	return rateResult, nil
 }
 
+// executeSumOverTime sums each series' values over the range. Over
+// histogram _bucket series, which hold per-flush counts, this counts the
+// observations in the range per bucket.
+func (e *Executor) executeSumOverTime(ctx context.Context, fn *FunctionCall, start, end time.Time, step time.Duration) (*Result, error) {
+	if len(fn.Args) != 1 {
+		return nil, fmt.Errorf("sum_over_time() requires exactly 1 argument, got %d", len(fn.Args))
+	}
+	rangeExpr, ok := fn.Args[0].(*RangeSelector)
+	if !ok {
+		return nil, fmt.Errorf("sum_over_time() requires a range vector argument")
+	}
+
+	data, err := e.executeRangeSelector(ctx, rangeExpr, start, end, step)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	result := &Result{Series: make([]TimeSeries, 0, len(data.Series))}
+	for _, ts := range data.Series {
+		sumSeries := TimeSeries{
+			Labels: ts.Labels,
+			Points: make([]Point, 0, len(ts.Points)),
+		}
+
+		// Sliding window over (t - duration, t]
+		sum := 0.0
+		startIdx := 0
+		for i, p := range ts.Points {
+			sum += p.Value
+			rangeStart := p.Time.Add(-rangeExpr.Duration)
+			for startIdx < i && !ts.Points[startIdx].Time.After(rangeStart) {
+				sum -= ts.Points[startIdx].Value
+				startIdx++
+			}
+			sumSeries.Points = append(sumSeries.Points, Point{Time: p.Time, Value: sum})
+		}
+
+		result.Series = append(result.Series, sumSeries)
+	}
+
+	return result, nil
+}
+
+// executeHistogramQuantile estimates the φ-quantile from histogram buckets:
+// series that differ only in their le (upper bound) label, holding
+// cumulative counts, at the same timestamps
+func (e *Executor) executeHistogramQuantile(ctx context.Context, fn *FunctionCall, start, end time.Time, step time.Duration) (*Result, error) {
+	if len(fn.Args) != 2 {
+		return nil, fmt.Errorf("histogram_quantile() requires exactly 2 arguments, got %d", len(fn.Args))
+	}
+	phi, ok := fn.Args[0].(*NumberLiteral)
+	if !ok {
+		return nil, fmt.Errorf("histogram_quantile() requires a number as first argument")
+	}
+
+	data, err := e.executeExpr(ctx, fn.Args[1], start, end, step)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	// Group bucket series by their labels without le
+	type histogram struct {
+		labels  map[string]string
+		buckets map[time.Time][]bucket
+	}
+	histograms := make(map[string]*histogram)
+	for _, ts := range data.Series {
+		upper, err := strconv.ParseFloat(ts.Labels["le"], 64)
+		if err != nil {
+			continue // Not a bucket series
+		}
+		labels := e.extractGroupLabels(ts.Labels, []string{"le"}, true)
+		key := e.seriesKey(labels)
+		h, exists := histograms[key]
+		if !exists {
+			h = &histogram{labels: labels, buckets: make(map[time.Time][]bucket)}
+			histograms[key] = h
+		}
+		for _, p := range ts.Points {
+			h.buckets[p.Time] = append(h.buckets[p.Time], bucket{upper: upper, count: p.Value})
+		}
+	}
+
+	result := &Result{Series: make([]TimeSeries, 0, len(histograms))}
+	for _, h := range histograms {
+		ts := TimeSeries{Labels: h.labels, Points: make([]Point, 0, len(h.buckets))}
+		for t, buckets := range h.buckets {
+			ts.Points = append(ts.Points, Point{Time: t, Value: bucketQuantile(phi.Value, buckets)})
+		}
+		sort.Slice(ts.Points, func(i, j int) bool {
+			return ts.Points[i].Time.Before(ts.Points[j].Time)
+		})
+		result.Series = append(result.Series, ts)
+	}
+
+	return result, nil
+}
+
+// executeQuantileOverTime estimates the φ-quantile of each series' values
+// over the range. Over raw samples it is exact; once the range reaches
+// compacted data, the aggregates' sketches are merged with the raw samples
+// and the quantile is within storage.SketchAccuracy.
+func (e *Executor) executeQuantileOverTime(ctx context.Context, fn *FunctionCall, start, end time.Time, step time.Duration) (*Result, error) {
+	if len(fn.Args) != 2 {
+		return nil, fmt.Errorf("quantile_over_time() requires exactly 2 arguments, got %d", len(fn.Args))
+	}
+	phi, ok := fn.Args[0].(*NumberLiteral)
+	if !ok {
+		return nil, fmt.Errorf("quantile_over_time() requires a number as first argument")
+	}
+	rangeExpr, ok := fn.Args[1].(*RangeSelector)
+	if !ok {
+		return nil, fmt.Errorf("quantile_over_time() requires a range vector argument")
+	}
+
+	stored, err := e.fetchSeries(ctx, rangeExpr.Vector, start.Add(-rangeExpr.Duration), end)
+	if err != nil {
+		return nil, err
+	}
+
+	// A sample or aggregate, in time order
+	type entry struct {
+		time  time.Time
+		value float64
+		agg   *storage.Aggregate
+	}
+
+	result := &Result{Series: make([]TimeSeries, 0, len(stored))}
+	for _, ss := range stored {
+		raw, aggs := storage.ResolveTiers(ss.metrics)
+		entries := make([]entry, 0, len(raw)+len(aggs))
+		for _, m := range raw {
+			entries = append(entries, entry{time: m.Timestamp, value: m.Value})
+		}
+		for _, agg := range aggs {
+			entries = append(entries, entry{time: agg.Timestamp, agg: agg})
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].time.Before(entries[j].time)
+		})
+
+		ts := TimeSeries{Labels: ss.labels, Points: make([]Point, 0, len(entries))}
+		startIdx := 0
+		for i, en := range entries {
+			// Window over (t - duration, t]
+			rangeStart := en.time.Add(-rangeExpr.Duration)
+			for startIdx < i && !entries[startIdx].time.After(rangeStart) {
+				startIdx++
+			}
+			window := entries[startIdx : i+1]
+
+			compacted := false
+			for _, w := range window {
+				compacted = compacted || w.agg != nil
+			}
+			var value float64
+			if compacted {
+				sketch := storage.NewSketch()
+				for _, w := range window {
+					if w.agg != nil {
+						sketch.Merge(w.agg.Distribution())
+					} else {
+						sketch.Add(w.value)
+					}
+				}
+				value = sketch.Quantile(phi.Value)
+			} else {
+				values := make([]float64, len(window))
+				for j, w := range window {
+					values[j] = w.value
+				}
+				value = storage.Percentile(values, phi.Value)
+			}
+			ts.Points = append(ts.Points, Point{Time: en.time, Value: value})
+		}
+
+		result.Series = append(result.Series, ts)
+	}
+
+	return result, nil
+}
+
+// bucket is one histogram bucket: the number of observations <= upper
+type bucket struct {
+	upper float64
+	count float64
+}
+
+// bucketQuantile estimates the φ-quantile of cumulative buckets, assuming
+// observations are spread evenly within a bucket (as Prometheus does).
+// The quantile is NaN without an +Inf bucket or observations.
+func bucketQuantile(phi float64, buckets []bucket) float64 {
+	if phi < 0 {
+		return math.Inf(-1)
+	}
+	if phi > 1 {
+		return math.Inf(1)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].upper < buckets[j].upper })
+	if len(buckets) < 2 || !math.IsInf(buckets[len(buckets)-1].upper, 1) {
+		return math.NaN()
+	}
+	total := buckets[len(buckets)-1].count
+	if total <= 0 {
+		return math.NaN()
+	}
+
+	rank := phi * total
+	i := sort.Search(len(buckets)-1, func(i int) bool { return buckets[i].count >= rank })
+	if i == len(buckets)-1 {
+		return buckets[len(buckets)-2].upper // In the +Inf bucket: its lower bound
+	}
+	if i == 0 && buckets[0].upper <= 0 {
+		return buckets[0].upper
+	}
+
+	lower, below := 0.0, 0.0
+	if i > 0 {
+		lower, below = buckets[i-1].upper, buckets[i-1].count
+	}
+	inBucket := buckets[i].count - below
+	if inBucket <= 0 {
+		return buckets[i].upper
+	}
+	return lower + (buckets[i].upper-lower)*(rank-below)/inBucket
+}
+
 // executeNumberLiteral returns a constant value
 func (e *Executor) executeNumberLiteral(ctx context.Context, num *NumberLiteral, start, end time.Time, step time.Duration) (*Result, error) {
 	// Generate points at each step
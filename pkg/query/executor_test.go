@@ -2,11 +2,15 @@ package query
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
 	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/memory"
 )
 
 // MockStorage implements storage.Storage for testing
@@ -182,3 +186,341 @@ func TestProductionExecutorConfig(t *testing.T) {
 		t.Errorf("Expected production MaxSamples to be 50M, got %d", config.MaxSamples)
 	}
 }
+
+func TestIncrease_AcrossResolutions(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// A counter sampled every 15s for 20 minutes, reset to zero at 7m30s
+	var raw []metrics.Metric
+	for i := 0; i <= 80; i++ {
+		value := float64(i)
+		if i >= 30 {
+			value = float64(i - 30)
+		}
+		raw = append(raw, metrics.Metric{
+			Name:      "requests_total",
+			Type:      metrics.CounterType,
+			Value:     value,
+			Labels:    map[string]string{"host": "a"},
+			Timestamp: base.Add(time.Duration(i) * 15 * time.Second),
+		})
+	}
+
+	// The same counter after compaction: 5m aggregates for the first 15
+	// minutes (raw data deleted), raw samples after
+	var compacted []metrics.Metric
+	buckets := map[time.Time]*storage.Aggregate{}
+	for _, m := range raw {
+		if !m.Timestamp.Before(base.Add(15 * time.Minute)) {
+			compacted = append(compacted, m)
+			continue
+		}
+		bucket := m.Timestamp.Truncate(5 * time.Minute)
+		if buckets[bucket] == nil {
+			buckets[bucket] = &storage.Aggregate{Name: m.Name, Labels: m.Labels, Timestamp: bucket, Resolution: storage.Resolution5m, Type: m.Type}
+		}
+		buckets[bucket].Add(m.Value)
+	}
+	for _, agg := range buckets {
+		compacted = append(compacted, agg.ToMetric())
+	}
+
+	increase := func(stored []metrics.Metric) float64 {
+		t.Helper()
+		expr, err := NewParser(`increase(requests_total[20m])`).Parse()
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		end := base.Add(20 * time.Minute)
+		result, err := NewExecutor(&MockStorage{metrics: stored}).Execute(context.Background(), &Query{Expr: expr, Start: end, End: end, Step: time.Minute})
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		defer result.Close()
+		if len(result.Series) != 1 || len(result.Series[0].Points) == 0 {
+			t.Fatalf("Expected one series with points, got %+v", result.Series)
+		}
+		if result.Series[0].Labels["__resolution__"] != "" {
+			t.Errorf("Aggregate labels leaked into series labels: %v", result.Series[0].Labels)
+		}
+		points := result.Series[0].Points
+		return points[len(points)-1].Value
+	}
+
+	// 0..29, reset, 0..50
+	want := 29.0 + 50.0
+	if got := increase(raw); math.Abs(got-want) > 1e-9 {
+		t.Errorf("increase over raw data = %v, want %v", got, want)
+	}
+	if got := increase(compacted); math.Abs(got-want) > 1e-9 {
+		t.Errorf("increase over compacted data = %v, want %v", got, want)
+	}
+}
+
+func TestHistogramQuantile_AcrossResolutions(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// An SDK histogram flushed every minute for an hour: each flush sends
+	// the cumulative bucket counts of its observations
+	var raw []metrics.Metric
+	for i := 0; i < 60; i++ {
+		for _, b := range []struct {
+			le    string
+			count float64
+		}{{"0.1", 2}, {"0.5", 5}, {"1", 9}, {"+Inf", 10}} {
+			raw = append(raw, metrics.Metric{
+				Name:      "latency_seconds_bucket",
+				Type:      metrics.HistogramType,
+				Value:     b.count,
+				Labels:    map[string]string{"le": b.le, "service": "api"},
+				Timestamp: base.Add(time.Duration(i) * time.Minute),
+			})
+		}
+	}
+
+	// The same histogram compacted into 5m aggregates
+	buckets := map[string]*storage.Aggregate{}
+	for _, m := range raw {
+		bucket := m.Timestamp.Truncate(5 * time.Minute)
+		key := m.Labels["le"] + "@" + bucket.String()
+		if buckets[key] == nil {
+			buckets[key] = &storage.Aggregate{Name: m.Name, Labels: m.Labels, Timestamp: bucket, Resolution: storage.Resolution5m, Type: m.Type}
+		}
+		buckets[key].Add(m.Value)
+	}
+	var compacted []metrics.Metric
+	for _, agg := range buckets {
+		compacted = append(compacted, agg.ToMetric())
+	}
+
+	quantile := func(stored []metrics.Metric) float64 {
+		t.Helper()
+		expr, err := NewParser(`histogram_quantile(0.4, sum by (le) (sum_over_time(latency_seconds_bucket[1h])))`).Parse()
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		end := base.Add(59 * time.Minute)
+		result, err := NewExecutor(&MockStorage{metrics: stored}).Execute(context.Background(), &Query{Expr: expr, Start: end, End: end, Step: time.Minute})
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		defer result.Close()
+		if len(result.Series) != 1 || len(result.Series[0].Points) == 0 {
+			t.Fatalf("Expected one series with points, got %+v", result.Series)
+		}
+		points := result.Series[0].Points
+		return points[len(points)-1].Value
+	}
+
+	// 600 observations: rank 240 falls in (0.1, 0.5], which holds 180 of
+	// them after the 120 below it
+	want := 0.1 + 0.4*(240.0-120.0)/180.0
+	if got := quantile(raw); math.Abs(got-want) > 1e-9 {
+		t.Errorf("quantile over raw data = %v, want %v", got, want)
+	}
+	if got := quantile(compacted); math.Abs(got-want) > 1e-9 {
+		t.Errorf("quantile over compacted data = %v, want %v", got, want)
+	}
+}
+
+func TestBucketQuantile(t *testing.T) {
+	buckets := func() []bucket {
+		return []bucket{{math.Inf(1), 100}, {1, 100}, {0.5, 50}}
+	}
+	tests := []struct {
+		phi  float64
+		want float64
+	}{
+		{0.25, 0.25}, // Interpolated within (0, 0.5]
+		{0.75, 0.75},
+		{1, 1},
+	}
+	for _, tt := range tests {
+		if got := bucketQuantile(tt.phi, buckets()); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("bucketQuantile(%v) = %v, want %v", tt.phi, got, tt.want)
+		}
+	}
+	if got := bucketQuantile(0.5, []bucket{{1, 10}}); !math.IsNaN(got) {
+		t.Errorf("bucketQuantile without +Inf bucket = %v, want NaN", got)
+	}
+}
+
+func TestQuantileOverTime_AcrossResolutions(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// A gauge sampled every minute for an hour: 1, 2, ..., 60
+	var raw []metrics.Metric
+	for i := 0; i < 60; i++ {
+		raw = append(raw, metrics.Metric{
+			Name:      "queue_depth",
+			Type:      metrics.GaugeType,
+			Value:     float64(i + 1),
+			Labels:    map[string]string{"queue": "jobs"},
+			Timestamp: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	// The first 40 minutes compacted into 5m aggregates, the rest still raw
+	var mixed []metrics.Metric
+	for bucket := 0; bucket < 8; bucket++ {
+		agg := &storage.Aggregate{Name: "queue_depth", Labels: map[string]string{"queue": "jobs"}, Timestamp: base.Add(time.Duration(bucket) * 5 * time.Minute), Resolution: storage.Resolution5m, Type: metrics.GaugeType}
+		for _, m := range raw[bucket*5 : bucket*5+5] {
+			agg.Add(m.Value)
+		}
+		mixed = append(mixed, agg.ToMetric())
+	}
+	mixed = append(mixed, raw[40:]...)
+
+	quantile := func(stored []metrics.Metric) float64 {
+		t.Helper()
+		expr, err := NewParser(`quantile_over_time(0.9, queue_depth[1h])`).Parse()
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		end := base.Add(59 * time.Minute)
+		result, err := NewExecutor(&MockStorage{metrics: stored}).Execute(context.Background(), &Query{Expr: expr, Start: end, End: end, Step: time.Minute})
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		defer result.Close()
+		if len(result.Series) != 1 || len(result.Series[0].Points) == 0 {
+			t.Fatalf("Expected one series with points, got %+v", result.Series)
+		}
+		points := result.Series[0].Points
+		return points[len(points)-1].Value
+	}
+
+	// Exact over raw samples: rank 0.9 * 59 = 53.1 lies between 54 and 55
+	if got := quantile(raw); math.Abs(got-54.1) > 1e-9 {
+		t.Errorf("quantile over raw data = %v, want 54.1", got)
+	}
+	// Within the sketch's accuracy once the range reaches compacted data
+	if got := quantile(mixed); math.Abs(got-54.1) > 54.1*2*storage.SketchAccuracy {
+		t.Errorf("quantile over compacted data = %v, want ~54.1", got)
+	}
+}
+
+func TestInstant_AlignsSeries(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Two counters of one service scraped out of phase, each growing 1/s,
+	// and a third that stopped reporting before the lookback
+	var samples []metrics.Metric
+	for i := 0; i <= 40; i++ {
+		for host, offset := range map[string]time.Duration{"a": 0, "b": 7 * time.Second} {
+			ts := at.Add(-10*time.Minute + time.Duration(i)*15*time.Second + offset)
+			samples = append(samples, metrics.Metric{
+				Name: "requests_total", Type: metrics.CounterType, Value: float64(i * 15),
+				Labels: map[string]string{"service": "api", "host": host}, Timestamp: ts,
+			})
+		}
+	}
+	samples = append(samples, metrics.Metric{
+		Name: "requests_total", Type: metrics.CounterType, Value: 1,
+		Labels: map[string]string{"service": "web", "host": "c"}, Timestamp: at.Add(-time.Hour),
+	})
+	store.Write(ctx, samples)
+
+	instant := func(q string) *Result {
+		t.Helper()
+		expr, err := NewParser(q).Parse()
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		result, err := NewExecutor(store).Instant(ctx, expr, at)
+		if err != nil {
+			t.Fatalf("Instant failed: %v", err)
+		}
+		return result
+	}
+
+	rates := instant(`rate(requests_total[1m])`)
+	defer rates.Close()
+	if len(rates.Series) != 2 {
+		t.Fatalf("rate series = %+v, want hosts a and b", rates.Series)
+	}
+	want := rates.Series[0].Points[0].Value + rates.Series[1].Points[0].Value
+
+	result := instant(`sum by (service) (rate(requests_total[1m]))`)
+	defer result.Close()
+	if len(result.Series) != 1 || result.Series[0].Labels["service"] != "api" {
+		t.Fatalf("Series = %+v, want one for service api", result.Series)
+	}
+	points := result.Series[0].Points
+	if len(points) != 1 || !points[0].Time.Equal(at) {
+		t.Fatalf("Points = %+v, want one at %v", points, at)
+	}
+	if math.Abs(points[0].Value-want) > 1e-9 || want == 0 {
+		t.Errorf("sum of rates = %v, want %v (both hosts)", points[0].Value, want)
+	}
+}
+
+func TestExecute_SelectorMatchers(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+	now := time.Now()
+
+	store.Write(ctx, []metrics.Metric{
+		{Name: "foo", Value: 1, Labels: map[string]string{"job": "api"}, Timestamp: now.Add(-time.Minute)},
+		{Name: "foo", Value: 2, Labels: map[string]string{"job": "batch"}, Timestamp: now.Add(-time.Minute)},
+		{Name: "bar", Value: 3, Labels: map[string]string{"job": "api"}, Timestamp: now.Add(-time.Minute)},
+	})
+
+	tests := []struct {
+		query string
+		want  []float64
+	}{
+		{`{__name__="foo"}`, []float64{1, 2}},
+		{`foo{job!="batch"}`, []float64{1}},
+		{`foo{job=~"b.*"}`, []float64{2}},
+		{`foo{job!~"b.*"}`, []float64{1}},
+		{`{__name__=~"foo|bar", job="api"}`, []float64{1, 3}},
+		{`{job="api"}`, []float64{1, 3}},
+	}
+	for _, test := range tests {
+		expr, err := NewParser(test.query).Parse()
+		if err != nil {
+			t.Fatalf("%s: parse error: %v", test.query, err)
+		}
+		result, err := NewExecutor(store).Execute(ctx, &Query{Expr: expr, Start: now.Add(-time.Hour), End: now})
+		if err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		var got []float64
+		for _, s := range result.Series {
+			for _, p := range s.Points {
+				got = append(got, p.Value)
+			}
+		}
+		sort.Float64s(got)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s = %v, want %v", test.query, got, test.want)
+		}
+		result.Close()
+	}
+
+	// Series of different metrics with the same labels stay apart
+	expr, _ := NewParser(`{job="api"}`).Parse()
+	result, err := NewExecutor(store).Execute(ctx, &Query{Expr: expr, Start: now.Add(-time.Hour), End: now})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	defer result.Close()
+	names := map[string]bool{}
+	for _, s := range result.Series {
+		names[s.Labels["__name__"]] = true
+	}
+	if len(result.Series) != 2 || !names["foo"] || !names["bar"] {
+		t.Errorf("Series = %+v, want foo and bar apart", result.Series)
+	}
+
+	// Invalid regexes are errors, not empty results
+	expr, _ = NewParser(`foo{job=~"("}`).Parse()
+	if _, err := NewExecutor(store).Execute(ctx, &Query{Expr: expr, Start: now.Add(-time.Hour), End: now}); err == nil {
+		t.Error("Invalid regex accepted")
+	}
+}
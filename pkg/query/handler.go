@@ -171,7 +171,7 @@ func (h *Handler) HandleQueryInstant(w http.ResponseWriter, r *http.Request) {
 	// For instant queries, we need a small time window to find the latest values
 	// Counters are stored with timestamps, so we look back 5 minutes to ensure we get data
 	// The aggregation will take the most recent value from each series
-	startTime := queryTime.Add(-5 * time.Minute)
+	startTime := queryTime.Add(-config.QueryInstantLookback)
 	endTime := queryTime
 
 	req := QueryRequest{
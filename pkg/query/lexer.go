@@ -122,7 +122,7 @@ func (l *Lexer) NextToken() Token {
 	case 0:
 		tok = Token{Type: TokenEOF, Literal: ""}
 	default:
-		if isLetter(l.ch) {
+		if isLetter(l.ch) || l.ch == '_' {
 			tok.Literal = l.readIdentifier()
 			tok.Type = lookupKeyword(tok.Literal)
 			return tok
@@ -162,6 +162,9 @@ func (p *Parser) parsePrimaryExpression() Expr {
 		return p.parseParenExpression()
 	case TokenIdentifier:
 		return p.parseVectorOrFunction()
+	case TokenLeftBrace:
+		// Selector without a metric name: {__name__=~"go_.*", job="api"}
+		return p.parseVectorSelector("")
 	case TokenSum, TokenAvg, TokenMax, TokenMin, TokenCount, TokenStddev, TokenStdvar,
 		TokenTopK, TokenBottomK, TokenQuantile, TokenCountValues:
 		return p.parseAggregation()
@@ -2,6 +2,8 @@ package query
 
 import (
 	"testing"
+
+	"github.com/nicktill/tinyobs/pkg/storage"
 )
 
 func TestLexer(t *testing.T) {
@@ -251,3 +253,51 @@ func TestParserUnaryExpression(t *testing.T) {
 		t.Errorf("Expected metric selector, got %T", unary.Expr)
 	}
 }
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []storage.Matcher
+		wantErr  bool
+	}{
+		{
+			input: `http_requests_total{job="api"}`,
+			expected: []storage.Matcher{
+				{Type: storage.MatchEqual, Name: storage.MetricNameLabel, Value: "http_requests_total"},
+				{Type: storage.MatchEqual, Name: "job", Value: "api"},
+			},
+		},
+		{
+			input: `{__name__=~"go_.*", env!="dev"}`,
+			expected: []storage.Matcher{
+				{Type: storage.MatchRegexp, Name: storage.MetricNameLabel, Value: "go_.*"},
+				{Type: storage.MatchNotEqual, Name: "env", Value: "dev"},
+			},
+		},
+		{input: `rate(x[5m])`, wantErr: true},
+		{input: `{}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			matchers, err := ParseSelector(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected error, got matchers %v", matchers)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSelector failed: %v", err)
+			}
+			if len(matchers) != len(tt.expected) {
+				t.Fatalf("Expected %d matchers, got %d", len(tt.expected), len(matchers))
+			}
+			for i, m := range matchers {
+				if m.Type != tt.expected[i].Type || m.Name != tt.expected[i].Name || m.Value != tt.expected[i].Value {
+					t.Errorf("Matcher %d = %s, want %s", i, m, tt.expected[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package query
+
+import (
+	"fmt"
+
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// ParseSelector parses a series selector such as http_requests_total{job="api"}
+// or {__name__=~"go_.*"} into storage matchers. The metric name, if present,
+// becomes an equality matcher on __name__.
+func ParseSelector(input string) ([]storage.Matcher, error) {
+	expr, err := NewParser(input).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	vec, ok := expr.(*VectorSelector)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a series selector", input)
+	}
+
+	matchers, err := vec.storageMatchers()
+	if err != nil {
+		return nil, err
+	}
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("selector %q must contain a metric name or at least one label matcher", input)
+	}
+
+	return matchers, nil
+}
+
+// storageMatchers converts the selector to storage matchers. The metric
+// name, if present, becomes an equality matcher on __name__.
+func (v *VectorSelector) storageMatchers() ([]storage.Matcher, error) {
+	matchers := make([]storage.Matcher, 0, len(v.Matchers)+1)
+	if v.Name != "" {
+		matchers = append(matchers, storage.Matcher{
+			Type:  storage.MatchEqual,
+			Name:  storage.MetricNameLabel,
+			Value: v.Name,
+		})
+	}
+
+	for _, lm := range v.Matchers {
+		m, err := storage.NewMatcher(matchTypeFromToken(lm.Op), lm.Name, lm.Value)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// matchTypeFromToken maps a label match operator token to a storage match type
+func matchTypeFromToken(op TokenType) storage.MatchType {
+	switch op {
+	case TokenNotEqual:
+		return storage.MatchNotEqual
+	case TokenMatch:
+		return storage.MatchRegexp
+	case TokenNotMatch:
+		return storage.MatchNotRegexp
+	default:
+		return storage.MatchEqual
+	}
+}
@@ -0,0 +1,333 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/config"
+	"github.com/nicktill/tinyobs/pkg/httpx"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+)
+
+// errResync means the follower's position is no longer valid in the
+// leader's log (new epoch or truncated log)
+var errResync = errors.New("follower must re-sync from a snapshot")
+
+// Run applies the leader's writes until ctx is done or the node is
+// promoted. Does nothing on a leader. Failed requests are retried after
+// config.ReplicationRetryInterval.
+func (r *Replicator) Run(ctx context.Context) {
+	r.mu.Lock()
+	if r.leaderURL == "" {
+		r.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r.stop = cancel
+	leader := r.leaderURL
+	r.mu.Unlock()
+
+	log.Printf("Following leader %s (read-only)", leader)
+	for ctx.Err() == nil {
+		err := r.step(ctx)
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		r.mu.Lock()
+		r.follow.caughtUp = false
+		r.follow.lastError = err.Error()
+		r.mu.Unlock()
+		log.Printf("Replication from %s failed: %v", leader, err)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(config.ReplicationRetryInterval):
+		}
+	}
+}
+
+// step bootstraps the follower if it has no valid position, otherwise
+// fetches and applies the next log entries
+func (r *Replicator) step(ctx context.Context) error {
+	r.mu.Lock()
+	epoch, applied := r.follow.epoch, r.follow.applied
+	r.mu.Unlock()
+
+	if epoch == "" {
+		return r.bootstrap(ctx)
+	}
+	err := r.pull(ctx, epoch, applied+1)
+	if errors.Is(err, errResync) {
+		log.Printf("Replication position lost (%v), re-syncing", err)
+		r.mu.Lock()
+		r.follow.epoch = ""
+		r.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// LogResponse is a batch of log entries (GET /v1/replication/log)
+type LogResponse struct {
+	Epoch   string  `json:"epoch"`
+	LastSeq uint64  `json:"last_seq"`
+	Entries []Entry `json:"entries"`
+}
+
+// pull long-polls the leader for entries from seq from and applies them
+func (r *Replicator) pull(ctx context.Context, epoch string, from uint64) error {
+	query := url.Values{"from": {strconv.FormatUint(from, 10)}, "epoch": {epoch}}
+	resp, err := r.get(ctx, "/v1/replication/log?"+query.Encode(), config.ReplicationPollTimeout+config.ReplicationTimeout)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return fmt.Errorf("%w: %s", errResync, readError(resp))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("leader returned %s: %s", resp.Status, readError(resp))
+	}
+	var batch LogResponse
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return fmt.Errorf("invalid log response: %w", err)
+	}
+	if batch.Epoch != epoch {
+		return fmt.Errorf("%w: leader epoch changed", errResync)
+	}
+
+	for _, e := range batch.Entries {
+		if err := r.applyEntry(ctx, e); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := &r.follow
+	f.leaderSeq = batch.LastSeq
+	f.lastError = ""
+	if f.applied >= batch.LastSeq {
+		f.caughtUp, f.syncedAt = true, time.Now()
+	} else {
+		f.caughtUp = false
+	}
+	return nil
+}
+
+// applyEntry applies one entry and advances the follower's position
+func (r *Replicator) applyEntry(ctx context.Context, e Entry) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if ctx.Err() != nil {
+		return ctx.Err() // Promoted meanwhile
+	}
+
+	if err := r.apply(ctx, e); err != nil {
+		return fmt.Errorf("failed to apply entry %d: %w", e.Seq, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := &r.follow
+	f.applied = e.Seq
+	f.samples += uint64(len(e.Metrics))
+	if !f.caughtUp {
+		f.syncedAt = e.Time
+	}
+	return nil
+}
+
+// bootstrap copies a snapshot of the leader's samples into the follower's
+// store and positions the follower at the log entry the snapshot includes.
+// Samples are written like any other, so the follower keeps serving (and
+// writing its own self-metrics) meanwhile.
+func (r *Replicator) bootstrap(ctx context.Context) error {
+	resp, err := r.get(ctx, "/v1/replication/snapshot", 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("leader snapshot returned %s: %s", resp.Status, readError(resp))
+	}
+	epoch := resp.Header.Get(epochHeader)
+	seq, err := strconv.ParseUint(resp.Header.Get(seqHeader), 10, 64)
+	if err != nil || epoch == "" {
+		return fmt.Errorf("leader snapshot is missing its log position")
+	}
+
+	start := time.Now()
+	dec := json.NewDecoder(resp.Body)
+	batch := make([]metrics.Metric, 0, config.ReplicationBatchSamples)
+	var samples uint64
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := r.applyWrite(ctx, batch)
+		samples += uint64(len(batch))
+		batch = batch[:0]
+		return err
+	}
+	for {
+		var m metrics.Metric
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read leader snapshot: %w", err)
+		}
+		batch = append(batch, m)
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	// Trailers are only readable once the body is consumed
+	if resp.Trailer.Get(completeTrailer) == "" {
+		return fmt.Errorf("leader snapshot stream ended early")
+	}
+	if err := r.copyMetadata(ctx); err != nil {
+		return err
+	}
+
+	r.setPosition(epoch, seq, start)
+	log.Printf("Copied leader snapshot at entry %d (%d samples) in %v", seq, samples, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// copyMetadata replays the leader's metric metadata into the follower's
+// registry. It is read after the snapshot's log position, and log entries
+// carry whole entries, so replaying the log from there on ends at the
+// leader's state.
+func (r *Replicator) copyMetadata(ctx context.Context) error {
+	if r.metadata == nil {
+		return nil
+	}
+	resp, err := r.get(ctx, "/v1/replication/metadata", config.ReplicationTimeout)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("leader metadata returned %s: %s", resp.Status, readError(resp))
+	}
+	var list []metrics.Metadata
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return fmt.Errorf("invalid leader metadata: %w", err)
+	}
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if ctx.Err() != nil {
+		return ctx.Err() // Promoted meanwhile
+	}
+	if err := r.metadata.Replay(list); err != nil {
+		return fmt.Errorf("failed to copy leader metadata: %w", err)
+	}
+	return nil
+}
+
+// applyWrite writes snapshot samples, unless the node was promoted meanwhile
+func (r *Replicator) applyWrite(ctx context.Context, batch []metrics.Metric) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := r.base.Write(ctx, batch); err != nil {
+		return fmt.Errorf("failed to write leader snapshot: %w", err)
+	}
+	return nil
+}
+
+// setPosition starts following epoch after entry seq
+func (r *Replicator) setPosition(epoch string, seq uint64, syncedAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.follow.epoch = epoch
+	r.follow.applied = seq
+	r.follow.caughtUp = false
+	r.follow.syncedAt = syncedAt
+	r.follow.resyncs++
+}
+
+// leader returns the leader's URL ("" while leading)
+func (r *Replicator) leader() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leaderURL
+}
+
+// get requests a path from the leader. timeout 0 = until ctx is done.
+func (r *Replicator) get(ctx context.Context, path string, timeout time.Duration) (*http.Response, error) {
+	r.mu.Lock()
+	leader, apiKey := r.leaderURL, r.apiKey
+	r.mu.Unlock()
+	if leader == "" {
+		return nil, context.Canceled // Promoted
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		// The body outlives this call: cancel once it's closed
+		resp, err := r.do(ctx, leader+path, apiKey)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+		return resp, nil
+	}
+	return r.do(ctx, leader+path, apiKey)
+}
+
+func (r *Replicator) do(ctx context.Context, target, apiKey string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	return r.client.Do(req)
+}
+
+// cancelOnClose releases a request's timeout when its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// readError returns the error message of a failed leader response
+func readError(resp *http.Response) string {
+	var body httpx.ErrorResponse
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(data, &body) == nil && body.Message != "" {
+		return body.Message
+	}
+	return string(data)
+}
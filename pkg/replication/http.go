@@ -0,0 +1,172 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/config"
+	"github.com/nicktill/tinyobs/pkg/httpx"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// Snapshot stream headers. The log position is known up front; the
+// trailer tells the follower the stream is complete.
+const (
+	epochHeader     = "X-Tinyobs-Replication-Epoch"
+	seqHeader       = "X-Tinyobs-Replication-Seq"
+	completeTrailer = "X-Tinyobs-Replication-Complete"
+)
+
+// HandleLog handles GET /v1/replication/log?from=N&epoch=E.
+// Returns log entries from seq N on, waiting up to
+// config.ReplicationPollTimeout for new writes if there are none yet.
+// Responds 410 Gone if the entries were dropped or the log is not of epoch
+// E (the follower re-syncs).
+func (r *Replicator) HandleLog(w http.ResponseWriter, req *http.Request) {
+	if r.Role() != RoleLeader {
+		httpx.RespondErrorString(w, http.StatusConflict, "node is a follower, not the leader")
+		return
+	}
+	from, err := strconv.ParseUint(req.URL.Query().Get("from"), 10, 64)
+	if err != nil || from == 0 {
+		httpx.RespondErrorString(w, http.StatusBadRequest, "from must be a positive sequence number")
+		return
+	}
+
+	l := r.currentLog()
+	if epoch := req.URL.Query().Get("epoch"); epoch != "" && epoch != l.Epoch() {
+		httpx.RespondErrorString(w, http.StatusGone, fmt.Sprintf("log epoch is %s, not %s", l.Epoch(), epoch))
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), config.ReplicationPollTimeout)
+	l.Wait(ctx, from)
+	cancel()
+
+	entries, last, err := l.Read(from, config.ReplicationBatchSamples)
+	if errors.Is(err, ErrTruncated) {
+		httpx.RespondError(w, http.StatusGone, err)
+		return
+	}
+	if err != nil {
+		httpx.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if entries == nil {
+		entries = []Entry{}
+	}
+	httpx.RespondJSON(w, http.StatusOK, LogResponse{Epoch: l.Epoch(), LastSeq: last, Entries: entries})
+}
+
+// HandleSnapshot handles GET /v1/replication/snapshot.
+// Streams every stored sample as JSON lines, with the log position the
+// snapshot includes, which is where a new follower starts tailing. The
+// store is streamed in one pass, a series at a time (storage.ScanSeries).
+func (r *Replicator) HandleSnapshot(w http.ResponseWriter, req *http.Request) {
+	if r.Role() != RoleLeader {
+		httpx.RespondErrorString(w, http.StatusConflict, "node is a follower, not the leader")
+		return
+	}
+
+	// Every write logged so far is applied, so the snapshot includes it.
+	// Writes logged while it runs may be included too; replaying them is
+	// harmless.
+	l := r.currentLog()
+	seq := l.LastSeq()
+
+	stats, err := r.base.Stats(req.Context())
+	if err != nil {
+		httpx.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Snapshots of large stores outlive the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Replication snapshot: could not lift write deadline: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(epochHeader, l.Epoch())
+	w.Header().Set(seqHeader, strconv.FormatUint(seq, 10))
+	w.Header().Set("Trailer", completeTrailer)
+	w.WriteHeader(http.StatusOK)
+
+	start := time.Now()
+	samples, err := r.writeSnapshot(req.Context(), w, stats.OldestMetric, stats.NewestMetric)
+	if err != nil {
+		// Headers are gone; the missing trailer tells the follower
+		log.Printf("Replication snapshot failed: %v", err)
+		return
+	}
+	w.Header().Set(completeTrailer, "true")
+	log.Printf("Sent replication snapshot at entry %d (%d samples) in %v", seq, samples, time.Since(start).Round(time.Millisecond))
+}
+
+// writeSnapshot encodes every sample in [oldest, newest] to w, holding
+// one series at a time
+func (r *Replicator) writeSnapshot(ctx context.Context, w io.Writer, oldest, newest time.Time) (int, error) {
+	if oldest.IsZero() {
+		return 0, nil // Empty store
+	}
+	enc := json.NewEncoder(w)
+	samples := 0
+	err := storage.ScanSeries(ctx, r.base, storage.QueryRequest{Start: oldest, End: newest}, func(series []metrics.Metric) error {
+		for _, m := range series {
+			if err := enc.Encode(m); err != nil {
+				return err
+			}
+		}
+		samples += len(series)
+		return nil
+	})
+	return samples, err
+}
+
+// HandleMetadata handles GET /v1/replication/metadata.
+// Returns the leader's metric metadata, which a follower copies after the
+// snapshot: later changes reach it through the log.
+func (r *Replicator) HandleMetadata(w http.ResponseWriter, req *http.Request) {
+	if r.Role() != RoleLeader {
+		httpx.RespondErrorString(w, http.StatusConflict, "node is a follower, not the leader")
+		return
+	}
+	list := []metrics.Metadata{}
+	if r.metadata != nil {
+		list = r.metadata.List()
+	}
+	httpx.RespondJSON(w, http.StatusOK, list)
+}
+
+// HandleStatus handles GET /v1/replication/status
+func (r *Replicator) HandleStatus(w http.ResponseWriter, req *http.Request) {
+	httpx.RespondJSON(w, http.StatusOK, r.Status())
+}
+
+// HandlePromote handles POST /v1/replication/promote.
+// Turns a follower into a leader that accepts writes.
+func (r *Replicator) HandlePromote(w http.ResponseWriter, req *http.Request) {
+	if err := r.Promote(); err != nil {
+		httpx.RespondError(w, http.StatusConflict, err)
+		return
+	}
+	httpx.RespondJSON(w, http.StatusOK, r.Status())
+}
+
+// LeaderOnly rejects requests while the node follows a leader, for
+// endpoints that write data
+func (r *Replicator) LeaderOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if leader := r.leader(); leader != "" {
+			httpx.RespondErrorString(w, http.StatusServiceUnavailable, fmt.Sprintf("read-only follower: send writes to the leader at %s", leader))
+			return
+		}
+		next(w, req)
+	}
+}
@@ -0,0 +1,166 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// ErrTruncated means a follower asked for entries the log no longer holds;
+// it has to start over from a snapshot
+var ErrTruncated = errors.New("replication log truncated")
+
+// Op is the kind of change an entry replays
+type Op string
+
+const (
+	OpWrite        Op = "write"
+	OpDelete       Op = "delete"
+	OpDeleteSeries Op = "delete_series" // Tombstones Delete.Matchers in [Delete.From, Delete.Before)
+	OpMetadata     Op = "metadata"      // Merges Metadata into the metric metadata registry
+)
+
+// Entry is one change applied on the leader
+type Entry struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"` // When the leader applied it
+	Op   Op        `json:"op"`
+
+	// OpWrite
+	Metrics []metrics.Metric        `json:"metrics,omitempty"`
+	Policy  storage.DuplicatePolicy `json:"policy,omitempty"`
+
+	// OpDelete and OpDeleteSeries
+	Delete *storage.DeleteOptions `json:"delete,omitempty"`
+
+	// OpMetadata: changed entries, as they are on the leader
+	Metadata []metrics.Metadata `json:"metadata,omitempty"`
+}
+
+// samples is the entry's weight against the log's bound
+func (e Entry) samples() int {
+	if len(e.Metrics) == 0 {
+		return 1
+	}
+	return len(e.Metrics)
+}
+
+// Log is the leader's in-memory write stream. It keeps the most recent
+// entries up to a sample budget; followers further behind re-sync from a
+// snapshot. Sequence numbers start at 1 and only mean something within one
+// epoch: a restarted or newly promoted leader starts a new one.
+type Log struct {
+	epoch      string
+	maxSamples int
+
+	mu      sync.Mutex
+	entries []Entry
+	samples int
+	next    uint64        // Seq of the next entry
+	notify  chan struct{} // Closed (and replaced) on every append
+}
+
+// NewLog creates an empty log keeping up to maxSamples samples
+func NewLog(maxSamples int) *Log {
+	return &Log{
+		epoch:      fmt.Sprintf("%x", time.Now().UnixNano()),
+		maxSamples: maxSamples,
+		next:       1,
+		notify:     make(chan struct{}),
+	}
+}
+
+// Epoch identifies this log; followers of another epoch must re-sync
+func (l *Log) Epoch() string {
+	return l.epoch
+}
+
+// LastSeq returns the sequence number of the latest entry (0 = none yet)
+func (l *Log) LastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.next - 1
+}
+
+// FirstSeq returns the sequence number of the oldest entry still held
+// (LastSeq+1 if the log is empty)
+func (l *Log) FirstSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) == 0 {
+		return l.next
+	}
+	return l.entries[0].Seq
+}
+
+// Append adds an entry, dropping the oldest entries over the sample
+// budget (the latest entry is always kept). Returns its sequence number.
+func (l *Log) Append(e Entry) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.next
+	l.next++
+	l.entries = append(l.entries, e)
+	l.samples += e.samples()
+
+	drop := 0
+	for l.samples > l.maxSamples && drop < len(l.entries)-1 {
+		l.samples -= l.entries[drop].samples()
+		drop++
+	}
+	if drop > 0 {
+		l.entries = append(l.entries[:0:0], l.entries[drop:]...)
+	}
+
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return e.Seq
+}
+
+// Read returns entries from seq from on, up to maxSamples samples (at
+// least one entry if any is available), and the latest sequence number.
+// Returns ErrTruncated if entries from on were already dropped.
+func (l *Log) Read(from uint64, maxSamples int) ([]Entry, uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	last := l.next - 1
+	first := l.next
+	if len(l.entries) > 0 {
+		first = l.entries[0].Seq
+	}
+	if from < first && from <= last || from > l.next {
+		return nil, last, fmt.Errorf("%w: entry %d requested, log holds %d-%d", ErrTruncated, from, first, last)
+	}
+
+	var out []Entry
+	samples := 0
+	for _, e := range l.entries[from-first:] {
+		if len(out) > 0 && samples+e.samples() > maxSamples {
+			break
+		}
+		out = append(out, e)
+		samples += e.samples()
+	}
+	return out, last, nil
+}
+
+// Wait blocks until the log holds entry from, or ctx is done
+func (l *Log) Wait(ctx context.Context, from uint64) {
+	l.mu.Lock()
+	ready, notify := l.next > from, l.notify
+	l.mu.Unlock()
+	if ready {
+		return
+	}
+	select {
+	case <-notify:
+	case <-ctx.Done():
+	}
+}
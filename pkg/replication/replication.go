@@ -0,0 +1,384 @@
+// Package replication keeps read-only followers in sync with a leader.
+//
+// The leader records every write that reaches storage through the
+// replicated view in an in-memory log. A follower bootstraps from a
+// snapshot of the leader's samples, streamed over HTTP, then tails the log
+// from the snapshot's position and applies each entry to its own store.
+// Followers serve queries, reject writes, and can be promoted to leader if
+// the leader is lost.
+//
+// Ingested data, recorded series (pkg/rules) and deletions through the
+// replicated view are replicated, including series deletion: followers
+// tombstone the same series, and purge them on their own schedule. So is
+// metric metadata (pkg/metadata), when a registry is set.
+// Compaction, retention and eviction run on every node against its own
+// copy, and the other admin operations (snapshots, manual compaction runs)
+// apply to the node they are sent to. Check repairs are leader-only.
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/config"
+	"github.com/nicktill/tinyobs/pkg/metadata"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// ErrReadOnly is returned for writes to a follower
+var ErrReadOnly = errors.New("read-only follower: send writes to the leader")
+
+// Role is a node's part in replication
+type Role string
+
+const (
+	RoleLeader   Role = "leader"
+	RoleFollower Role = "follower"
+)
+
+// Replicator is a node's replication state: the leader's write log, or
+// the follower's position in its leader's log.
+type Replicator struct {
+	base     storage.Storage
+	metadata *metadata.Registry // Optional: replicated metric metadata
+	client   *http.Client
+
+	// writeMu orders writes, so the log replays them in the order the
+	// leader applied them (and keeps follower writes out during promotion)
+	writeMu sync.Mutex
+
+	mu        sync.Mutex
+	log       *Log
+	leaderURL string             // Set while following
+	apiKey    string             // Sent to the leader as a Bearer token
+	stop      context.CancelFunc // Stops the follow loop on promotion
+	follow    followState
+}
+
+// followState is the follower's position and lag
+type followState struct {
+	epoch     string    // Leader log epoch the position belongs to
+	applied   uint64    // Last applied entry
+	leaderSeq uint64    // Leader's latest entry, as of the last response
+	caughtUp  bool      // Every leader entry was applied at the last response
+	syncedAt  time.Time // Leader time the follower's data is complete up to
+	samples   uint64    // Samples applied since startup
+	resyncs   uint64    // Snapshots restored since startup
+	lastError string
+}
+
+// New creates a replicator for a node storing into base. It leads until
+// Follow is called.
+func New(base storage.Storage) *Replicator {
+	return &Replicator{
+		base:   base,
+		client: &http.Client{},
+		log:    NewLog(config.ReplicationLogSamples),
+	}
+}
+
+// Follow makes the node a read-only follower of the leader at leaderURL
+// (e.g. "http://leader:8080"). Run applies the leader's writes.
+func (r *Replicator) Follow(leaderURL, apiKey string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.leaderURL = strings.TrimRight(leaderURL, "/")
+	r.apiKey = apiKey
+	r.follow = followState{}
+}
+
+// SetMetadata replicates the metric metadata registry: updates made while
+// leading are recorded for followers, and a follower replays the leader's
+// into it. Call before Run.
+func (r *Replicator) SetMetadata(registry *metadata.Registry) {
+	r.metadata = registry
+	registry.SetOnUpdate(func(changed []metrics.Metadata) {
+		// The registry is already updated: only ErrReadOnly can come back,
+		// and a follower's own updates aren't replicated
+		_ = r.record(func() error { return nil }, Entry{Op: OpMetadata, Metadata: changed})
+	})
+}
+
+// Role returns whether the node currently leads or follows
+func (r *Replicator) Role() Role {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.leaderURL != "" {
+		return RoleFollower
+	}
+	return RoleLeader
+}
+
+// Promote makes a follower the leader: it stops following, accepts writes
+// and starts a new log epoch, so nodes following it re-sync.
+func (r *Replicator) Promote() error {
+	r.mu.Lock()
+	if r.leaderURL == "" {
+		r.mu.Unlock()
+		return errors.New("node is already the leader")
+	}
+	leader := r.leaderURL
+	r.leaderURL = ""
+	if r.stop != nil {
+		r.stop()
+	}
+	r.mu.Unlock()
+
+	// Let an in-flight apply finish before accepting writes
+	r.writeMu.Lock()
+	r.mu.Lock()
+	r.log = NewLog(config.ReplicationLogSamples)
+	r.mu.Unlock()
+	r.writeMu.Unlock()
+
+	log.Printf("Promoted to leader (was following %s)", leader)
+	return nil
+}
+
+// currentLog returns the log new writes are recorded in
+func (r *Replicator) currentLog() *Log {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.log
+}
+
+// Storage returns the replicated view of the store: writes are recorded in
+// the log while leading and rejected with ErrReadOnly while following.
+func (r *Replicator) Storage() *Storage {
+	return &Storage{r: r}
+}
+
+// record applies a change to the base store and logs it, unless following
+func (r *Replicator) record(apply func() error, entry Entry) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	if r.Role() == RoleFollower {
+		return ErrReadOnly
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	entry.Time = time.Now()
+	r.currentLog().Append(entry)
+	return nil
+}
+
+// Status reports a node's replication state
+type Status struct {
+	Role  Role   `json:"role"`
+	Epoch string `json:"epoch,omitempty"` // Leader: its log epoch. Follower: the epoch it follows
+	Seq   uint64 `json:"seq"`             // Leader: latest entry. Follower: latest applied entry
+
+	// Leader only: oldest entry still in the log
+	FirstSeq uint64 `json:"first_seq,omitempty"`
+
+	// Follower only
+	Leader         string  `json:"leader,omitempty"`
+	LeaderSeq      uint64  `json:"leader_seq,omitempty"`
+	LagSeconds     float64 `json:"lag_seconds"`
+	AppliedSamples uint64  `json:"applied_samples,omitempty"`
+	Resyncs        uint64  `json:"resyncs,omitempty"`
+	LastError      string  `json:"last_error,omitempty"`
+}
+
+// Status returns the node's current replication state
+func (r *Replicator) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.leaderURL == "" {
+		return Status{Role: RoleLeader, Epoch: r.log.Epoch(), Seq: r.log.LastSeq(), FirstSeq: r.log.FirstSeq()}
+	}
+	f := r.follow
+	return Status{
+		Role:           RoleFollower,
+		Epoch:          f.epoch,
+		Seq:            f.applied,
+		Leader:         r.leaderURL,
+		LeaderSeq:      f.leaderSeq,
+		LagSeconds:     r.lag(time.Now()).Seconds(),
+		AppliedSamples: f.samples,
+		Resyncs:        f.resyncs,
+		LastError:      f.lastError,
+	}
+}
+
+// lag is how far the follower's data trails the leader: zero while caught
+// up, otherwise the time since its data was last complete. Caller holds r.mu.
+func (r *Replicator) lag(now time.Time) time.Duration {
+	f := r.follow
+	if f.caughtUp || f.syncedAt.IsZero() {
+		return 0
+	}
+	return max(now.Sub(f.syncedAt), 0)
+}
+
+// SelfMetrics reports replication lag while following (tinyobs_replication_*)
+func (r *Replicator) SelfMetrics() []metrics.Metric {
+	status := r.Status()
+	if status.Role != RoleFollower {
+		return nil
+	}
+	return []metrics.Metric{
+		{Name: "tinyobs_replication_lag_seconds", Type: metrics.GaugeType, Value: status.LagSeconds},
+		{Name: "tinyobs_replication_lag_entries", Type: metrics.GaugeType, Value: float64(status.LeaderSeq - min(status.Seq, status.LeaderSeq))},
+		{Name: "tinyobs_replication_applied_samples_total", Type: metrics.CounterType, Value: float64(status.AppliedSamples)},
+	}
+}
+
+// Storage is the replicated view of a node's store. Writes and deletes
+// through it are recorded for followers; reads pass through.
+type Storage struct {
+	r *Replicator
+}
+
+// Write stores metrics and records them for followers
+func (s *Storage) Write(ctx context.Context, batch []metrics.Metric) error {
+	return s.r.record(func() error {
+		return s.r.base.Write(ctx, batch)
+	}, Entry{Op: OpWrite, Metrics: batch})
+}
+
+// WriteWithPolicy stores metrics with the duplicate policy (if the store
+// supports it) and records them, so followers resolve duplicates alike
+func (s *Storage) WriteWithPolicy(ctx context.Context, batch []metrics.Metric, policy storage.DuplicatePolicy) (storage.WriteResult, error) {
+	var result storage.WriteResult
+	err := s.r.record(func() error {
+		if writer, ok := s.r.base.(storage.PolicyWriter); ok {
+			var err error
+			result, err = writer.WriteWithPolicy(ctx, batch, policy)
+			return err
+		}
+		// Backend can't detect duplicates: every sample counts as added
+		result = storage.WriteResult{Added: len(batch)}
+		return s.r.base.Write(ctx, batch)
+	}, Entry{Op: OpWrite, Metrics: batch, Policy: policy})
+	return result, err
+}
+
+// Delete removes metrics and records the deletion for followers
+func (s *Storage) Delete(ctx context.Context, opts storage.DeleteOptions) error {
+	return s.r.record(func() error {
+		return s.r.base.Delete(ctx, opts)
+	}, Entry{Op: OpDelete, Delete: &opts})
+}
+
+// DeleteCounted removes metrics, records the deletion for followers and
+// returns how many samples were removed
+func (s *Storage) DeleteCounted(ctx context.Context, opts storage.DeleteOptions) (int, error) {
+	var deleted int
+	err := s.r.record(func() error {
+		var err error
+		deleted, err = storage.DeleteCounted(ctx, s.r.base, opts)
+		return err
+	}, Entry{Op: OpDelete, Delete: &opts})
+	return deleted, err
+}
+
+// DeleteSeries tombstones series in [start, end) and records the deletion,
+// so followers hide the same data
+func (s *Storage) DeleteSeries(ctx context.Context, matchers []storage.Matcher, start, end time.Time) (*storage.Tombstone, error) {
+	deleter, ok := s.r.base.(storage.SeriesDeleter)
+	if !ok {
+		return nil, errors.New("storage backend does not support series deletion")
+	}
+	var t *storage.Tombstone
+	err := s.r.record(func() error {
+		var err error
+		t, err = deleter.DeleteSeries(ctx, matchers, start, end)
+		return err
+	}, Entry{Op: OpDeleteSeries, Delete: &storage.DeleteOptions{From: start, Before: end, Matchers: matchers}})
+	return t, err
+}
+
+// Tombstones lists the node's unpurged tombstones
+func (s *Storage) Tombstones() []storage.Tombstone {
+	if deleter, ok := s.r.base.(storage.SeriesDeleter); ok {
+		return deleter.Tombstones()
+	}
+	return nil
+}
+
+// CleanTombstones purges the node's tombstoned samples. It isn't recorded:
+// followers purge theirs on their own schedule.
+func (s *Storage) CleanTombstones(ctx context.Context) (int, error) {
+	if deleter, ok := s.r.base.(storage.SeriesDeleter); ok {
+		return deleter.CleanTombstones(ctx)
+	}
+	return 0, nil
+}
+
+// Query reads from the node's store
+func (s *Storage) Query(ctx context.Context, req storage.QueryRequest) ([]metrics.Metric, error) {
+	return s.r.base.Query(ctx, req)
+}
+
+// QueryDownsampled reads from the node's store with the request's step applied
+func (s *Storage) QueryDownsampled(ctx context.Context, req storage.QueryRequest) ([]metrics.Metric, error) {
+	return storage.QueryDownsampled(ctx, s.r.base, req)
+}
+
+// Stats returns the node's storage statistics
+func (s *Storage) Stats(ctx context.Context) (*storage.Stats, error) {
+	return s.r.base.Stats(ctx)
+}
+
+// Close closes the node's store
+func (s *Storage) Close() error {
+	return s.r.base.Close()
+}
+
+// apply replays a leader entry on the follower's store
+func (r *Replicator) apply(ctx context.Context, e Entry) error {
+	switch e.Op {
+	case OpWrite:
+		if writer, ok := r.base.(storage.PolicyWriter); ok && e.Policy != storage.LastWriteWins {
+			_, err := writer.WriteWithPolicy(ctx, e.Metrics, e.Policy)
+			return err
+		}
+		return r.base.Write(ctx, e.Metrics)
+	case OpDelete, OpDeleteSeries:
+		if e.Delete == nil {
+			return fmt.Errorf("entry %d: delete without options", e.Seq)
+		}
+		opts := *e.Delete
+		if err := compileMatchers(opts.Matchers); err != nil {
+			return fmt.Errorf("entry %d: %w", e.Seq, err)
+		}
+		for _, set := range opts.Exclude {
+			if err := compileMatchers(set); err != nil {
+				return fmt.Errorf("entry %d: %w", e.Seq, err)
+			}
+		}
+		if deleter, ok := r.base.(storage.SeriesDeleter); ok && e.Op == OpDeleteSeries {
+			_, err := deleter.DeleteSeries(ctx, opts.Matchers, opts.From, opts.Before)
+			return err
+		}
+		return r.base.Delete(ctx, opts)
+	case OpMetadata:
+		if r.metadata == nil {
+			return nil
+		}
+		return r.metadata.Replay(e.Metadata)
+	default:
+		return fmt.Errorf("entry %d: unknown op %q", e.Seq, e.Op)
+	}
+}
+
+// compileMatchers restores the regexes lost in JSON encoding
+func compileMatchers(matchers []storage.Matcher) error {
+	for i := range matchers {
+		if err := matchers[i].Compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package replication_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicktill/tinyobs/pkg/ingest"
+	"github.com/nicktill/tinyobs/pkg/metadata"
+	"github.com/nicktill/tinyobs/pkg/replication"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/badger"
+)
+
+// node is an in-process TinyObs server with ingest and replication
+type node struct {
+	store      *badger.Storage
+	metadata   *metadata.Registry
+	replicator *replication.Replicator
+	server     *httptest.Server
+	routes     atomic.Pointer[http.ServeMux]
+}
+
+func newNode(t *testing.T) *node {
+	t.Helper()
+	store, err := badger.New(badger.Config{InMemory: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	registry, err := metadata.Open("")
+	require.NoError(t, err)
+
+	n := &node{store: store, metadata: registry}
+	n.start()
+	n.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.routes.Load().ServeHTTP(w, r)
+	}))
+	t.Cleanup(n.server.Close)
+	return n
+}
+
+// start serves the node's store with a fresh replicator, as after a restart
+func (n *node) start() {
+	n.replicator = replication.New(n.store)
+	n.replicator.SetMetadata(n.metadata)
+	ingestHandler := ingest.NewHandler(n.replicator.Storage())
+	ingestHandler.SetMetadata(n.metadata)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/ingest", n.replicator.LeaderOnly(ingestHandler.HandleIngest))
+	mux.HandleFunc("GET /v1/replication/log", n.replicator.HandleLog)
+	mux.HandleFunc("GET /v1/replication/snapshot", n.replicator.HandleSnapshot)
+	mux.HandleFunc("GET /v1/replication/metadata", n.replicator.HandleMetadata)
+	mux.HandleFunc("GET /v1/replication/status", n.replicator.HandleStatus)
+	mux.HandleFunc("POST /v1/replication/promote", n.replicator.HandlePromote)
+	n.routes.Store(mux)
+}
+
+func (n *node) ingest(t *testing.T, ms ...metrics.Metric) int {
+	t.Helper()
+	return n.ingestRequest(t, ingest.IngestRequest{Metrics: ms})
+}
+
+func (n *node) ingestRequest(t *testing.T, req ingest.IngestRequest) int {
+	t.Helper()
+	body, err := json.Marshal(req)
+	require.NoError(t, err)
+	resp, err := http.Post(n.server.URL+"/v1/ingest", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func (n *node) count(t *testing.T, name string) int {
+	t.Helper()
+	results, err := n.store.Query(context.Background(), storage.QueryRequest{
+		Start:       time.Now().Add(-time.Hour),
+		End:         time.Now().Add(time.Hour),
+		MetricNames: []string{name},
+	})
+	require.NoError(t, err)
+	return len(results)
+}
+
+func sample(name string, value float64, ts time.Time) metrics.Metric {
+	return metrics.Metric{Name: name, Type: metrics.GaugeType, Value: value, Timestamp: ts, Labels: map[string]string{"host": "a"}}
+}
+
+func TestReplication_LeaderFollower(t *testing.T) {
+	leader, follower := newNode(t), newNode(t)
+	now := time.Now().Add(-time.Minute)
+
+	// Written before the follower exists: arrives with the snapshot
+	require.Equal(t, http.StatusOK, leader.ingest(t, sample("cpu", 1, now), sample("cpu", 2, now.Add(time.Second))))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	follower.replicator.Follow(leader.server.URL, "")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		follower.replicator.Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool { return follower.count(t, "cpu") == 2 }, 10*time.Second, 20*time.Millisecond)
+
+	// Written afterwards: arrives through the log
+	require.Equal(t, http.StatusOK, leader.ingest(t, sample("cpu", 3, now.Add(2*time.Second)), sample("mem", 4, now)))
+	require.Eventually(t, func() bool {
+		return follower.count(t, "cpu") == 3 && follower.count(t, "mem") == 1
+	}, 10*time.Second, 20*time.Millisecond)
+
+	status := follower.replicator.Status()
+	require.Equal(t, replication.RoleFollower, status.Role)
+	require.Equal(t, leader.replicator.Status().Seq, status.Seq)
+	require.Equal(t, leader.replicator.Status().Epoch, status.Epoch)
+
+	// Caught up: no lag
+	require.Eventually(t, func() bool { return follower.replicator.Status().LagSeconds == 0 }, 10*time.Second, 20*time.Millisecond)
+	lag := map[string]float64{}
+	for _, m := range follower.replicator.SelfMetrics() {
+		lag[m.Name] = m.Value
+	}
+	require.Contains(t, lag, "tinyobs_replication_lag_seconds")
+	require.Zero(t, lag["tinyobs_replication_lag_entries"])
+	require.Empty(t, leader.replicator.SelfMetrics(), "leaders report no lag")
+
+	// Followers are read-only
+	require.Equal(t, http.StatusServiceUnavailable, follower.ingest(t, sample("cpu", 5, now.Add(3*time.Second))))
+	require.ErrorIs(t, follower.replicator.Storage().Write(ctx, []metrics.Metric{sample("cpu", 5, now)}), replication.ErrReadOnly)
+
+	// Promotion stops following and accepts writes
+	resp, err := http.Post(follower.server.URL+"/v1/replication/promote", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("follow loop still running after promotion")
+	}
+	require.Equal(t, replication.RoleLeader, follower.replicator.Role())
+	require.Equal(t, http.StatusOK, follower.ingest(t, sample("cpu", 5, now.Add(3*time.Second))))
+	require.Equal(t, 4, follower.count(t, "cpu"))
+	require.Equal(t, 3, leader.count(t, "cpu"), "writes to the new leader don't flow back")
+}
+
+func TestReplication_ResyncAfterLeaderRestart(t *testing.T) {
+	leader, follower := newNode(t), newNode(t)
+	now := time.Now().Add(-time.Minute)
+	require.Equal(t, http.StatusOK, leader.ingest(t, sample("cpu", 1, now)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	follower.replicator.Follow(leader.server.URL, "")
+	go follower.replicator.Run(ctx)
+	require.Eventually(t, func() bool { return follower.count(t, "cpu") == 1 }, 10*time.Second, 20*time.Millisecond)
+
+	// A restarted leader has a new, empty log: the follower must re-sync
+	// from a snapshot instead of waiting for entries that never come (after
+	// its pending long poll on the old log times out)
+	leader.start()
+	require.Equal(t, http.StatusOK, leader.ingest(t, sample("cpu", 2, now.Add(time.Second))))
+
+	epoch := leader.replicator.Status().Epoch
+	require.Eventually(t, func() bool {
+		return follower.count(t, "cpu") == 2 && follower.replicator.Status().Epoch == epoch
+	}, 20*time.Second, 20*time.Millisecond)
+	require.EqualValues(t, 2, follower.replicator.Status().Resyncs)
+}
+
+func TestReplication_Deletes(t *testing.T) {
+	leader, follower := newNode(t), newNode(t)
+	now := time.Now().Add(-time.Minute)
+	require.Equal(t, http.StatusOK, leader.ingest(t, sample("cpu", 1, now), sample("mem", 2, now), sample("disk", 3, now)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	follower.replicator.Follow(leader.server.URL, "")
+	go follower.replicator.Run(ctx)
+	require.Eventually(t, func() bool { return follower.count(t, "disk") == 1 }, 10*time.Second, 20*time.Millisecond)
+
+	// Series deletion (tombstones) and plain deletes both reach the follower
+	replicated := leader.replicator.Storage()
+	byName := func(name string) []storage.Matcher {
+		return []storage.Matcher{{Type: storage.MatchEqual, Name: storage.MetricNameLabel, Value: name}}
+	}
+	_, err := replicated.DeleteSeries(ctx, byName("cpu"), now.Add(-time.Hour), time.Now())
+	require.NoError(t, err)
+	deleted, err := replicated.DeleteCounted(ctx, storage.DeleteOptions{Before: time.Now(), Matchers: byName("mem")})
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+
+	require.Eventually(t, func() bool {
+		return follower.count(t, "cpu") == 0 && follower.count(t, "mem") == 0
+	}, 10*time.Second, 20*time.Millisecond)
+	require.Len(t, follower.store.Tombstones(), 1, "the follower keeps its own tombstone")
+	require.Equal(t, 1, follower.count(t, "disk"))
+}
+
+func TestReplication_Metadata(t *testing.T) {
+	leader, follower := newNode(t), newNode(t)
+	now := time.Now().Add(-time.Minute)
+	require.Equal(t, http.StatusOK, leader.ingestRequest(t, ingest.IngestRequest{
+		Metrics:  []metrics.Metric{sample("cpu", 1, now)},
+		Metadata: []metrics.Metadata{{Name: "cpu", Unit: "percent", Help: "CPU usage"}},
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	follower.replicator.Follow(leader.server.URL, "")
+	go follower.replicator.Run(ctx)
+
+	// Known before the follower started: copied after the snapshot
+	require.Eventually(t, func() bool { return follower.count(t, "cpu") == 1 }, 10*time.Second, 20*time.Millisecond)
+	md, ok := follower.metadata.Lookup("cpu")
+	require.True(t, ok)
+	require.Equal(t, metrics.Metadata{Name: "cpu", Type: metrics.GaugeType, Unit: "percent", Help: "CPU usage"}, md)
+
+	// Learned afterwards: arrives through the log
+	require.Equal(t, http.StatusOK, leader.ingestRequest(t, ingest.IngestRequest{
+		Metrics:  []metrics.Metric{{Name: "requests_total", Type: metrics.CounterType, Value: 1, Timestamp: now}},
+		Metadata: []metrics.Metadata{{Name: "cpu", Help: "CPU usage, all cores"}},
+	}))
+	require.Eventually(t, func() bool {
+		md, _ := follower.metadata.Lookup("cpu")
+		return md.Help == "CPU usage, all cores"
+	}, 10*time.Second, 20*time.Millisecond)
+	require.Equal(t, leader.metadata.List(), follower.metadata.List())
+}
+
+func TestLog_Truncation(t *testing.T) {
+	l := replication.NewLog(3)
+	for i := 0; i < 5; i++ {
+		l.Append(replication.Entry{Op: replication.OpWrite, Metrics: []metrics.Metric{sample("cpu", float64(i), time.Now())}})
+	}
+	require.EqualValues(t, 5, l.LastSeq())
+	require.EqualValues(t, 3, l.FirstSeq())
+
+	entries, last, err := l.Read(3, 2)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, last)
+	require.Len(t, entries, 2)
+	require.EqualValues(t, 3, entries[0].Seq)
+
+	// Caught up: nothing new
+	entries, _, err = l.Read(6, 2)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	_, _, err = l.Read(2, 2)
+	require.ErrorIs(t, err, replication.ErrTruncated)
+	_, _, err = l.Read(7, 2)
+	require.ErrorIs(t, err, replication.ErrTruncated)
+}
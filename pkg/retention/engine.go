@@ -0,0 +1,224 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/tenant"
+)
+
+// defaultRuleName labels deletions driven by Policy.Defaults in reports
+const defaultRuleName = "default"
+
+// Report lists the deletions a policy performs (or would perform) at a point in time
+type Report struct {
+	GeneratedAt time.Time  `json:"generated_at"`
+	DryRun      bool       `json:"dry_run"`
+	Deletions   []Deletion `json:"deletions"`
+}
+
+// Deletion describes what one rule deletes at one resolution
+type Deletion struct {
+	Rule       string    `json:"rule"`
+	Selector   string    `json:"selector,omitempty"`
+	Resolution string    `json:"resolution"`
+	Retention  string    `json:"retention"`
+	Cutoff     time.Time `json:"cutoff"` // Data before this time is deleted
+
+	// Held is set when the cutoff was held back to keep data that has not
+	// been compacted into the next tier yet
+	Held bool `json:"held,omitempty"`
+
+	// Samples deleted (or that would be, in a dry run)
+	Samples int `json:"samples"`
+
+	// Populated by DryRun only
+	Series int `json:"series"`
+}
+
+// plannedDeletion pairs a report entry with the storage criteria that implement it
+type plannedDeletion struct {
+	Deletion
+	opts storage.DeleteOptions
+}
+
+// plan expands the policy into concrete deletions relative to now.
+//
+// For each resolution, rules are applied in order and each rule excludes the
+// series already claimed by earlier rules (first match wins). Defaults apply
+// to whatever no rule claimed. Rules with retention 0 delete nothing but still
+// claim their series, which is how "keep billing_* forever" protects data
+// from the defaults.
+//
+// Rules match metric names without their tenant prefix, so a rule applies
+// to every tenant's series of that name.
+//
+// Cutoffs are held back to the limit of their resolution, if it has one;
+// a zero limit deletes nothing at that resolution.
+func (p *Policy) plan(now time.Time, limits map[storage.Resolution]time.Time) []plannedDeletion {
+	var planned []plannedDeletion
+
+	for _, res := range p.tiers() {
+		res := res
+		var claimed [][]storage.Matcher
+
+		// cutoff applies the resolution's limit to a retention period
+		cutoff := func(retention time.Duration) (time.Time, bool) {
+			t := now.Add(-retention)
+			if limit, ok := limits[res]; ok && limit.Before(t) {
+				return limit, true
+			}
+			return t, false
+		}
+
+		for _, rule := range p.Rules {
+			if !rule.appliesTo(res) {
+				continue
+			}
+			matchers := tenant.AnyTenant(rule.matchers)
+			if rule.Retention > 0 {
+				before, held := cutoff(rule.Retention)
+				planned = append(planned, plannedDeletion{
+					Deletion: Deletion{
+						Rule:       rule.Name,
+						Selector:   rule.Match,
+						Resolution: resolutionName(res),
+						Retention:  formatRetention(rule.Retention),
+						Cutoff:     before,
+						Held:       held,
+					},
+					opts: storage.DeleteOptions{
+						Before:     before,
+						Resolution: &res,
+						Matchers:   matchers,
+						Exclude:    append([][]storage.Matcher(nil), claimed...),
+					},
+				})
+			}
+			claimed = append(claimed, matchers)
+		}
+
+		if d := p.Defaults[res]; d > 0 {
+			before, held := cutoff(d)
+			planned = append(planned, plannedDeletion{
+				Deletion: Deletion{
+					Rule:       defaultRuleName,
+					Resolution: resolutionName(res),
+					Retention:  formatRetention(d),
+					Cutoff:     before,
+					Held:       held,
+				},
+				opts: storage.DeleteOptions{
+					Before:     before,
+					Resolution: &res,
+					Exclude:    claimed,
+				},
+			})
+		}
+	}
+
+	return planned
+}
+
+// Enforce deletes all data that has outlived its retention
+func (p *Policy) Enforce(ctx context.Context, store storage.Storage, now time.Time) (*Report, error) {
+	return p.EnforceUntil(ctx, store, now, nil)
+}
+
+// EnforceUntil deletes data that has outlived its retention, but never data
+// at or after the limit of its resolution. Compaction passes its watermarks
+// as limits so nothing is deleted before it has been rolled up.
+func (p *Policy) EnforceUntil(ctx context.Context, store storage.Storage, now time.Time, limits map[storage.Resolution]time.Time) (*Report, error) {
+	report := &Report{GeneratedAt: now}
+
+	for _, d := range p.plan(now, limits) {
+		if d.opts.Before.IsZero() {
+			report.Deletions = append(report.Deletions, d.Deletion)
+			continue // Nothing compacted yet: keep everything
+		}
+		deleted, err := storage.DeleteCounted(ctx, store, d.opts)
+		if err != nil {
+			return report, fmt.Errorf("retention rule %q (%s) failed: %w", d.Rule, d.Resolution, err)
+		}
+		d.Samples = deleted
+		report.Deletions = append(report.Deletions, d.Deletion)
+	}
+
+	return report, nil
+}
+
+// DryRun reports how many samples and series each rule would delete, without
+// deleting anything. Expired data is streamed a series at a time
+// (storage.ScanSeries) and counted, never held in memory all at once.
+func (p *Policy) DryRun(ctx context.Context, store storage.Storage, now time.Time) (*Report, error) {
+	return p.DryRunUntil(ctx, store, now, nil)
+}
+
+// DryRunUntil is DryRun with the cutoffs held back to limits, the same way
+// EnforceUntil holds them, so the report matches what enforcement deletes.
+func (p *Policy) DryRunUntil(ctx context.Context, store storage.Storage, now time.Time, limits map[storage.Resolution]time.Time) (*Report, error) {
+	report := &Report{GeneratedAt: now, DryRun: true}
+
+	for _, d := range p.plan(now, limits) {
+		if d.opts.Before.IsZero() {
+			report.Deletions = append(report.Deletions, d.Deletion)
+			continue // Nothing compacted yet: keep everything
+		}
+		req := storage.QueryRequest{
+			End: d.opts.Before,
+		}
+		if name, ok := storage.MetricNameFromMatchers(d.opts.Matchers); ok {
+			req.MetricNames = []string{name}
+		}
+
+		series := make(map[string]bool)
+		err := storage.ScanSeries(ctx, store, req, func(samples []metrics.Metric) error {
+			for _, m := range samples {
+				if !d.opts.Matches(m) {
+					continue
+				}
+				d.Samples++
+				series[seriesKey(m.Name, m.Labels)] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("retention dry run for rule %q (%s) failed: %w", d.Rule, d.Resolution, err)
+		}
+		d.Series = len(series)
+
+		report.Deletions = append(report.Deletions, d.Deletion)
+	}
+
+	return report, nil
+}
+
+// formatRetention renders a retention period the way it is written in config
+func formatRetention(d time.Duration) string {
+	day := 24 * time.Hour
+	if d >= day && d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return d.String()
+}
+
+// seriesKey creates a unique key for a time series (metric name + sorted labels)
+func seriesKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range keys {
+		b.WriteString("," + k + "=" + labels[k])
+	}
+	return b.String()
+}
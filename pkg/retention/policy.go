@@ -0,0 +1,241 @@
+package retention
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/nicktill/tinyobs/pkg/query"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// Default retention per resolution (what compaction used to hardcode)
+const (
+	DefaultRawRetention = 6 * time.Hour      // Keep raw data for 6 hours
+	Default5mRetention  = 7 * 24 * time.Hour // Keep 5m aggregates for 7 days
+	Default1hRetention  = 0                  // Keep 1h aggregates forever
+)
+
+// resolutions lists the tiers retention applies to by default, finest first
+var resolutions = []storage.Resolution{
+	storage.ResolutionRaw,
+	storage.Resolution5m,
+	storage.Resolution1h,
+}
+
+// Policy decides how long each series is kept at each resolution.
+//
+// Rules are evaluated in order and the first rule whose selector matches a
+// series wins for the resolutions it covers. Series no rule matches fall
+// back to Defaults. A retention of 0 means "keep forever".
+type Policy struct {
+	Defaults map[storage.Resolution]time.Duration
+	Rules    []Rule
+
+	// Resolutions are the tiers retention runs over, finest first
+	// (nil = raw, 5m and 1h). Compaction sets them from its tier ladder.
+	Resolutions []storage.Resolution
+}
+
+// Rule sets the retention of the series matched by a selector
+type Rule struct {
+	// Name identifies the rule in reports and logs
+	Name string
+
+	// Match is a series selector, e.g. {__name__=~"go_.*"} or billing_total{env="prod"}
+	Match string
+
+	// Resolution limits the rule to one tier (nil = every tier)
+	Resolution *storage.Resolution
+
+	// Retention is how long matching data is kept (0 = forever)
+	Retention time.Duration
+
+	matchers []storage.Matcher
+}
+
+// Matchers returns the compiled selector (valid after NewPolicy or LoadFile)
+func (r Rule) Matchers() []storage.Matcher {
+	return r.matchers
+}
+
+// tiers returns the resolutions the policy runs over, finest first
+func (p *Policy) tiers() []storage.Resolution {
+	if p.Resolutions != nil {
+		return p.Resolutions
+	}
+	return resolutions
+}
+
+// appliesTo reports whether the rule covers a resolution tier
+func (r Rule) appliesTo(res storage.Resolution) bool {
+	return r.Resolution == nil || *r.Resolution == res
+}
+
+// DefaultPolicy returns the built-in tiered retention with no rules
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Defaults: map[storage.Resolution]time.Duration{
+			storage.ResolutionRaw: DefaultRawRetention,
+			storage.Resolution5m:  Default5mRetention,
+			storage.Resolution1h:  Default1hRetention,
+		},
+	}
+}
+
+// NewPolicy validates rules and compiles their selectors.
+// Resolutions missing from defaults use the built-in defaults.
+func NewPolicy(defaults map[storage.Resolution]time.Duration, rules []Rule) (*Policy, error) {
+	p := DefaultPolicy()
+	for res, d := range defaults {
+		if d < 0 {
+			return nil, fmt.Errorf("default retention for %s must not be negative", resolutionName(res))
+		}
+		p.Defaults[res] = d
+	}
+
+	seen := make(map[string]bool)
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule name %q", rule.Name)
+		}
+		seen[rule.Name] = true
+
+		if rule.Retention < 0 {
+			return nil, fmt.Errorf("rule %q: retention must not be negative", rule.Name)
+		}
+
+		matchers, err := query.ParseSelector(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: invalid match: %w", rule.Name, err)
+		}
+		rule.matchers = matchers
+		p.Rules = append(p.Rules, rule)
+	}
+
+	return p, nil
+}
+
+// fileConfig is the YAML layout of a retention policy file:
+//
+//	defaults:
+//	  raw: 6h
+//	  5m: 7d
+//	  1h: forever
+//	rules:
+//	  - name: histogram-detail
+//	    match: '{__name__="http_request_duration_seconds_bucket"}'
+//	    resolution: raw
+//	    retention: 2d
+//	  - name: drop-go-runtime
+//	    match: '{__name__=~"go_.*"}'
+//	    retention: 24h
+type fileConfig struct {
+	Defaults map[string]string `yaml:"defaults"`
+	Rules    []struct {
+		Name       string `yaml:"name"`
+		Match      string `yaml:"match"`
+		Resolution string `yaml:"resolution"`
+		Retention  string `yaml:"retention"`
+	} `yaml:"rules"`
+}
+
+// LoadFile reads a YAML retention policy
+func LoadFile(path string) (*Policy, error) {
+	return LoadFileWithDefaults(path, nil)
+}
+
+// LoadFileWithDefaults reads a YAML retention policy on top of per-tier
+// defaults: the file's defaults override them, and tiers neither sets use
+// the built-in defaults
+func LoadFileWithDefaults(path string, base map[storage.Resolution]time.Duration) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retention policy: %w", err)
+	}
+
+	var cfg fileConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse retention policy %s: %w", path, err)
+	}
+
+	defaults := make(map[storage.Resolution]time.Duration, len(base)+len(cfg.Defaults))
+	for res, d := range base {
+		defaults[res] = d
+	}
+	for name, value := range cfg.Defaults {
+		res, err := storage.ParseResolution(name)
+		if err != nil {
+			return nil, fmt.Errorf("defaults: %w", err)
+		}
+		d, err := ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("defaults.%s: %w", name, err)
+		}
+		defaults[res] = d
+	}
+
+	rules := make([]Rule, 0, len(cfg.Rules))
+	for i, r := range cfg.Rules {
+		rule := Rule{Name: r.Name, Match: r.Match}
+		if r.Resolution != "" && r.Resolution != "all" {
+			res, err := storage.ParseResolution(r.Resolution)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			rule.Resolution = &res
+		}
+		if rule.Retention, err = ParseDuration(r.Retention); err != nil {
+			return nil, fmt.Errorf("rule %d: retention: %w", i+1, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return NewPolicy(defaults, rules)
+}
+
+// ParseDuration parses a retention period. On top of time.ParseDuration it
+// accepts day/week/year suffixes ("7d", "2w", "1y") and "forever" (= 0).
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "":
+		return 0, fmt.Errorf("retention is required")
+	case "forever", "0":
+		return 0, nil
+	}
+
+	units := map[byte]time.Duration{
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+	if unit, ok := units[s[len(s)-1]]; ok {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// resolutionName formats a resolution for reports ("raw" instead of "")
+func resolutionName(res storage.Resolution) string {
+	if res == storage.ResolutionRaw {
+		return "raw"
+	}
+	return string(res)
+}
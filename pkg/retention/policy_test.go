@@ -0,0 +1,413 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/memory"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"6h", 6 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"1y", 365 * 24 * time.Hour, false},
+		{"forever", 0, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"xd", 0, true},
+		{"-1d", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDuration(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retention.yaml")
+	content := `
+defaults:
+  raw: 12h
+  1h: 1y
+rules:
+  - name: keep-billing
+    match: '{__name__=~"billing_.*"}'
+    retention: forever
+  - match: 'go_goroutines{env="dev"}'
+    resolution: raw
+    retention: 1h
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write policy file: %v", err)
+	}
+
+	policy, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	if got := policy.Defaults[storage.ResolutionRaw]; got != 12*time.Hour {
+		t.Errorf("Expected raw default 12h, got %v", got)
+	}
+	if got := policy.Defaults[storage.Resolution5m]; got != Default5mRetention {
+		t.Errorf("Expected unset 5m default to keep built-in %v, got %v", Default5mRetention, got)
+	}
+	if got := policy.Defaults[storage.Resolution1h]; got != 365*24*time.Hour {
+		t.Errorf("Expected 1h default 1y, got %v", got)
+	}
+
+	if len(policy.Rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(policy.Rules))
+	}
+	if policy.Rules[0].Name != "keep-billing" || policy.Rules[0].Retention != 0 {
+		t.Errorf("Unexpected first rule: %+v", policy.Rules[0])
+	}
+	if policy.Rules[1].Name != "rule-2" {
+		t.Errorf("Expected unnamed rule to be named rule-2, got %q", policy.Rules[1].Name)
+	}
+	if policy.Rules[1].Resolution == nil || *policy.Rules[1].Resolution != storage.ResolutionRaw {
+		t.Errorf("Expected second rule to be limited to raw")
+	}
+	if len(policy.Rules[1].Matchers()) != 2 {
+		t.Errorf("Expected 2 matchers for second rule, got %d", len(policy.Rules[1].Matchers()))
+	}
+}
+
+func TestNewPolicy_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+	}{
+		{"bad selector", []Rule{{Match: "{", Retention: time.Hour}}},
+		{"empty selector", []Rule{{Match: "", Retention: time.Hour}}},
+		{"negative retention", []Rule{{Match: "cpu", Retention: -time.Hour}}},
+		{"duplicate names", []Rule{
+			{Name: "a", Match: "cpu", Retention: time.Hour},
+			{Name: "a", Match: "mem", Retention: time.Hour},
+		}},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewPolicy(nil, tt.rules); err == nil {
+			t.Errorf("%s: expected error, got nil", tt.name)
+		}
+	}
+}
+
+func TestEnforce_FirstMatchWins(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-3 * time.Hour)
+
+	store.Write(ctx, []metrics.Metric{
+		{Name: "go_goroutines", Value: 1, Timestamp: old},
+		{Name: "billing_total", Value: 2, Timestamp: old},
+		{Name: "billing_total", Value: 3, Timestamp: now.Add(-10 * 24 * time.Hour)},
+		{Name: "cpu", Value: 4, Timestamp: old},
+		{Name: "cpu", Value: 5, Timestamp: now.Add(-8 * time.Hour)},
+	})
+
+	policy, err := NewPolicy(nil, []Rule{
+		{Name: "keep-billing", Match: `{__name__=~"billing_.*"}`, Retention: 0},
+		{Name: "drop-go", Match: `{__name__=~"go_.*"}`, Retention: time.Hour},
+		// Never reached for billing_total: keep-billing claims it first
+		{Name: "catch-all", Match: `{__name__=~".+"}`, Retention: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+
+	report, err := policy.Enforce(ctx, store, now)
+	if err != nil {
+		t.Fatalf("Enforce failed: %v", err)
+	}
+	if len(report.Deletions) == 0 {
+		t.Error("Expected report to list deletions")
+	}
+
+	results, err := store.Query(ctx, storage.QueryRequest{End: now})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	remaining := make(map[string]int)
+	for _, m := range results {
+		remaining[m.Name]++
+	}
+
+	if remaining["billing_total"] != 2 {
+		t.Errorf("Expected both billing_total samples kept forever, got %d", remaining["billing_total"])
+	}
+	if remaining["go_goroutines"] != 0 {
+		t.Errorf("Expected go_goroutines deleted by drop-go, got %d", remaining["go_goroutines"])
+	}
+	if remaining["cpu"] != 0 {
+		t.Errorf("Expected cpu deleted by catch-all, got %d", remaining["cpu"])
+	}
+}
+
+func TestEnforce_TenantSeries(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-3 * time.Hour)
+
+	// Tenants' series are stored under prefixed names
+	store.Write(ctx, []metrics.Metric{
+		{Name: "team-a/billing_total", Value: 1, Timestamp: old},
+		{Name: "team-a/go_goroutines", Value: 2, Timestamp: old},
+		{Name: "team-a/cpu", Value: 3, Timestamp: old},
+	})
+
+	policy, err := NewPolicy(nil, []Rule{
+		{Name: "keep-billing", Match: `{__name__=~"billing_.*"}`, Retention: 0},
+		{Name: "drop-go", Match: `{__name__="go_goroutines"}`, Retention: time.Hour},
+		{Name: "keep-rest", Match: `{__name__!="go_goroutines"}`, Retention: 0},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+	policy.Defaults = map[storage.Resolution]time.Duration{storage.ResolutionRaw: time.Hour}
+
+	if _, err := policy.Enforce(ctx, store, now); err != nil {
+		t.Fatalf("Enforce failed: %v", err)
+	}
+
+	results, err := store.Query(ctx, storage.QueryRequest{End: now})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	remaining := make(map[string]int)
+	for _, m := range results {
+		remaining[m.Name]++
+	}
+	want := map[string]int{"team-a/billing_total": 1, "team-a/cpu": 1}
+	if len(remaining) != len(want) || remaining["team-a/billing_total"] != 1 || remaining["team-a/cpu"] != 1 {
+		t.Errorf("Remaining = %v, want %v: rules match names without the tenant prefix", remaining, want)
+	}
+}
+
+func TestEnforce_DefaultsPerResolution(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	agg5m := map[string]string{"__resolution__": string(storage.Resolution5m)}
+
+	store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Timestamp: now.Add(-7 * time.Hour)},                     // raw, expired
+		{Name: "cpu", Value: 2, Timestamp: now.Add(-5 * time.Hour)},                     // raw, kept
+		{Name: "cpu", Value: 3, Labels: agg5m, Timestamp: now.Add(-7 * time.Hour)},      // 5m, kept
+		{Name: "cpu", Value: 4, Labels: agg5m, Timestamp: now.Add(-8 * 24 * time.Hour)}, // 5m, expired
+	})
+
+	if _, err := DefaultPolicy().Enforce(ctx, store, now); err != nil {
+		t.Fatalf("Enforce failed: %v", err)
+	}
+
+	results, err := store.Query(ctx, storage.QueryRequest{End: now})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	kept := make(map[float64]bool)
+	for _, m := range results {
+		kept[m.Value] = true
+	}
+	if len(results) != 2 || !kept[2] || !kept[3] {
+		t.Errorf("Expected samples 2 and 3 to survive, got %v", kept)
+	}
+}
+
+func TestEnforceUntil_HoldsBackToLimits(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	agg5m := map[string]string{"__resolution__": string(storage.Resolution5m)}
+
+	store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Timestamp: now.Add(-9 * time.Hour)},                     // raw, compacted
+		{Name: "cpu", Value: 2, Timestamp: now.Add(-7 * time.Hour)},                     // raw, expired but not compacted
+		{Name: "cpu", Value: 3, Labels: agg5m, Timestamp: now.Add(-8 * 24 * time.Hour)}, // 5m, never rolled up
+	})
+
+	limits := map[storage.Resolution]time.Time{
+		storage.ResolutionRaw: now.Add(-8 * time.Hour),
+		storage.Resolution5m:  {},
+	}
+	report, err := DefaultPolicy().EnforceUntil(ctx, store, now, limits)
+	if err != nil {
+		t.Fatalf("EnforceUntil failed: %v", err)
+	}
+	for _, d := range report.Deletions {
+		if d.Resolution == "raw" && (!d.Held || !d.Cutoff.Equal(limits[storage.ResolutionRaw])) {
+			t.Errorf("Raw deletion = %+v, want held back to the limit", d)
+		}
+	}
+
+	results, err := store.Query(ctx, storage.QueryRequest{End: now})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	kept := make(map[float64]bool)
+	for _, m := range results {
+		kept[m.Value] = true
+	}
+	if len(results) != 2 || !kept[2] || !kept[3] {
+		t.Errorf("Expected samples 2 and 3 to survive, got %v", kept)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-2 * time.Hour)
+
+	store.Write(ctx, []metrics.Metric{
+		{Name: "go_goroutines", Labels: map[string]string{"host": "a"}, Value: 1, Timestamp: old},
+		{Name: "go_goroutines", Labels: map[string]string{"host": "a"}, Value: 2, Timestamp: old.Add(time.Minute)},
+		{Name: "go_goroutines", Labels: map[string]string{"host": "b"}, Value: 3, Timestamp: old},
+		{Name: "go_goroutines", Labels: map[string]string{"host": "b"}, Value: 4, Timestamp: now},
+		{Name: "cpu", Value: 5, Timestamp: old},
+	})
+
+	policy, err := NewPolicy(nil, []Rule{
+		{Name: "drop-go", Match: `{__name__=~"go_.*"}`, Retention: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+
+	report, err := policy.DryRun(ctx, store, now)
+	if err != nil {
+		t.Fatalf("DryRun failed: %v", err)
+	}
+	if !report.DryRun {
+		t.Error("Expected report to be marked as dry run")
+	}
+
+	var found bool
+	for _, d := range report.Deletions {
+		if d.Rule == "drop-go" && d.Resolution == "raw" {
+			found = true
+			if d.Samples != 3 || d.Series != 2 {
+				t.Errorf("Expected 3 samples in 2 series, got %d samples in %d series", d.Samples, d.Series)
+			}
+		}
+		if d.Rule == defaultRuleName && d.Resolution == "raw" && d.Samples != 0 {
+			t.Errorf("Expected default rule to delete nothing, got %d samples", d.Samples)
+		}
+	}
+	if !found {
+		t.Fatal("Expected drop-go deletion in report")
+	}
+
+	// Nothing is actually deleted
+	results, _ := store.Query(ctx, storage.QueryRequest{End: now.Add(time.Second)})
+	if len(results) != 5 {
+		t.Errorf("Expected dry run to keep all 5 samples, got %d", len(results))
+	}
+
+	// Streaming backends are scanned a series at a time, never queried
+	streamed, err := policy.DryRun(ctx, &streamStore{Storage: store}, now)
+	if err != nil {
+		t.Fatalf("DryRun over a streaming store failed: %v", err)
+	}
+	if !reflect.DeepEqual(streamed.Deletions, report.Deletions) {
+		t.Errorf("Streamed deletions = %+v, want %+v", streamed.Deletions, report.Deletions)
+	}
+}
+
+func TestDryRunUntil_MatchesEnforceUntil(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	agg5m := map[string]string{"__resolution__": string(storage.Resolution5m)}
+
+	store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Timestamp: now.Add(-9 * time.Hour)},                     // raw, compacted
+		{Name: "cpu", Value: 2, Timestamp: now.Add(-7 * time.Hour)},                     // raw, expired but not compacted
+		{Name: "cpu", Value: 3, Labels: agg5m, Timestamp: now.Add(-8 * 24 * time.Hour)}, // 5m, never rolled up
+	})
+
+	// The raw watermark lags an hour behind raw retention
+	limits := map[storage.Resolution]time.Time{
+		storage.ResolutionRaw: now.Add(-8 * time.Hour),
+		storage.Resolution5m:  {},
+	}
+	dry, err := DefaultPolicy().DryRunUntil(ctx, store, now, limits)
+	if err != nil {
+		t.Fatalf("DryRunUntil failed: %v", err)
+	}
+	planned := make(map[string]int)
+	for _, d := range dry.Deletions {
+		if d.Resolution == "raw" && (!d.Held || !d.Cutoff.Equal(limits[storage.ResolutionRaw])) {
+			t.Errorf("Raw dry-run deletion = %+v, want held back to the limit", d)
+		}
+		planned[d.Resolution] += d.Samples
+	}
+	if planned["raw"] != 1 || planned["5m"] != 0 {
+		t.Errorf("Dry run planned %v, want 1 raw sample and no 5m samples", planned)
+	}
+
+	// Enforcement deletes exactly what the dry run reported
+	report, err := DefaultPolicy().EnforceUntil(ctx, store, now, limits)
+	if err != nil {
+		t.Fatalf("EnforceUntil failed: %v", err)
+	}
+	deleted := make(map[string]int)
+	for _, d := range report.Deletions {
+		deleted[d.Resolution] += d.Samples
+	}
+	if !reflect.DeepEqual(deleted, planned) {
+		t.Errorf("EnforceUntil deleted %v, dry run planned %v", deleted, planned)
+	}
+}
+
+// streamStore is a SeriesScanner that refuses whole-range queries
+type streamStore struct {
+	storage.Storage
+}
+
+func (s *streamStore) Query(ctx context.Context, req storage.QueryRequest) ([]metrics.Metric, error) {
+	return nil, errors.New("dry run materialized a query")
+}
+
+func (s *streamStore) ScanSeries(ctx context.Context, req storage.QueryRequest, fn func([]metrics.Metric) error) error {
+	return storage.ScanSeries(ctx, s.Storage, req, fn)
+}
@@ -0,0 +1,30 @@
+package rules
+
+import (
+	"net/http"
+
+	"github.com/nicktill/tinyobs/pkg/httpx"
+	"github.com/nicktill/tinyobs/pkg/tenant"
+)
+
+// RulesResponse is the /api/v1/rules response (Prometheus-compatible)
+type RulesResponse struct {
+	Status string    `json:"status"`
+	Data   RulesData `json:"data"`
+}
+
+// RulesData lists the rule groups
+type RulesData struct {
+	Groups []GroupStatus `json:"groups"`
+}
+
+// HandleRules handles GET /api/v1/rules: every group with its rules'
+// health and last evaluation. Rules belong to the default tenant, so other
+// tenants get an empty list.
+func (m *Manager) HandleRules(w http.ResponseWriter, r *http.Request) {
+	groups := []GroupStatus{}
+	if tenant.FromContext(r.Context()) == tenant.Default {
+		groups = m.Groups()
+	}
+	httpx.RespondJSON(w, http.StatusOK, RulesResponse{Status: "success", Data: RulesData{Groups: groups}})
+}
@@ -0,0 +1,251 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/config"
+	"github.com/nicktill/tinyobs/pkg/query"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// Rule health, as reported by /api/v1/rules
+const (
+	HealthUnknown = "unknown" // Not evaluated yet
+	HealthOK      = "ok"
+	HealthErr     = "err"
+)
+
+// Manager evaluates rule groups against a store, each on its own interval
+type Manager struct {
+	store    storage.Storage
+	file     string
+	groups   []*groupState
+	isLeader func() bool
+}
+
+// groupState is a group and the outcome of its last evaluation
+type groupState struct {
+	Group
+	executor *query.Executor // Per group: an executor runs one query at a time
+
+	mu             sync.RWMutex
+	lastEvaluation time.Time
+	evaluationTime time.Duration
+	rules          []ruleState
+}
+
+// ruleState is the outcome of a rule's last evaluation
+type ruleState struct {
+	health         string
+	lastError      string
+	lastEvaluation time.Time
+	evaluationTime time.Duration
+	samples        int
+}
+
+// NewManager returns a manager for groups validated by Validate or LoadFile.
+// file is the path they were loaded from, for reports ("" if none).
+func NewManager(store storage.Storage, file string, groups []Group) *Manager {
+	m := &Manager{store: store, file: file}
+	for _, g := range groups {
+		state := &groupState{Group: g, executor: query.NewExecutor(store), rules: make([]ruleState, len(g.Rules))}
+		for i := range state.rules {
+			state.rules[i].health = HealthUnknown
+		}
+		m.groups = append(m.groups, state)
+	}
+	return m
+}
+
+// SetLeaderCheck makes evaluations skip while isLeader returns false. On a
+// follower, recorded series arrive from the leader instead.
+func (m *Manager) SetLeaderCheck(isLeader func() bool) {
+	m.isLeader = isLeader
+}
+
+// Run evaluates each group at once and then every interval, until ctx is
+// cancelled
+func (m *Manager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, g := range m.groups {
+		wg.Add(1)
+		go func(g *groupState) {
+			defer wg.Done()
+			ticker := time.NewTicker(g.Interval)
+			defer ticker.Stop()
+			for {
+				m.evalGroup(ctx, g, time.Now())
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// Evaluate evaluates every group once at ts
+func (m *Manager) Evaluate(ctx context.Context, ts time.Time) {
+	for _, g := range m.groups {
+		m.evalGroup(ctx, g, ts)
+	}
+}
+
+// evalGroup evaluates a group's rules in order at ts and records the outcome
+func (m *Manager) evalGroup(ctx context.Context, g *groupState, ts time.Time) {
+	if m.isLeader != nil && !m.isLeader() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, min(g.Interval, config.QueryTimeout))
+	defer cancel()
+
+	start := time.Now()
+	states := make([]ruleState, len(g.Rules))
+	for i := range g.Rules {
+		ruleStart := time.Now()
+		samples, err := m.evalRule(ctx, g, &g.Rules[i], ts)
+		states[i] = ruleState{
+			health:         HealthOK,
+			lastEvaluation: ts,
+			evaluationTime: time.Since(ruleStart),
+			samples:        samples,
+		}
+		if err != nil {
+			states[i].health = HealthErr
+			states[i].lastError = err.Error()
+			log.Printf("Rule %s in group %s failed: %v", g.Rules[i].Record, g.Name, err)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lastEvaluation = ts
+	g.evaluationTime = time.Since(start)
+	g.rules = states
+}
+
+// evalRule evaluates a rule at ts and stores the results, returning how
+// many samples were written
+func (m *Manager) evalRule(ctx context.Context, g *groupState, rule *Rule, ts time.Time) (int, error) {
+	result, err := g.executor.Instant(ctx, rule.expr, ts)
+	if err != nil {
+		return 0, err
+	}
+	defer result.Close()
+
+	batch := make([]metrics.Metric, 0, len(result.Series))
+	seen := make(map[string]bool, len(result.Series))
+	for _, series := range result.Series {
+		labels := make(map[string]string, len(series.Labels)+len(rule.Labels))
+		for k, v := range series.Labels {
+			if k != "__name__" {
+				labels[k] = v
+			}
+		}
+		for k, v := range rule.Labels {
+			labels[k] = v
+		}
+
+		key := labelsKey(labels)
+		if seen[key] {
+			return 0, fmt.Errorf("results contain the same labels %s more than once after applying rule labels", key)
+		}
+		seen[key] = true
+
+		batch = append(batch, metrics.Metric{
+			Name:      rule.Record,
+			Type:      metrics.GaugeType,
+			Value:     series.Points[0].Value,
+			Labels:    labels,
+			Timestamp: ts,
+		})
+	}
+
+	if len(batch) == 0 {
+		return 0, nil
+	}
+	if err := m.store.Write(ctx, batch); err != nil {
+		return 0, fmt.Errorf("failed to write results: %w", err)
+	}
+	return len(batch), nil
+}
+
+// labelsKey formats a label set canonically: {a="1", b="2"}
+func labelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%q", k, labels[k])
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// GroupStatus reports a group and its rules' health (Prometheus's
+// /api/v1/rules format)
+type GroupStatus struct {
+	Name           string       `json:"name"`
+	File           string       `json:"file"`
+	Interval       float64      `json:"interval"` // Seconds
+	Rules          []RuleStatus `json:"rules"`
+	LastEvaluation time.Time    `json:"lastEvaluation"`
+	EvaluationTime float64      `json:"evaluationTime"` // Seconds
+}
+
+// RuleStatus reports a rule's health and last evaluation
+type RuleStatus struct {
+	Name           string            `json:"name"`
+	Query          string            `json:"query"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Health         string            `json:"health"` // ok, err or unknown
+	LastError      string            `json:"lastError,omitempty"`
+	LastEvaluation time.Time         `json:"lastEvaluation"`
+	EvaluationTime float64           `json:"evaluationTime"` // Seconds
+	Samples        int               `json:"samples"`        // Written by the last evaluation
+	Type           string            `json:"type"`           // Always "recording"
+}
+
+// Groups reports every group's health, in file order
+func (m *Manager) Groups() []GroupStatus {
+	out := make([]GroupStatus, 0, len(m.groups))
+	for _, g := range m.groups {
+		g.mu.RLock()
+		status := GroupStatus{
+			Name:           g.Name,
+			File:           m.file,
+			Interval:       g.Interval.Seconds(),
+			Rules:          make([]RuleStatus, len(g.Rules)),
+			LastEvaluation: g.lastEvaluation,
+			EvaluationTime: g.evaluationTime.Seconds(),
+		}
+		for i, rule := range g.Rules {
+			state := g.rules[i]
+			status.Rules[i] = RuleStatus{
+				Name:           rule.Record,
+				Query:          rule.Expr,
+				Labels:         rule.Labels,
+				Health:         state.health,
+				LastError:      state.lastError,
+				LastEvaluation: state.lastEvaluation,
+				EvaluationTime: state.evaluationTime.Seconds(),
+				Samples:        state.samples,
+				Type:           "recording",
+			}
+		}
+		g.mu.RUnlock()
+		out = append(out, status)
+	}
+	return out
+}
@@ -0,0 +1,208 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/memory"
+	"github.com/nicktill/tinyobs/pkg/tenant"
+)
+
+// writeRequests stores 10 minutes of http_requests_total for two services,
+// growing 1/s per series, up to at
+func writeRequests(t *testing.T, store storage.Storage, at time.Time) {
+	t.Helper()
+	var samples []metrics.Metric
+	for i := 0; i <= 40; i++ {
+		ts := at.Add(-10*time.Minute + time.Duration(i)*15*time.Second)
+		for _, labels := range []map[string]string{
+			{"service": "api", "host": "a"},
+			{"service": "api", "host": "b"},
+			{"service": "web", "host": "c"},
+		} {
+			samples = append(samples, metrics.Metric{
+				Name: "http_requests_total", Type: metrics.CounterType, Value: float64(i * 15), Labels: labels, Timestamp: ts,
+			})
+		}
+	}
+	if err := store.Write(context.Background(), samples); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+}
+
+func newManager(t *testing.T, store storage.Storage, groups ...Group) *Manager {
+	t.Helper()
+	if err := Validate(groups); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	return NewManager(store, "rules.yaml", groups)
+}
+
+func TestManager_Evaluate(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	writeRequests(t, store, at)
+
+	m := newManager(t, store, Group{Name: "http", Interval: time.Minute, Rules: []Rule{
+		{Record: "service:http_requests:rate5m", Expr: `sum by (service) (rate(http_requests_total[5m]))`, Labels: map[string]string{"source": "recording"}},
+		{Record: "http_requests:count", Expr: `count(http_requests_total)`},
+	}})
+	if got := m.Groups()[0].Rules[0].Health; got != HealthUnknown {
+		t.Errorf("Health before evaluation = %q, want %q", got, HealthUnknown)
+	}
+
+	m.Evaluate(ctx, at)
+
+	results, err := store.Query(ctx, storage.QueryRequest{MetricNames: []string{"service:http_requests:rate5m"}, Start: at, End: at})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Recorded %d samples, want one per service: %+v", len(results), results)
+	}
+	rates := map[string]float64{}
+	for _, r := range results {
+		if !r.Timestamp.Equal(at) || r.Labels["source"] != "recording" || r.Labels["host"] != "" {
+			t.Errorf("Recorded %+v, want service labels and the rule's, at %v", r, at)
+		}
+		rates[r.Labels["service"]] = r.Value
+	}
+	if rates["web"] <= 0 || math.Abs(rates["api"]-2*rates["web"]) > 1e-9 {
+		t.Errorf("Rates = %v, want api (two hosts) twice web (one host)", rates)
+	}
+
+	status := m.Groups()
+	if len(status) != 1 || status[0].Name != "http" || status[0].File != "rules.yaml" || status[0].Interval != 60 {
+		t.Fatalf("Groups = %+v", status)
+	}
+	if !status[0].LastEvaluation.Equal(at) {
+		t.Errorf("Group last evaluation = %v, want %v", status[0].LastEvaluation, at)
+	}
+	for i, want := range []int{2, 1} {
+		rule := status[0].Rules[i]
+		if rule.Health != HealthOK || rule.LastError != "" || rule.Samples != want || rule.Type != "recording" {
+			t.Errorf("Rule %d = %+v, want ok with %d samples", i, rule, want)
+		}
+	}
+}
+
+func TestManager_RuleErrors(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	writeRequests(t, store, at)
+
+	m := newManager(t, store, Group{Name: "g", Interval: time.Minute, Rules: []Rule{
+		{Record: "bad:function", Expr: `irate(http_requests_total[5m])`},
+		// The rule's labels collapse the per-host results into one label set
+		{Record: "bad:labels", Expr: `http_requests_total{service="api"}`, Labels: map[string]string{"host": "all"}},
+		{Record: "good", Expr: `sum(http_requests_total)`},
+	}})
+	m.Evaluate(context.Background(), at)
+
+	rules := m.Groups()[0].Rules
+	if rules[0].Health != HealthErr || !strings.Contains(rules[0].LastError, "unsupported function") {
+		t.Errorf("Rule 0 = %+v, want an unsupported function error", rules[0])
+	}
+	if rules[1].Health != HealthErr || !strings.Contains(rules[1].LastError, "same labels") {
+		t.Errorf("Rule 1 = %+v, want a duplicate labels error", rules[1])
+	}
+	if rules[2].Health != HealthOK {
+		t.Errorf("Rule 2 = %+v, want later rules still evaluated", rules[2])
+	}
+}
+
+func TestManager_SkipsFollowers(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	writeRequests(t, store, at)
+
+	m := newManager(t, store, Group{Name: "g", Interval: time.Minute, Rules: []Rule{
+		{Record: "total", Expr: `sum(http_requests_total)`},
+	}})
+	m.SetLeaderCheck(func() bool { return false })
+	m.Evaluate(context.Background(), at)
+
+	if rule := m.Groups()[0].Rules[0]; rule.Health != HealthUnknown {
+		t.Errorf("Rule = %+v, want it left unevaluated on a follower", rule)
+	}
+	results, _ := store.Query(context.Background(), storage.QueryRequest{MetricNames: []string{"total"}, Start: at, End: at})
+	if len(results) != 0 {
+		t.Errorf("Follower recorded %d samples", len(results))
+	}
+}
+
+func TestManager_Run(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	writeRequests(t, store, time.Now())
+
+	m := newManager(t, store, Group{Name: "g", Interval: 10 * time.Millisecond, Rules: []Rule{
+		{Record: "total", Expr: `sum(http_requests_total)`},
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Groups()[0].Rules[0].Health == HealthUnknown && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if rule := m.Groups()[0].Rules[0]; rule.Health != HealthOK || rule.Samples != 1 {
+		t.Errorf("Rule = %+v, want evaluated by Run", rule)
+	}
+}
+
+func TestHandleRules(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	m := newManager(t, store, Group{Name: "g", Interval: time.Minute, Rules: []Rule{
+		{Record: "total", Expr: `sum(http_requests_total)`},
+	}})
+
+	get := func(ctx context.Context) RulesResponse {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/rules", nil).WithContext(ctx)
+		rr := httptest.NewRecorder()
+		m.HandleRules(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Status = %d, want 200", rr.Code)
+		}
+		var resp RulesResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		return resp
+	}
+
+	resp := get(context.Background())
+	if resp.Status != "success" || len(resp.Data.Groups) != 1 {
+		t.Fatalf("Response = %+v, want the group", resp)
+	}
+	rule := resp.Data.Groups[0].Rules[0]
+	if rule.Name != "total" || rule.Query != `sum(http_requests_total)` || rule.Health != HealthUnknown {
+		t.Errorf("Rule = %+v", rule)
+	}
+
+	// Rules belong to the default tenant
+	if resp := get(tenant.WithID(context.Background(), "team-a")); len(resp.Data.Groups) != 0 {
+		t.Errorf("Tenant team-a got %d groups, want none", len(resp.Data.Groups))
+	}
+}
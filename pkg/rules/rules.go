@@ -0,0 +1,153 @@
+// Package rules evaluates recording rules: PromQL expressions computed on
+// a schedule and stored as new series, so expensive queries (rates summed
+// across hundreds of series) become cheap lookups of a precomputed metric.
+//
+// Rules are loaded from a YAML file in Prometheus's rule-file format and
+// organized in groups. Each group is evaluated on its own interval, its
+// rules in order at one instant, with query.Executor's instant semantics;
+// the results are written through storage under the rule's record name,
+// with the rule's labels added. Alerting rules are not supported.
+package rules
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/nicktill/tinyobs/pkg/config"
+	"github.com/nicktill/tinyobs/pkg/query"
+)
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Rule is a recording rule: the result of Expr, stored as Record
+type Rule struct {
+	Record string            // Metric name the results are stored under
+	Expr   string            // PromQL expression, evaluated at an instant
+	Labels map[string]string // Added to every result, overriding its own
+
+	expr query.Expr
+}
+
+// Group is a set of rules evaluated together, in order, on one interval
+type Group struct {
+	Name     string
+	Interval time.Duration
+	Rules    []Rule
+}
+
+// fileConfig is the YAML layout of a rule file (Prometheus's format):
+//
+//	groups:
+//	  - name: http
+//	    interval: 30s
+//	    rules:
+//	      - record: service:http_requests:rate5m
+//	        expr: sum by (service) (rate(http_requests_total[5m]))
+//	        labels:
+//	          source: recording
+type fileConfig struct {
+	Groups []struct {
+		Name     string `yaml:"name"`
+		Interval string `yaml:"interval"`
+		Rules    []struct {
+			Record string            `yaml:"record"`
+			Alert  string            `yaml:"alert"`
+			Expr   string            `yaml:"expr"`
+			Labels map[string]string `yaml:"labels"`
+		} `yaml:"rules"`
+	} `yaml:"groups"`
+}
+
+// LoadFile reads and validates a YAML rule file. Groups without an
+// interval are evaluated every config.DefaultRuleInterval.
+func LoadFile(path string) ([]Group, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+
+	var cfg fileConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse rules %s: %w", path, err)
+	}
+
+	groups := make([]Group, 0, len(cfg.Groups))
+	for i, g := range cfg.Groups {
+		group := Group{Name: g.Name, Interval: config.DefaultRuleInterval}
+		if g.Interval != "" {
+			if group.Interval, err = time.ParseDuration(g.Interval); err != nil {
+				return nil, fmt.Errorf("group %d: invalid interval %q", i+1, g.Interval)
+			}
+		}
+		for j, r := range g.Rules {
+			if r.Alert != "" {
+				return nil, fmt.Errorf("group %d rule %d: alerting rules are not supported (alert %q)", i+1, j+1, r.Alert)
+			}
+			group.Rules = append(group.Rules, Rule{Record: r.Record, Expr: r.Expr, Labels: r.Labels})
+		}
+		groups = append(groups, group)
+	}
+
+	if err := Validate(groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// Validate checks rule groups and parses their expressions
+func Validate(groups []Group) error {
+	names := make(map[string]bool, len(groups))
+	for i := range groups {
+		g := &groups[i]
+		if g.Name == "" {
+			return fmt.Errorf("group %d: name is required", i+1)
+		}
+		if names[g.Name] {
+			return fmt.Errorf("group %q: duplicate group name", g.Name)
+		}
+		names[g.Name] = true
+		if g.Interval <= 0 {
+			return fmt.Errorf("group %q: interval must be positive", g.Name)
+		}
+		if len(g.Rules) == 0 {
+			return fmt.Errorf("group %q: no rules", g.Name)
+		}
+
+		for j := range g.Rules {
+			if err := g.Rules[j].parse(); err != nil {
+				return fmt.Errorf("group %q rule %d: %w", g.Name, j+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parse validates a rule and parses its expression
+func (r *Rule) parse() error {
+	if !metricNameRe.MatchString(r.Record) {
+		return fmt.Errorf("invalid record name %q", r.Record)
+	}
+	if strings.TrimSpace(r.Expr) == "" {
+		return fmt.Errorf("%s: expr is required", r.Record)
+	}
+	for name := range r.Labels {
+		if !labelNameRe.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("%s: invalid label name %q", r.Record, name)
+		}
+	}
+
+	expr, err := query.NewParser(r.Expr).Parse()
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.Record, err)
+	}
+	r.expr = expr
+	return nil
+}
@@ -0,0 +1,120 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/config"
+)
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	os.WriteFile(path, []byte(`
+groups:
+  - name: http
+    interval: 30s
+    rules:
+      - record: service:http_requests:rate5m
+        expr: sum by (service) (rate(http_requests_total[5m]))
+        labels:
+          source: recording
+      - record: service:http_errors:rate5m
+        expr: sum by (service) (rate(http_errors_total[5m]))
+  - name: cpu
+    rules:
+      - record: host:cpu:avg
+        expr: avg by (host) (cpu_usage)
+`), 0o644)
+
+	groups, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if len(groups) != 2 || groups[0].Name != "http" || groups[1].Name != "cpu" {
+		t.Fatalf("Groups = %+v, want http and cpu", groups)
+	}
+	if groups[0].Interval != 30*time.Second || groups[1].Interval != config.DefaultRuleInterval {
+		t.Errorf("Intervals = %v, %v, want 30s and the default", groups[0].Interval, groups[1].Interval)
+	}
+	rule := groups[0].Rules[0]
+	if len(groups[0].Rules) != 2 || rule.Record != "service:http_requests:rate5m" || rule.Labels["source"] != "recording" {
+		t.Errorf("Rules = %+v", groups[0].Rules)
+	}
+	if rule.expr == nil {
+		t.Error("Expression was not parsed")
+	}
+}
+
+func TestLoadFile_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"alerting rule", `
+groups:
+  - name: g
+    rules:
+      - alert: HighErrorRate
+        expr: rate(errors_total[5m]) > 1
+`, "alerting rules are not supported"},
+		{"bad interval", `
+groups:
+  - name: g
+    interval: often
+    rules:
+      - record: r
+        expr: up
+`, "invalid interval"},
+		{"duplicate group", `
+groups:
+  - name: g
+    rules:
+      - {record: a, expr: up}
+  - name: g
+    rules:
+      - {record: b, expr: up}
+`, "duplicate group name"},
+		{"invalid record name", `
+groups:
+  - name: g
+    rules:
+      - record: team/requests
+        expr: up
+`, "invalid record name"},
+		{"reserved label", `
+groups:
+  - name: g
+    rules:
+      - record: r
+        expr: up
+        labels:
+          __resolution__: 5m
+`, "invalid label name"},
+		{"parse error", `
+groups:
+  - name: g
+    rules:
+      - record: r
+        expr: sum(rate(up[5m])))
+`, "group \"g\" rule 1"},
+		{"no rules", `
+groups:
+  - name: g
+`, "no rules"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.yaml")
+			os.WriteFile(path, []byte(tt.yaml), 0o644)
+			_, err := LoadFile(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadFile error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
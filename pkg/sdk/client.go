@@ -17,6 +17,7 @@ import (
 type ClientConfig struct {
 	Service    string        `json:"service"`
 	APIKey     string        `json:"api_key"`
+	TenantID   string        `json:"tenant_id"` // Optional: tenant to write to when the server doesn't derive it from APIKey
 	Endpoint   string        `json:"endpoint"`
 	FlushEvery time.Duration `json:"flush_every"`
 }
@@ -57,6 +58,7 @@ func New(cfg ClientConfig) (*Client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create transport: %w", err)
 	}
+	trans.SetTenant(cfg.TenantID)
 
 	// Create batcher
 	batcher := batch.New(trans, batch.Config{
@@ -76,8 +78,11 @@ func New(cfg ClientConfig) (*Client, error) {
 	return client, nil
 }
 
-// Counter returns a counter metric with the given name
-func (c *Client) Counter(name string) metrics.CounterInterface {
+// Counter returns a counter metric with the given name. Options such as
+// metrics.WithHelp and metrics.WithUnit describe it to the server.
+func (c *Client) Counter(name string, opts ...metrics.Option) metrics.CounterInterface {
+	c.describe(name, metrics.CounterType, opts)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -90,8 +95,11 @@ func (c *Client) Counter(name string) metrics.CounterInterface {
 	return counter
 }
 
-// Gauge returns a gauge metric with the given name
-func (c *Client) Gauge(name string) metrics.GaugeInterface {
+// Gauge returns a gauge metric with the given name. Options such as
+// metrics.WithHelp and metrics.WithUnit describe it to the server.
+func (c *Client) Gauge(name string, opts ...metrics.Option) metrics.GaugeInterface {
+	c.describe(name, metrics.GaugeType, opts)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -104,8 +112,11 @@ func (c *Client) Gauge(name string) metrics.GaugeInterface {
 	return gauge
 }
 
-// Histogram returns a histogram metric with the given name
-func (c *Client) Histogram(name string) metrics.HistogramInterface {
+// Histogram returns a histogram metric with the given name. Options such as
+// metrics.WithHelp and metrics.WithUnit describe it to the server.
+func (c *Client) Histogram(name string, opts ...metrics.Option) metrics.HistogramInterface {
+	c.describe(name, metrics.HistogramType, opts)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -118,6 +129,20 @@ func (c *Client) Histogram(name string) metrics.HistogramInterface {
 	return histogram
 }
 
+// describe registers a metric's metadata with the transport, if it
+// carries metadata and any options were given
+func (c *Client) describe(name string, typ metrics.MetricType, opts []metrics.Option) {
+	describer, ok := c.transport.(transport.Describer)
+	if !ok || len(opts) == 0 {
+		return
+	}
+	md := metrics.Metadata{Name: name, Type: typ}
+	for _, opt := range opts {
+		opt(&md)
+	}
+	describer.Describe(md)
+}
+
 // Start starts the client and begins collecting metrics
 func (c *Client) Start(ctx context.Context) error {
 	if !c.started.CompareAndSwap(false, true) {
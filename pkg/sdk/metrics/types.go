@@ -21,6 +21,50 @@ type Metric struct {
 	Value     float64           `json:"value"`
 	Labels    map[string]string `json:"labels,omitempty"`
 	Timestamp time.Time         `json:"timestamp"`
+
+	// Aggregate holds the statistics of a compacted sample, which stands
+	// for all samples of its series in one time bucket (nil for raw samples)
+	Aggregate *AggregateStats `json:"aggregate,omitempty"`
+}
+
+// AggregateStats are the statistics of a compacted sample, kept at full
+// precision by every storage backend
+type AggregateStats struct {
+	Sum   float64 `json:"sum"`
+	Count uint64  `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+
+	// Counters: first and last sample, and the increase between them
+	// adjusted for counter resets
+	First    float64 `json:"first,omitempty"`
+	Last     float64 `json:"last,omitempty"`
+	Increase float64 `json:"increase,omitempty"`
+
+	// Gauges: encoded quantile sketch of the values (see storage.Sketch)
+	Sketch []byte `json:"sketch,omitempty"`
+}
+
+// Metadata describes a metric: its type, unit and help text. Histograms
+// are described once under their base name, not per _bucket/_sum/_count.
+type Metadata struct {
+	Name string     `json:"name"`
+	Type MetricType `json:"type,omitempty"`
+	Unit string     `json:"unit,omitempty"` // e.g. "seconds", "bytes"
+	Help string     `json:"help,omitempty"`
+}
+
+// Option adds metadata to a metric when it is created
+type Option func(*Metadata)
+
+// WithHelp describes what a metric measures
+func WithHelp(help string) Option {
+	return func(md *Metadata) { md.Help = help }
+}
+
+// WithUnit sets a metric's unit, e.g. "seconds" or "bytes"
+func WithUnit(unit string) Option {
+	return func(md *Metadata) { md.Unit = unit }
 }
 
 // CounterInterface represents a counter metric
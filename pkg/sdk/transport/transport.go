@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
@@ -16,11 +17,27 @@ type Transport interface {
 	Send(ctx context.Context, metrics []metrics.Metric) error
 }
 
+// Describer is implemented by transports that send metric metadata
+// (type, unit, help) along with samples
+type Describer interface {
+	Describe(md metrics.Metadata)
+}
+
+// MetadataResendInterval is how often all metadata is sent again, so a
+// server that lost it (e.g. a new node) learns it back
+const MetadataResendInterval = 10 * time.Minute
+
 // HTTPTransport implements Transport using HTTP
 type HTTPTransport struct {
 	endpoint string
 	apiKey   string
+	tenantID string
 	client   *http.Client
+
+	mu       sync.Mutex
+	metadata map[string]metrics.Metadata
+	pending  map[string]bool // Described since the last successful send
+	lastAll  time.Time       // When all metadata was last sent
 }
 
 // NewHTTP creates a new HTTP transport
@@ -34,6 +51,56 @@ func NewHTTP(endpoint, apiKey string) (*HTTPTransport, error) {
 	}, nil
 }
 
+// SetTenant sends metrics to a tenant via the X-Scope-OrgID header.
+// Not needed when the server maps the API key to a tenant.
+func (t *HTTPTransport) SetTenant(id string) {
+	t.tenantID = id
+}
+
+// Describe registers a metric's metadata. It is sent with the next batch
+// of metrics, and again every MetadataResendInterval.
+func (t *HTTPTransport) Describe(md metrics.Metadata) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.metadata == nil {
+		t.metadata = make(map[string]metrics.Metadata)
+		t.pending = make(map[string]bool)
+	}
+	if old, ok := t.metadata[md.Name]; ok && old == md {
+		return
+	}
+	t.metadata[md.Name] = md
+	t.pending[md.Name] = true
+}
+
+// pendingMetadata returns the metadata to send with the next batch
+func (t *HTTPTransport) pendingMetadata(now time.Time) []metrics.Metadata {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	all := now.Sub(t.lastAll) >= MetadataResendInterval
+	var out []metrics.Metadata
+	for name, md := range t.metadata {
+		if all || t.pending[name] {
+			out = append(out, md)
+		}
+	}
+	return out
+}
+
+// sentMetadata marks metadata as delivered
+func (t *HTTPTransport) sentMetadata(sent []metrics.Metadata, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(sent) == len(t.metadata) {
+		t.lastAll = now
+	}
+	for _, md := range sent {
+		if t.metadata[md.Name] == md {
+			delete(t.pending, md.Name)
+		}
+	}
+}
+
 // Send sends metrics to the ingest endpoint
 func (t *HTTPTransport) Send(ctx context.Context, metrics []metrics.Metric) error {
 	if len(metrics) == 0 {
@@ -43,6 +110,11 @@ func (t *HTTPTransport) Send(ctx context.Context, metrics []metrics.Metric) erro
 	payload := map[string]interface{}{
 		"metrics": metrics,
 	}
+	now := time.Now()
+	metadata := t.pendingMetadata(now)
+	if len(metadata) > 0 {
+		payload["metadata"] = metadata
+	}
 
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
@@ -58,6 +130,9 @@ func (t *HTTPTransport) Send(ctx context.Context, metrics []metrics.Metric) erro
 	if t.apiKey != "" {
 		req.Header.Set("Authorization", "Bearer "+t.apiKey)
 	}
+	if t.tenantID != "" {
+		req.Header.Set("X-Scope-OrgID", t.tenantID)
+	}
 
 	resp, err := t.client.Do(req)
 	if err != nil {
@@ -69,5 +144,6 @@ func (t *HTTPTransport) Send(ctx context.Context, metrics []metrics.Metric) erro
 		return fmt.Errorf("request failed with status %d", resp.StatusCode)
 	}
 
+	t.sentMetadata(metadata, now)
 	return nil
 }
@@ -319,6 +319,74 @@ func TestHTTPTransport_Send_NoAPIKey(t *testing.T) {
 	}
 }
 
+func TestHTTPTransport_Send_Tenant(t *testing.T) {
+	var receivedTenant string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedTenant = r.Header.Get("X-Scope-OrgID")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	transport, err := NewHTTP(server.URL, "")
+	if err != nil {
+		t.Fatalf("NewHTTP() error = %v", err)
+	}
+	transport.SetTenant("team-a")
+
+	testMetrics := []metrics.Metric{
+		{Name: "test", Type: "counter", Value: 1.0, Timestamp: time.Now()},
+	}
+
+	if err := transport.Send(context.Background(), testMetrics); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if receivedTenant != "team-a" {
+		t.Errorf("X-Scope-OrgID header = %q, want %q", receivedTenant, "team-a")
+	}
+}
+
+func TestHTTPTransport_Send_Metadata(t *testing.T) {
+	var received [][]metrics.Metadata
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Metadata []metrics.Metadata `json:"metadata"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+		received = append(received, payload.Metadata)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	transport, err := NewHTTP(server.URL, "")
+	if err != nil {
+		t.Fatalf("NewHTTP() error = %v", err)
+	}
+	md := metrics.Metadata{Name: "request_duration_seconds", Type: metrics.HistogramType, Unit: "seconds", Help: "Request latency"}
+	transport.Describe(md)
+
+	testMetrics := []metrics.Metric{
+		{Name: "test", Type: "counter", Value: 1.0, Timestamp: time.Now()},
+	}
+	for i := 0; i < 2; i++ {
+		if err := transport.Send(context.Background(), testMetrics); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("Received %d requests, want 2", len(received))
+	}
+	if len(received[0]) != 1 || received[0][0] != md {
+		t.Errorf("First request metadata = %+v, want %+v", received[0], md)
+	}
+	if len(received[1]) != 0 {
+		t.Errorf("Second request metadata = %+v, want none (already sent)", received[1])
+	}
+}
+
 func TestHTTPTransport_Send_Timeout(t *testing.T) {
 	// Create server that never responds
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
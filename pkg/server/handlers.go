@@ -6,31 +6,37 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"github.com/nicktill/tinyobs/pkg/admin"
 	"github.com/nicktill/tinyobs/pkg/export"
 	"github.com/nicktill/tinyobs/pkg/httpx"
 	"github.com/nicktill/tinyobs/pkg/ingest"
 	"github.com/nicktill/tinyobs/pkg/query"
+	"github.com/nicktill/tinyobs/pkg/replication"
+	"github.com/nicktill/tinyobs/pkg/rules"
 	"github.com/nicktill/tinyobs/pkg/server/monitor"
+	"github.com/nicktill/tinyobs/pkg/tenant"
 )
 
 var startTime = time.Now()
 
 // StorageUsage represents current storage usage stats.
 type StorageUsage struct {
-	UsedBytes int64 `json:"used_bytes"`
-	MaxBytes  int64 `json:"max_bytes"`
+	UsedBytes int64                   `json:"used_bytes"`
+	MaxBytes  int64                   `json:"max_bytes"`
+	Eviction  *monitor.EvictionStatus `json:"eviction,omitempty"`
 }
 
 // HealthResponse represents the health check response.
 type HealthResponse struct {
-	Status     string                   `json:"status"`
-	Version    string                   `json:"version"`
-	Uptime     string                   `json:"uptime"`
-	Compaction monitor.CompactionStatus `json:"compaction"`
+	Status      string                   `json:"status"`
+	Version     string                   `json:"version"`
+	Uptime      string                   `json:"uptime"`
+	Compaction  monitor.CompactionStatus `json:"compaction"`
+	Replication replication.Status       `json:"replication"`
 }
 
 // handleHealth returns service health status.
-func handleHealth(compactionMonitor *monitor.CompactionMonitor) http.HandlerFunc {
+func handleHealth(compactionMonitor *monitor.CompactionMonitor, replicator *replication.Replicator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		compactionHealthy := compactionMonitor.IsHealthy()
 		overallStatus := "healthy"
@@ -42,10 +48,11 @@ func handleHealth(compactionMonitor *monitor.CompactionMonitor) http.HandlerFunc
 		}
 
 		response := HealthResponse{
-			Status:     overallStatus,
-			Version:    "1.0.0",
-			Uptime:     time.Since(startTime).String(),
-			Compaction: compactionMonitor.Status(),
+			Status:      overallStatus,
+			Version:     "1.0.0",
+			Uptime:      time.Since(startTime).String(),
+			Compaction:  compactionMonitor.Status(),
+			Replication: replicator.Status(),
 		}
 
 		httpx.RespondJSON(w, statusCode, response)
@@ -64,6 +71,7 @@ func handleStorageUsage(monitor *monitor.StorageMonitor) http.HandlerFunc {
 		usage := StorageUsage{
 			UsedBytes: usedBytes,
 			MaxBytes:  monitor.GetLimit(),
+			Eviction:  monitor.EvictionStatus(),
 		}
 
 		httpx.RespondJSON(w, http.StatusOK, usage)
@@ -73,29 +81,57 @@ func handleStorageUsage(monitor *monitor.StorageMonitor) http.HandlerFunc {
 // SetupRoutes configures all HTTP routes for the server.
 // Registers both TinyObs-specific endpoints (/v1/*) and Prometheus-compatible endpoints (/api/v1/*).
 // The Prometheus endpoints enable Grafana integration.
+// API requests are scoped to a tenant from apiKeys or the X-Scope-OrgID header;
+// admin and replication endpoints are limited to the default tenant.
+// On a follower, endpoints that write data are rejected. A few admin
+// endpoints act on the node that receives them, on leader and followers
+// alike, without diverging the follower's data:
+//   - snapshot only reads the store
+//   - clean_tombstones purges data its replicated tombstones already hide
+//   - compaction/run re-compacts the node's own copy, as scheduled
+//     compaction does on every node, from the same replicated source data
+//
+// Check repairs delete entries the leader still has, so followers can only
+// check (restart a corrupt follower to copy a fresh snapshot instead).
 func SetupRoutes(
 	router *mux.Router,
 	ingestHandler *ingest.Handler,
 	queryHandler *query.Handler,
 	exportHandler *export.Handler,
+	adminHandler *admin.Handler,
 	storageMonitor *monitor.StorageMonitor,
 	compactionMonitor *monitor.CompactionMonitor,
+	replicator *replication.Replicator,
+	ruleManager *rules.Manager,
 	hub *ingest.MetricsHub,
 	port string,
+	apiKeys map[string]string,
 ) {
 	// CORS middleware for API access
 	router.Use(corsMiddleware(port))
 
+	// Health checks need no API key (registered before the /v1 subrouter)
+	router.HandleFunc("/v1/health", handleHealth(compactionMonitor, replicator)).Methods("GET")
+
 	// Prometheus-compatible API routes (for Grafana integration)
 	promAPI := router.PathPrefix("/api/v1").Subrouter()
+	promAPI.Use(tenant.Middleware(apiKeys))
 	promAPI.HandleFunc("/query", queryHandler.HandlePrometheusQuery).Methods("GET", "POST")
 	promAPI.HandleFunc("/query_range", queryHandler.HandlePrometheusQueryRange).Methods("GET", "POST")
+	promAPI.HandleFunc("/metadata", ingestHandler.HandleMetadata).Methods("GET")
+	promAPI.HandleFunc("/rules", ruleManager.HandleRules).Methods("GET")
+
+	// Prometheus-compatible admin API (destructive - series deletion)
+	promAPI.HandleFunc("/admin/tsdb/delete_series", tenant.AdminOnly(replicator.LeaderOnly(adminHandler.HandleDeleteSeries))).Methods("POST")
+	promAPI.HandleFunc("/admin/tsdb/clean_tombstones", tenant.AdminOnly(adminHandler.HandleCleanTombstones)).Methods("POST")
+	promAPI.HandleFunc("/admin/snapshot", tenant.AdminOnly(adminHandler.HandleSnapshot)).Methods("POST")
 
 	// API routes
 	api := router.PathPrefix("/v1").Subrouter()
+	api.Use(tenant.Middleware(apiKeys))
 
 	// Metrics ingestion and querying
-	api.HandleFunc("/ingest", ingestHandler.HandleIngest).Methods("POST")
+	api.HandleFunc("/ingest", replicator.LeaderOnly(ingestHandler.HandleIngest)).Methods("POST")
 	api.HandleFunc("/query", ingestHandler.HandleQuery).Methods("GET")
 	api.HandleFunc("/query/range", ingestHandler.HandleRangeQuery).Methods("GET")
 	api.HandleFunc("/query/execute", queryHandler.HandleQueryExecute).Methods("POST")
@@ -105,15 +141,31 @@ func SetupRoutes(
 	api.HandleFunc("/metrics/list", ingestHandler.HandleMetricsList).Methods("GET")
 	api.HandleFunc("/stats", ingestHandler.HandleStats).Methods("GET")
 	api.HandleFunc("/cardinality", ingestHandler.HandleCardinalityStats).Methods("GET")
-	api.HandleFunc("/storage", handleStorageUsage(storageMonitor)).Methods("GET")
-	api.HandleFunc("/health", handleHealth(compactionMonitor)).Methods("GET")
 
-	// WebSocket for real-time updates
-	api.HandleFunc("/ws", ingestHandler.HandleWebSocket(hub)).Methods("GET")
+	// Usage and eviction of the whole store, so default tenant only
+	api.HandleFunc("/storage", tenant.AdminOnly(handleStorageUsage(storageMonitor))).Methods("GET")
+
+	// Admin
+	api.HandleFunc("/admin/retention/dry-run", tenant.AdminOnly(adminHandler.HandleRetentionDryRun)).Methods("GET")
+	api.HandleFunc("/admin/check", tenant.AdminOnly(adminHandler.HandleCheck)).Methods("GET")
+	api.HandleFunc("/admin/check", tenant.AdminOnly(replicator.LeaderOnly(adminHandler.HandleCheck))).Methods("POST")
+	api.HandleFunc("/admin/compaction/run", tenant.AdminOnly(adminHandler.HandleCompactionRun)).Methods("POST")
+	api.HandleFunc("/admin/compaction/pause", tenant.AdminOnly(adminHandler.HandleCompactionPause)).Methods("POST")
+	api.HandleFunc("/admin/compaction/resume", tenant.AdminOnly(adminHandler.HandleCompactionResume)).Methods("POST")
+
+	// WebSocket for real-time updates (streams the whole store, so default tenant only)
+	api.HandleFunc("/ws", tenant.AdminOnly(ingestHandler.HandleWebSocket(hub))).Methods("GET")
 
 	// Export/import
 	api.HandleFunc("/export", exportHandler.HandleExport).Methods("GET")
-	api.HandleFunc("/import", exportHandler.HandleImport).Methods("POST")
+	api.HandleFunc("/import", replicator.LeaderOnly(exportHandler.HandleImport)).Methods("POST")
+
+	// Replication (followers pull the leader's snapshot and write log)
+	api.HandleFunc("/replication/log", tenant.AdminOnly(replicator.HandleLog)).Methods("GET")
+	api.HandleFunc("/replication/snapshot", tenant.AdminOnly(replicator.HandleSnapshot)).Methods("GET")
+	api.HandleFunc("/replication/metadata", tenant.AdminOnly(replicator.HandleMetadata)).Methods("GET")
+	api.HandleFunc("/replication/status", tenant.AdminOnly(replicator.HandleStatus)).Methods("GET")
+	api.HandleFunc("/replication/promote", tenant.AdminOnly(replicator.HandlePromote)).Methods("POST")
 
 	// Serve static files from ./web/ directory
 	router.PathPrefix("/web/").Handler(http.StripPrefix("/web/", http.FileServer(http.Dir("./web/"))))
@@ -151,7 +203,7 @@ func corsMiddleware(port string) func(http.Handler) http.Handler {
 			if allowed {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
 				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+tenant.Header)
 				w.Header().Set("Access-Control-Allow-Credentials", "true")
 			}
 
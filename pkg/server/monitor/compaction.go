@@ -3,15 +3,89 @@ package monitor
 import (
 	"sync"
 	"time"
+
+	"github.com/nicktill/tinyobs/pkg/compaction"
+	"github.com/nicktill/tinyobs/pkg/config"
 )
 
-// CompactionMonitor tracks compaction health and failures.
+// CompactionMonitor tracks compaction health and failures. It also carries
+// operator control of the scheduler: run requests and pausing.
 type CompactionMonitor struct {
 	mu                sync.RWMutex
 	lastSuccess       time.Time
 	lastAttempt       time.Time
 	consecutiveErrors int
 	lastError         string
+	progress          func(now time.Time) []compaction.TierProgress
+	paused            bool
+	runs              []compaction.Run // Most recent first
+
+	requestsOnce sync.Once
+	requests     chan compaction.RunOptions
+}
+
+// RequestRun asks the scheduler for a compaction run without blocking.
+// Returns false if a requested run is already waiting.
+func (cm *CompactionMonitor) RequestRun(opts compaction.RunOptions) bool {
+	select {
+	case cm.requested() <- opts:
+		return true
+	default:
+		return false
+	}
+}
+
+// Requested returns a channel that receives requested runs.
+func (cm *CompactionMonitor) Requested() <-chan compaction.RunOptions {
+	return cm.requested()
+}
+
+func (cm *CompactionMonitor) requested() chan compaction.RunOptions {
+	cm.requestsOnce.Do(func() {
+		cm.requests = make(chan compaction.RunOptions, 1)
+	})
+	return cm.requests
+}
+
+// Pause stops scheduled compaction until Resume. Runs in progress finish,
+// and requested runs still happen.
+func (cm *CompactionMonitor) Pause() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.paused = true
+}
+
+// Resume restarts scheduled compaction after Pause.
+func (cm *CompactionMonitor) Resume() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.paused = false
+}
+
+// Paused reports whether scheduled compaction is paused.
+func (cm *CompactionMonitor) Paused() bool {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.paused
+}
+
+// RecordRun adds a run to the history, keeping the most recent
+// config.CompactionHistory runs.
+func (cm *CompactionMonitor) RecordRun(run compaction.Run) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.runs = append([]compaction.Run{run}, cm.runs...)
+	if len(cm.runs) > config.CompactionHistory {
+		cm.runs = cm.runs[:config.CompactionHistory]
+	}
+}
+
+// SetProgress sets where Status reads per-tier compaction progress from
+// (typically Compactor.Progress).
+func (cm *CompactionMonitor) SetProgress(progress func(now time.Time) []compaction.TierProgress) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.progress = progress
 }
 
 // RecordSuccess records a successful compaction.
@@ -64,6 +138,13 @@ type CompactionStatus struct {
 	LastAttempt       string `json:"last_attempt,omitempty"`
 	ConsecutiveErrors int    `json:"consecutive_errors,omitempty"`
 	LastError         string `json:"last_error,omitempty"`
+
+	Tiers []compaction.TierProgress `json:"tiers,omitempty"` // Watermark and backlog per tier
+
+	Paused bool             `json:"paused,omitempty"`
+	Runs   []compaction.Run `json:"runs,omitempty"` // Recent runs, most recent first
+
+	FailedShards []compaction.ShardFailure `json:"failed_shards,omitempty"` // Shards the last run failed on
 }
 
 // Status returns current compaction status for health checks.
@@ -89,5 +170,15 @@ func (cm *CompactionMonitor) Status() CompactionStatus {
 		status.LastError = cm.lastError
 	}
 
+	if cm.progress != nil {
+		status.Tiers = cm.progress(time.Now())
+	}
+
+	status.Paused = cm.paused
+	status.Runs = append([]compaction.Run(nil), cm.runs...)
+	if len(cm.runs) > 0 {
+		status.FailedShards = cm.runs[0].FailedShards
+	}
+
 	return status
 }
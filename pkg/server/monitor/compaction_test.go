@@ -4,6 +4,9 @@ import (
 	"errors"
 	"testing"
 	"time"
+
+	"github.com/nicktill/tinyobs/pkg/compaction"
+	"github.com/nicktill/tinyobs/pkg/config"
 )
 
 func TestCompactionMonitor_RecordSuccess(t *testing.T) {
@@ -101,3 +104,70 @@ func TestCompactionMonitor_Status(t *testing.T) {
 		t.Error("TimeSinceSuccess should be set")
 	}
 }
+
+func TestCompactionMonitor_Progress(t *testing.T) {
+	cm := &CompactionMonitor{}
+	if status := cm.Status(); status.Tiers != nil {
+		t.Errorf("Tiers = %+v without a progress source, want none", status.Tiers)
+	}
+
+	cm.SetProgress(func(now time.Time) []compaction.TierProgress {
+		return []compaction.TierProgress{{Tier: "5m", Watermark: now.Format(time.RFC3339), PendingBuckets: 3}}
+	})
+	status := cm.Status()
+	if len(status.Tiers) != 1 || status.Tiers[0].Tier != "5m" || status.Tiers[0].PendingBuckets != 3 {
+		t.Errorf("Tiers = %+v, want the 5m tier's progress", status.Tiers)
+	}
+}
+
+func TestCompactionMonitor_Runs(t *testing.T) {
+	cm := &CompactionMonitor{}
+	for i := 0; i < config.CompactionHistory+5; i++ {
+		cm.RecordRun(compaction.Run{Trigger: "scheduled", SamplesDeleted: i})
+	}
+
+	status := cm.Status()
+	if len(status.Runs) != config.CompactionHistory {
+		t.Fatalf("Got %d runs, want the last %d", len(status.Runs), config.CompactionHistory)
+	}
+	if status.Runs[0].SamplesDeleted != config.CompactionHistory+4 {
+		t.Errorf("First run = %+v, want the most recent", status.Runs[0])
+	}
+	if status.FailedShards != nil {
+		t.Errorf("FailedShards = %+v, want none", status.FailedShards)
+	}
+
+	// Failed shards of the latest run are surfaced
+	failed := []compaction.ShardFailure{{Tier: "5m", Shard: "cpu", Error: "disk full"}}
+	cm.RecordRun(compaction.Run{Trigger: "scheduled", Error: "5m compaction failed", FailedShards: failed})
+	if got := cm.Status().FailedShards; len(got) != 1 || got[0] != failed[0] {
+		t.Errorf("FailedShards = %+v, want %+v", got, failed)
+	}
+}
+
+func TestCompactionMonitor_PauseAndRequests(t *testing.T) {
+	cm := &CompactionMonitor{}
+	cm.Pause()
+	if !cm.Paused() || !cm.Status().Paused {
+		t.Error("Expected compaction to be paused")
+	}
+	cm.Resume()
+	if cm.Paused() {
+		t.Error("Expected compaction to be resumed")
+	}
+
+	// One request waits for the scheduler; more are refused until it is taken
+	opts := compaction.RunOptions{Tier: "5m"}
+	if !cm.RequestRun(opts) {
+		t.Fatal("First request refused")
+	}
+	if cm.RequestRun(compaction.RunOptions{}) {
+		t.Error("Second request accepted while one is pending")
+	}
+	if got := <-cm.Requested(); got != opts {
+		t.Errorf("Requested %+v, want %+v", got, opts)
+	}
+	if !cm.RequestRun(opts) {
+		t.Error("Request refused after the pending one was taken")
+	}
+}
@@ -0,0 +1,302 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/config"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// evictionOrder is the default tier ladder, finest first
+var evictionOrder = []storage.Resolution{
+	storage.ResolutionRaw,
+	storage.Resolution5m,
+	storage.Resolution1h,
+}
+
+// UsageReader reports storage usage for eviction decisions.
+type UsageReader interface {
+	// RefreshUsage returns current storage usage in bytes (uncached).
+	RefreshUsage() (int64, error)
+	// GetLimit returns the configured storage limit in bytes.
+	GetLimit() int64
+}
+
+// Evictor deletes the oldest data when storage reaches its limit, so new
+// data keeps flowing instead of ingestion being rejected with 507.
+//
+// Each tier is evicted in config.EvictionRounds steps, from its own oldest
+// sample up to its limit: config.EvictionMinAge ago, and for every tier but
+// the last, no later than what has been rolled up into the next tier (see
+// SetRollups). Steps run oldest first, across tiers, the coarser tier first
+// on ties. After every step that deleted something it reclaims disk space
+// and re-measures usage, stopping once usage is under the low watermark.
+type Evictor struct {
+	store        storage.Storage
+	usage        UsageReader
+	lowWatermark float64
+	trigger      chan struct{}
+	order        []storage.Resolution
+	rollups      func() map[storage.Resolution]time.Time
+
+	runMu sync.Mutex // Serializes eviction runs
+
+	mu      sync.RWMutex
+	runs    int
+	lastRun *EvictionRun
+}
+
+// EvictionRun describes one eviction run.
+type EvictionRun struct {
+	StartedAt   time.Time      `json:"started_at"`
+	Duration    string         `json:"duration"`
+	UsageBefore int64          `json:"usage_before_bytes"`
+	UsageAfter  int64          `json:"usage_after_bytes"`
+	TargetBytes int64          `json:"target_bytes"`
+	Evicted     []EvictedRange `json:"evicted"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// EvictedRange records that all data of a tier older than Before was evicted.
+type EvictedRange struct {
+	Resolution string    `json:"resolution"`
+	Before     time.Time `json:"before"`
+	Samples    int       `json:"samples"`
+}
+
+// EvictionStatus represents eviction state for the /v1/storage endpoint.
+type EvictionStatus struct {
+	Enabled      bool         `json:"enabled"`
+	LowWatermark float64      `json:"low_watermark"`
+	Runs         int          `json:"runs"`
+	LastRun      *EvictionRun `json:"last_run,omitempty"`
+}
+
+// NewEvictor creates an evictor that brings usage down to lowWatermark × limit.
+// lowWatermark must be in (0, 1); anything else falls back to the default.
+func NewEvictor(store storage.Storage, usage UsageReader, lowWatermark float64) *Evictor {
+	if lowWatermark <= 0 || lowWatermark >= 1 {
+		lowWatermark = config.DefaultEvictionLowWatermark
+	}
+	return &Evictor{
+		store:        store,
+		usage:        usage,
+		lowWatermark: lowWatermark,
+		trigger:      make(chan struct{}, 1),
+		order:        evictionOrder,
+	}
+}
+
+// SetResolutions sets the tiers to evict, finest first (typically the
+// compactor's ladder). The default is raw, 5m, 1h.
+func (e *Evictor) SetResolutions(order []storage.Resolution) {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	e.order = order
+}
+
+// SetRollups bounds eviction by compaction progress: rollups returns, per
+// tier, the time before which its data has been rolled up into the next
+// tier (typically Compactor.RolledUp). A tier is never evicted past it, so
+// no data is lost before it exists at a coarser resolution; a tier missing
+// from the map is not evicted at all. The last tier is only bounded by
+// config.EvictionMinAge. Without rollups, every tier is.
+func (e *Evictor) SetRollups(rollups func() map[storage.Resolution]time.Time) {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	e.rollups = rollups
+}
+
+// TriggerEviction requests an eviction run without blocking.
+// Called by the ingest handler when storage is full.
+func (e *Evictor) TriggerEviction() {
+	select {
+	case e.trigger <- struct{}{}:
+	default: // A run is already pending
+	}
+}
+
+// Triggered returns a channel that receives when eviction was requested.
+func (e *Evictor) Triggered() <-chan struct{} {
+	return e.trigger
+}
+
+// Evict deletes the oldest data until usage drops under the low watermark.
+// Returns nil if usage is under the limit and nothing had to be evicted.
+func (e *Evictor) Evict(ctx context.Context, now time.Time) (*EvictionRun, error) {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	usage, err := e.usage.RefreshUsage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure storage usage: %w", err)
+	}
+	limit := e.usage.GetLimit()
+	if usage < limit {
+		return nil, nil
+	}
+
+	run := &EvictionRun{
+		StartedAt:   now,
+		UsageBefore: usage,
+		TargetBytes: int64(float64(limit) * e.lowWatermark),
+	}
+	start := time.Now()
+
+	err = e.evict(ctx, run, now)
+	if err != nil {
+		run.Error = err.Error()
+	}
+	run.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	e.mu.Lock()
+	e.runs++
+	e.lastRun = run
+	e.mu.Unlock()
+
+	return run, err
+}
+
+// evictionStep deletes a tier's data before cutoff
+type evictionStep struct {
+	tier   int // Index in Evictor.order
+	cutoff time.Time
+}
+
+// evict runs the deletion steps and fills in run.Evicted and run.UsageAfter
+func (e *Evictor) evict(ctx context.Context, run *EvictionRun, now time.Time) error {
+	run.UsageAfter = run.UsageBefore
+
+	stats, err := e.store.Stats(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get storage stats: %w", err)
+	}
+	if stats.TotalMetrics == 0 {
+		return nil
+	}
+
+	steps := e.plan(stats, now)
+	if len(steps) == 0 {
+		return fmt.Errorf("storage is full but no data is old enough to evict (newer than %v or not yet compacted)", config.EvictionMinAge)
+	}
+
+	for _, st := range steps {
+		if run.UsageAfter <= run.TargetBytes {
+			return nil
+		}
+
+		res := e.order[st.tier]
+		deleted, err := storage.DeleteCounted(ctx, e.store, storage.DeleteOptions{
+			Before:     st.cutoff,
+			Resolution: &res,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to evict %s data before %v: %w", resolutionName(res), st.cutoff, err)
+		}
+		if deleted == 0 {
+			continue // Nothing to reclaim
+		}
+		run.recordEviction(res, st.cutoff, deleted)
+
+		if reclaimer, ok := e.store.(storage.SpaceReclaimer); ok {
+			if err := reclaimer.ReclaimSpace(ctx); err != nil {
+				return fmt.Errorf("failed to reclaim space: %w", err)
+			}
+		}
+
+		usage, err := e.usage.RefreshUsage()
+		if err != nil {
+			return fmt.Errorf("failed to measure storage usage: %w", err)
+		}
+		run.UsageAfter = usage
+	}
+
+	if run.UsageAfter > run.TargetBytes {
+		log.Printf("Eviction could not reach target: %d bytes used, target %d (data newer than %v or not yet compacted is never evicted)",
+			run.UsageAfter, run.TargetBytes, config.EvictionMinAge)
+	}
+	return nil
+}
+
+// plan returns the eviction steps of every tier, oldest cutoff first and
+// the coarser tier first on ties
+func (e *Evictor) plan(stats *storage.Stats, now time.Time) []evictionStep {
+	var rolledUp map[storage.Resolution]time.Time
+	if e.rollups != nil {
+		rolledUp = e.rollups()
+	}
+
+	var steps []evictionStep
+	for i, res := range e.order {
+		tier, ok := stats.Resolutions[res]
+		if !ok || tier.Samples == 0 {
+			continue
+		}
+
+		limit := now.Add(-config.EvictionMinAge)
+		if e.rollups != nil && i < len(e.order)-1 {
+			if watermark := rolledUp[res]; watermark.Before(limit) {
+				limit = watermark
+			}
+		}
+		if !tier.Oldest.Before(limit) {
+			continue
+		}
+
+		step := limit.Sub(tier.Oldest) / config.EvictionRounds
+		for round := 1; round <= config.EvictionRounds; round++ {
+			cutoff := tier.Oldest.Add(step * time.Duration(round))
+			if round == config.EvictionRounds {
+				cutoff = limit
+			}
+			steps = append(steps, evictionStep{tier: i, cutoff: cutoff})
+		}
+	}
+
+	sort.SliceStable(steps, func(a, b int) bool {
+		if !steps[a].cutoff.Equal(steps[b].cutoff) {
+			return steps[a].cutoff.Before(steps[b].cutoff)
+		}
+		return steps[a].tier > steps[b].tier
+	})
+	return steps
+}
+
+// recordEviction records (or advances) the eviction cutoff of a tier
+func (r *EvictionRun) recordEviction(res storage.Resolution, cutoff time.Time, samples int) {
+	name := resolutionName(res)
+	for i := range r.Evicted {
+		if r.Evicted[i].Resolution == name {
+			r.Evicted[i].Before = cutoff
+			r.Evicted[i].Samples += samples
+			return
+		}
+	}
+	r.Evicted = append(r.Evicted, EvictedRange{Resolution: name, Before: cutoff, Samples: samples})
+}
+
+// Status returns eviction status for the /v1/storage endpoint.
+func (e *Evictor) Status() EvictionStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return EvictionStatus{
+		Enabled:      true,
+		LowWatermark: e.lowWatermark,
+		Runs:         e.runs,
+		LastRun:      e.lastRun,
+	}
+}
+
+// resolutionName formats a resolution for reports ("raw" instead of "")
+func resolutionName(res storage.Resolution) string {
+	if res == storage.ResolutionRaw {
+		return "raw"
+	}
+	return string(res)
+}
@@ -0,0 +1,230 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/memory"
+)
+
+// sampleUsage reports 100 bytes per stored sample
+type sampleUsage struct {
+	store storage.Storage
+	limit int64
+}
+
+func (u *sampleUsage) RefreshUsage() (int64, error) {
+	stats, err := u.store.Stats(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return int64(stats.TotalMetrics) * 100, nil
+}
+
+func (u *sampleUsage) GetLimit() int64 { return u.limit }
+
+func TestEvictor_UnderLimit(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+
+	now := time.Now()
+	store.Write(context.Background(), []metrics.Metric{{Name: "cpu", Value: 1, Timestamp: now.Add(-2 * time.Hour)}})
+
+	evictor := NewEvictor(store, &sampleUsage{store: store, limit: 1000}, 0.5)
+	run, err := evictor.Evict(context.Background(), now)
+	if err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+	if run != nil {
+		t.Errorf("Expected no eviction under the limit, got %+v", run)
+	}
+	if evictor.Status().Runs != 0 {
+		t.Errorf("Expected 0 runs, got %d", evictor.Status().Runs)
+	}
+}
+
+func TestEvictor_EvictsOldestFirst(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	agg := map[string]string{"__resolution__": string(storage.Resolution1h)}
+
+	// 10 hourly raw samples (10h..1h ago), one 30m-old raw sample and one old 1h aggregate
+	var batch []metrics.Metric
+	for i := 1; i <= 10; i++ {
+		batch = append(batch, metrics.Metric{Name: "cpu", Value: float64(i), Timestamp: now.Add(-time.Duration(i)*time.Hour - time.Minute)})
+	}
+	batch = append(batch,
+		metrics.Metric{Name: "cpu", Value: 100, Timestamp: now.Add(-30 * time.Minute)},
+		metrics.Metric{Name: "cpu", Value: 200, Labels: agg, Timestamp: now.Add(-12 * time.Hour)},
+	)
+	if err := store.Write(ctx, batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// 12 samples = 1200 bytes, limit 1000, target 600 → at least 6 samples must go
+	evictor := NewEvictor(store, &sampleUsage{store: store, limit: 1000}, 0.6)
+	run, err := evictor.Evict(ctx, now)
+	if err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+	if run == nil {
+		t.Fatal("Expected an eviction run")
+	}
+
+	if run.UsageBefore != 1200 || run.UsageAfter > 600 {
+		t.Errorf("Expected usage 1200 -> <=600, got %d -> %d", run.UsageBefore, run.UsageAfter)
+	}
+	// The 1h aggregate is the oldest data, so it goes before any raw sample
+	if len(run.Evicted) != 2 || run.Evicted[0].Resolution != "1h" || run.Evicted[0].Samples != 1 || run.Evicted[1].Resolution != "raw" {
+		t.Fatalf("Expected the 1h aggregate, then raw data to be evicted, got %+v", run.Evicted)
+	}
+
+	results, err := store.Query(ctx, storage.QueryRequest{End: now})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	var oldestRaw time.Time
+	kept := make(map[float64]bool)
+	for _, m := range results {
+		kept[m.Value] = true
+		if m.Labels["__resolution__"] == "" && (oldestRaw.IsZero() || m.Timestamp.Before(oldestRaw)) {
+			oldestRaw = m.Timestamp
+		}
+	}
+	if kept[200] {
+		t.Error("Expected the oldest data, the 1h aggregate, to be evicted")
+	}
+	if !kept[100] || !kept[1] {
+		t.Error("Expected the newest raw samples to survive")
+	}
+	if oldestRaw.Before(run.Evicted[1].Before) {
+		t.Errorf("Found raw data at %v older than eviction cutoff %v", oldestRaw, run.Evicted[1].Before)
+	}
+
+	status := evictor.Status()
+	if !status.Enabled || status.Runs != 1 || status.LastRun != run {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+}
+
+func TestEvictor_StopsAtRollups(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	var batch []metrics.Metric
+	for i := 1; i <= 10; i++ {
+		batch = append(batch, metrics.Metric{Name: "cpu", Value: float64(i), Timestamp: now.Add(-time.Duration(i) * time.Hour)})
+	}
+	if err := store.Write(ctx, batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Raw data is rolled up until 7h ago, and the 5m tier not at all
+	rolledUp := now.Add(-7 * time.Hour)
+	evictor := NewEvictor(store, &sampleUsage{store: store, limit: 100}, 0.5)
+	evictor.SetRollups(func() map[storage.Resolution]time.Time {
+		return map[storage.Resolution]time.Time{storage.ResolutionRaw: rolledUp}
+	})
+	run, err := evictor.Evict(ctx, now)
+	if err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+
+	if len(run.Evicted) != 1 || run.Evicted[0].Resolution != "raw" || run.Evicted[0].Before.After(rolledUp) || run.Evicted[0].Samples != 3 {
+		t.Errorf("Expected raw data before %v (3 samples) to be evicted, got %+v", rolledUp, run.Evicted)
+	}
+	stats, _ := store.Stats(ctx)
+	if stats.TotalMetrics != 7 || stats.OldestMetric.Before(rolledUp) {
+		t.Errorf("Expected the 7 samples not yet rolled up to survive, got %d from %v", stats.TotalMetrics, stats.OldestMetric)
+	}
+}
+
+// reclaimCounter counts ReclaimSpace calls
+type reclaimCounter struct {
+	storage.Storage
+	reclaims int
+}
+
+func (r *reclaimCounter) ReclaimSpace(ctx context.Context) error {
+	r.reclaims++
+	return nil
+}
+
+func TestEvictor_ReclaimsOnlyAfterDeleting(t *testing.T) {
+	mem := memory.New()
+	defer mem.Close()
+	store := &reclaimCounter{Storage: mem}
+	ctx := context.Background()
+
+	// Two samples far apart: most rounds between them delete nothing
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Timestamp: now.Add(-100 * time.Hour)},
+		{Name: "cpu", Value: 2, Timestamp: now.Add(-2 * time.Hour)},
+	})
+
+	evictor := NewEvictor(store, &sampleUsage{store: store, limit: 100}, 0.1)
+	run, err := evictor.Evict(ctx, now)
+	if err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+	if run.UsageAfter != 0 || len(run.Evicted) != 1 || run.Evicted[0].Samples != 2 {
+		t.Errorf("Expected both samples to be evicted, got %+v", run)
+	}
+	if store.reclaims != 2 {
+		t.Errorf("Expected space reclaimed once per round that deleted something (2), got %d", store.reclaims)
+	}
+}
+
+func TestEvictor_NeverEvictsRecentData(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	ctx := context.Background()
+
+	now := time.Now()
+	store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Timestamp: now.Add(-10 * time.Minute)},
+		{Name: "cpu", Value: 2, Timestamp: now.Add(-5 * time.Minute)},
+	})
+
+	evictor := NewEvictor(store, &sampleUsage{store: store, limit: 100}, 0.5)
+	if _, err := evictor.Evict(ctx, now); err == nil {
+		t.Error("Expected error when all data is too recent to evict")
+	}
+
+	stats, _ := store.Stats(ctx)
+	if stats.TotalMetrics != 2 {
+		t.Errorf("Expected recent data to be kept, got %d samples", stats.TotalMetrics)
+	}
+}
+
+func TestEvictor_Trigger(t *testing.T) {
+	evictor := NewEvictor(memory.New(), &sampleUsage{}, 0)
+	if evictor.Status().LowWatermark != 0.8 {
+		t.Errorf("Expected default low watermark 0.8, got %v", evictor.Status().LowWatermark)
+	}
+
+	// Triggers coalesce and never block
+	evictor.TriggerEviction()
+	evictor.TriggerEviction()
+
+	select {
+	case <-evictor.Triggered():
+	default:
+		t.Fatal("Expected a pending trigger")
+	}
+	select {
+	case <-evictor.Triggered():
+		t.Fatal("Expected triggers to coalesce")
+	default:
+	}
+}
@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// SelfMetricsSource reports internal counters and gauges as metrics
+type SelfMetricsSource interface {
+	// SelfMetrics returns the current values (timestamps are filled in on collection)
+	SelfMetrics() []metrics.Metric
+}
+
+// SelfMetrics writes TinyObs' own metrics (tinyobs_*) into its storage, so
+// they can be queried and charted like any other metric.
+type SelfMetrics struct {
+	store   storage.Storage
+	mu      sync.Mutex
+	sources []SelfMetricsSource
+}
+
+// NewSelfMetrics creates a self-metrics collector writing to store
+func NewSelfMetrics(store storage.Storage) *SelfMetrics {
+	return &SelfMetrics{store: store}
+}
+
+// Register adds a source to every collection
+func (s *SelfMetrics) Register(source SelfMetricsSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources = append(s.sources, source)
+}
+
+// Collect stamps every source's metrics with now and writes them.
+// Returns the number of samples written.
+func (s *SelfMetrics) Collect(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	sources := append([]SelfMetricsSource(nil), s.sources...)
+	s.mu.Unlock()
+
+	var batch []metrics.Metric
+	for _, source := range sources {
+		for _, m := range source.SelfMetrics() {
+			m.Timestamp = now
+			batch = append(batch, m)
+		}
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+	if err := s.store.Write(ctx, batch); err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
@@ -0,0 +1,44 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/memory"
+)
+
+type staticSource []metrics.Metric
+
+func (s staticSource) SelfMetrics() []metrics.Metric { return s }
+
+func TestSelfMetrics_Collect(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	selfMetrics := NewSelfMetrics(store)
+
+	if n, err := selfMetrics.Collect(ctx, time.Now()); err != nil || n != 0 {
+		t.Fatalf("Expected nothing collected without sources, got %d, %v", n, err)
+	}
+
+	selfMetrics.Register(staticSource{
+		{Name: "tinyobs_a_total", Type: metrics.CounterType, Value: 3},
+		{Name: "tinyobs_b", Value: 1, Labels: map[string]string{"reason": "x"}},
+	})
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	n, err := selfMetrics.Collect(ctx, now)
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 samples collected, got %d", n)
+	}
+
+	results, _ := store.Query(ctx, storage.QueryRequest{Start: now, End: now, MetricNames: []string{"tinyobs_a_total"}})
+	if len(results) != 1 || results[0].Value != 3 || !results[0].Timestamp.Equal(now) {
+		t.Errorf("Expected tinyobs_a_total=3 at collection time, got %+v", results)
+	}
+}
@@ -15,6 +15,8 @@ type StorageMonitor struct {
 	lastCheck     time.Time
 	cacheDuration time.Duration
 	mu            sync.Mutex
+
+	evictor *Evictor // Optional - reported in EvictionStatus
 }
 
 // NewStorageMonitor creates a new storage monitor.
@@ -48,6 +50,42 @@ func (sm *StorageMonitor) GetUsage() (int64, error) {
 	return usage, nil
 }
 
+// RefreshUsage recalculates storage usage, bypassing the cache.
+// Eviction uses this to see the effect of each deletion round immediately.
+func (sm *StorageMonitor) RefreshUsage() (int64, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	usage, err := calculateDirSize(sm.dataDir)
+	if err != nil {
+		return 0, err
+	}
+
+	sm.cachedUsage = usage
+	sm.lastCheck = time.Now()
+	return usage, nil
+}
+
+// SetEvictor attaches the evictor whose status is reported alongside usage.
+func (sm *StorageMonitor) SetEvictor(e *Evictor) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.evictor = e
+}
+
+// EvictionStatus returns the eviction status, or nil if eviction is disabled.
+func (sm *StorageMonitor) EvictionStatus() *EvictionStatus {
+	sm.mu.Lock()
+	e := sm.evictor
+	sm.mu.Unlock()
+
+	if e == nil {
+		return nil
+	}
+	status := e.Status()
+	return &status
+}
+
 // GetLimit returns the configured storage limit in bytes.
 func (sm *StorageMonitor) GetLimit() int64 {
 	return sm.maxBytes
@@ -1,26 +1,72 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/nicktill/tinyobs/pkg/admin"
 	"github.com/nicktill/tinyobs/pkg/compaction"
 	"github.com/nicktill/tinyobs/pkg/config"
 	"github.com/nicktill/tinyobs/pkg/export"
 	"github.com/nicktill/tinyobs/pkg/ingest"
+	"github.com/nicktill/tinyobs/pkg/metadata"
 	"github.com/nicktill/tinyobs/pkg/query"
+	"github.com/nicktill/tinyobs/pkg/replication"
+	"github.com/nicktill/tinyobs/pkg/rules"
 	"github.com/nicktill/tinyobs/pkg/server/monitor"
+	"github.com/nicktill/tinyobs/pkg/snapshot"
 	"github.com/nicktill/tinyobs/pkg/storage"
-	"github.com/nicktill/tinyobs/pkg/storage/badger"
+	"github.com/nicktill/tinyobs/pkg/storage/objectstore"
+	"github.com/nicktill/tinyobs/pkg/tenant"
+
+	// Storage backends register themselves with storage.RegisterBackend
+	_ "github.com/nicktill/tinyobs/pkg/storage/badger"
+	_ "github.com/nicktill/tinyobs/pkg/storage/memory"
+	_ "github.com/nicktill/tinyobs/pkg/storage/ring"
 )
 
 // Config holds server configuration loaded from environment variables.
 type Config struct {
 	MaxStorageGB int64  // Maximum storage in GB (from TINYOBS_MAX_STORAGE_GB)
 	MaxMemoryMB  int64  // BadgerDB memory limit in MB (from TINYOBS_MAX_MEMORY_MB)
-	DataDir      string // Data directory path (default: ./data/tinyobs)
+	DataDir      string // Data directory path (from TINYOBS_DATA_DIR, default: ./data/tinyobs)
 	Port         string // Server port (from PORT, default: 8080)
+
+	StorageBackend  string            // Registered backend name: badger (default), ring or memory (from TINYOBS_STORAGE_BACKEND)
+	StorageInMemory bool              // Keep a disk-backed backend in memory (from TINYOBS_STORAGE_IN_MEMORY)
+	StorageOptions  map[string]string // Backend-specific options (from TINYOBS_STORAGE_OPTIONS, e.g. "retention=2h,snapshot=./data/ring.snap")
+
+	RetentionFile string // Optional YAML retention policy (from TINYOBS_RETENTION_FILE)
+	TiersFile     string // Optional YAML downsampling ladder (from TINYOBS_TIERS_FILE)
+	RulesFile     string // Optional YAML recording rules, Prometheus format (from TINYOBS_RULES_FILE)
+
+	CompactionWorkers int // Metric names compacted in parallel (from TINYOBS_COMPACTION_WORKERS, default: 1)
+
+	ColdStorageDir string // Optional cold tier for sealed blocks (from TINYOBS_COLD_STORAGE_DIR)
+
+	SnapshotDir string // Where admin snapshots are written (from TINYOBS_SNAPSHOT_DIR, default: ./data/snapshots)
+	RestoreFrom string // Snapshot to restore into an empty data dir on startup (from TINYOBS_RESTORE_FROM)
+
+	OutOfOrderWindow time.Duration           // How far behind its series a late sample may be (from TINYOBS_OUT_OF_ORDER_WINDOW)
+	FutureTolerance  time.Duration           // How far ahead of the server clock a sample may be (from TINYOBS_FUTURE_TOLERANCE)
+	Duplicates       storage.DuplicatePolicy // first or last write wins (from TINYOBS_DUPLICATE_POLICY)
+
+	EvictionEnabled      bool    // Evict oldest data at the storage limit instead of rejecting ingest (from TINYOBS_EVICTION)
+	EvictionLowWatermark float64 // Fraction of the limit eviction frees down to (from TINYOBS_EVICTION_LOW_WATERMARK)
+
+	APIKeys            map[string]string // API key -> tenant ID; if set, every API request needs a key (from TINYOBS_API_KEYS, e.g. "k1=team-a,k2=default")
+	MaxSeries          int               // Unique series across all tenants (from TINYOBS_MAX_SERIES, default: 100000)
+	TenantMaxSeries    int               // Unique series per tenant (from TINYOBS_TENANT_MAX_SERIES, default: 100000)
+	TenantMaxStorageMB int64             // Estimated storage per tenant, 0 = unlimited (from TINYOBS_TENANT_MAX_STORAGE_MB)
+
+	LeaderURL    string // Follow this leader as a read-only replica (from TINYOBS_LEADER_URL, e.g. "http://leader:8080")
+	LeaderAPIKey string // API key for the leader, if it requires one (from TINYOBS_LEADER_API_KEY)
 }
 
 // LoadConfig loads configuration from environment variables with sensible defaults.
@@ -30,72 +76,370 @@ func LoadConfig() Config {
 	port := getPort()
 
 	// Ensure data directory exists
-	dataDir := "./data/tinyobs"
+	dataDir := getEnvString("TINYOBS_DATA_DIR", config.DefaultDataDir)
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		log.Fatalf("Failed to create data directory: %v", err)
 	}
 
+	apiKeys, err := tenant.ParseAPIKeys(os.Getenv("TINYOBS_API_KEYS"))
+	if err != nil {
+		log.Fatalf("Invalid TINYOBS_API_KEYS: %v", err)
+	}
+
 	return Config{
-		MaxStorageGB: maxStorageGB,
-		MaxMemoryMB:  maxMemoryMB,
-		DataDir:      dataDir,
-		Port:         port,
+		MaxStorageGB:  maxStorageGB,
+		MaxMemoryMB:   maxMemoryMB,
+		DataDir:       dataDir,
+		Port:          port,
+		RetentionFile: os.Getenv("TINYOBS_RETENTION_FILE"),
+		TiersFile:     os.Getenv("TINYOBS_TIERS_FILE"),
+		RulesFile:     os.Getenv("TINYOBS_RULES_FILE"),
+
+		CompactionWorkers: int(getEnvInt64("TINYOBS_COMPACTION_WORKERS", config.DefaultCompactionWorkers)),
+
+		StorageBackend:  getEnvString("TINYOBS_STORAGE_BACKEND", config.DefaultStorageBackend),
+		StorageInMemory: getEnvBool("TINYOBS_STORAGE_IN_MEMORY", false),
+		StorageOptions:  getEnvOptions("TINYOBS_STORAGE_OPTIONS"),
+
+		ColdStorageDir: os.Getenv("TINYOBS_COLD_STORAGE_DIR"),
+
+		SnapshotDir: getEnvString("TINYOBS_SNAPSHOT_DIR", config.DefaultSnapshotDir),
+		RestoreFrom: os.Getenv("TINYOBS_RESTORE_FROM"),
+
+		OutOfOrderWindow: getEnvDuration("TINYOBS_OUT_OF_ORDER_WINDOW", config.DefaultOutOfOrderWindow),
+		FutureTolerance:  getEnvDuration("TINYOBS_FUTURE_TOLERANCE", config.DefaultFutureTolerance),
+		Duplicates:       getEnvDuplicatePolicy("TINYOBS_DUPLICATE_POLICY"),
+
+		EvictionEnabled:      getEnvBool("TINYOBS_EVICTION", false),
+		EvictionLowWatermark: getEnvFloat64("TINYOBS_EVICTION_LOW_WATERMARK", config.DefaultEvictionLowWatermark),
+
+		APIKeys:            apiKeys,
+		MaxSeries:          int(getEnvInt64("TINYOBS_MAX_SERIES", ingest.MaxUniqueSeries)),
+		TenantMaxSeries:    int(getEnvInt64("TINYOBS_TENANT_MAX_SERIES", ingest.MaxUniqueSeries)),
+		TenantMaxStorageMB: getEnvInt64("TINYOBS_TENANT_MAX_STORAGE_MB", 0),
+
+		LeaderURL:    os.Getenv("TINYOBS_LEADER_URL"),
+		LeaderAPIKey: os.Getenv("TINYOBS_LEADER_API_KEY"),
 	}
 }
 
-// InitializeStorage initializes BadgerDB storage with the given configuration.
-// Returns an error if storage cannot be initialized.
+// InitializeStorage opens the storage backend selected by cfg.StorageBackend
+// from the backend registry (badger by default, ring for devices without
+// much disk). Returns an error if storage cannot be initialized.
+// If cfg.RestoreFrom is set, the snapshot is restored first (data dir must be empty).
 func InitializeStorage(cfg Config) (storage.Storage, error) {
-	log.Println("Initializing BadgerDB storage with Snappy compression...")
-	store, err := badger.New(badger.Config{
+	if cfg.RestoreFrom != "" {
+		if err := snapshot.EnsureEmptyDir(cfg.DataDir); err != nil {
+			return nil, err
+		}
+	}
+
+	backend := cfg.StorageBackend
+	if backend == "" {
+		backend = config.DefaultStorageBackend
+	}
+	log.Printf("Initializing %s storage...", backend)
+	store, err := storage.OpenBackend(backend, storage.BackendOptions{
 		Path:        cfg.DataDir,
+		InMemory:    cfg.StorageInMemory,
 		MaxMemoryMB: cfg.MaxMemoryMB,
+		Params:      cfg.StorageOptions,
 	})
 	if err != nil {
 		return nil, err
 	}
-	log.Println("BadgerDB storage initialized successfully")
-	return store, nil
+	log.Printf("%s storage initialized successfully", backend)
+
+	if cfg.RestoreFrom != "" {
+		snapshotter, ok := store.(storage.Snapshotter)
+		if !ok {
+			store.Close()
+			return nil, fmt.Errorf("storage backend %s doesn't support snapshot restore", backend)
+		}
+		if err := restoreSnapshot(cfg, snapshotter); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+
+	if cfg.ColdStorageDir == "" {
+		return store, nil
+	}
+
+	// Tiered storage: old data is sealed into blocks in the cold directory
+	bucket, err := objectstore.NewFSBucket(cfg.ColdStorageDir)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	tiered, err := objectstore.New(store, objectstore.Config{Bucket: bucket})
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	log.Printf("Cold tier enabled: %s (%d sealed blocks)", cfg.ColdStorageDir, len(tiered.Blocks()))
+	return tiered, nil
+}
+
+// restoreSnapshot loads cfg.RestoreFrom into a freshly opened store.
+// RestoreFrom is a snapshot file path, a snapshot name in cfg.SnapshotDir,
+// or "latest". Incremental snapshots restore the chain they are based on.
+func restoreSnapshot(cfg Config, store storage.Snapshotter) error {
+	dir, name := cfg.SnapshotDir, cfg.RestoreFrom
+	if name == "latest" {
+		name = ""
+	} else if strings.ContainsRune(name, filepath.Separator) || filepath.Ext(name) != "" {
+		dir = filepath.Dir(name)
+		name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	}
+
+	start := time.Now()
+	chain, err := snapshot.Restore(context.Background(), dir, name, store)
+	if err != nil {
+		return err
+	}
+	for _, info := range chain {
+		log.Printf("Restored snapshot %s (version %d)", info.Name, info.Version)
+	}
+	if path := metadataPath(cfg); path != "" {
+		if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+			return fmt.Errorf("failed to create data directory: %w", err)
+		}
+		restored, err := snapshot.RestoreMetadata(dir, chain[len(chain)-1].Name, path)
+		if err != nil {
+			return err
+		}
+		if restored {
+			log.Printf("Restored metric metadata")
+		}
+	}
+	log.Printf("Restore complete in %v (%d snapshots)", time.Since(start).Round(time.Millisecond), len(chain))
+	return nil
+}
+
+// InitializeSnapshots creates the snapshot manager behind POST /api/v1/admin/snapshot.
+// Snapshots include the metric metadata registry.
+// Does nothing if the storage backend doesn't support snapshots.
+func InitializeSnapshots(cfg Config, store storage.Storage, adminHandler *admin.Handler, registry *metadata.Registry) error {
+	snapshotter, ok := store.(storage.Snapshotter)
+	if !ok {
+		return nil
+	}
+	manager, err := snapshot.NewManager(cfg.SnapshotDir, snapshotter)
+	if err != nil {
+		return err
+	}
+	manager.SetMetadata(registry)
+	adminHandler.SetSnapshots(manager)
+	log.Printf("Snapshots enabled: %s", cfg.SnapshotDir)
+	return nil
+}
+
+// InitializeReplication creates the node's replicator. The node leads
+// unless cfg.LeaderURL is set, in which case it is a read-only follower
+// of that leader until promoted.
+func InitializeReplication(cfg Config, store storage.Storage) *replication.Replicator {
+	replicator := replication.New(store)
+	if cfg.LeaderURL != "" {
+		replicator.Follow(cfg.LeaderURL, cfg.LeaderAPIKey)
+		log.Printf("Replication: read-only follower of %s", cfg.LeaderURL)
+	}
+	return replicator
+}
+
+// InitializeRules loads the recording rules from cfg.RulesFile, evaluated
+// in the default tenant. Results are written through the replicated view,
+// so followers receive them from the leader and skip evaluation.
+// Without a rules file the manager has no groups.
+func InitializeRules(cfg Config, replicator *replication.Replicator) (*rules.Manager, error) {
+	var groups []rules.Group
+	if cfg.RulesFile != "" {
+		loaded, err := rules.LoadFile(cfg.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+		groups = loaded
+		log.Printf("Recording rules loaded from %s (%d groups)", cfg.RulesFile, len(groups))
+	}
+
+	manager := rules.NewManager(tenant.Scope(replicator.Storage(), tenant.Default), cfg.RulesFile, groups)
+	manager.SetLeaderCheck(func() bool { return replicator.Role() == replication.RoleLeader })
+	return manager, nil
+}
+
+// InitializeMetadata opens the metric metadata registry (type, unit and
+// help text per metric) behind /api/v1/metadata and replicates it. It is
+// saved next to the data unless storage is kept in memory.
+func InitializeMetadata(cfg Config, ingestHandler *ingest.Handler, replicator *replication.Replicator) (*metadata.Registry, error) {
+	path := metadataPath(cfg)
+	if path != "" {
+		if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %w", err)
+		}
+	}
+	registry, err := metadata.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	ingestHandler.SetMetadata(registry)
+	replicator.SetMetadata(registry)
+	log.Printf("Metric metadata: %d metrics known", len(registry.List()))
+	return registry, nil
+}
+
+// metadataPath returns where the metric metadata registry is saved ("" =
+// in memory, along with storage)
+func metadataPath(cfg Config) string {
+	if cfg.StorageInMemory || cfg.StorageBackend == "memory" {
+		return ""
+	}
+	return filepath.Join(cfg.DataDir, config.MetadataFile)
+}
+
+// InitializeSelfMetrics creates the collector that writes TinyObs' own
+// metrics (tinyobs_*) into storage, starting with ingest outcomes and
+// replication lag.
+func InitializeSelfMetrics(store storage.Storage, ingestHandler *ingest.Handler, replicator *replication.Replicator) *monitor.SelfMetrics {
+	selfMetrics := monitor.NewSelfMetrics(store)
+	selfMetrics.Register(ingestHandler)
+	selfMetrics.Register(replicator)
+	log.Printf("Self-metrics enabled (written every %v)", config.SelfMetricsInterval)
+	return selfMetrics
+}
+
+// InitializeEviction creates a size-based evictor if cfg.EvictionEnabled is set.
+// The ingest handler then triggers eviction at the storage limit instead of
+// rejecting writes, and /v1/storage reports what was evicted.
+// Tiers are those of the compaction ladder, each evicted no further than
+// it has been rolled up into the next. Returns nil if eviction is disabled.
+func InitializeEviction(cfg Config, store storage.Storage, storageMonitor *monitor.StorageMonitor, ingestHandler *ingest.Handler, compactor *compaction.Compactor) *monitor.Evictor {
+	if !cfg.EvictionEnabled {
+		return nil
+	}
+
+	// Only the hot tier counts against the storage limit
+	if tiered, ok := store.(*objectstore.Storage); ok {
+		store = tiered.Hot()
+	}
+
+	evictor := monitor.NewEvictor(store, storageMonitor, cfg.EvictionLowWatermark)
+	evictor.SetResolutions(compactor.Resolutions())
+	evictor.SetRollups(compactor.RolledUp)
+	ingestHandler.SetEvictionTrigger(evictor)
+	storageMonitor.SetEvictor(evictor)
+	log.Printf("Size-based eviction enabled (evicts oldest data down to %.0f%% of the limit)", evictor.Status().LowWatermark*100)
+	return evictor
 }
 
 // InitializeHandlers creates and configures all HTTP request handlers.
-// Returns handlers for ingestion, querying, export/import, and the WebSocket hub.
+// Returns handlers for ingestion, querying, export/import, admin operations, and the WebSocket hub.
+// Ingest, query and export see only the requesting tenant's data; admin
+// operations work on the whole store. Writes go through the replicator, so
+// followers receive them.
 func InitializeHandlers(
+	cfg Config,
 	store storage.Storage,
+	replicator *replication.Replicator,
 	storageMonitor *monitor.StorageMonitor,
 ) (
 	*ingest.Handler,
 	*query.Handler,
 	*export.Handler,
+	*admin.Handler,
 	*ingest.MetricsHub,
 ) {
+	// Tenant-scoped view: metric names are prefixed with the request's tenant
+	tenantStore := tenant.NewStorage(replicator.Storage())
+	if len(cfg.APIKeys) > 0 {
+		log.Printf("API keys required (%d keys configured)", len(cfg.APIKeys))
+	}
+
 	// Create ingest handler
-	ingestHandler := ingest.NewHandler(store)
+	ingestHandler := ingest.NewHandler(tenantStore)
 	ingestHandler.SetStorageChecker(storageMonitor)
+	ingestHandler.SetSeriesLimit(cfg.MaxSeries)
+	ingestHandler.SetTenantLimits(ingest.TenantLimits{
+		MaxSeries:       cfg.TenantMaxSeries,
+		MaxStorageBytes: cfg.TenantMaxStorageMB * 1024 * 1024,
+	})
+	ingestHandler.SetSamplePolicy(ingest.SamplePolicy{
+		OutOfOrderWindow: cfg.OutOfOrderWindow,
+		FutureTolerance:  cfg.FutureTolerance,
+		Duplicates:       cfg.Duplicates,
+	})
 	log.Println("Ingest handler created with cardinality protection & storage limits")
 
 	// Create query handler
-	queryHandler := query.NewHandler(store)
+	queryHandler := query.NewHandler(tenantStore)
 	log.Println("Query handler created")
 
 	// Create export/import handler for backup & restore
-	exportHandler := export.NewHandler(store)
+	exportHandler := export.NewHandler(tenantStore)
 	log.Println("Export/Import handler created (JSON & CSV backup support)")
 
+	// Create admin handler for destructive maintenance operations
+	adminHandler := admin.NewHandler(store)
+	adminHandler.SetSeriesDeleter(replicator.Storage()) // Followers tombstone the same series
+	log.Println("Admin handler created (series deletion with tombstones)")
+
 	// Create WebSocket hub for real-time updates
 	hub := ingest.NewMetricsHub()
 	log.Println("WebSocket hub created for real-time metrics streaming")
 
-	return ingestHandler, queryHandler, exportHandler, hub
+	return ingestHandler, queryHandler, exportHandler, adminHandler, hub
 }
 
 // InitializeCompactor creates a compactor with health monitoring.
-// The compactor downsamples old metrics (raw → 5m → 1h aggregates) to save storage.
-func InitializeCompactor(store storage.Storage) (*compaction.Compactor, *monitor.CompactionMonitor) {
+// The compactor downsamples old metrics along the tier ladder from
+// cfg.TiersFile (raw → 5m → 1h if unset) to save storage, and enforces each
+// tier's retention plus the rules from cfg.RetentionFile.
+// Metric types from the metadata registry tell it how to aggregate each series.
+// Per-tier watermarks are saved next to the data unless storage is kept in memory.
+func InitializeCompactor(store storage.Storage, cfg Config, registry *metadata.Registry) (*compaction.Compactor, *monitor.CompactionMonitor, error) {
 	compactor := compaction.New(store)
+	compactor.SetMetadata(registry)
+
+	if !cfg.StorageInMemory && cfg.StorageBackend != "memory" {
+		if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+			return nil, nil, fmt.Errorf("failed to create data directory: %w", err)
+		}
+		checkpoint, err := compaction.OpenCheckpoint(filepath.Join(cfg.DataDir, config.CompactionStateFile))
+		if err != nil {
+			return nil, nil, err
+		}
+		compactor.SetCheckpoint(checkpoint)
+	}
+
+	tiers := compaction.DefaultTiers()
+	if cfg.TiersFile != "" {
+		loaded, err := compaction.LoadTiers(cfg.TiersFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		tiers = loaded
+	}
+	if err := compactor.SetTiers(tiers); err != nil {
+		return nil, nil, err
+	}
+	if cfg.TiersFile != "" {
+		log.Printf("Compaction tiers loaded from %s (%d tiers)", cfg.TiersFile, len(tiers))
+	}
+
+	policy, err := compaction.RetentionPolicy(tiers, cfg.RetentionFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	compactor.SetRetention(policy)
+	if cfg.RetentionFile != "" {
+		log.Printf("Retention policy loaded from %s (%d rules)", cfg.RetentionFile, len(policy.Rules))
+	}
+
+	compactor.SetWorkers(cfg.CompactionWorkers)
+
 	compactionMonitor := &monitor.CompactionMonitor{}
-	log.Printf("Compaction engine ready (runs every %v)", config.CompactionInterval)
-	return compactor, compactionMonitor
+	compactionMonitor.SetProgress(compactor.Progress)
+	log.Printf("Compaction engine ready (runs every %v, %d workers)", config.CompactionInterval, compactor.Workers())
+	return compactor, compactionMonitor, nil
 }
 
 // getEnvInt64 gets an int64 from environment variable or returns default.
@@ -109,6 +453,78 @@ func getEnvInt64(key string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+// getEnvString gets a string from environment variable or returns default.
+func getEnvString(key, defaultValue string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return defaultValue
+}
+
+// getEnvDuration gets a duration (e.g. "1h", "90s") from environment variable or returns default.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if val := os.Getenv(key); val != "" {
+		if parsed, err := time.ParseDuration(val); err == nil && parsed >= 0 {
+			return parsed
+		}
+		log.Printf("Invalid value for %s: %q, using default %v", key, val, defaultValue)
+	}
+	return defaultValue
+}
+
+// getEnvDuplicatePolicy gets the duplicate sample policy ("first" or "last") or defaults to last.
+func getEnvDuplicatePolicy(key string) storage.DuplicatePolicy {
+	val := os.Getenv(key)
+	if val == "" {
+		return storage.LastWriteWins
+	}
+	policy, ok := ingest.ParseDuplicatePolicy(val)
+	if !ok {
+		log.Printf("Invalid value for %s: %q, using default \"last\"", key, val)
+	}
+	return policy
+}
+
+// getEnvOptions parses comma-separated key=value pairs (e.g. "retention=2h,max_chunks=32").
+func getEnvOptions(key string) map[string]string {
+	val := os.Getenv(key)
+	if val == "" {
+		return nil
+	}
+	options := make(map[string]string)
+	for _, pair := range strings.Split(val, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || k == "" {
+			log.Printf("Invalid option in %s: %q (expected key=value), ignoring", key, pair)
+			continue
+		}
+		options[k] = v
+	}
+	return options
+}
+
+// getEnvBool gets a bool from environment variable or returns default.
+func getEnvBool(key string, defaultValue bool) bool {
+	if val := os.Getenv(key); val != "" {
+		if parsed, err := strconv.ParseBool(val); err == nil {
+			return parsed
+		}
+		log.Printf("Invalid value for %s: %q, using default %t", key, val, defaultValue)
+	}
+	return defaultValue
+}
+
+// getEnvFloat64 gets a float64 from environment variable or returns default.
+func getEnvFloat64(key string, defaultValue float64) float64 {
+	if val := os.Getenv(key); val != "" {
+		if parsed, err := strconv.ParseFloat(val, 64); err == nil {
+			return parsed
+		}
+		log.Printf("Invalid value for %s: %q, using default %g", key, val, defaultValue)
+	}
+	return defaultValue
+}
+
 // getPort gets the server port from PORT environment variable or returns default.
 func getPort() string {
 	if port := os.Getenv("PORT"); port != "" {
@@ -12,19 +12,38 @@ import (
 	"github.com/nicktill/tinyobs/pkg/server/monitor"
 	"github.com/nicktill/tinyobs/pkg/storage"
 	"github.com/nicktill/tinyobs/pkg/storage/badger"
+	"github.com/nicktill/tinyobs/pkg/storage/objectstore"
 )
 
 // RunCompaction runs the compaction job periodically in the background.
 // Downsamples old metrics (raw → 5m → 1h) to save storage space.
 // Uses exponential backoff retry on failures and reports health via monitor.
+// Scheduled runs are skipped while the monitor is paused; runs requested
+// through the monitor (the admin API) are run once, paused or not.
 func RunCompaction(compactor *compaction.Compactor, monitor *monitor.CompactionMonitor, stop chan bool, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	ticker := time.NewTicker(config.CompactionInterval)
 	defer ticker.Stop()
 
+	// run runs compaction once and records the outcome
+	run := func(ctx context.Context, opts compaction.RunOptions, trigger string) error {
+		report, err := compactor.Run(ctx, opts)
+		if report != nil {
+			report.Trigger = trigger
+			monitor.RecordRun(*report)
+		}
+		if err != nil {
+			monitor.RecordFailure(err)
+			return err
+		}
+		monitor.RecordSuccess()
+		log.Printf("Compaction (%s) completed in %s", trigger, report.Duration)
+		return nil
+	}
+
 	// Helper function to run compaction with retry and exponential backoff
-	runWithRetry := func(ctx context.Context, isInitial bool) {
+	runWithRetry := func(ctx context.Context, trigger string) {
 		maxRetries := 3
 		baseDelay := 30 * time.Second
 
@@ -38,23 +57,17 @@ func RunCompaction(compactor *compaction.Compactor, monitor *monitor.CompactionM
 					return
 				}
 			}
+			if monitor.Paused() {
+				log.Println("Compaction is paused, skipping")
+				return
+			}
 
-			start := time.Now()
-			err := compactor.CompactAndCleanup(ctx)
-
+			err := run(ctx, compaction.RunOptions{}, trigger)
 			if err == nil {
-				// Success!
-				monitor.RecordSuccess()
-				if isInitial {
-					log.Printf("Initial compaction completed in %v", time.Since(start).Round(time.Millisecond))
-				} else {
-					log.Printf("Compaction completed in %v (data cleanup + downsampling)", time.Since(start).Round(time.Millisecond))
-				}
 				return
 			}
 
-			// Failure - record and log
-			monitor.RecordFailure(err)
+			// Failure - log
 			log.Printf("Compaction failed (attempt %d/%d): %v", attempt+1, maxRetries+1, err)
 
 			// Check if we should alert
@@ -70,14 +83,19 @@ func RunCompaction(compactor *compaction.Compactor, monitor *monitor.CompactionM
 	// Run once on startup (non-blocking)
 	go func() {
 		log.Println("Running initial compaction (raw -> 5m -> 1h aggregates)...")
-		runWithRetry(context.Background(), true)
+		runWithRetry(context.Background(), "initial")
 	}()
 
 	for {
 		select {
 		case <-ticker.C:
 			log.Println("Scheduled compaction started...")
-			runWithRetry(context.Background(), false)
+			runWithRetry(context.Background(), "scheduled")
+		case opts := <-monitor.Requested():
+			log.Println("Requested compaction started...")
+			if err := run(context.Background(), opts, "manual"); err != nil {
+				log.Printf("Requested compaction failed: %v", err)
+			}
 		case <-stop:
 			log.Println("Stopping compaction scheduler")
 			return
@@ -170,7 +188,10 @@ func RunBadgerGC(store storage.Storage, stop chan bool, wg *sync.WaitGroup) {
 	ticker := time.NewTicker(config.BadgerGCInterval)
 	defer ticker.Stop()
 
-	// Type assert to get underlying BadgerDB
+	// Type assert to get underlying BadgerDB (the hot tier when tiered)
+	if tiered, ok := store.(*objectstore.Storage); ok {
+		store = tiered.Hot()
+	}
 	badgerStore, ok := store.(*badger.Storage)
 	if !ok {
 		log.Println("Storage is not BadgerDB, skipping GC")
@@ -201,3 +222,128 @@ func RunBadgerGC(store storage.Storage, stop chan bool, wg *sync.WaitGroup) {
 		}
 	}
 }
+
+// RunTombstoneCleanup periodically purges data hidden by series deletion.
+// Tombstones hide deleted series from queries immediately; this job removes
+// the underlying samples so the disk space can be reclaimed by GC.
+func RunTombstoneCleanup(store storage.Storage, stop chan bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	deleter, ok := store.(storage.SeriesDeleter)
+	if !ok {
+		log.Println("Storage does not support series deletion, skipping tombstone cleanup")
+		return
+	}
+
+	ticker := time.NewTicker(config.TombstoneCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if len(deleter.Tombstones()) == 0 {
+				continue
+			}
+
+			start := time.Now()
+			cleaned, err := deleter.CleanTombstones(context.Background())
+			if err != nil {
+				log.Printf("Tombstone cleanup failed after purging %d tombstones: %v", cleaned, err)
+				continue
+			}
+			log.Printf("Purged %d tombstones in %v", cleaned, time.Since(start).Round(time.Millisecond))
+		case <-stop:
+			log.Println("Stopping tombstone cleanup scheduler")
+			return
+		}
+	}
+}
+
+// RunEviction evicts the oldest data whenever storage reaches its limit.
+// Runs when the ingest handler signals a full disk and on a periodic check,
+// so usage is also brought down when data arrives through other paths (import).
+func RunEviction(evictor *monitor.Evictor, stop chan bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(config.EvictionCheckInterval)
+	defer ticker.Stop()
+
+	evict := func() {
+		run, err := evictor.Evict(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("Eviction failed: %v", err)
+			return
+		}
+		if run == nil {
+			return // Under the limit
+		}
+		log.Printf("Evicted oldest data in %s: %d -> %d bytes (target %d), ranges: %+v",
+			run.Duration, run.UsageBefore, run.UsageAfter, run.TargetBytes, run.Evicted)
+	}
+
+	for {
+		select {
+		case <-evictor.Triggered():
+			evict()
+		case <-ticker.C:
+			evict()
+		case <-stop:
+			log.Println("Stopping eviction scheduler")
+			return
+		}
+	}
+}
+
+// RunBlockSealing periodically moves old data from the hot tier into
+// immutable cold blocks. Does nothing unless tiered storage is enabled.
+func RunBlockSealing(store storage.Storage, stop chan bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	tiered, ok := store.(*objectstore.Storage)
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(config.BlockSealInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			sealed, err := tiered.Seal(context.Background(), time.Now())
+			if err != nil {
+				log.Printf("Block sealing failed after %d blocks: %v", sealed, err)
+				continue
+			}
+			if sealed > 0 {
+				log.Printf("Sealed %d blocks in %v", sealed, time.Since(start).Round(time.Millisecond))
+			}
+		case <-stop:
+			log.Println("Stopping block sealing scheduler")
+			return
+		}
+	}
+}
+
+// RunSelfMetrics periodically writes TinyObs' own metrics (tinyobs_*) into storage.
+func RunSelfMetrics(selfMetrics *monitor.SelfMetrics, stop chan bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(config.SelfMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), config.IngestTimeout)
+			if _, err := selfMetrics.Collect(ctx, time.Now()); err != nil {
+				log.Printf("Self-metrics collection failed: %v", err)
+			}
+			cancel()
+		case <-stop:
+			log.Println("Stopping self-metrics collection")
+			return
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Package snapshot manages online backups of the storage backend.
+//
+// A snapshot directory holds one data file (<name>.snap) and one manifest
+// (<name>.json) per snapshot, plus the metric metadata registry at the time
+// (<name>.metadata) when the manager has one. Full snapshots contain
+// everything; incremental snapshots contain only what changed since the
+// previous snapshot and are restored on top of the chain they are based on.
+// Metadata is small, so every snapshot holds all of it.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/metadata"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// File extensions of a snapshot's data, manifest and metric metadata
+const (
+	dataExt     = ".snap"
+	manifestExt = ".json"
+	metadataExt = ".metadata"
+)
+
+// Info describes a snapshot (stored as its manifest)
+type Info struct {
+	Name        string    `json:"name"`
+	Incremental bool      `json:"incremental"`
+	Since       uint64    `json:"since"`   // First version included (0 for full snapshots)
+	Version     uint64    `json:"version"` // Last version included
+	SizeBytes   int64     `json:"size_bytes"`
+	CreatedAt   time.Time `json:"created_at"`
+	Metadata    bool      `json:"metadata,omitempty"` // Metric metadata saved alongside
+}
+
+// Manager creates snapshots of a store in a directory
+type Manager struct {
+	dir      string
+	store    storage.Snapshotter
+	metadata *metadata.Registry // Optional: saved with every snapshot
+	mu       sync.Mutex         // Serializes snapshot creation (incrementals build on the latest)
+}
+
+// NewManager creates a snapshot manager writing to dir
+func NewManager(dir string, store storage.Snapshotter) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	return &Manager{dir: dir, store: store}, nil
+}
+
+// SetMetadata saves the metric metadata registry with every new snapshot
+func (m *Manager) SetMetadata(registry *metadata.Registry) {
+	m.metadata = registry
+}
+
+// Dir returns the snapshot directory
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// Create writes a new snapshot. An incremental snapshot covers what changed
+// since the latest snapshot; without a previous snapshot it is a full one.
+func (m *Manager) Create(ctx context.Context, incremental bool) (*Info, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var since uint64
+	if incremental {
+		existing, err := List(m.dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(existing) > 0 {
+			since = existing[len(existing)-1].Version + 1
+		}
+	}
+
+	now := time.Now().UTC()
+	info := Info{
+		Name:        snapshotName(now, since > 0),
+		Incremental: since > 0,
+		Since:       since,
+		CreatedAt:   now,
+	}
+
+	// Write to a temp file and rename, so a crashed snapshot is never listed
+	tmp, err := os.CreateTemp(m.dir, ".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	version, err := m.store.Snapshot(ctx, tmp, since)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Version = version
+
+	stat, err := os.Stat(tmp.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat snapshot: %w", err)
+	}
+	info.SizeBytes = stat.Size()
+
+	if err := os.Rename(tmp.Name(), filepath.Join(m.dir, info.Name+dataExt)); err != nil {
+		return nil, fmt.Errorf("failed to commit snapshot: %w", err)
+	}
+
+	if m.metadata != nil {
+		data, err := json.MarshalIndent(m.metadata.List(), "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode metric metadata: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(m.dir, info.Name+metadataExt), data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write metric metadata: %w", err)
+		}
+		info.Metadata = true
+	}
+
+	// The manifest goes last: a snapshot without one is ignored
+	manifest, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(m.dir, info.Name+manifestExt), manifest, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot manifest: %w", err)
+	}
+	return &info, nil
+}
+
+// snapshotName returns a name that sorts by creation time
+func snapshotName(now time.Time, incremental bool) string {
+	kind := "full"
+	if incremental {
+		kind = "incr"
+	}
+	return fmt.Sprintf("%s-%s-%s", now.Format("20060102T150405Z"), strconv.FormatInt(now.UnixNano(), 36), kind)
+}
+
+// List returns the complete snapshots in dir, oldest first
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	var infos []Info
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), manifestExt) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot manifest: %w", err)
+		}
+		var info Info
+		if err := json.Unmarshal(data, &info); err != nil {
+			return nil, fmt.Errorf("invalid snapshot manifest %s: %w", e.Name(), err)
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.Before(infos[j].CreatedAt)
+	})
+	return infos, nil
+}
+
+// Chain returns the snapshots needed to restore the named snapshot, in
+// restore order: the full snapshot it is based on, then every incremental
+// up to and including it. An empty name selects the latest snapshot.
+func Chain(dir, name string) ([]Info, error) {
+	infos, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("no snapshots in %s", dir)
+	}
+
+	target := len(infos) - 1
+	if name != "" {
+		target = -1
+		for i, info := range infos {
+			if info.Name == name {
+				target = i
+				break
+			}
+		}
+		if target < 0 {
+			return nil, fmt.Errorf("snapshot %q not found in %s", name, dir)
+		}
+	}
+
+	// Walk back through older snapshots until reaching a full one
+	chain := []Info{infos[target]}
+	for i := target; infos[i].Incremental; {
+		prev := -1
+		for j := i - 1; j >= 0; j-- {
+			if infos[j].Version == infos[i].Since-1 {
+				prev = j
+				break
+			}
+		}
+		if prev < 0 {
+			return nil, fmt.Errorf("snapshot %s: missing base snapshot with version %d", infos[i].Name, infos[i].Since-1)
+		}
+		chain = append(chain, infos[prev])
+		i = prev
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain, nil
+}
+
+// Restore loads the named snapshot (and the chain it is based on) into the
+// store. An empty name restores the latest snapshot.
+// CRITICAL: The store must be empty and must not receive writes meanwhile.
+func Restore(ctx context.Context, dir, name string, store storage.Snapshotter) ([]Info, error) {
+	chain, err := Chain(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, info := range chain {
+		f, err := os.Open(filepath.Join(dir, info.Name+dataExt))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open snapshot %s: %w", info.Name, err)
+		}
+		err = store.Restore(ctx, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore snapshot %s: %w", info.Name, err)
+		}
+	}
+	return chain, nil
+}
+
+// RestoreMetadata writes the metric metadata saved with the named snapshot
+// (empty = latest) to path, where metadata.Open reads it. Returns false if
+// the snapshot was taken without metadata.
+func RestoreMetadata(dir, name, path string) (bool, error) {
+	chain, err := Chain(dir, name)
+	if err != nil {
+		return false, err
+	}
+	info := chain[len(chain)-1]
+	if !info.Metadata {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, info.Name+metadataExt))
+	if err != nil {
+		return false, fmt.Errorf("failed to read metric metadata of snapshot %s: %w", info.Name, err)
+	}
+	var list []metrics.Metadata
+	if err := json.Unmarshal(data, &list); err != nil {
+		return false, fmt.Errorf("invalid metric metadata in snapshot %s: %w", info.Name, err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return false, fmt.Errorf("failed to restore metric metadata: %w", err)
+	}
+	return true, nil
+}
+
+// EnsureEmptyDir fails unless dir is missing or empty. Restoring into a
+// directory that already holds data would silently merge two histories.
+func EnsureEmptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read data directory: %w", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("refusing to restore into non-empty data directory %s", dir)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/metadata"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/badger"
+)
+
+func newStore(t *testing.T) *badger.Storage {
+	t.Helper()
+	store, err := badger.New(badger.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestFullAndIncrementalRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := newStore(t)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	manager, err := NewManager(dir, source)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	if err := source.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Timestamp: base},
+		{Name: "mem", Value: 2, Timestamp: base},
+	}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	full, err := manager.Create(ctx, true) // No previous snapshot: falls back to full
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if full.Incremental || full.Since != 0 || full.Version == 0 {
+		t.Fatalf("Expected a full snapshot, got %+v", full)
+	}
+
+	// Changes after the full snapshot, including a deletion
+	if err := source.Write(ctx, []metrics.Metric{{Name: "cpu", Value: 3, Timestamp: base.Add(time.Minute)}}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := source.Delete(ctx, storage.DeleteOptions{
+		Before:   base.Add(time.Hour),
+		Matchers: []storage.Matcher{{Type: storage.MatchEqual, Name: storage.MetricNameLabel, Value: "mem"}},
+	}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	incr, err := manager.Create(ctx, true)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !incr.Incremental || incr.Since != full.Version+1 {
+		t.Fatalf("Expected an incremental snapshot since %d, got %+v", full.Version+1, incr)
+	}
+
+	// An incremental with no changes keeps the previous version
+	empty, err := manager.Create(ctx, true)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if empty.Version != incr.Version {
+		t.Errorf("Expected empty incremental to keep version %d, got %d", incr.Version, empty.Version)
+	}
+
+	chain, err := Chain(dir, "")
+	if err != nil {
+		t.Fatalf("Chain failed: %v", err)
+	}
+	if len(chain) != 3 || chain[0].Name != full.Name || chain[1].Name != incr.Name {
+		t.Fatalf("Unexpected restore chain: %+v", chain)
+	}
+
+	// Restoring only the full snapshot gives the state at that point
+	atFull := newStore(t)
+	if _, err := Restore(ctx, dir, full.Name, atFull); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	stats, _ := atFull.Stats(ctx)
+	if stats.TotalMetrics != 2 {
+		t.Errorf("Expected 2 samples at the full snapshot, got %d", stats.TotalMetrics)
+	}
+
+	// Restoring the latest replays the incrementals, deletion included
+	restored := newStore(t)
+	if _, err := Restore(ctx, dir, "", restored); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	results, err := restored.Query(ctx, storage.QueryRequest{Start: base.Add(-time.Hour), End: base.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 restored samples, got %d: %+v", len(results), results)
+	}
+	for _, m := range results {
+		if m.Name != "cpu" {
+			t.Errorf("Deleted series restored: %+v", m)
+		}
+	}
+
+	stats, _ = restored.Stats(ctx)
+	if stats.TotalMetrics != 2 || stats.TotalSeries != 1 {
+		t.Errorf("Expected stats rebuilt after restore (2 samples, 1 series), got %d, %d", stats.TotalMetrics, stats.TotalSeries)
+	}
+}
+
+func TestSnapshotDuringWrites(t *testing.T) {
+	ctx := context.Background()
+	source := newStore(t)
+	manager, err := NewManager(t.TempDir(), source)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	// Ingestion keeps running while the snapshot is taken
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ts := time.Now()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			source.Write(ctx, []metrics.Metric{{Name: "requests", Value: float64(i), Timestamp: ts.Add(time.Duration(i) * time.Millisecond)}})
+		}
+	}()
+
+	info, err := manager.Create(ctx, false)
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	restored := newStore(t)
+	if _, err := Restore(ctx, manager.Dir(), info.Name, restored); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+}
+
+func TestRestoreMetadata(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := newStore(t)
+	manager, _ := NewManager(dir, source)
+
+	// A snapshot taken without a registry restores no metadata
+	source.Write(ctx, []metrics.Metric{{Name: "cpu", Value: 1, Timestamp: time.Now()}})
+	bare, err := manager.Create(ctx, false)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	registry, _ := metadata.Open("")
+	registry.Update([]metrics.Metadata{{Name: "cpu", Type: metrics.GaugeType, Unit: "percent"}})
+	manager.SetMetadata(registry)
+	incr, err := manager.Create(ctx, true)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !incr.Metadata || bare.Metadata {
+		t.Errorf("Expected metadata in the second snapshot only, got %+v and %+v", bare, incr)
+	}
+
+	path := filepath.Join(t.TempDir(), "metadata.json")
+	if restored, err := RestoreMetadata(dir, bare.Name, path); err != nil || restored {
+		t.Errorf("RestoreMetadata(%s) = %v, %v, want nothing restored", bare.Name, restored, err)
+	}
+	if restored, err := RestoreMetadata(dir, "", path); err != nil || !restored {
+		t.Fatalf("RestoreMetadata(latest) = %v, %v", restored, err)
+	}
+	reopened, err := metadata.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open restored metadata: %v", err)
+	}
+	if md, ok := reopened.Lookup("cpu"); !ok || md.Unit != "percent" || md.Type != metrics.GaugeType {
+		t.Errorf("Restored metadata for cpu = %+v, %v", md, ok)
+	}
+}
+
+func TestChain_MissingBase(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := newStore(t)
+	manager, _ := NewManager(dir, source)
+
+	source.Write(ctx, []metrics.Metric{{Name: "cpu", Value: 1, Timestamp: time.Now()}})
+	full, err := manager.Create(ctx, false)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	source.Write(ctx, []metrics.Metric{{Name: "cpu", Value: 2, Timestamp: time.Now()}})
+	if _, err := manager.Create(ctx, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Without its manifest the full snapshot is gone from the listing
+	if err := os.Remove(filepath.Join(dir, full.Name+manifestExt)); err != nil {
+		t.Fatalf("Failed to remove snapshot: %v", err)
+	}
+	if _, err := Chain(dir, ""); err == nil {
+		t.Error("Expected error restoring an incremental without its base")
+	}
+}
+
+func TestEnsureEmptyDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := EnsureEmptyDir(dir); err != nil {
+		t.Errorf("Empty dir rejected: %v", err)
+	}
+	if err := EnsureEmptyDir(dir + "/missing"); err != nil {
+		t.Errorf("Missing dir rejected: %v", err)
+	}
+
+	store, err := badger.New(badger.Config{Path: dir})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	store.Close()
+	if err := EnsureEmptyDir(dir); err == nil {
+		t.Error("Expected non-empty data dir to be rejected")
+	}
+}
@@ -0,0 +1,402 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+)
+
+// Aggregate stores aggregated metrics for a time bucket. Compaction writes
+// aggregates as metrics carrying their statistics in Metric.Aggregate (ToMetric).
+type Aggregate struct {
+	// Metric identification
+	Name   string
+	Labels map[string]string
+
+	// Time bucket
+	Timestamp  time.Time
+	Resolution Resolution
+
+	// Type of the aggregated metric (counters keep First, Last and Increase)
+	Type metrics.MetricType
+
+	// Aggregated values
+	Sum   float64
+	Count uint64
+	Min   float64
+	Max   float64
+
+	// Counters: first and last sample in the bucket, and the increase from
+	// First to Last adjusted for counter resets
+	First    float64
+	Last     float64
+	Increase float64
+
+	// Gauges: distribution of the values, for percentiles. Nil for counters
+	// and histograms, and for aggregates compacted before sketches existed.
+	Sketch *Sketch
+}
+
+// ToMetric converts an aggregate to the metric storage keeps: its
+// statistics go in Metric.Aggregate at full precision, its resolution in
+// the __resolution__ label, and its user labels are kept as they are
+func (a *Aggregate) ToMetric() metrics.Metric {
+	labels := make(map[string]string, len(a.Labels)+1)
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	labels["__resolution__"] = string(a.Resolution)
+
+	stats := &metrics.AggregateStats{Sum: a.Sum, Count: a.Count, Min: a.Min, Max: a.Max}
+	if a.Sketch != nil {
+		stats.Sketch = a.Sketch.Encode()
+	}
+	m := metrics.Metric{
+		Name:      a.Name,
+		Type:      metrics.GaugeType,
+		Value:     a.Average(), // Store average as the main value
+		Labels:    labels,
+		Timestamp: a.Timestamp,
+		Aggregate: stats,
+	}
+
+	switch a.Type {
+	case metrics.CounterType:
+		// A counter's value is where it ended up, like a raw sample
+		stats.First, stats.Last, stats.Increase = a.First, a.Last, a.Increase
+		m.Type, m.Value = metrics.CounterType, a.Last
+	case metrics.HistogramType:
+		// Histogram series hold counts per flush: the bucket's count is their sum
+		m.Type, m.Value = metrics.HistogramType, a.Sum
+	}
+	return m
+}
+
+// Add folds a raw sample into the aggregate. Samples must be added in
+// time order so counter increases are correct.
+func (a *Aggregate) Add(value float64) {
+	if a.Count == 0 {
+		a.Min, a.Max, a.First = value, value, value
+	} else {
+		a.Increase += counterIncrease(a.Last, value)
+		if value < a.Min {
+			a.Min = value
+		}
+		if value > a.Max {
+			a.Max = value
+		}
+	}
+	a.Sum += value
+	a.Count++
+	a.Last = value
+
+	if a.hasSketch() {
+		if a.Sketch == nil {
+			a.Sketch = NewSketch()
+		}
+		a.Sketch.Add(value)
+	}
+}
+
+// hasSketch reports whether the aggregate keeps a sketch of its values:
+// percentiles of counters and histogram counts aren't meaningful
+func (a *Aggregate) hasSketch() bool {
+	return a.Type != metrics.CounterType && a.Type != metrics.HistogramType
+}
+
+// Distribution returns the aggregate's sketch. Aggregates without one
+// stand in as Count samples of their average.
+func (a *Aggregate) Distribution() *Sketch {
+	if a.Sketch != nil {
+		return a.Sketch
+	}
+	s := NewSketch()
+	s.addN(a.Average(), a.Count)
+	return s
+}
+
+// Merge folds a finer aggregate into the aggregate. Aggregates must be
+// merged in time order so counter increases are correct.
+func (a *Aggregate) Merge(b *Aggregate) {
+	if a.hasSketch() {
+		if a.Sketch == nil {
+			a.Sketch = NewSketch()
+		}
+		a.Sketch.Merge(b.Distribution())
+	}
+	if a.Count == 0 {
+		a.Sum, a.Count, a.Min, a.Max = b.Sum, b.Count, b.Min, b.Max
+		a.First, a.Last, a.Increase = b.First, b.Last, b.Increase
+		return
+	}
+	// Re-aggregate by combining Sum and Count (preserves original data)
+	a.Sum += b.Sum
+	a.Count += b.Count
+	if b.Min < a.Min {
+		a.Min = b.Min
+	}
+	if b.Max > a.Max {
+		a.Max = b.Max
+	}
+	a.Increase += counterIncrease(a.Last, b.First) + b.Increase
+	a.Last = b.Last
+}
+
+// counterIncrease is how much a counter grew from prev to next. A drop
+// means the counter was reset to zero and has counted up to next since.
+func counterIncrease(prev, next float64) float64 {
+	if next < prev {
+		return next
+	}
+	return next - prev
+}
+
+// Average calculates the mean value
+func (a *Aggregate) Average() float64 {
+	if a.Count == 0 {
+		return 0
+	}
+	return a.Sum / float64(a.Count)
+}
+
+// ParseAggregate reconstructs an Aggregate from a stored metric. Returns
+// nil if the metric is not an aggregate (no __resolution__ label or no
+// statistics) or its sketch is malformed.
+func ParseAggregate(m metrics.Metric) *Aggregate {
+	resolution, isAggregate := m.Labels["__resolution__"]
+	if !isAggregate || m.Aggregate == nil {
+		return nil
+	}
+	stats := m.Aggregate
+
+	agg := &Aggregate{
+		Name:       m.Name,
+		Labels:     UserLabels(m.Labels),
+		Timestamp:  m.Timestamp,
+		Resolution: Resolution(resolution),
+		Type:       m.Type,
+		Sum:        stats.Sum,
+		Count:      stats.Count,
+		Min:        stats.Min,
+		Max:        stats.Max,
+		First:      stats.First,
+		Last:       stats.Last,
+		Increase:   stats.Increase,
+	}
+	if len(stats.Sketch) > 0 {
+		sketch, err := DecodeSketch(stats.Sketch)
+		if err != nil {
+			return nil
+		}
+		agg.Sketch = sketch
+	}
+	return agg
+}
+
+// Duration returns the length of the aggregate's time bucket (0 for raw
+// data or a malformed resolution)
+func (r Resolution) Duration() time.Duration {
+	if days, ok := strings.CutSuffix(string(r), "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0
+		}
+		return time.Duration(n) * 24 * time.Hour
+	}
+	d, err := time.ParseDuration(string(r))
+	if err != nil || d <= 0 {
+		return 0
+	}
+	return d
+}
+
+// ParseResolution parses a configured resolution: "raw", or a bucket length
+// such as "1m", "15m", "1h" or "1d"
+func ParseResolution(name string) (Resolution, error) {
+	if name == "raw" {
+		return ResolutionRaw, nil
+	}
+	res := Resolution(name)
+	if res.Duration() < time.Second {
+		return "", fmt.Errorf("invalid resolution %q (expected raw or a bucket length like 5m, 1h or 1d)", name)
+	}
+	return res, nil
+}
+
+// SortResolutions orders resolutions finest first (raw before any aggregate)
+func SortResolutions(resolutions []Resolution) {
+	sort.Slice(resolutions, func(i, j int) bool {
+		di, dj := resolutions[i].Duration(), resolutions[j].Duration()
+		if di != dj {
+			return di < dj
+		}
+		return resolutions[i] < resolutions[j]
+	})
+}
+
+// Percentile estimates the pth percentile (0 <= p <= 1) of the aggregated
+// values from the sketch, within SketchAccuracy. It is NaN for aggregates
+// without a sketch.
+func (a *Aggregate) Percentile(p float64) float64 {
+	if a.Sketch == nil {
+		return math.NaN()
+	}
+	return a.Sketch.Quantile(p)
+}
+
+// Percentile computes the exact pth percentile (0 <= p <= 1) of values,
+// interpolating linearly between the closest ranks. It is 0 for no values.
+func Percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	index := p * float64(len(sorted)-1)
+	lower := int(math.Floor(index))
+	upper := int(math.Ceil(index))
+
+	if lower == upper {
+		return sorted[lower]
+	}
+
+	// Linear interpolation
+	weight := index - float64(lower)
+	return sorted[lower]*(1-weight) + sorted[upper]*weight
+}
+
+// UserLabels returns a stored metric's labels without __resolution__, so
+// raw samples and aggregates of a series share one label set
+func UserLabels(labels map[string]string) map[string]string {
+	if _, ok := labels["__resolution__"]; !ok {
+		return labels
+	}
+	out := make(map[string]string, len(labels)-1)
+	for k, v := range labels {
+		out[k] = v
+	}
+	delete(out, "__resolution__")
+	return out
+}
+
+// ResolutionOf returns the resolution of a stored series from its labels:
+// its __resolution__ label, or ResolutionRaw for raw samples
+func ResolutionOf(labels map[string]string) Resolution {
+	return Resolution(labels["__resolution__"])
+}
+
+// MergeResolutions turns the stored samples of one series, raw and aggregated,
+// into plain samples in time order, as queries read them.
+//
+// Each time range is read from the finest resolution that covers it (see
+// ResolveTiers). Gauge aggregates become their average. Counter aggregates
+// become samples with the same reset-adjusted increase, so rate() and
+// increase() work across tiers. Histogram aggregates become the sum of the
+// counts they aggregate.
+func MergeResolutions(stored []metrics.Metric) []metrics.Metric {
+	raw, aggs := ResolveTiers(stored)
+	if len(aggs) == 0 {
+		return sortByTime(raw)
+	}
+	out := raw
+	for _, agg := range aggs {
+		out = append(out, agg.samples()...)
+	}
+	return sortByTime(out)
+}
+
+// ResolveTiers splits the stored samples of one series into the raw samples
+// and aggregates to read: raw samples where they exist, then each coarser
+// tier (5m, then 1h, ...) before the oldest sample of the finer ones.
+// Neither result is sorted.
+func ResolveTiers(stored []metrics.Metric) (raw []metrics.Metric, aggs []*Aggregate) {
+	tiers := map[Resolution][]metrics.Metric{}
+	for _, m := range stored {
+		resolution := ResolutionRaw
+		if r := m.Labels["__resolution__"]; r != "" {
+			resolution = Resolution(r)
+		}
+		tiers[resolution] = append(tiers[resolution], m)
+	}
+	if len(tiers[ResolutionRaw]) == len(stored) {
+		return stored, nil // No aggregates: the common case
+	}
+
+	resolutions := make([]Resolution, 0, len(tiers))
+	for resolution := range tiers {
+		if resolution == ResolutionRaw || resolution.Duration() > 0 {
+			resolutions = append(resolutions, resolution)
+		}
+	}
+	SortResolutions(resolutions)
+
+	cutoff := time.Time{} // Finer tiers cover everything from here on
+	for _, resolution := range resolutions {
+		oldest := time.Time{}
+		for _, m := range tiers[resolution] {
+			if !cutoff.IsZero() && m.Timestamp.Add(resolution.Duration()).After(cutoff) {
+				continue // A finer tier covers this bucket
+			}
+			if oldest.IsZero() || m.Timestamp.Before(oldest) {
+				oldest = m.Timestamp
+			}
+			if resolution == ResolutionRaw {
+				raw = append(raw, m)
+				continue
+			}
+			if agg := ParseAggregate(m); agg != nil {
+				aggs = append(aggs, agg)
+			}
+		}
+		if !oldest.IsZero() {
+			cutoff = oldest
+		}
+	}
+	return raw, aggs
+}
+
+// samples returns plain samples standing in for the aggregate
+func (a *Aggregate) samples() []metrics.Metric {
+	sample := func(ts time.Time, value float64) metrics.Metric {
+		return metrics.Metric{Name: a.Name, Type: a.Type, Value: value, Labels: a.Labels, Timestamp: ts}
+	}
+	switch a.Type {
+	case metrics.HistogramType:
+		return []metrics.Metric{sample(a.Timestamp, a.Sum)}
+	case metrics.CounterType:
+	default:
+		return []metrics.Metric{sample(a.Timestamp, a.Average())}
+	}
+
+	// A counter starts at First and ends at Last, in the bucket's last
+	// second. If it was reset in between, samples at the bucket's middle
+	// carry the increase before the reset and the reset to zero.
+	out := []metrics.Metric{sample(a.Timestamp, a.First)}
+	if a.Count < 2 {
+		return out
+	}
+	duration := a.Resolution.Duration()
+	tolerance := 1e-9 * (math.Abs(a.First) + math.Abs(a.Last) + a.Increase)
+	if a.Increase > a.Last-a.First+tolerance {
+		middle := a.Timestamp.Add(duration / 2)
+		out = append(out,
+			sample(middle, a.First+a.Increase-a.Last),
+			sample(middle.Add(time.Second), 0))
+	}
+	return append(out, sample(a.Timestamp.Add(duration-time.Second), a.Last))
+}
+
+func sortByTime(ms []metrics.Metric) []metrics.Metric {
+	sort.SliceStable(ms, func(i, j int) bool {
+		return ms[i].Timestamp.Before(ms[j].Timestamp)
+	})
+	return ms
+}
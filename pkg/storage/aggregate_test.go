@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+)
+
+func TestAggregate_RoundTrip(t *testing.T) {
+	agg := &Aggregate{
+		Name:       "requests_total",
+		Labels:     map[string]string{"_shard": "3", "path": "/api"},
+		Timestamp:  time.Unix(1700000000, 0),
+		Resolution: Resolution5m,
+		Type:       metrics.CounterType,
+	}
+	for _, v := range []float64{1e9 + 0.123456789, 1e9 + 0.987654321} {
+		agg.Add(v)
+	}
+
+	m := agg.ToMetric()
+	if len(m.Labels) != 3 || m.Labels["__resolution__"] != "5m" {
+		t.Errorf("Stored labels = %v, want the user labels and __resolution__", m.Labels)
+	}
+	parsed := ParseAggregate(m)
+	if parsed == nil {
+		t.Fatal("ParseAggregate returned nil")
+	}
+	if parsed.Sum != agg.Sum || parsed.Min != agg.Min || parsed.Max != agg.Max || parsed.Increase != agg.Increase {
+		t.Errorf("Parsed %+v, want %+v at full precision", parsed, agg)
+	}
+	if len(parsed.Labels) != 2 || parsed.Labels["_shard"] != "3" {
+		t.Errorf("Parsed labels = %v, want %v", parsed.Labels, agg.Labels)
+	}
+
+	// Without statistics a sample is not an aggregate
+	if ParseAggregate(metrics.Metric{Name: "cpu", Labels: map[string]string{"__resolution__": "5m"}}) != nil {
+		t.Error("Expected nil for a resolution label without statistics")
+	}
+}
+
+func TestUpgradeLegacyAggregate(t *testing.T) {
+	legacy := metrics.Metric{
+		Name:   "requests_total",
+		Type:   metrics.CounterType,
+		Value:  30,
+		Labels: map[string]string{"_shard": "3", "__resolution__": "1h", "__sum__": "60.500000", "__count__": "3", "__min__": "10.000000", "__max__": "30.000000", "__first__": "10.000000", "__last__": "30.000000", "__increase__": "20.000000"},
+	}
+	if !IsLegacyAggregate(legacy) {
+		t.Fatal("Expected a legacy aggregate")
+	}
+
+	upgraded, err := UpgradeLegacyAggregate(legacy)
+	if err != nil {
+		t.Fatalf("UpgradeLegacyAggregate failed: %v", err)
+	}
+	if IsLegacyAggregate(upgraded) || len(upgraded.Labels) != 2 || upgraded.Labels["_shard"] != "3" {
+		t.Errorf("Upgraded labels = %v, want _shard and __resolution__", upgraded.Labels)
+	}
+	want := &metrics.AggregateStats{Sum: 60.5, Count: 3, Min: 10, Max: 30, First: 10, Last: 30, Increase: 20}
+	if !reflect.DeepEqual(upgraded.Aggregate, want) {
+		t.Errorf("Upgraded statistics = %+v, want %+v", upgraded.Aggregate, want)
+	}
+	if legacy.Labels["__sum__"] == "" {
+		t.Error("UpgradeLegacyAggregate modified its input's labels")
+	}
+
+	legacy.Labels["__count__"] = "many"
+	if _, err := UpgradeLegacyAggregate(legacy); err == nil {
+		t.Error("Expected an error for a malformed count")
+	}
+}
+
+func TestParseResolution(t *testing.T) {
+	for name, want := range map[string]time.Duration{"raw": 0, "1m": time.Minute, "15m": 15 * time.Minute, "1h": time.Hour, "1d": 24 * time.Hour} {
+		res, err := ParseResolution(name)
+		if err != nil {
+			t.Errorf("ParseResolution(%q) failed: %v", name, err)
+			continue
+		}
+		if res.Duration() != want {
+			t.Errorf("ParseResolution(%q).Duration() = %v, want %v", name, res.Duration(), want)
+		}
+	}
+	for _, name := range []string{"", "daily", "0d", "-5m", "1ms"} {
+		if _, err := ParseResolution(name); err == nil {
+			t.Errorf("ParseResolution(%q) succeeded, want error", name)
+		}
+	}
+}
+
+func TestResolveTiers_AnyLadder(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	aggregate := func(res Resolution, ts time.Time) metrics.Metric {
+		agg := &Aggregate{Name: "cpu", Timestamp: ts, Resolution: res}
+		agg.Add(1)
+		return agg.ToMetric()
+	}
+	stored := []metrics.Metric{
+		aggregate("1d", day),                    // Read: nothing finer covers it
+		aggregate("1d", day.Add(24*time.Hour)),  // Covered by the 15m aggregates
+		aggregate("15m", day.Add(36*time.Hour)), // Read
+		aggregate("15m", day.Add(47*time.Hour)), // Covered by raw data
+		{Name: "cpu", Value: 1, Timestamp: day.Add(47 * time.Hour)},
+	}
+
+	raw, aggs := ResolveTiers(stored)
+	if len(raw) != 1 || len(aggs) != 2 {
+		t.Fatalf("Got %d raw samples and %d aggregates, want 1 and 2", len(raw), len(aggs))
+	}
+	for _, agg := range aggs {
+		if !(agg.Resolution == "1d" && agg.Timestamp.Equal(day)) && !(agg.Resolution == "15m" && agg.Timestamp.Equal(day.Add(36*time.Hour))) {
+			t.Errorf("Unexpected aggregate %s at %v", agg.Resolution, agg.Timestamp)
+		}
+	}
+}
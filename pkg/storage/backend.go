@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BackendOptions configures a backend opened by name with OpenBackend.
+// Backends ignore options that don't apply to them.
+type BackendOptions struct {
+	// Path is where disk-backed backends keep their data
+	Path string
+
+	// InMemory keeps everything in memory (disk-backed backends, for testing)
+	InMemory bool
+
+	// MaxMemoryMB bounds caches and memtables (0 = backend default)
+	MaxMemoryMB int64
+
+	// Params holds backend-specific options (e.g. ring's "retention")
+	Params map[string]string
+}
+
+// String returns a backend-specific option, or def if unset
+func (o BackendOptions) String(key, def string) string {
+	if v, ok := o.Params[key]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// Int returns a backend-specific integer option, or def if unset
+func (o BackendOptions) Int(key string, def int) (int, error) {
+	v, ok := o.Params[key]
+	if !ok || v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, v, err)
+	}
+	return n, nil
+}
+
+// Duration returns a backend-specific duration option (e.g. "2h"), or def if unset
+func (o BackendOptions) Duration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := o.Params[key]
+	if !ok || v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, v, err)
+	}
+	return d, nil
+}
+
+// BackendFactory opens a storage backend
+type BackendFactory func(opts BackendOptions) (Storage, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]BackendFactory)
+)
+
+// RegisterBackend makes a backend available to OpenBackend under name.
+// Backend packages register themselves in init, like database/sql drivers.
+// Panics if the name is already taken.
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if factory == nil {
+		panic("storage: RegisterBackend factory is nil")
+	}
+	if _, dup := backends[name]; dup {
+		panic("storage: RegisterBackend called twice for backend " + name)
+	}
+	backends[name] = factory
+}
+
+// OpenBackend opens the backend registered under name
+func OpenBackend(name string, opts BackendOptions) (Storage, error) {
+	backendsMu.RLock()
+	factory, ok := backends[name]
+	backendsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown storage backend %q (available: %v)", name, Backends())
+	}
+	return factory(opts)
+}
+
+// Backends returns the names of the registered backends, sorted
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,13 @@
+package badger
+
+import "github.com/nicktill/tinyobs/pkg/storage"
+
+func init() {
+	storage.RegisterBackend("badger", func(opts storage.BackendOptions) (storage.Storage, error) {
+		return New(Config{
+			Path:        opts.Path,
+			InMemory:    opts.InMemory,
+			MaxMemoryMB: opts.MaxMemoryMB,
+		})
+	})
+}
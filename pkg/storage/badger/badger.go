@@ -4,9 +4,13 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/cespare/xxhash/v2"
@@ -19,6 +23,18 @@ import (
 // Storage implements storage.Storage using BadgerDB (LSM tree)
 type Storage struct {
 	db *badger.DB
+
+	// Tombstones hide deleted series from queries until they are purged
+	mu         sync.RWMutex
+	tombstones []storage.Tombstone
+
+	// Per-series counters behind Stats, maintained on every write and delete.
+	// statsMu also serializes writes and deletes so each sample is counted once.
+	statsMu sync.Mutex
+	series  map[uint64]*seriesStats
+
+	// Series -> ID assignments used in sample keys
+	registry *seriesRegistry
 }
 
 // Config holds BadgerDB configuration
@@ -96,20 +112,56 @@ func New(cfg Config) (*Storage, error) {
 		return nil, fmt.Errorf("failed to open badger: %w", err)
 	}
 
-	return &Storage{db: db}, nil
+	s := &Storage{db: db}
+	if err := s.loadTombstones(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load tombstones: %w", err)
+	}
+	if err := s.loadRegistry(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load series registry: %w", err)
+	}
+	if err := s.migrateKeys(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate keys: %w", err)
+	}
+	if err := s.loadStats(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load stats: %w", err)
+	}
+
+	return s, nil
 }
 
 // Write stores metrics in BadgerDB
 // CRITICAL: Enforces context timeout/cancellation to prevent indefinite blocking
 func (s *Storage) Write(ctx context.Context, metrics []metrics.Metric) error {
+	_, err := s.WriteWithPolicy(ctx, metrics, storage.LastWriteWins)
+	return err
+}
+
+// WriteWithPolicy stores metrics, resolving samples whose key already exists
+// (stored, or earlier in the same batch) with the duplicate policy
+// CRITICAL: Enforces context timeout/cancellation to prevent indefinite blocking
+func (s *Storage) WriteWithPolicy(ctx context.Context, metrics []metrics.Metric, policy storage.DuplicatePolicy) (storage.WriteResult, error) {
 	// Check context before starting expensive operation
 	if err := ctx.Err(); err != nil {
-		return err
+		return storage.WriteResult{}, err
 	}
 
-	done := make(chan error, 1)
+	type writeResult struct {
+		result storage.WriteResult
+		err    error
+	}
+	done := make(chan writeResult, 1)
 	go func() {
-		done <- s.db.Update(func(txn *badger.Txn) error {
+		s.statsMu.Lock()
+		defer s.statsMu.Unlock()
+
+		var result storage.WriteResult
+		delta := make(statsDelta)
+		assigner := s.registry.assigner()
+		err := s.db.Update(func(txn *badger.Txn) error {
 			for i, m := range metrics {
 				// Check context periodically (every 100 metrics)
 				if i%100 == 0 {
@@ -120,87 +172,182 @@ func (s *Storage) Write(ctx context.Context, metrics []metrics.Metric) error {
 					}
 				}
 
-				key := makeKey(m.Name, m.Labels, m.Timestamp)
+				id, err := assigner.id(txn, seriesKeyString(m.Name, m.Labels))
+				if err != nil {
+					return err
+				}
+				key := sampleKey(m.Name, id, m.Timestamp)
 				value, err := encodeMetric(m)
 				if err != nil {
 					return fmt.Errorf("failed to encode metric: %w", err)
 				}
 
+				// Only new samples count towards stats (overwrites don't)
+				// PERFORMANCE: Bloom filters make this lookup cheap for new keys
+				// (txn.Get also sees keys set earlier in this batch)
+				_, err = txn.Get(key)
+				switch {
+				case errors.Is(err, badger.ErrKeyNotFound):
+					delta.add(id, m.Name, m.Timestamp.UnixNano())
+					result.Added++
+				case err != nil:
+					return fmt.Errorf("failed to check existing metric: %w", err)
+				case policy == storage.FirstWriteWins:
+					result.Dropped++
+					continue
+				default:
+					result.Overwritten++
+				}
+
 				if err := txn.Set(key, value); err != nil {
 					return fmt.Errorf("failed to write metric: %w", err)
 				}
 			}
 			return nil
 		})
+		if err == nil {
+			assigner.commit()
+			s.applyWrite(delta)
+		} else {
+			result = storage.WriteResult{}
+		}
+		done <- writeResult{result, err}
 	}()
 
 	select {
-	case err := <-done:
-		return err
+	case res := <-done:
+		return res.result, res.err
 	case <-ctx.Done():
 		// Context cancelled while waiting for operation to complete
-		return fmt.Errorf("write operation cancelled: %w", ctx.Err())
+		return storage.WriteResult{}, fmt.Errorf("write operation cancelled: %w", ctx.Err())
 	}
 }
 
 // Query retrieves metrics matching the request
 // CRITICAL: Enforces context timeout/cancellation to prevent indefinite blocking
 func (s *Storage) Query(ctx context.Context, req storage.QueryRequest) ([]metrics.Metric, error) {
+	var results []metrics.Metric
+	err := s.scan(ctx, req, func(m metrics.Metric) bool {
+		results = append(results, m)
+		return req.Limit <= 0 || len(results) < req.Limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// QueryDownsampled aggregates samples into req.Step steps while scanning,
+// so memory use is bounded by the steps returned rather than the samples read
+func (s *Storage) QueryDownsampled(ctx context.Context, req storage.QueryRequest) ([]metrics.Metric, error) {
+	d := storage.NewDownsampler(req)
+	err := s.scan(ctx, req, func(m metrics.Metric) bool {
+		d.Add(m)
+		return true // Limit applies to the steps, not the samples
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.Result(), nil
+}
+
+// ScanSeries streams matching series to fn one at a time. A series'
+// samples are adjacent in key order and sorted by time, so only the series
+// being read is held in memory.
+func (s *Storage) ScanSeries(ctx context.Context, req storage.QueryRequest, fn func(series []metrics.Metric) error) error {
+	var series []metrics.Metric
+	var fnErr error
+	flush := func() bool {
+		if len(series) == 0 {
+			return true
+		}
+		if fnErr = ctx.Err(); fnErr == nil {
+			fnErr = fn(series)
+		}
+		series = nil // fn may keep the slice
+		return fnErr == nil
+	}
+
+	req.Limit = 0
+	err := s.scan(ctx, req, func(m metrics.Metric) bool {
+		if len(series) > 0 && (series[0].Name != m.Name || !maps.Equal(series[0].Labels, m.Labels)) {
+			if !flush() {
+				return false
+			}
+		}
+		series = append(series, m)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if fnErr != nil {
+		return fnErr
+	}
+	flush()
+	return fnErr
+}
+
+// scan calls visit for every sample matching the request's filters until
+// visit returns false. req.Limit is left to visit.
+func (s *Storage) scan(ctx context.Context, req storage.QueryRequest, visit func(metrics.Metric) bool) error {
 	// Check context before starting expensive operation
 	if err := ctx.Err(); err != nil {
-		return nil, err
+		return err
 	}
 
-	var results []metrics.Metric
 	startTime := time.Now()
-	var iterCount int
+	var iterCount, matched int
 
-	type queryResult struct {
-		results []metrics.Metric
-		err     error
-	}
-	done := make(chan queryResult, 1)
+	// Snapshot tombstones once so deleted series stay hidden for the whole scan
+	tombstones := s.Tombstones()
+
+	done := make(chan error, 1)
 
 	go func() {
-		var res queryResult
-		res.err = s.db.View(func(txn *badger.Txn) error {
+		done <- s.db.View(func(txn *badger.Txn) error {
 			opts := badger.DefaultIteratorOptions
 			opts.PrefetchSize = 100
 
 			// PERFORMANCE FIX: Use prefix scanning when metric names are specified
 			// This provides 100x speedup by scanning only relevant keys
+			// No metric filter: fall back to a full scan (nil prefix)
+			prefixes := [][]byte{nil}
 			if len(req.MetricNames) > 0 {
-				// Scan each metric name with prefix iteration
+				prefixes = prefixes[:0]
 				for _, metricName := range req.MetricNames {
-					nameBytes := []byte(metricName)
-					nameLen := uint16(len(nameBytes))
-
-					// Build prefix: [name_length (2 bytes)][metric_name]
-					prefix := make([]byte, 2+len(nameBytes))
-					binary.BigEndian.PutUint16(prefix[0:2], nameLen)
-					copy(prefix[2:], nameBytes)
+					prefixes = append(prefixes, metricPrefix(metricName))
+				}
+			}
 
-					opts.Prefix = prefix
+			for _, prefix := range prefixes {
+				opts.Prefix = prefix
+				more, err := func() (bool, error) {
 					it := txn.NewIterator(opts)
+					defer it.Close()
 
 					for it.Rewind(); it.ValidForPrefix(prefix); it.Next() {
 						iterCount++
 
-						// Check context cancellation every 1000 iterations
+						// CRITICAL: Check for context cancellation every 1000 iterations
+						// Prevents long-running queries from blocking shutdown or exceeding timeouts
 						if iterCount%1000 == 0 {
-							select {
-							case <-ctx.Done():
-								it.Close()
+							if err := ctx.Err(); err != nil {
+								// Log slow query warning before returning error
 								elapsed := time.Since(startTime)
 								if elapsed > 5*time.Second {
-									log.Printf("Query cancelled after %v (%d iterations, %d results)\n", elapsed, iterCount, len(results))
+									log.Printf("Query cancelled after %v (%d iterations, %d results)\n", elapsed, iterCount, matched)
 								}
-								return ctx.Err()
-							default:
+								return false, err
 							}
 						}
 
 						item := it.Item()
+						if isMetaKey(item.Key()) {
+							continue // Internal metadata, not a sample
+						}
+
+						more := true
 						err := item.Value(func(val []byte) error {
 							m, err := decodeMetric(val)
 							if err != nil {
@@ -208,205 +355,221 @@ func (s *Storage) Query(ctx context.Context, req storage.QueryRequest) ([]metric
 							}
 
 							// Apply remaining filters (time range, labels)
-							if !matchesQuery(m, req) {
-								return nil
-							}
-
-							results = append(results, m)
-
-							// Limit check
-							if req.Limit > 0 && len(results) >= req.Limit {
+							if !matchesQuery(m, req) || isTombstoned(m, tombstones) {
 								return nil
 							}
 
+							matched++
+							more = visit(m)
 							return nil
 						})
-
 						if err != nil {
-							it.Close()
-							return err
+							return false, err
 						}
 
-						// Early exit if limit reached
-						if req.Limit > 0 && len(results) >= req.Limit {
-							break
+						// Early exit once the caller has enough
+						if !more {
+							return false, nil
 						}
 					}
-					it.Close()
-
-					// Stop if limit reached across all metrics
-					if req.Limit > 0 && len(results) >= req.Limit {
-						break
-					}
+					return true, nil
+				}()
+				if err != nil {
+					return err
 				}
-			} else {
-				// No metric filter: fall back to full scan
-				it := txn.NewIterator(opts)
-				defer it.Close()
-
-				for it.Rewind(); it.Valid(); it.Next() {
-					iterCount++
-
-					// CRITICAL: Check for context cancellation every 1000 iterations
-					// Prevents long-running queries from blocking shutdown or exceeding timeouts
-					if iterCount%1000 == 0 {
-						select {
-						case <-ctx.Done():
-							// Log slow query warning before returning error
-							elapsed := time.Since(startTime)
-							if elapsed > 5*time.Second {
-								log.Printf("Query cancelled after %v (%d iterations, %d results)\n", elapsed, iterCount, len(results))
-							}
-							return ctx.Err()
-						default:
-							// Continue processing
-						}
-					}
-
-					item := it.Item()
-
-					err := item.Value(func(val []byte) error {
-						m, err := decodeMetric(val)
-						if err != nil {
-							return err
-						}
-
-						// Apply filters
-						if !matchesQuery(m, req) {
-							return nil
-						}
-
-						results = append(results, m)
-
-						// Limit check
-						if req.Limit > 0 && len(results) >= req.Limit {
-							return nil
-						}
-
-						return nil
-					})
-
-					if err != nil {
-						return err
-					}
-
-					// Early exit if limit reached
-					if req.Limit > 0 && len(results) >= req.Limit {
-						break
-					}
+				if !more {
+					break
 				}
-			} // End else (full scan)
+			}
 
 			// Log slow queries for performance monitoring
 			elapsed := time.Since(startTime)
 			if elapsed > 5*time.Second {
-				log.Printf("Slow query completed in %v (%d iterations, %d results)\n", elapsed, iterCount, len(results))
+				log.Printf("Slow query completed in %v (%d iterations, %d results)\n", elapsed, iterCount, matched)
 			}
 
 			return nil
 		})
-		res.results = results
-		done <- res
 	}()
 
 	select {
-	case res := <-done:
-		return res.results, res.err
+	case err := <-done:
+		return err
 	case <-ctx.Done():
 		// Context cancelled while waiting for operation to complete
-		return nil, fmt.Errorf("query operation cancelled: %w", ctx.Err())
+		return fmt.Errorf("query operation cancelled: %w", ctx.Err())
 	}
 }
 
 // Delete removes metrics matching the deletion criteria
 // CRITICAL: Enforces context timeout/cancellation to prevent indefinite blocking
 func (s *Storage) Delete(ctx context.Context, opts storage.DeleteOptions) error {
+	_, err := s.DeleteCounted(ctx, opts)
+	return err
+}
+
+// DeleteCounted removes metrics matching the deletion criteria and returns
+// how many samples were removed
+func (s *Storage) DeleteCounted(ctx context.Context, opts storage.DeleteOptions) (int, error) {
 	// Check context before starting expensive operation
 	if err := ctx.Err(); err != nil {
-		return err
+		return 0, err
 	}
 
-	done := make(chan error, 1)
+	type deleteResult struct {
+		deleted int
+		err     error
+	}
+	done := make(chan deleteResult, 1)
 	go func() {
-		done <- s.db.Update(func(txn *badger.Txn) error {
-			iterOpts := badger.DefaultIteratorOptions
-			// Need values if filtering by resolution
-			iterOpts.PrefetchValues = opts.Resolution != nil
-
-			it := txn.NewIterator(iterOpts)
-			defer it.Close()
-
-			var keysToDelete [][]byte
-			var iterCount int
+		deleted, err := s.deleteMatching(ctx, opts)
+		done <- deleteResult{deleted, err}
+	}()
 
-			for it.Rewind(); it.Valid(); it.Next() {
-				iterCount++
+	select {
+	case res := <-done:
+		return res.deleted, res.err
+	case <-ctx.Done():
+		// Context cancelled while waiting for operation to complete
+		return 0, fmt.Errorf("delete operation cancelled: %w", ctx.Err())
+	}
+}
 
-				// Check context periodically (every 1000 iterations)
-				if iterCount%1000 == 0 {
-					select {
-					case <-ctx.Done():
-						return ctx.Err()
-					default:
-					}
-				}
+// deleteMatching collects keys matching the deletion criteria in a read-only
+// scan, then removes them with a WriteBatch. The batch splits large deletions
+// across transactions, so deleting millions of samples can't hit ErrTxnTooBig.
+func (s *Storage) deleteMatching(ctx context.Context, opts storage.DeleteOptions) (int, error) {
+	// Values are only needed to filter on labels (including __resolution__)
+	needValues := opts.Resolution != nil
+	for _, m := range opts.Matchers {
+		if m.Name != storage.MetricNameLabel {
+			needValues = true
+		}
+	}
+	for _, exclude := range opts.Exclude {
+		for _, m := range exclude {
+			if m.Name != storage.MetricNameLabel {
+				needValues = true
+			}
+		}
+	}
 
-				item := it.Item()
+	var keysToDelete [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.PrefetchValues = needValues
 
-				// Extract timestamp from key
-				_, ts := parseKey(item.Key())
-				if !ts.Before(opts.Before) {
-					continue // Keep metrics after cutoff
-				}
+		// PERFORMANCE: Scan a single metric's keys when the matchers pin the name
+		var prefix []byte
+		if name, ok := storage.MetricNameFromMatchers(opts.Matchers); ok {
+			prefix = metricPrefix(name)
+			iterOpts.Prefix = prefix
+		}
 
-				// If resolution filter is specified, check the metric's resolution
-				if opts.Resolution != nil {
-					var m metrics.Metric
-					if err := item.Value(func(val []byte) error {
-						return json.Unmarshal(val, &m)
-					}); err != nil {
-						return fmt.Errorf("failed to unmarshal metric: %w", err)
-					}
+		it := txn.NewIterator(iterOpts)
+		defer it.Close()
 
-					// Get resolution from labels
-					resolution := "" // Default for raw metrics
-					if m.Labels != nil {
-						resolution = m.Labels["__resolution__"]
-					}
+		var iterCount int
+		for it.Rewind(); it.ValidForPrefix(prefix); it.Next() {
+			iterCount++
 
-					// Skip if resolution doesn't match filter
-					if resolution != string(*opts.Resolution) {
-						continue
-					}
+			// Check context periodically (every 1000 iterations)
+			if iterCount%1000 == 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
 				}
+			}
 
-				// Mark for deletion
-				key := item.KeyCopy(nil)
-				keysToDelete = append(keysToDelete, key)
+			item := it.Item()
+			if isMetaKey(item.Key()) {
+				continue
 			}
 
-			// Delete collected keys
-			for _, key := range keysToDelete {
-				if err := txn.Delete(key); err != nil {
+			// Cheap checks on the key first (name and timestamp)
+			name, ts := parseKey(item.Key())
+			m := metrics.Metric{Name: name, Timestamp: ts}
+			if !needValues && !opts.Matches(m) {
+				continue
+			}
+
+			if needValues {
+				if err := item.Value(func(val []byte) error {
+					var err error
+					m, err = decodeMetric(val)
 					return err
+				}); err != nil {
+					return fmt.Errorf("failed to unmarshal metric: %w", err)
+				}
+				if !opts.Matches(m) {
+					continue
 				}
 			}
 
-			return nil
-		})
-	}()
+			// Mark for deletion
+			keysToDelete = append(keysToDelete, item.KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
 
-	select {
-	case err := <-done:
-		return err
-	case <-ctx.Done():
-		// Context cancelled while waiting for operation to complete
-		return fmt.Errorf("delete operation cancelled: %w", ctx.Err())
+	if len(keysToDelete) == 0 {
+		return 0, nil
 	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	// Re-check keys under the lock: a concurrent delete may have removed some
+	// since the scan, and they must not be subtracted from the stats twice
+	delta := make(statsDelta)
+	if err := s.db.View(func(txn *badger.Txn) error {
+		live := keysToDelete[:0]
+		for _, key := range keysToDelete {
+			if _, err := txn.Get(key); errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			name, id, ts, _ := splitKey(key)
+			delta.add(id, name, ts)
+			live = append(live, key)
+		}
+		keysToDelete = live
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to check metrics to delete: %w", err)
+	}
+
+	// Delete collected keys
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keysToDelete {
+		if err := wb.Delete(key); err != nil {
+			return 0, fmt.Errorf("failed to delete metric: %w", err)
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+
+	if err := s.applyDelete(delta); err != nil {
+		return 0, fmt.Errorf("failed to update stats: %w", err)
+	}
+	return len(keysToDelete), nil
 }
 
-// Close shuts down BadgerDB cleanly
+// Close persists stats and shuts down BadgerDB cleanly
 func (s *Storage) Close() error {
+	s.statsMu.Lock()
+	err := s.persistStats(true)
+	s.statsMu.Unlock()
+	if err != nil {
+		log.Printf("Failed to persist stats (they will be rebuilt on next open): %v", err)
+	}
 	return s.db.Close()
 }
 
@@ -418,144 +581,84 @@ func (s *Storage) RunGC(discardRatio float64) error {
 	return s.db.RunValueLogGC(discardRatio)
 }
 
-// Stats returns storage statistics
-// CRITICAL: Enforces context timeout/cancellation to prevent indefinite blocking
-func (s *Storage) Stats(ctx context.Context) (*storage.Stats, error) {
-	// Check context before starting expensive operation
-	if err := ctx.Err(); err != nil {
-		return nil, err
-	}
-
-	type statsResult struct {
-		stats *storage.Stats
-		err   error
+// ReclaimSpace releases disk space held by deleted keys.
+// Flattens the LSM tree (drops deleted keys from SST files), then runs value
+// log GC until there is nothing left to rewrite. Used by size-based eviction,
+// where disk usage must actually drop before the next eviction round.
+func (s *Storage) ReclaimSpace(ctx context.Context) error {
+	if err := s.db.Flatten(1); err != nil {
+		return fmt.Errorf("failed to flatten LSM tree: %w", err)
 	}
-	done := make(chan statsResult, 1)
 
-	go func() {
-		var res statsResult
-		stats := &storage.Stats{}
-
-		res.err = s.db.View(func(txn *badger.Txn) error {
-			opts := badger.DefaultIteratorOptions
-			opts.PrefetchValues = false
-
-			it := txn.NewIterator(opts)
-			defer it.Close()
-
-			seriesMap := make(map[uint64]bool)
-			var oldestTS, newestTS time.Time
-			var iterCount int
-
-			for it.Rewind(); it.Valid(); it.Next() {
-				iterCount++
-
-				// Check context periodically (every 1000 iterations)
-				if iterCount%1000 == 0 {
-					select {
-					case <-ctx.Done():
-						return ctx.Err()
-					default:
-					}
-				}
-
-				item := it.Item()
-				stats.TotalMetrics++
-
-				// Parse key to get timestamp
-				key := item.Key()
-				_, ts := parseKey(key)
-
-				// Extract series hash for unique series counting
-				// Key format: [name_len(2)][name][series_hash(8)][timestamp(8)]
-				if len(key) >= 18 {
-					nameLen := binary.BigEndian.Uint16(key[0:2])
-					if len(key) >= int(2+nameLen+16) {
-						// Extract 8-byte series hash (after name, before timestamp)
-						seriesHash := binary.BigEndian.Uint64(key[2+nameLen : 2+nameLen+8])
-						seriesMap[seriesHash] = true
-					}
-				}
-
-				if oldestTS.IsZero() || ts.Before(oldestTS) {
-					oldestTS = ts
-				}
-				if newestTS.IsZero() || ts.After(newestTS) {
-					newestTS = ts
-				}
-			}
-
-			stats.TotalSeries = uint64(len(seriesMap))
-			stats.OldestMetric = oldestTS
-			stats.NewestMetric = newestTS
-
-			return nil
-		})
-
-		if res.err == nil {
-			// Get DB size from LSM
-			lsmSize, vlogSize := s.db.Size()
-			stats.SizeBytes = uint64(lsmSize + vlogSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := s.db.RunValueLogGC(0.5)
+		if errors.Is(err, badger.ErrNoRewrite) || errors.Is(err, badger.ErrRejected) {
+			return nil // Nothing left to collect (or another GC is already running)
+		}
+		if err != nil {
+			return fmt.Errorf("value log GC failed: %w", err)
 		}
-
-		res.stats = stats
-		done <- res
-	}()
-
-	select {
-	case res := <-done:
-		return res.stats, res.err
-	case <-ctx.Done():
-		// Context cancelled while waiting for operation to complete
-		return nil, fmt.Errorf("stats operation cancelled: %w", ctx.Err())
 	}
 }
 
-// makeKey creates a sortable key with metric name prefix for efficient scanning
-// Format: [metric_name_length (2 bytes)][metric_name][series_hash (8 bytes)][timestamp (8 bytes)]
-// This enables prefix scanning by metric name (100x faster queries)
-func makeKey(name string, labels map[string]string, ts time.Time) []byte {
-	seriesKey := seriesKeyString(name, labels)
-	hash := xxhash.Sum64String(seriesKey)
+// seriesHash hashes a series key string. Series are identified by registry
+// IDs, the hash only detects collisions (a variable so tests can force them)
+var seriesHash = xxhash.Sum64String
 
-	nameBytes := []byte(name)
-	nameLen := len(nameBytes)
+// sampleKey creates a sortable key with metric name prefix for efficient scanning
+// Format: [metric_name_length (2 bytes)][metric_name][series_id (uvarint)][timestamp (8 bytes)]
+// This enables prefix scanning by metric name (100x faster queries). Uvarints
+// are prefix-free, so each series' samples share a unique, contiguous prefix.
+func sampleKey(name string, id uint64, ts time.Time) []byte {
+	key := seriesPrefix(name, id)
 
-	// Allocate: 2 (name length) + name + 8 (hash) + 8 (timestamp)
-	key := make([]byte, 2+nameLen+16)
+	// Write timestamp (maintains time ordering within series)
+	return binary.BigEndian.AppendUint64(key, uint64(ts.UnixNano()))
+}
 
-	// Write name length (allows parsing)
-	binary.BigEndian.PutUint16(key[0:2], uint16(nameLen))
+// metricPrefix returns the key prefix shared by every sample of a metric
+// Format: [name_length (2 bytes)][metric_name]
+func metricPrefix(name string) []byte {
+	prefix := make([]byte, 2+len(name), 2+len(name)+binary.MaxVarintLen64+8)
+	binary.BigEndian.PutUint16(prefix[0:2], uint16(len(name)))
+	copy(prefix[2:], name)
+	return prefix
+}
 
-	// Write metric name (enables prefix scanning)
-	copy(key[2:2+nameLen], nameBytes)
+// splitKey extracts metric name, series ID and timestamp (Unix nanos) from a
+// sample key. ok is false for keys that don't match the layout (see Check).
+func splitKey(key []byte) (name string, id uint64, ts int64, ok bool) {
+	if len(key) < 2+1+8 { // Min: 2 (len) + 0 (name) + 1 (id) + 8 (ts)
+		return "", 0, 0, false
+	}
 
-	// Write series hash (for exact series matching)
-	binary.BigEndian.PutUint64(key[2+nameLen:2+nameLen+8], hash)
+	// Read metric name length
+	nameLen := int(binary.BigEndian.Uint16(key[0:2]))
+	if len(key) < 2+nameLen+1+8 {
+		return "", 0, 0, false
+	}
 
-	// Write timestamp (maintains time ordering within series)
-	binary.BigEndian.PutUint64(key[2+nameLen+8:2+nameLen+16], uint64(ts.UnixNano()))
+	// The series ID must fill the gap between name and timestamp exactly
+	idBytes := key[2+nameLen : len(key)-8]
+	id, n := binary.Uvarint(idBytes)
+	if n != len(idBytes) {
+		return "", 0, 0, false
+	}
 
-	return key
+	ts = int64(binary.BigEndian.Uint64(key[len(key)-8:]))
+	return string(key[2 : 2+nameLen]), id, ts, true
 }
 
 // parseKey extracts metric name and timestamp from storage key
 func parseKey(key []byte) (string, time.Time) {
-	if len(key) < 18 { // Min: 2 (len) + 0 (name) + 8 (hash) + 8 (ts)
+	name, _, ts, ok := splitKey(key)
+	if !ok {
 		return "", time.Time{}
 	}
-
-	// Read metric name length
-	nameLen := binary.BigEndian.Uint16(key[0:2])
-
-	// Extract metric name
-	metricName := string(key[2 : 2+nameLen])
-
-	// Extract timestamp (skip name and hash)
-	tsNano := binary.BigEndian.Uint64(key[2+nameLen+8 : 2+nameLen+16])
-	ts := time.Unix(0, int64(tsNano))
-
-	return metricName, ts
+	return name, time.Unix(0, ts)
 }
 
 // encodeMetric serializes a metric to bytes
@@ -601,10 +704,14 @@ func matchesQuery(m metrics.Metric, req storage.QueryRequest) bool {
 	return true
 }
 
-// seriesKeyString creates a deterministic string key for a series
+// seriesKeyString creates a deterministic string key for a series: the
+// quoted metric name, then each label as "key"="value" in key order, comma
+// separated. Quoting makes the encoding injective, so label values
+// containing separators can't make two label sets share a key.
 func seriesKeyString(name string, labels map[string]string) string {
+	key := strconv.Quote(name)
 	if len(labels) == 0 {
-		return name
+		return key
 	}
 
 	// Sort label keys for deterministic ordering
@@ -615,9 +722,53 @@ func seriesKeyString(name string, labels map[string]string) string {
 	sort.Strings(keys)
 
 	// Build key with sorted labels
-	key := name
 	for _, k := range keys {
-		key += "," + k + "=" + labels[k]
+		key += "," + strconv.Quote(k) + "=" + strconv.Quote(labels[k])
 	}
 	return key
 }
+
+// parseSeriesKey returns the metric name and labels of a series key (see
+// seriesKeyString). ok is false for keys in another encoding.
+func parseSeriesKey(seriesKey string) (name string, labels map[string]string, ok bool) {
+	name, rest, ok := unquotePrefix(seriesKey)
+	if !ok {
+		return "", nil, false
+	}
+	for rest != "" {
+		if rest[0] != ',' {
+			return "", nil, false
+		}
+		k, after, ok := unquotePrefix(rest[1:])
+		if !ok || after == "" || after[0] != '=' {
+			return "", nil, false
+		}
+		v, after, ok := unquotePrefix(after[1:])
+		if !ok {
+			return "", nil, false
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[k] = v
+		rest = after
+	}
+	return name, labels, true
+}
+
+// unquotePrefix unquotes the double-quoted string s starts with and returns
+// the rest of s
+func unquotePrefix(s string) (string, string, bool) {
+	if s == "" || s[0] != '"' {
+		return "", "", false
+	}
+	quoted, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return "", "", false
+	}
+	value, err := strconv.Unquote(quoted)
+	if err != nil {
+		return "", "", false
+	}
+	return value, s[len(quoted):], true
+}
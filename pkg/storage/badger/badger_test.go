@@ -8,6 +8,7 @@ import (
 
 	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
 	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/storagetest"
 )
 
 func TestBadgerStorage_WriteAndQuery(t *testing.T) {
@@ -165,6 +166,41 @@ func TestBadgerStorage_Delete(t *testing.T) {
 	}
 }
 
+func TestBadgerStorage_ReclaimSpace(t *testing.T) {
+	store, err := New(Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	batch := make([]metrics.Metric, 1000)
+	for i := range batch {
+		batch[i] = metrics.Metric{Name: "cpu", Value: float64(i), Timestamp: now.Add(-time.Duration(i) * time.Second)}
+	}
+	if err := store.Write(ctx, batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := store.Delete(ctx, storage.DeleteOptions{Before: now.Add(-500 * time.Second)}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	// Nothing to collect is not an error
+	if err := store.ReclaimSpace(ctx); err != nil {
+		t.Fatalf("ReclaimSpace failed: %v", err)
+	}
+
+	stats, err := store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TotalMetrics != 501 {
+		t.Errorf("Expected 501 metrics after reclaim, got %d", stats.TotalMetrics)
+	}
+}
+
 func TestBadgerStorage_Stats(t *testing.T) {
 	store, err := New(Config{InMemory: true})
 	if err != nil {
@@ -369,3 +405,13 @@ func TestBadgerStorage_Compression(t *testing.T) {
 			stats.SizeBytes, maxExpectedSize)
 	}
 }
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		store, err := New(Config{Path: t.TempDir()})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		return store
+	})
+}
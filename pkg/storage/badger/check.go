@@ -0,0 +1,185 @@
+package badger
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// quarantinePrefix namespaces entries moved out of the sample keyspace by Check.
+// Quarantined entries keep their original value under metaKey("quarantine/<hex key>").
+const quarantinePrefix = "quarantine/"
+
+// badEntry is a sample entry flagged for repair
+type badEntry struct {
+	key   []byte
+	value []byte
+}
+
+// Check scans every sample entry and reports corruption by category.
+// With a repair mode, bad entries are quarantined or deleted and stats are
+// rebuilt. Series ID collisions (two label sets registered under one ID) are
+// reported but never repaired: both series hold valid data and there is no
+// way to tell which sample a key belonged to.
+func (s *Storage) Check(ctx context.Context, opts storage.CheckOptions) (*storage.CheckReport, error) {
+	switch opts.Repair {
+	case storage.RepairNone, storage.RepairQuarantine, storage.RepairDelete:
+	default:
+		return nil, fmt.Errorf("unknown repair mode %q", opts.Repair)
+	}
+	maxExamples := opts.MaxExamples
+	if maxExamples <= 0 {
+		maxExamples = storage.DefaultCheckExamples
+	}
+
+	start := time.Now()
+	report := &storage.CheckReport{
+		StartedAt: start,
+		Problems:  make(map[string]int),
+		Repair:    opts.Repair,
+	}
+	addProblem := func(category string, key []byte, detail string) {
+		report.Problems[category]++
+		if len(report.Examples) < maxExamples {
+			report.Examples = append(report.Examples, storage.CheckProblem{
+				Category: category,
+				Key:      hex.EncodeToString(key),
+				Detail:   detail,
+			})
+		}
+	}
+
+	var bad []badEntry
+	err := s.db.View(func(txn *badger.Txn) error {
+		// The persisted registry, not the in-memory one: Check reports what's on disk
+		ids, err := readRegistry(txn)
+		if err != nil {
+			return err
+		}
+		owners := make(map[uint64][]string, len(ids))
+		for seriesKey, id := range ids {
+			owners[id] = append(owners[id], seriesKey)
+		}
+		for id, seriesKeys := range owners {
+			if len(seriesKeys) > 1 {
+				sort.Strings(seriesKeys)
+				addProblem(storage.ProblemHashCollision, metaKey(seriesRegistryPrefix+seriesKeys[0]),
+					fmt.Sprintf("series %s share ID %d", strings.Join(seriesKeys, ", "), id))
+			}
+		}
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			// Check context periodically (every 1000 keys)
+			if report.KeysScanned%1000 == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+
+			item := it.Item()
+			key := item.Key()
+			if isMetaKey(key) {
+				continue
+			}
+			report.KeysScanned++
+
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return fmt.Errorf("failed to read value: %w", err)
+			}
+
+			if category, detail := checkEntry(key, value, ids); category != "" {
+				addProblem(category, key, detail)
+				bad = append(bad, badEntry{key: item.KeyCopy(nil), value: value})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("check failed after %d keys: %w", report.KeysScanned, err)
+	}
+
+	if opts.Repair != storage.RepairNone && len(bad) > 0 {
+		repaired, err := s.repairEntries(bad, opts.Repair)
+		report.Repaired = repaired
+		if err != nil {
+			return report, err
+		}
+		if _, err := s.RepairStats(ctx); err != nil {
+			return report, err
+		}
+	}
+
+	report.Duration = time.Since(start).Round(time.Millisecond).String()
+	return report, nil
+}
+
+// checkEntry validates one sample entry against the series registry.
+// Returns the problem category and a description, or "" if the entry is sound.
+func checkEntry(key, value []byte, ids map[string]uint64) (string, string) {
+	name, id, ts, ok := splitKey(key)
+	if !ok {
+		return storage.ProblemShortKey, fmt.Sprintf("%d-byte key doesn't fit [name_len][name][series_id][ts]", len(key))
+	}
+
+	m, err := decodeMetric(value)
+	if err != nil {
+		return storage.ProblemUndecodable, err.Error()
+	}
+
+	registered, isRegistered := ids[seriesKeyString(m.Name, m.Labels)]
+	switch {
+	case m.Name != name:
+		return storage.ProblemKeyMismatch, fmt.Sprintf("key name %q, value name %q", name, m.Name)
+	case m.Timestamp.UnixNano() != ts:
+		return storage.ProblemKeyMismatch, fmt.Sprintf("key timestamp %v, value timestamp %v", time.Unix(0, ts), m.Timestamp)
+	case !isRegistered:
+		return storage.ProblemKeyMismatch, fmt.Sprintf("labels %v have no registered series ID (key has %d)", m.Labels, id)
+	case registered != id:
+		return storage.ProblemKeyMismatch, fmt.Sprintf("key series ID %d, labels %v are registered as %d", id, m.Labels, registered)
+	}
+
+	// Every aggregate must carry statistics storage.ParseAggregate can read.
+	// Legacy label-encoded ones left over were malformed when migrated.
+	if _, isAggregate := m.Labels["__resolution__"]; isAggregate {
+		if m.Aggregate == nil {
+			return storage.ProblemMalformedAggregate, fmt.Sprintf("labels %v, no aggregate statistics", m.Labels)
+		}
+		if len(m.Aggregate.Sketch) > 0 {
+			if _, err := storage.DecodeSketch(m.Aggregate.Sketch); err != nil {
+				return storage.ProblemMalformedAggregate, fmt.Sprintf("sketch: %v", err)
+			}
+		}
+	}
+	return "", ""
+}
+
+// repairEntries quarantines or deletes bad entries. Returns how many were repaired.
+func (s *Storage) repairEntries(bad []badEntry, mode storage.RepairMode) (int, error) {
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, e := range bad {
+		if mode == storage.RepairQuarantine {
+			if err := wb.Set(metaKey(quarantinePrefix+hex.EncodeToString(e.key)), e.value); err != nil {
+				return 0, fmt.Errorf("failed to quarantine entry: %w", err)
+			}
+		}
+		if err := wb.Delete(e.key); err != nil {
+			return 0, fmt.Errorf("failed to delete entry: %w", err)
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to repair entries: %w", err)
+	}
+	return len(bad), nil
+}
@@ -0,0 +1,124 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// putRaw writes an entry directly, bypassing Write's encoding
+func putRaw(t *testing.T, s *Storage, key, value []byte) {
+	t.Helper()
+	if err := s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	}); err != nil {
+		t.Fatalf("Failed to write raw entry: %v", err)
+	}
+}
+
+func TestBadgerStorage_Check(t *testing.T) {
+	store, err := New(Config{InMemory: true})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	if err := store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Timestamp: now},
+		{Name: "cpu", Value: 2, Labels: map[string]string{"__resolution__": "5m"}, Timestamp: now, Aggregate: &metrics.AggregateStats{Sum: 2, Count: 1, Min: 2, Max: 2}},
+	}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	report, err := store.Check(ctx, storage.CheckOptions{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.Healthy() || report.KeysScanned != 2 {
+		t.Fatalf("Expected a healthy store with 2 keys, got %+v", report)
+	}
+
+	// One entry per corruption category
+	putRaw(t, store, []byte{0, 3, 'c', 'p', 'u'}, []byte("{}"))
+	putRaw(t, store, sampleKey("cpu", 100, now), []byte("not json"))
+	value, _ := encodeMetric(metrics.Metric{Name: "mem", Value: 1, Timestamp: now})
+	putRaw(t, store, sampleKey("cpu", 101, now), value)
+	malformed := metrics.Metric{Name: "cpu", Labels: map[string]string{"__resolution__": "1h", "__sum__": "NaN-ish"}, Timestamp: now}
+	if err := store.Write(ctx, []metrics.Metric{malformed}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	report, err = store.Check(ctx, storage.CheckOptions{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	for _, category := range []string{storage.ProblemShortKey, storage.ProblemUndecodable, storage.ProblemKeyMismatch, storage.ProblemMalformedAggregate} {
+		if report.Problems[category] != 1 {
+			t.Errorf("Expected 1 %s problem, got %d (%+v)", category, report.Problems[category], report.Examples)
+		}
+	}
+	if report.Repaired != 0 {
+		t.Errorf("Report-only check repaired %d entries", report.Repaired)
+	}
+
+	// Quarantine moves bad entries out of the sample keyspace
+	report, err = store.Check(ctx, storage.CheckOptions{Repair: storage.RepairQuarantine})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if report.Repaired != 4 {
+		t.Errorf("Expected 4 entries quarantined, got %d", report.Repaired)
+	}
+
+	report, err = store.Check(ctx, storage.CheckOptions{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.Healthy() || report.KeysScanned != 2 {
+		t.Errorf("Expected a healthy store after repair, got %+v", report)
+	}
+
+	stats, _ := store.Stats(ctx)
+	if stats.TotalMetrics != 2 {
+		t.Errorf("Expected stats rebuilt to 2 samples, got %d", stats.TotalMetrics)
+	}
+}
+
+func TestBadgerStorage_CheckSeriesIDCollision(t *testing.T) {
+	store, err := New(Config{InMemory: true})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	if err := store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Labels: map[string]string{"host": "a"}, Timestamp: now},
+		{Name: "mem", Value: 3, Timestamp: now},
+	}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// A corrupt registry entry claims the ID of an existing series
+	id, _ := store.registry.lookup(seriesKeyString("cpu", map[string]string{"host": "a"}))
+	putRaw(t, store, metaKey(seriesRegistryPrefix+"cpu,host=b"), binary.AppendUvarint(nil, id))
+
+	report, err := store.Check(ctx, storage.CheckOptions{Repair: storage.RepairDelete})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if report.Problems[storage.ProblemHashCollision] != 1 {
+		t.Errorf("Expected 1 series ID collision, got %+v", report.Problems)
+	}
+	if report.Repaired != 0 {
+		t.Errorf("Collisions must not be repaired, got %d repaired", report.Repaired)
+	}
+}
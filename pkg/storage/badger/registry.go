@@ -0,0 +1,427 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// Registry metadata keys
+const (
+	seriesRegistryPrefix = "series/"     // series/<series key string> -> uvarint series ID
+	seriesNextKeyName    = "series_next" // uvarint lower bound of the next ID, once registered series were dropped
+	formatKeyName        = "format"      // Key layout version of the sample keyspace
+)
+
+// keyFormat is the current sample key layout. Version 1 (no format key)
+// identified series by an 8-byte xxhash of the labels, so colliding label
+// sets silently merged. Version 2 uses registry-assigned series IDs.
+// Version 3 stores aggregate statistics in Metric.Aggregate instead of
+// labels, so all aggregates of a series share its series ID. Version 4
+// quotes names and labels in registry keys, which were ambiguous: a label
+// value containing ",k=v" made two label sets share a series ID.
+const keyFormat = "4"
+
+// seriesRegistry maps series (metric name + sorted labels) to monotonically
+// assigned IDs. IDs are persisted in the same transaction as the first
+// sample of a series and are never reused, even after the series is deleted.
+type seriesRegistry struct {
+	mu     sync.RWMutex
+	ids    map[string]uint64             // seriesKeyString -> ID
+	next   uint64                        // Next ID to assign
+	hashes map[uint64]string             // xxhash -> first series, to detect collisions
+	tiers  map[uint64]storage.Resolution // ID -> resolution, aggregate series only
+
+	collisions int // Label sets whose hash collided (kept apart by their IDs)
+}
+
+func newSeriesRegistry() *seriesRegistry {
+	return &seriesRegistry{
+		ids:    make(map[string]uint64),
+		next:   1,
+		hashes: make(map[uint64]string),
+		tiers:  make(map[uint64]storage.Resolution),
+	}
+}
+
+// lookup returns the ID of a registered series
+func (r *seriesRegistry) lookup(seriesKey string) (uint64, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	id, ok := r.ids[seriesKey]
+	return id, ok
+}
+
+// resolution returns the resolution of a registered series
+func (r *seriesRegistry) resolution(id uint64) storage.Resolution {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.tiers[id]
+}
+
+// add registers a series under an ID and tracks hash collisions.
+// Caller must hold r.mu.
+func (r *seriesRegistry) addLocked(seriesKey string, id uint64) {
+	r.ids[seriesKey] = id
+	if res := seriesKeyResolution(seriesKey); res != storage.ResolutionRaw {
+		r.tiers[id] = res
+	}
+	if id >= r.next {
+		r.next = id + 1
+	}
+
+	hash := seriesHash(seriesKey)
+	if other, ok := r.hashes[hash]; ok && other != seriesKey {
+		r.collisions++
+		log.Printf("Series hash collision between %q and %q (kept apart by series IDs %d and %d)",
+			other, seriesKey, r.ids[other], id)
+		return
+	}
+	r.hashes[hash] = seriesKey
+}
+
+// removeLocked unregisters a series. Its ID is not reused. Caller must
+// hold r.mu.
+func (r *seriesRegistry) removeLocked(seriesKey string) {
+	id, ok := r.ids[seriesKey]
+	if !ok {
+		return
+	}
+	delete(r.ids, seriesKey)
+	delete(r.tiers, id)
+	if hash := seriesHash(seriesKey); r.hashes[hash] == seriesKey {
+		delete(r.hashes, hash)
+	}
+}
+
+// seriesKeyResolution returns the resolution of a series from the labels
+// in its key (see seriesKeyString). Keys in an older encoding, only loaded
+// while migrating, read as raw.
+func seriesKeyResolution(seriesKey string) storage.Resolution {
+	_, labels, ok := parseSeriesKey(seriesKey)
+	if !ok {
+		return storage.ResolutionRaw
+	}
+	return storage.ResolutionOf(labels)
+}
+
+// seriesAssigner hands out IDs within one write transaction. New IDs only
+// reach the registry once the transaction commits, so a failed write can't
+// leave the registry pointing at IDs that were never persisted.
+// Writes are serialized by statsMu, so assigners never race for IDs.
+type seriesAssigner struct {
+	registry *seriesRegistry
+	pending  map[string]uint64
+}
+
+func (r *seriesRegistry) assigner() *seriesAssigner {
+	return &seriesAssigner{registry: r}
+}
+
+// id returns the series' ID, assigning a new one (and persisting it in txn)
+// for a series seen for the first time
+func (a *seriesAssigner) id(txn *badger.Txn, seriesKey string) (uint64, error) {
+	if id, ok := a.registry.lookup(seriesKey); ok {
+		return id, nil
+	}
+	if id, ok := a.pending[seriesKey]; ok {
+		return id, nil
+	}
+
+	a.registry.mu.RLock()
+	id := a.registry.next + uint64(len(a.pending))
+	a.registry.mu.RUnlock()
+
+	if err := txn.Set(metaKey(seriesRegistryPrefix+seriesKey), binary.AppendUvarint(nil, id)); err != nil {
+		return 0, fmt.Errorf("failed to register series: %w", err)
+	}
+	if a.pending == nil {
+		a.pending = make(map[string]uint64)
+	}
+	a.pending[seriesKey] = id
+	return id, nil
+}
+
+// commit publishes the IDs assigned in a committed transaction
+func (a *seriesAssigner) commit() {
+	if len(a.pending) == 0 {
+		return
+	}
+	a.registry.mu.Lock()
+	defer a.registry.mu.Unlock()
+	for seriesKey, id := range a.pending {
+		a.registry.addLocked(seriesKey, id)
+	}
+}
+
+// loadRegistry reads the persisted series registry
+func (s *Storage) loadRegistry() error {
+	var ids map[string]uint64
+	var next uint64
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		if ids, err = readRegistry(txn); err != nil {
+			return err
+		}
+		item, err := txn.Get(metaKey(seriesNextKeyName))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			next, _ = binary.Uvarint(val)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	registry := newSeriesRegistry()
+	for seriesKey, id := range ids {
+		registry.addLocked(seriesKey, id)
+	}
+	registry.next = max(registry.next, next)
+	s.registry = registry
+	return nil
+}
+
+// readRegistry returns every persisted series -> ID assignment
+func readRegistry(txn *badger.Txn) (map[string]uint64, error) {
+	ids := make(map[string]uint64)
+	prefix := metaKey(seriesRegistryPrefix)
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Rewind(); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		seriesKey := string(item.Key()[len(prefix):])
+		if err := item.Value(func(val []byte) error {
+			id, n := binary.Uvarint(val)
+			if n <= 0 {
+				return fmt.Errorf("corrupt series ID for %q", seriesKey)
+			}
+			ids[seriesKey] = id
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// migrateKeys rewrites samples stored under hash-based keys (format 1) to
+// series ID keys, label-encoded aggregates (format 2) to Metric.Aggregate
+// under their series' key, and samples of series registered under unquoted
+// keys (format 3) to the IDs of their quoted keys. Idempotent: keys already in the
+// current layout are left alone, so an interrupted migration resumes on
+// the next open.
+func (s *Storage) migrateKeys(ctx context.Context) error {
+	var format string
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(metaKey(formatKeyName))
+		if err != nil {
+			return err
+		}
+		value, err := item.ValueCopy(nil)
+		format = string(value)
+		return err
+	})
+	if err == nil && format == keyFormat {
+		return nil
+	}
+	if err != nil && err != badger.ErrKeyNotFound {
+		return fmt.Errorf("failed to read key format: %w", err)
+	}
+
+	migrated, err := s.rewriteSampleKeys(ctx)
+	if err != nil {
+		return err
+	}
+	if migrated > 0 {
+		log.Printf("Migrated %d samples to storage format %s", migrated, keyFormat)
+	}
+	dropped, err := s.dropStaleSeries()
+	if err != nil {
+		return err
+	}
+	if dropped > 0 {
+		log.Printf("Dropped %d stale series from the registry", dropped)
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		// Persisted stats are keyed by the old series hashes: force a rebuild
+		if migrated > 0 {
+			if err := txn.Delete(metaKey(statsKeyName)); err != nil {
+				return err
+			}
+		}
+		return txn.Set(metaKey(formatKeyName), []byte(keyFormat))
+	})
+}
+
+// isStaleSeries reports whether a registered series key is left over from
+// an older format: a key in an older encoding (before format 4), or a
+// format 2 aggregate, which had its statistics in labels and so one series
+// per bucket (see storage.IsLegacyAggregate). rewriteSampleKeys has moved
+// their samples to current series keys.
+func isStaleSeries(seriesKey string) bool {
+	_, labels, ok := parseSeriesKey(seriesKey)
+	if !ok {
+		return true
+	}
+	_, hasSum := labels["__sum__"]
+	return hasSum && storage.ResolutionOf(labels) != storage.ResolutionRaw
+}
+
+// dropStaleSeries unregisters the stale series (see isStaleSeries), on
+// disk and in memory. The next ID is persisted so their IDs are still never
+// reused. Returns the number of series dropped.
+func (s *Storage) dropStaleSeries() (int, error) {
+	r := s.registry
+	r.mu.RLock()
+	var stale []string
+	for seriesKey := range r.ids {
+		if isStaleSeries(seriesKey) {
+			stale = append(stale, seriesKey)
+		}
+	}
+	next := r.next
+	r.mu.RUnlock()
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	if err := wb.Set(metaKey(seriesNextKeyName), binary.AppendUvarint(nil, next)); err != nil {
+		return 0, fmt.Errorf("failed to save next series ID: %w", err)
+	}
+	for _, seriesKey := range stale {
+		if err := wb.Delete(metaKey(seriesRegistryPrefix + seriesKey)); err != nil {
+			return 0, fmt.Errorf("failed to drop stale series: %w", err)
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to drop stale series: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, seriesKey := range stale {
+		r.removeLocked(seriesKey)
+	}
+	return len(stale), nil
+}
+
+// rewriteSampleKeys moves every sample whose key doesn't match the current
+// layout to its series ID key, upgrading legacy aggregates, in batches. Returns the number of samples moved.
+func (s *Storage) rewriteSampleKeys(ctx context.Context) (int, error) {
+	const batchSize = 1000
+	migrated := 0
+
+	type move struct {
+		oldKey []byte
+		value  []byte
+		name   string
+		series string
+		ts     time.Time
+	}
+
+	var resume []byte // Continue each scan after the last key of the previous batch
+	for {
+		if err := ctx.Err(); err != nil {
+			return migrated, err
+		}
+
+		// Collect a batch of keys to move (read-only scan)
+		var moves []move
+		err := s.db.View(func(txn *badger.Txn) error {
+			it := txn.NewIterator(badger.DefaultIteratorOptions)
+			defer it.Close()
+
+			for it.Seek(resume); it.Valid() && len(moves) < batchSize; it.Next() {
+				item := it.Item()
+				key := item.Key()
+				if isMetaKey(key) {
+					continue
+				}
+				value, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				m, err := decodeMetric(value)
+				if err != nil {
+					continue // Corrupt value, left for Check to report
+				}
+				if storage.IsLegacyAggregate(m) {
+					upgraded, err := storage.UpgradeLegacyAggregate(m)
+					if err != nil {
+						continue // Malformed, left for Check to report
+					}
+					if value, err = encodeMetric(upgraded); err != nil {
+						return err
+					}
+					m = upgraded // Its new labels belong to another series ID
+				}
+
+				seriesKey := seriesKeyString(m.Name, m.Labels)
+				if id, ok := s.registry.lookup(seriesKey); ok {
+					if name, keyID, ts, ok := splitKey(key); ok && name == m.Name && keyID == id && ts == m.Timestamp.UnixNano() {
+						continue // Already in the current layout
+					}
+				}
+				moves = append(moves, move{
+					oldKey: item.KeyCopy(nil),
+					value:  value,
+					name:   m.Name,
+					series: seriesKey,
+					ts:     m.Timestamp,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("failed to scan legacy keys: %w", err)
+		}
+		if len(moves) == 0 {
+			return migrated, nil
+		}
+
+		assigner := s.registry.assigner()
+		err = s.db.Update(func(txn *badger.Txn) error {
+			for _, mv := range moves {
+				id, err := assigner.id(txn, mv.series)
+				if err != nil {
+					return err
+				}
+				if err := txn.Delete(mv.oldKey); err != nil {
+					return err
+				}
+				if err := txn.Set(sampleKey(mv.name, id, mv.ts), mv.value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("failed to migrate keys: %w", err)
+		}
+		assigner.commit()
+		migrated += len(moves)
+
+		// The last moved key is gone: resume right after it
+		resume = append(moves[len(moves)-1].oldKey, 0)
+	}
+}
@@ -0,0 +1,409 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"maps"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// legacyKey builds a format 1 sample key: [name_len][name][xxhash (8 bytes)][ts]
+func legacyKey(name string, labels map[string]string, ts time.Time) []byte {
+	key := metricPrefix(name)
+	key = binary.BigEndian.AppendUint64(key, xxhash.Sum64String(legacySeriesKeyString(name, labels)))
+	return binary.BigEndian.AppendUint64(key, uint64(ts.UnixNano()))
+}
+
+func TestBadgerStorage_HashCollisionKeepsSeriesApart(t *testing.T) {
+	// Every series hashes to the same value
+	original := seriesHash
+	seriesHash = func(string) uint64 { return 42 }
+	defer func() { seriesHash = original }()
+
+	store, err := New(Config{InMemory: true})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	if err := store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Labels: map[string]string{"host": "a"}, Timestamp: now},
+		{Name: "cpu", Value: 2, Labels: map[string]string{"host": "b"}, Timestamp: now},
+	}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	results, err := store.Query(ctx, storage.QueryRequest{Start: now.Add(-time.Minute), End: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected both colliding series kept, got %+v", results)
+	}
+
+	stats, _ := store.Stats(ctx)
+	if stats.TotalSeries != 2 || stats.TotalMetrics != 2 {
+		t.Errorf("Expected 2 series and 2 samples, got %d and %d", stats.TotalSeries, stats.TotalMetrics)
+	}
+	if store.registry.collisions != 1 {
+		t.Errorf("Expected 1 collision detected, got %d", store.registry.collisions)
+	}
+
+	report, err := store.Check(ctx, storage.CheckOptions{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.Healthy() {
+		t.Errorf("Expected a healthy store, got %+v", report)
+	}
+}
+
+func TestBadgerStorage_SeriesKeysUnambiguous(t *testing.T) {
+	store, err := New(Config{InMemory: true})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	one := map[string]string{"a": "1,b=2"}
+	two := map[string]string{"a": "1", "b": "2"}
+	spoofed := map[string]string{"host": "a,__resolution__=1h"}
+	if err := store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Labels: one, Timestamp: now},
+		{Name: "cpu", Value: 2, Labels: two, Timestamp: now},
+		{Name: "cpu", Value: 3, Labels: spoofed, Timestamp: now},
+	}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	idOne, _ := store.registry.lookup(seriesKeyString("cpu", one))
+	idTwo, _ := store.registry.lookup(seriesKeyString("cpu", two))
+	if idOne == 0 || idTwo == 0 || idOne == idTwo {
+		t.Errorf("Expected distinct series IDs, got %d and %d", idOne, idTwo)
+	}
+	results, err := store.Query(ctx, storage.QueryRequest{Start: now.Add(-time.Minute), End: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 samples, got %+v", results)
+	}
+	for _, m := range results {
+		if want := map[float64]map[string]string{1: one, 2: two, 3: spoofed}[m.Value]; !maps.Equal(m.Labels, want) {
+			t.Errorf("Sample %v has labels %v, want %v", m.Value, m.Labels, want)
+		}
+	}
+
+	// A label value can't move a series to another tier
+	id, _ := store.registry.lookup(seriesKeyString("cpu", spoofed))
+	if res := store.registry.resolution(id); res != storage.ResolutionRaw {
+		t.Errorf("Expected the spoofed series raw, got %q", res)
+	}
+}
+
+func TestBadgerStorage_SeriesIDsPersist(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	now := time.Now()
+
+	store, err := New(Config{Path: dir})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Labels: map[string]string{"host": "a"}, Timestamp: now},
+		{Name: "cpu", Value: 2, Labels: map[string]string{"host": "b"}, Timestamp: now},
+	})
+	idA, _ := store.registry.lookup(seriesKeyString("cpu", map[string]string{"host": "a"}))
+
+	// Deleted series keep their IDs: they are never reused
+	store.Delete(ctx, storage.DeleteOptions{
+		Before:   now.Add(time.Hour),
+		Matchers: []storage.Matcher{{Type: storage.MatchEqual, Name: "host", Value: "b"}},
+	})
+	store.Close()
+
+	store, err = New(Config{Path: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+
+	if id, ok := store.registry.lookup(seriesKeyString("cpu", map[string]string{"host": "a"})); !ok || id != idA {
+		t.Errorf("Expected series ID %d after reopen, got %d (%v)", idA, id, ok)
+	}
+	store.Write(ctx, []metrics.Metric{{Name: "cpu", Value: 3, Labels: map[string]string{"host": "c"}, Timestamp: now}})
+	if id, _ := store.registry.lookup(seriesKeyString("cpu", map[string]string{"host": "c"})); id != 3 {
+		t.Errorf("Expected new series to get ID 3, got %d", id)
+	}
+}
+
+func TestBadgerStorage_MigrateLegacyKeys(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	// A format 1 database: hash keys, no registry and no format marker
+	store, err := New(Config{Path: dir})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	legacy := []metrics.Metric{
+		{Name: "cpu", Value: 1, Labels: map[string]string{"host": "a"}, Timestamp: now},
+		{Name: "cpu", Value: 2, Labels: map[string]string{"host": "a"}, Timestamp: now.Add(time.Second)},
+		{Name: "cpu", Value: 3, Labels: map[string]string{"host": "b"}, Timestamp: now},
+		{Name: "mem", Value: 4, Timestamp: now},
+	}
+	var legacyKeyLen int
+	for _, m := range legacy {
+		value, _ := encodeMetric(m)
+		key := legacyKey(m.Name, m.Labels, m.Timestamp)
+		legacyKeyLen = len(key)
+		putRaw(t, store, key, value)
+	}
+	if err := store.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(metaKey(formatKeyName))
+	}); err != nil {
+		t.Fatalf("Failed to drop format marker: %v", err)
+	}
+	store.Close()
+
+	store, err = New(Config{Path: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+
+	results, err := store.Query(ctx, storage.QueryRequest{
+		Start:       now.Add(-time.Minute),
+		End:         now.Add(time.Minute),
+		MetricNames: []string{"cpu"},
+	})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 3 {
+		t.Errorf("Expected 3 migrated cpu samples, got %d", len(results))
+	}
+
+	stats, _ := store.Stats(ctx)
+	if stats.TotalSeries != 3 || stats.TotalMetrics != 4 {
+		t.Errorf("Expected stats rebuilt (3 series, 4 samples), got %d, %d", stats.TotalSeries, stats.TotalMetrics)
+	}
+
+	report, err := store.Check(ctx, storage.CheckOptions{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.Healthy() || report.KeysScanned != 4 {
+		t.Errorf("Expected 4 healthy keys after migration, got %+v", report)
+	}
+
+	// Series IDs are shorter than the 8-byte hashes they replace
+	id, _ := store.registry.lookup(seriesKeyString("cpu", map[string]string{"host": "a"}))
+	if key := sampleKey("cpu", id, now); len(key) >= legacyKeyLen {
+		t.Errorf("Expected keys shorter than %d bytes, got %d", legacyKeyLen, len(key))
+	}
+}
+
+func TestBadgerStorage_MigrateLegacyAggregates(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Hour)
+
+	// A format 2 database: aggregate statistics in labels, one series per bucket
+	store, err := New(Config{Path: dir})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Type: metrics.GaugeType, Value: 1.5, Labels: map[string]string{"_team": "infra", "__resolution__": "5m", "__sum__": "3.000000", "__count__": "2", "__min__": "1.000000", "__max__": "2.000000"}, Timestamp: now},
+		{Name: "cpu", Type: metrics.GaugeType, Value: 4, Labels: map[string]string{"_team": "infra", "__resolution__": "5m", "__sum__": "4.000000", "__count__": "1", "__min__": "4.000000", "__max__": "4.000000"}, Timestamp: now.Add(5 * time.Minute)},
+		{Name: "requests", Type: metrics.CounterType, Value: 30, Labels: map[string]string{"__resolution__": "5m", "__sum__": "60.000000", "__count__": "3", "__min__": "10.000000", "__max__": "30.000000", "__first__": "10.000000", "__last__": "30.000000", "__increase__": "20.000000"}, Timestamp: now},
+		{Name: "cpu", Type: metrics.GaugeType, Value: 2, Labels: map[string]string{"_team": "infra"}, Timestamp: now.Add(10 * time.Minute)},
+	}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := store.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(metaKey(formatKeyName), []byte("2"))
+	}); err != nil {
+		t.Fatalf("Failed to set format marker: %v", err)
+	}
+	store.Close()
+
+	store, err = New(Config{Path: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer func() { store.Close() }() // Reopened below
+
+	results, err := store.Query(ctx, storage.QueryRequest{Start: now.Add(-time.Minute), End: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 samples after migration, got %d", len(results))
+	}
+	aggregates := map[string]*storage.Aggregate{}
+	for _, m := range results {
+		if m.Labels["__resolution__"] == "" {
+			continue
+		}
+		agg := storage.ParseAggregate(m)
+		if agg == nil {
+			t.Fatalf("Migrated aggregate %+v doesn't parse", m)
+		}
+		if len(m.Labels) != len(agg.Labels)+1 {
+			t.Errorf("Expected only __resolution__ besides user labels, got %v", m.Labels)
+		}
+		aggregates[m.Name+"@"+m.Timestamp.Sub(now).String()] = agg
+	}
+	if agg := aggregates["cpu@0s"]; agg == nil || agg.Sum != 3 || agg.Count != 2 || agg.Min != 1 || agg.Max != 2 || agg.Labels["_team"] != "infra" {
+		t.Errorf("Expected cpu aggregate {3 2 1 2} keeping label _team, got %+v", agg)
+	}
+	if agg := aggregates["requests@0s"]; agg == nil || agg.First != 10 || agg.Last != 30 || agg.Increase != 20 {
+		t.Errorf("Expected counter aggregate {10 30 20}, got %+v", agg)
+	}
+
+	// Both cpu buckets are now one series
+	if _, ok := store.registry.lookup(seriesKeyString("cpu", map[string]string{"__resolution__": "5m", "_team": "infra"})); !ok {
+		t.Error("Expected the cpu aggregates registered as one series")
+	}
+	report, err := store.Check(ctx, storage.CheckOptions{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.Healthy() || report.KeysScanned != 4 {
+		t.Errorf("Expected 4 healthy keys after migration, got %+v", report)
+	}
+
+	// The per-bucket legacy series are unregistered, in memory and on disk
+	store.registry.mu.RLock()
+	registered, next := len(store.registry.ids), store.registry.next
+	store.registry.mu.RUnlock()
+	var persisted map[string]uint64
+	store.db.View(func(txn *badger.Txn) error {
+		persisted, err = readRegistry(txn)
+		return err
+	})
+	if registered != 3 || len(persisted) != 3 {
+		t.Errorf("Expected 3 registered series (raw cpu and two aggregates), got %d in memory and %v on disk", registered, persisted)
+	}
+
+	// Their IDs are not reused after a reopen
+	store.Close()
+	store, err = New(Config{Path: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	store.Write(ctx, []metrics.Metric{{Name: "mem", Value: 1, Timestamp: now}})
+	if id, _ := store.registry.lookup(seriesKeyString("mem", nil)); id != next {
+		t.Errorf("Expected the next series to get ID %d, got %d", next, id)
+	}
+}
+
+// legacySeriesKeyString is the unquoted series key of formats 1 to 3
+func legacySeriesKeyString(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	key := name
+	for _, k := range keys {
+		key += "," + k + "=" + labels[k]
+	}
+	return key
+}
+
+func TestBadgerStorage_MigrateUnquotedSeriesKeys(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	now := time.Now()
+
+	// A format 3 database: unquoted registry keys, under which these two
+	// label sets are one series
+	store, err := New(Config{Path: dir})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	one := map[string]string{"a": "1,b=2"}
+	two := map[string]string{"a": "1", "b": "2"}
+	if err := store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Labels: one, Timestamp: now},
+		{Name: "cpu", Value: 2, Labels: two, Timestamp: now.Add(time.Second)},
+		{Name: "mem", Value: 3, Timestamp: now},
+	}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := store.db.Update(func(txn *badger.Txn) error {
+		ids, err := readRegistry(txn)
+		if err != nil {
+			return err
+		}
+		for seriesKey, id := range ids {
+			name, labels, _ := parseSeriesKey(seriesKey)
+			if err := txn.Delete(metaKey(seriesRegistryPrefix + seriesKey)); err != nil {
+				return err
+			}
+			if err := txn.Set(metaKey(seriesRegistryPrefix+legacySeriesKeyString(name, labels)), binary.AppendUvarint(nil, id)); err != nil {
+				return err
+			}
+		}
+		return txn.Set(metaKey(formatKeyName), []byte("3"))
+	}); err != nil {
+		t.Fatalf("Failed to write format 3 registry: %v", err)
+	}
+	store.Close()
+
+	store, err = New(Config{Path: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+
+	idOne, _ := store.registry.lookup(seriesKeyString("cpu", one))
+	idTwo, _ := store.registry.lookup(seriesKeyString("cpu", two))
+	if idOne == 0 || idTwo == 0 || idOne == idTwo {
+		t.Errorf("Expected distinct series IDs after migration, got %d and %d", idOne, idTwo)
+	}
+	var persisted map[string]uint64
+	store.db.View(func(txn *badger.Txn) error {
+		persisted, err = readRegistry(txn)
+		return err
+	})
+	for seriesKey := range persisted {
+		if _, _, ok := parseSeriesKey(seriesKey); !ok {
+			t.Errorf("Unquoted series key %q still registered", seriesKey)
+		}
+	}
+	if len(persisted) != 3 {
+		t.Errorf("Expected 3 registered series, got %v", persisted)
+	}
+
+	results, err := store.Query(ctx, storage.QueryRequest{Start: now.Add(-time.Minute), End: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 3 {
+		t.Errorf("Expected 3 migrated samples, got %+v", results)
+	}
+	report, err := store.Check(ctx, storage.CheckOptions{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.Healthy() || report.KeysScanned != 3 {
+		t.Errorf("Expected 3 healthy keys after migration, got %+v", report)
+	}
+}
@@ -0,0 +1,99 @@
+package badger
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// maxPendingRestoreWrites bounds memory used while loading a snapshot
+const maxPendingRestoreWrites = 256
+
+// ctxWriter fails writes once the context is cancelled, which is how a
+// running Badger stream gets stopped (Stream.Backup takes no context)
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (cw ctxWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cw.w.Write(p)
+}
+
+// Snapshot streams a consistent backup of the database to w.
+// The backup is read at a single Badger read timestamp, so ingestion
+// continues while it runs and writes made after it started are excluded.
+// With since > 0 only keys written or deleted at version >= since are
+// included (incremental backup).
+func (s *Storage) Snapshot(ctx context.Context, w io.Writer, since uint64) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	stream := s.db.NewStream()
+	stream.LogPrefix = "Snapshot"
+	// The iterator's SinceTs is exclusive while Backup's since is inclusive
+	// (DB.Backup passes since to both, skipping version since itself)
+	if since > 0 {
+		stream.SinceTs = since - 1
+	}
+
+	version, err := stream.Backup(ctxWriter{ctx: ctx, w: w}, since)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, fmt.Errorf("snapshot cancelled: %w", ctx.Err())
+		}
+		return 0, fmt.Errorf("snapshot failed: %w", err)
+	}
+
+	// Nothing changed since the previous snapshot: it keeps that version
+	if version == 0 && since > 0 {
+		version = since - 1
+	}
+	return version, nil
+}
+
+// Restore loads a snapshot into the database, then reloads tombstones and the
+// series registry, migrates keys of older snapshots and rebuilds stats.
+// CRITICAL: Must not run concurrently with writes (Badger requirement).
+func (s *Storage) Restore(ctx context.Context, r io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.db.Load(r, maxPendingRestoreWrites); err != nil {
+		return fmt.Errorf("failed to load snapshot: %w", err)
+	}
+
+	s.mu.Lock()
+	s.tombstones = nil
+	err := s.loadTombstones()
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to reload tombstones: %w", err)
+	}
+
+	if err := s.loadRegistry(); err != nil {
+		return fmt.Errorf("failed to reload series registry: %w", err)
+	}
+	// The snapshot may predate series IDs: forget the format marker (restored
+	// or not) so every key is re-checked
+	if err := s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(metaKey(formatKeyName))
+	}); err != nil {
+		return fmt.Errorf("failed to reset key format: %w", err)
+	}
+	if err := s.migrateKeys(ctx); err != nil {
+		return fmt.Errorf("failed to migrate restored keys: %w", err)
+	}
+
+	// Restored data bypassed Write, so the incremental counters are stale
+	if _, err := s.RepairStats(ctx); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// statsKeyName is the metadata key holding the persisted series statistics
+const statsKeyName = "stats"
+
+// seriesStats tracks one series (metric name + labels) for Stats
+type seriesStats struct {
+	ID     uint64 `json:"id"` // Series ID (see seriesRegistry)
+	Name   string `json:"n"`
+	Count  uint64 `json:"c"`
+	Oldest int64  `json:"o"` // Unix nanos
+	Newest int64  `json:"x"` // Unix nanos
+}
+
+// persistedStats is the on-disk form of the series statistics.
+// Clean is only true between Close and the next open: finding Clean=false
+// on open means the process crashed and the counters can't be trusted.
+type persistedStats struct {
+	Clean  bool          `json:"clean"`
+	Series []seriesStats `json:"series"`
+}
+
+// statsDelta accumulates the stat changes of one write or delete
+type statsDelta map[uint64]*seriesStats
+
+// add records a sample of a series (or a removal if count is negative)
+func (d statsDelta) add(id uint64, name string, ts int64) {
+	st, ok := d[id]
+	if !ok {
+		d[id] = &seriesStats{ID: id, Name: name, Count: 1, Oldest: ts, Newest: ts}
+		return
+	}
+	st.Count++
+	if ts < st.Oldest {
+		st.Oldest = ts
+	}
+	if ts > st.Newest {
+		st.Newest = ts
+	}
+}
+
+// applyWrite merges newly written samples into the series stats.
+// Caller must hold statsMu.
+func (s *Storage) applyWrite(delta statsDelta) {
+	for id, d := range delta {
+		st, ok := s.series[id]
+		if !ok {
+			cp := *d
+			s.series[id] = &cp
+			continue
+		}
+		st.Count += d.Count
+		if d.Oldest < st.Oldest {
+			st.Oldest = d.Oldest
+		}
+		if d.Newest > st.Newest {
+			st.Newest = d.Newest
+		}
+	}
+}
+
+// applyDelete removes deleted samples from the series stats. Series whose
+// oldest or newest sample was deleted get their bounds re-read from disk
+// (a single seek each, since keys are sorted by timestamp within a series).
+// Caller must hold statsMu.
+func (s *Storage) applyDelete(delta statsDelta) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		for id, d := range delta {
+			st, ok := s.series[id]
+			if !ok {
+				continue
+			}
+			if d.Count >= st.Count {
+				delete(s.series, id)
+				continue
+			}
+			st.Count -= d.Count
+
+			if d.Oldest <= st.Oldest || d.Newest >= st.Newest {
+				oldest, newest, found := seriesBounds(txn, st.Name, id)
+				if !found {
+					delete(s.series, id)
+					continue
+				}
+				st.Oldest, st.Newest = oldest, newest
+			}
+		}
+		return nil
+	})
+}
+
+// seriesBounds returns the first and last sample timestamps of a series
+func seriesBounds(txn *badger.Txn, name string, id uint64) (oldest, newest int64, found bool) {
+	prefix := seriesPrefix(name, id)
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+
+	it := txn.NewIterator(opts)
+	it.Rewind()
+	if !it.ValidForPrefix(prefix) {
+		it.Close()
+		return 0, 0, false
+	}
+	_, ts := parseKey(it.Item().Key())
+	it.Close()
+	oldest = ts.UnixNano()
+
+	// Reverse iteration starts at the last key <= seek key, so seek past
+	// every possible timestamp of the series
+	opts.Reverse = true
+	rit := txn.NewIterator(opts)
+	defer rit.Close()
+	end := append(append([]byte{}, prefix...), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	rit.Seek(end)
+	if !rit.ValidForPrefix(prefix) {
+		return oldest, oldest, true
+	}
+	_, ts = parseKey(rit.Item().Key())
+	return oldest, ts.UnixNano(), true
+}
+
+// seriesPrefix returns the key prefix shared by every sample of one series
+// Format: [name_length (2 bytes)][metric_name][series_id (uvarint)]
+func seriesPrefix(name string, id uint64) []byte {
+	return binary.AppendUvarint(metricPrefix(name), id)
+}
+
+// Stats returns storage statistics from the incrementally maintained
+// counters. Unlike a full scan this is O(series), so dashboards can poll it.
+func (s *Storage) Stats(ctx context.Context) (*storage.Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.statsMu.Lock()
+	stats := &storage.Stats{
+		TotalSeries: uint64(len(s.series)),
+		Metrics:     make(map[string]storage.MetricStats),
+	}
+	var oldest, newest int64
+	for _, st := range s.series {
+		stats.TotalMetrics += st.Count
+		ms := storage.MetricStats{
+			Series:  1,
+			Samples: st.Count,
+			Oldest:  time.Unix(0, st.Oldest),
+			Newest:  time.Unix(0, st.Newest),
+		}
+		stats.AddSeries(st.Name, s.registry.resolution(st.ID), ms)
+		if oldest == 0 || st.Oldest < oldest {
+			oldest = st.Oldest
+		}
+		if newest == 0 || st.Newest > newest {
+			newest = st.Newest
+		}
+	}
+	s.statsMu.Unlock()
+
+	if stats.TotalMetrics > 0 {
+		stats.OldestMetric = time.Unix(0, oldest)
+		stats.NewestMetric = time.Unix(0, newest)
+	}
+
+	// Get DB size from LSM
+	lsmSize, vlogSize := s.db.Size()
+	stats.SizeBytes = uint64(lsmSize + vlogSize)
+
+	return stats, nil
+}
+
+// RepairStats recomputes the statistics from a full scan and persists them.
+// Run it (via `tinyobs repair-stats`) if the counters ever look wrong.
+// Writes and deletes are blocked while the scan runs.
+func (s *Storage) RepairStats(ctx context.Context) (*storage.Stats, error) {
+	s.statsMu.Lock()
+	series, err := s.scanSeriesStats(ctx)
+	if err == nil {
+		s.series = series
+		err = s.persistStats(false)
+	}
+	s.statsMu.Unlock()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to repair stats: %w", err)
+	}
+	return s.Stats(ctx)
+}
+
+// scanSeriesStats walks every sample key and rebuilds the series stats
+func (s *Storage) scanSeriesStats(ctx context.Context) (map[uint64]*seriesStats, error) {
+	series := make(map[uint64]*seriesStats)
+	delta := statsDelta(series)
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		var iterCount int
+		for it.Rewind(); it.Valid(); it.Next() {
+			iterCount++
+
+			// Check context periodically (every 1000 iterations)
+			if iterCount%1000 == 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+				}
+			}
+
+			key := it.Item().Key()
+			if isMetaKey(key) {
+				continue // Internal metadata, not a sample
+			}
+			name, id, ts, ok := splitKey(key)
+			if !ok {
+				continue // Malformed key
+			}
+			delta.add(id, name, ts)
+		}
+		return nil
+	})
+	return series, err
+}
+
+// loadStats restores persisted stats on open. Missing stats (a database
+// written before stats were persisted) or stats from a process that didn't
+// shut down cleanly are rebuilt with a full scan.
+func (s *Storage) loadStats() error {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	var persisted persistedStats
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(metaKey(statsKeyName))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &persisted)
+		})
+	})
+
+	switch {
+	case err == nil && persisted.Clean:
+		s.series = make(map[uint64]*seriesStats, len(persisted.Series))
+		for i := range persisted.Series {
+			st := persisted.Series[i]
+			s.series[st.ID] = &st
+		}
+	case err == nil || errors.Is(err, badger.ErrKeyNotFound):
+		series, err := s.scanSeriesStats(context.Background())
+		if err != nil {
+			return err
+		}
+		s.series = series
+	default:
+		return fmt.Errorf("failed to read stats: %w", err)
+	}
+
+	// Mark the stats as in use until Close persists them again
+	return s.persistStats(false)
+}
+
+// persistStats writes the series stats to their metadata key.
+// Caller must hold statsMu.
+func (s *Storage) persistStats(clean bool) error {
+	persisted := persistedStats{
+		Clean:  clean,
+		Series: make([]seriesStats, 0, len(s.series)),
+	}
+	for _, st := range s.series {
+		persisted.Series = append(persisted.Series, *st)
+	}
+
+	value, err := json.Marshal(persisted)
+	if err != nil {
+		return fmt.Errorf("failed to encode stats: %w", err)
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(metaKey(statsKeyName), value)
+	})
+}
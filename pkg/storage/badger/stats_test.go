@@ -0,0 +1,178 @@
+package badger
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+func TestBadgerStorage_IncrementalStats(t *testing.T) {
+	store, err := New(Config{InMemory: true})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	batch := []metrics.Metric{
+		{Name: "cpu", Labels: map[string]string{"host": "a"}, Value: 1, Timestamp: base},
+		{Name: "cpu", Labels: map[string]string{"host": "a"}, Value: 2, Timestamp: base.Add(time.Minute)},
+		{Name: "cpu", Labels: map[string]string{"host": "b"}, Value: 3, Timestamp: base.Add(2 * time.Minute)},
+		{Name: "mem", Value: 4, Timestamp: base.Add(3 * time.Minute)},
+	}
+	if err := store.Write(ctx, batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Overwriting existing samples must not inflate the counters
+	if err := store.Write(ctx, batch[:2]); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	stats, err := store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TotalMetrics != 4 || stats.TotalSeries != 3 {
+		t.Errorf("Expected 4 samples in 3 series, got %d in %d", stats.TotalMetrics, stats.TotalSeries)
+	}
+	if stats.Metrics["cpu"].Samples != 3 || stats.Metrics["mem"].Samples != 1 {
+		t.Errorf("Unexpected per-metric counts: %v", stats.Metrics)
+	}
+	if !stats.OldestMetric.Equal(base) || !stats.NewestMetric.Equal(base.Add(3*time.Minute)) {
+		t.Errorf("Unexpected time range: %v - %v", stats.OldestMetric, stats.NewestMetric)
+	}
+
+	// Deleting the oldest sample moves the oldest timestamp forward
+	if err := store.Delete(ctx, storage.DeleteOptions{Before: base.Add(30 * time.Second)}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	// Deleting a whole series drops it from the series count
+	if err := store.Delete(ctx, storage.DeleteOptions{
+		Before:   base.Add(time.Hour),
+		Matchers: []storage.Matcher{{Type: storage.MatchEqual, Name: storage.MetricNameLabel, Value: "mem"}},
+	}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	stats, err = store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TotalMetrics != 2 || stats.TotalSeries != 2 {
+		t.Errorf("Expected 2 samples in 2 series after delete, got %d in %d", stats.TotalMetrics, stats.TotalSeries)
+	}
+	if _, ok := stats.Metrics["mem"]; ok {
+		t.Errorf("Expected mem to be gone from metric counts: %v", stats.Metrics)
+	}
+	if !stats.OldestMetric.Equal(base.Add(time.Minute)) || !stats.NewestMetric.Equal(base.Add(2*time.Minute)) {
+		t.Errorf("Unexpected time range after delete: %v - %v", stats.OldestMetric, stats.NewestMetric)
+	}
+}
+
+func TestBadgerStorage_StatsPersistence(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	now := time.Now()
+
+	store, err := New(Config{Path: dir})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	batch := make([]metrics.Metric, 100)
+	for i := range batch {
+		batch[i] = metrics.Metric{Name: "requests", Value: float64(i), Timestamp: now.Add(-time.Duration(i) * time.Second)}
+	}
+	if err := store.Write(ctx, batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	store, err = New(Config{Path: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+
+	stats, err := store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TotalMetrics != 100 || stats.TotalSeries != 1 {
+		t.Errorf("Expected 100 samples in 1 series after reopen, got %d in %d", stats.TotalMetrics, stats.TotalSeries)
+	}
+}
+
+func TestBadgerStorage_RepairStats(t *testing.T) {
+	store, err := New(Config{InMemory: true})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	if err := store.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Timestamp: now},
+		{Name: "mem", Value: 2, Timestamp: now},
+	}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Simulate drifted counters
+	store.statsMu.Lock()
+	store.series = make(map[uint64]*seriesStats)
+	store.statsMu.Unlock()
+
+	stats, err := store.RepairStats(ctx)
+	if err != nil {
+		t.Fatalf("RepairStats failed: %v", err)
+	}
+	if stats.TotalMetrics != 2 || stats.TotalSeries != 2 {
+		t.Errorf("Expected 2 samples in 2 series after repair, got %d in %d", stats.TotalMetrics, stats.TotalSeries)
+	}
+}
+
+func TestBadgerStorage_StatsConcurrentWrites(t *testing.T) {
+	store, err := New(Config{InMemory: true})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	base := time.Now()
+
+	// Every goroutine writes the same 50 samples
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batch := make([]metrics.Metric, 50)
+			for i := range batch {
+				batch[i] = metrics.Metric{Name: "cpu", Value: float64(i), Timestamp: base.Add(time.Duration(i) * time.Second)}
+			}
+			if err := store.Write(ctx, batch); err != nil {
+				t.Errorf("Write failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats, err := store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TotalMetrics != 50 {
+		t.Errorf("Expected 50 samples, got %d", stats.TotalMetrics)
+	}
+}
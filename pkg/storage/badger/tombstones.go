@@ -0,0 +1,164 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// metaPrefix marks internal metadata keys (tombstones, etc).
+// Data keys start with a 2-byte metric name length and names are capped
+// far below 0xFFFF, so this prefix never collides with a sample key.
+var metaPrefix = []byte{0xff, 0xff}
+
+// tombstonePrefix namespaces tombstone records within the metadata keyspace
+const tombstonePrefix = "tombstone/"
+
+// metaKey builds an internal metadata key: [0xff 0xff][name]
+func metaKey(name string) []byte {
+	key := make([]byte, 0, len(metaPrefix)+len(name))
+	key = append(key, metaPrefix...)
+	return append(key, name...)
+}
+
+// isMetaKey reports whether a key holds internal metadata rather than a sample
+func isMetaKey(key []byte) bool {
+	return bytes.HasPrefix(key, metaPrefix)
+}
+
+// DeleteSeries hides every sample in [start, end) of the series matching the
+// matchers. The tombstone is persisted, so data stays hidden across restarts
+// until CleanTombstones purges it.
+func (s *Storage) DeleteSeries(ctx context.Context, matchers []storage.Matcher, start, end time.Time) (*storage.Tombstone, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("at least one matcher is required")
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("invalid time range: end (%v) must be after start (%v)", end, start)
+	}
+
+	now := time.Now()
+	t := storage.Tombstone{
+		ID:        strconv.FormatInt(now.UnixNano(), 36),
+		Matchers:  matchers,
+		Start:     start,
+		End:       end,
+		CreatedAt: now,
+	}
+
+	value, err := json.Marshal(t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode tombstone: %w", err)
+	}
+
+	// Hold the lock across the write so IDs and the in-memory list stay in sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(metaKey(tombstonePrefix+t.ID), value)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to write tombstone: %w", err)
+	}
+
+	s.tombstones = append(s.tombstones, t)
+	return &t, nil
+}
+
+// Tombstones returns a copy of the tombstones that haven't been purged yet
+func (s *Storage) Tombstones() []storage.Tombstone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.tombstones) == 0 {
+		return nil
+	}
+	out := make([]storage.Tombstone, len(s.tombstones))
+	copy(out, s.tombstones)
+	return out
+}
+
+// CleanTombstones deletes tombstoned samples from disk, then drops the
+// tombstones themselves. A tombstone is only dropped after its data is gone,
+// so a failed run is simply retried on the next call.
+func (s *Storage) CleanTombstones(ctx context.Context) (int, error) {
+	cleaned := 0
+	for _, t := range s.Tombstones() {
+		if _, err := s.deleteMatching(ctx, t.DeleteOptions()); err != nil {
+			return cleaned, fmt.Errorf("failed to purge tombstone %s: %w", t.ID, err)
+		}
+
+		if err := s.db.Update(func(txn *badger.Txn) error {
+			return txn.Delete(metaKey(tombstonePrefix + t.ID))
+		}); err != nil {
+			return cleaned, fmt.Errorf("failed to remove tombstone %s: %w", t.ID, err)
+		}
+
+		s.removeTombstone(t.ID)
+		cleaned++
+	}
+	return cleaned, nil
+}
+
+// removeTombstone drops a tombstone from the in-memory list
+func (s *Storage) removeTombstone(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, t := range s.tombstones {
+		if t.ID == id {
+			s.tombstones = append(s.tombstones[:i], s.tombstones[i+1:]...)
+			return
+		}
+	}
+}
+
+// loadTombstones reads persisted tombstones into memory on startup
+func (s *Storage) loadTombstones() error {
+	prefix := metaKey(tombstonePrefix)
+
+	return s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.ValidForPrefix(prefix); it.Next() {
+			var t storage.Tombstone
+			if err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &t)
+			}); err != nil {
+				return fmt.Errorf("failed to decode tombstone: %w", err)
+			}
+
+			// Compile regex matchers once, before queries share them
+			for i := range t.Matchers {
+				if err := t.Matchers[i].Compile(); err != nil {
+					return fmt.Errorf("tombstone %s: %w", t.ID, err)
+				}
+			}
+			s.tombstones = append(s.tombstones, t)
+		}
+		return nil
+	})
+}
+
+// isTombstoned reports whether any tombstone hides the metric
+func isTombstoned(m metrics.Metric, tombstones []storage.Tombstone) bool {
+	for _, t := range tombstones {
+		if t.Covers(m) {
+			return true
+		}
+	}
+	return false
+}
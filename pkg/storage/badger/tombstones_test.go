@@ -0,0 +1,150 @@
+package badger
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+func TestBadgerStorage_DeleteWithMatchers(t *testing.T) {
+	store, err := New(Config{InMemory: true})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	testMetrics := []metrics.Metric{
+		{Name: "requests", Value: 1, Labels: map[string]string{"job": "api"}, Timestamp: now.Add(-2 * time.Hour)},
+		{Name: "requests", Value: 2, Labels: map[string]string{"job": "api"}, Timestamp: now.Add(-30 * time.Minute)},
+		{Name: "requests", Value: 3, Labels: map[string]string{"job": "worker"}, Timestamp: now.Add(-2 * time.Hour)},
+		{Name: "go_goroutines", Value: 4, Labels: map[string]string{"job": "api"}, Timestamp: now.Add(-2 * time.Hour)},
+	}
+	if err := store.Write(ctx, testMetrics); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Delete only job="api" samples of "requests" in [-3h, -1h)
+	err = store.Delete(ctx, storage.DeleteOptions{
+		From:   now.Add(-3 * time.Hour),
+		Before: now.Add(-1 * time.Hour),
+		Matchers: []storage.Matcher{
+			{Type: storage.MatchEqual, Name: storage.MetricNameLabel, Value: "requests"},
+			{Type: storage.MatchEqual, Name: "job", Value: "api"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	results, err := store.Query(ctx, storage.QueryRequest{
+		Start: now.Add(-4 * time.Hour),
+		End:   now.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 metrics after deletion, got %d", len(results))
+	}
+	for _, m := range results {
+		if m.Value == 1 {
+			t.Errorf("Expected requests{job=\"api\"} at -2h to be deleted")
+		}
+	}
+}
+
+func TestBadgerStorage_DeleteSeriesTombstones(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "badger-tombstone-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ctx := context.Background()
+	now := time.Now()
+
+	store, err := New(Config{Path: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	testMetrics := []metrics.Metric{
+		{Name: "logins", Value: 1, Labels: map[string]string{"email": "a@example.com"}, Timestamp: now.Add(-time.Minute)},
+		{Name: "logins", Value: 2, Labels: map[string]string{"email": "b@example.com"}, Timestamp: now.Add(-time.Minute)},
+		{Name: "logins", Value: 3, Labels: map[string]string{"region": "us"}, Timestamp: now.Add(-time.Minute)},
+	}
+	if err := store.Write(ctx, testMetrics); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Regex matcher: drop every series carrying an email label
+	emailMatcher, err := storage.NewMatcher(storage.MatchRegexp, "email", ".+")
+	if err != nil {
+		t.Fatalf("NewMatcher failed: %v", err)
+	}
+	tomb, err := store.DeleteSeries(ctx, []storage.Matcher{emailMatcher}, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("DeleteSeries failed: %v", err)
+	}
+	if tomb.ID == "" {
+		t.Error("Expected tombstone to have an ID")
+	}
+
+	query := storage.QueryRequest{Start: now.Add(-time.Hour), End: now.Add(time.Hour)}
+
+	// Data is hidden immediately, before any purge
+	results, err := store.Query(ctx, query)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Labels["region"] != "us" {
+		t.Fatalf("Expected only the region series to be visible, got %+v", results)
+	}
+
+	// Tombstones survive a restart
+	store.Close()
+	store, err = New(Config{Path: tmpDir})
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+
+	if got := len(store.Tombstones()); got != 1 {
+		t.Fatalf("Expected 1 persisted tombstone, got %d", got)
+	}
+	results, err = store.Query(ctx, query)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("Expected tombstoned data to stay hidden after restart, got %d results", len(results))
+	}
+
+	// Purge removes both the samples and the tombstone
+	cleaned, err := store.CleanTombstones(ctx)
+	if err != nil {
+		t.Fatalf("CleanTombstones failed: %v", err)
+	}
+	if cleaned != 1 {
+		t.Errorf("Expected 1 tombstone cleaned, got %d", cleaned)
+	}
+	if got := len(store.Tombstones()); got != 0 {
+		t.Errorf("Expected no tombstones after cleanup, got %d", got)
+	}
+
+	stats, err := store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TotalMetrics != 1 {
+		t.Errorf("Expected 1 sample on disk after purge, got %d", stats.TotalMetrics)
+	}
+}
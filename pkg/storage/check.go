@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Corruption categories reported by a consistency check
+const (
+	ProblemShortKey           = "short_key"           // Key too short for the sample key layout
+	ProblemUndecodable        = "undecodable_value"   // Value isn't a valid encoded metric
+	ProblemKeyMismatch        = "key_mismatch"        // Key name, series or timestamp disagrees with the value
+	ProblemMalformedAggregate = "malformed_aggregate" // Aggregate with missing statistics or an undecodable sketch
+	ProblemHashCollision      = "hash_collision"      // Two label sets registered under one series ID (and overwrite each other)
+)
+
+// RepairMode selects what a consistency check does with bad entries
+type RepairMode string
+
+const (
+	RepairNone       RepairMode = ""           // Report only
+	RepairQuarantine RepairMode = "quarantine" // Move bad entries out of the sample keyspace (kept for inspection)
+	RepairDelete     RepairMode = "delete"     // Delete bad entries
+)
+
+// CheckOptions configures a consistency check
+type CheckOptions struct {
+	Repair      RepairMode
+	MaxExamples int // Problems listed in the report (0 = DefaultCheckExamples)
+}
+
+// DefaultCheckExamples is how many problems a report lists by default
+const DefaultCheckExamples = 100
+
+// CheckReport summarizes a consistency check
+type CheckReport struct {
+	StartedAt   time.Time      `json:"started_at"`
+	Duration    string         `json:"duration"`
+	KeysScanned int            `json:"keys_scanned"`
+	Problems    map[string]int `json:"problems"` // Count per category
+	Examples    []CheckProblem `json:"examples,omitempty"`
+	Repair      RepairMode     `json:"repair,omitempty"`
+	Repaired    int            `json:"repaired"` // Entries quarantined or deleted
+}
+
+// CheckProblem describes one bad entry
+type CheckProblem struct {
+	Category string `json:"category"`
+	Key      string `json:"key"` // Hex-encoded
+	Detail   string `json:"detail"`
+}
+
+// Healthy reports whether the check found no problems
+func (r *CheckReport) Healthy() bool {
+	for _, n := range r.Problems {
+		if n > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Checker is implemented by backends that can verify their own consistency
+type Checker interface {
+	// Check scans every entry and reports corruption by category,
+	// optionally quarantining or deleting bad entries
+	Check(ctx context.Context, opts CheckOptions) (*CheckReport, error)
+}
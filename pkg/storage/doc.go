@@ -6,7 +6,8 @@ Package storage provides the pluggable storage abstraction for TinyObs metrics.
 TinyObs uses an interface-based design to support multiple storage backends:
   - memory: In-memory storage for testing and ephemeral workloads
   - badger: BadgerDB (LSM tree + Snappy compression) for persistent storage
-  - objectstore: (Future) S3/GCS for long-term archival
+  - ring: Bounded in-memory ring buffers of compressed chunks for edge devices
+  - objectstore: Hot backend plus immutable cold blocks in a bucket for long-term archival
 
 All backends implement the Storage interface:
 
@@ -23,6 +24,7 @@ All backends implement the Storage interface:
 Different use cases need different backends:
 
   - Development: Memory backend (fast, no disk I/O)
+  - Edge devices: Ring backend (bounded memory, optional disk snapshots)
   - Production: BadgerDB (persistent, compressed, fast writes)
   - Testing: Memory backend (no cleanup, fast teardown)
   - Long-term: Object storage (cheap, infinite retention)
@@ -31,18 +33,23 @@ By abstracting storage, you can switch backends without changing application cod
 
 # Resolution Levels
 
-TinyObs stores metrics at three resolutions:
+By default TinyObs stores metrics at three resolutions:
 
   - Raw (ResolutionRaw): Original data points (retained 14 days)
   - 5-minute (Resolution5m): Aggregates every 5 minutes (retained 90 days)
   - 1-hour (Resolution1h): Aggregates every hour (retained 1 year)
 
+Compaction can be configured with other tiers; a resolution is its bucket
+length ("1m", "15m", "1d", see ParseResolution).
+
 Resolution is stored as a special label "__resolution__":
   - Raw metrics: no __resolution__ label
   - 5m aggregates: __resolution__="5m"
   - 1h aggregates: __resolution__="1h"
 
-This allows querying specific resolution levels using label filters.
+This allows querying specific resolution levels using label filters. The
+statistics of an aggregate (sum, count, min, max, ...) are not labels: they
+travel in Metric.Aggregate at full precision (see Aggregate.ToMetric).
 
 # Usage Example
 
@@ -125,6 +132,24 @@ Use DeleteOptions to implement retention policies:
 	    Resolution: nil,  // nil = all resolutions
 	})
 
+# Series Deletion
+
+DeleteOptions also accepts label matchers and a lower time bound, so you can
+remove a single misbehaving series instead of everything older than a cutoff:
+
+	store.Delete(ctx, storage.DeleteOptions{
+	    From:   start,
+	    Before: end,
+	    Matchers: []storage.Matcher{
+	        {Type: storage.MatchEqual, Name: storage.MetricNameLabel, Value: "http_requests_total"},
+	        {Type: storage.MatchEqual, Name: "job", Value: "batch"},
+	    },
+	})
+
+Backends that implement SeriesDeleter (badger) can instead record a tombstone
+with DeleteSeries. Tombstoned data disappears from queries right away and is
+purged from disk later by CleanTombstones.
+
 # Performance Characteristics
 
 Different backends have different performance profiles:
@@ -156,6 +181,7 @@ BadgerDB backend:
 
   - memory.New() for in-memory storage
   - badger.New() for persistent BadgerDB storage
+  - ring.New() for bounded in-memory storage
   - pkg/compaction for downsampling logic
 */
 package storage
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+)
+
+// Aggregation combines the samples of a series that fall into one step
+type Aggregation string
+
+const (
+	AggregateAvg  Aggregation = "avg" // Mean of the samples (default)
+	AggregateMin  Aggregation = "min"
+	AggregateMax  Aggregation = "max"
+	AggregateSum  Aggregation = "sum"
+	AggregateLast Aggregation = "last" // Sample with the latest timestamp
+)
+
+// ParseAggregation parses an aggregation name ("" = avg)
+func ParseAggregation(s string) (Aggregation, error) {
+	switch a := Aggregation(strings.ToLower(s)); a {
+	case "":
+		return AggregateAvg, nil
+	case AggregateAvg, AggregateMin, AggregateMax, AggregateSum, AggregateLast:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown aggregation %q (want avg, min, max, sum or last)", s)
+	}
+}
+
+// DownsampleQuerier is implemented by backends that aggregate samples into
+// steps while scanning, instead of returning every raw sample
+type DownsampleQuerier interface {
+	// QueryDownsampled is Query with req.Step and req.Aggregation applied
+	QueryDownsampled(ctx context.Context, req QueryRequest) ([]metrics.Metric, error)
+}
+
+// QueryDownsampled runs a query with its step and aggregation applied.
+// Backends implementing DownsampleQuerier aggregate while scanning; for the
+// rest the raw samples are fetched and aggregated here. Without a step it
+// is a plain Query.
+func QueryDownsampled(ctx context.Context, s Storage, req QueryRequest) ([]metrics.Metric, error) {
+	if req.Step <= 0 {
+		return s.Query(ctx, req)
+	}
+	if _, err := ParseAggregation(string(req.Aggregation)); err != nil {
+		return nil, err
+	}
+	if dq, ok := s.(DownsampleQuerier); ok {
+		return dq.QueryDownsampled(ctx, req)
+	}
+
+	raw := req
+	raw.Step, raw.Aggregation, raw.Limit = 0, "", 0 // Limit applies to the steps
+	results, err := s.Query(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDownsampler(req)
+	for _, m := range results {
+		d.Add(m)
+	}
+	return d.Result(), nil
+}
+
+// Downsampler aggregates samples into fixed steps aligned to req.Start,
+// per series. Samples may be added in any order. Backends that push
+// downsampling into their scan feed it directly; memory use is bounded by
+// the number of steps returned, not the number of samples.
+type Downsampler struct {
+	req    QueryRequest
+	agg    Aggregation
+	series map[string]*downsampledSeries
+}
+
+type downsampledSeries struct {
+	name   string
+	typ    metrics.MetricType
+	labels map[string]string
+	steps  map[int64]*step
+}
+
+// step accumulates the samples of one series in one step
+type step struct {
+	sum, min, max float64
+	count         int
+	last          float64
+	lastTs        int64
+}
+
+// NewDownsampler creates a downsampler for req.Step and req.Aggregation
+func NewDownsampler(req QueryRequest) *Downsampler {
+	agg, err := ParseAggregation(string(req.Aggregation))
+	if err != nil {
+		agg = AggregateAvg
+	}
+	return &Downsampler{req: req, agg: agg, series: make(map[string]*downsampledSeries)}
+}
+
+// Add folds a sample into its series' step. Samples outside the query's
+// time range are ignored.
+func (d *Downsampler) Add(m metrics.Metric) {
+	if m.Timestamp.Before(d.req.Start) || m.Timestamp.After(d.req.End) {
+		return
+	}
+
+	key := downsampleKey(m.Name, m.Labels)
+	sr, ok := d.series[key]
+	if !ok {
+		sr = &downsampledSeries{name: m.Name, labels: m.Labels, steps: make(map[int64]*step)}
+		d.series[key] = sr
+	}
+	sr.typ = m.Type
+
+	ts := m.Timestamp.UnixNano()
+	idx := (ts - d.req.Start.UnixNano()) / int64(d.req.Step)
+	st, ok := sr.steps[idx]
+	if !ok {
+		st = &step{min: math.Inf(1), max: math.Inf(-1)}
+		sr.steps[idx] = st
+	}
+	st.sum += m.Value
+	st.count++
+	st.min = math.Min(st.min, m.Value)
+	st.max = math.Max(st.max, m.Value)
+	if st.count == 1 || ts >= st.lastTs {
+		st.last, st.lastTs = m.Value, ts
+	}
+}
+
+// Result returns one sample per series and step, timestamped at the start
+// of the step, ordered by series then time and cut to req.Limit
+func (d *Downsampler) Result() []metrics.Metric {
+	keys := make([]string, 0, len(d.series))
+	for key := range d.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var results []metrics.Metric
+	for _, key := range keys {
+		sr := d.series[key]
+		idxs := make([]int64, 0, len(sr.steps))
+		for idx := range sr.steps {
+			idxs = append(idxs, idx)
+		}
+		sort.Slice(idxs, func(i, j int) bool { return idxs[i] < idxs[j] })
+
+		for _, idx := range idxs {
+			if d.req.Limit > 0 && len(results) >= d.req.Limit {
+				return results
+			}
+			results = append(results, metrics.Metric{
+				Name:      sr.name,
+				Type:      sr.typ,
+				Value:     sr.steps[idx].value(d.agg),
+				Labels:    sr.labels,
+				Timestamp: d.req.Start.Add(time.Duration(idx) * d.req.Step),
+			})
+		}
+	}
+	return results
+}
+
+func (st *step) value(agg Aggregation) float64 {
+	switch agg {
+	case AggregateMin:
+		return st.min
+	case AggregateMax:
+		return st.max
+	case AggregateSum:
+		return st.sum
+	case AggregateLast:
+		return st.last
+	default:
+		return st.sum / float64(st.count)
+	}
+}
+
+// downsampleKey identifies a series (metric name + sorted labels)
+func downsampleKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range keys {
+		b.WriteString("," + k + "=" + labels[k])
+	}
+	return b.String()
+}
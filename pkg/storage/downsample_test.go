@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+)
+
+func TestDownsampler_AggregateSamples(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	d := NewDownsampler(QueryRequest{Start: start, End: start.Add(time.Hour), Step: time.Hour, Aggregation: AggregateMax})
+
+	// Compacted aggregates of a series share its labels: they are one series
+	for i, v := range []float64{3, 7, 5} {
+		d.Add(metrics.Metric{
+			Name:      "cpu",
+			Value:     v,
+			Labels:    map[string]string{"host": "a", "__resolution__": "5m"},
+			Timestamp: start.Add(time.Duration(i) * 5 * time.Minute),
+			Aggregate: &metrics.AggregateStats{Sum: v, Count: 1, Min: v, Max: v},
+		})
+	}
+	d.Add(metrics.Metric{Name: "cpu", Value: 100, Timestamp: start.Add(-time.Second)}) // Out of range
+
+	results := d.Result()
+	if len(results) != 1 || results[0].Value != 7 || !results[0].Timestamp.Equal(start) {
+		t.Fatalf("Expected one step with max 7, got %+v", results)
+	}
+	if results[0].Labels["__resolution__"] != "5m" || results[0].Aggregate != nil {
+		t.Errorf("Expected resolution kept and statistics dropped, got %v, %+v", results[0].Labels, results[0].Aggregate)
+	}
+}
+
+func TestParseAggregation(t *testing.T) {
+	for in, want := range map[string]Aggregation{"": AggregateAvg, "avg": AggregateAvg, "MAX": AggregateMax, "last": AggregateLast} {
+		got, err := ParseAggregation(in)
+		if err != nil || got != want {
+			t.Errorf("ParseAggregation(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseAggregation("p99"); err == nil {
+		t.Error("Expected an error for an unknown aggregation")
+	}
+}
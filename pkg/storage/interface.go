@@ -2,12 +2,16 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"io"
 	"time"
 
 	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
 )
 
-// Resolution represents the time granularity of aggregated data
+// Resolution represents the time granularity of aggregated data. It is
+// the bucket length as a duration ("5m", "1h", "1d"); compaction can be
+// configured with any ladder of them (see ParseResolution).
 type Resolution string
 
 const (
@@ -17,7 +21,7 @@ const (
 )
 
 // Storage defines the interface for metric storage backends.
-// Implementations: memory (testing), badger (production), objectstore (long-term)
+// Implementations: memory (testing), badger (production), objectstore (tiered long-term)
 type Storage interface {
 	// Write stores metrics
 	Write(ctx context.Context, metrics []metrics.Metric) error
@@ -35,6 +39,83 @@ type Storage interface {
 	Stats(ctx context.Context) (*Stats, error)
 }
 
+// DuplicatePolicy decides what a write does with a sample whose series and
+// timestamp are already stored
+type DuplicatePolicy int
+
+const (
+	// LastWriteWins replaces the stored sample (what Write does)
+	LastWriteWins DuplicatePolicy = iota
+	// FirstWriteWins keeps the stored sample and drops the new one
+	FirstWriteWins
+)
+
+// WriteResult reports how a write treated samples that were already stored
+type WriteResult struct {
+	Added       int // Samples for a new (series, timestamp)
+	Overwritten int // Replaced a stored sample (LastWriteWins)
+	Dropped     int // Kept the stored sample instead (FirstWriteWins)
+}
+
+// PolicyWriter is implemented by backends that detect duplicate samples on write
+type PolicyWriter interface {
+	// WriteWithPolicy stores metrics, resolving duplicates (within the batch
+	// or against stored data) with the given policy
+	WriteWithPolicy(ctx context.Context, metrics []metrics.Metric, policy DuplicatePolicy) (WriteResult, error)
+}
+
+// CountingDeleter is implemented by backends that report how many samples
+// a deletion removed
+type CountingDeleter interface {
+	// DeleteCounted is Delete, returning the number of samples removed
+	DeleteCounted(ctx context.Context, opts DeleteOptions) (int, error)
+}
+
+// DeleteCounted deletes the metrics matching opts and returns how many
+// samples were removed. Backends that don't implement CountingDeleter report
+// the drop in Stats.TotalMetrics, which undercounts while samples are being
+// written concurrently.
+func DeleteCounted(ctx context.Context, s Storage, opts DeleteOptions) (int, error) {
+	if cd, ok := s.(CountingDeleter); ok {
+		return cd.DeleteCounted(ctx, opts)
+	}
+
+	before, err := s.Stats(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.Delete(ctx, opts); err != nil {
+		return 0, err
+	}
+	after, err := s.Stats(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if after.TotalMetrics >= before.TotalMetrics {
+		return 0, nil
+	}
+	return int(before.TotalMetrics - after.TotalMetrics), nil
+}
+
+// SpaceReclaimer is implemented by backends that keep deleted data on disk
+// until a garbage collection pass (e.g. BadgerDB's LSM tree and value log)
+type SpaceReclaimer interface {
+	// ReclaimSpace compacts storage so deleted data is released to the filesystem
+	ReclaimSpace(ctx context.Context) error
+}
+
+// Snapshotter is implemented by backends that support online backups
+type Snapshotter interface {
+	// Snapshot writes a consistent backup of everything written since
+	// version since (0 = full backup) while writes continue. Returns the
+	// snapshot's version; pass version+1 as since for the next incremental.
+	Snapshot(ctx context.Context, w io.Writer, since uint64) (uint64, error)
+
+	// Restore loads a backup written by Snapshot. Incremental backups are
+	// restored in order on top of the full backup they are based on.
+	Restore(ctx context.Context, r io.Reader) error
+}
+
 // QueryRequest specifies what metrics to retrieve
 type QueryRequest struct {
 	// Time range
@@ -49,6 +130,13 @@ type QueryRequest struct {
 
 	// Limit number of results (0 = no limit)
 	Limit int
+
+	// Step aggregates each series into one sample per step, aligned to
+	// Start (0 = raw samples). Applied by QueryDownsampled.
+	Step time.Duration
+
+	// Aggregation combines the samples within a step ("" = avg)
+	Aggregation Aggregation
 }
 
 // DeleteOptions specifies which metrics to delete
@@ -62,6 +150,87 @@ type DeleteOptions struct {
 	// &Resolution5m = delete only 5m aggregates
 	// &Resolution1h = delete only 1h aggregates
 	Resolution *Resolution
+
+	// Delete only metrics with timestamps at or after this time (optional)
+	// Zero = no lower bound
+	From time.Time
+
+	// Delete only series matching all of these matchers (optional)
+	// An equality matcher on __name__ lets backends avoid a full scan
+	Matchers []Matcher
+
+	// Keep series matching any of these matcher sets (optional)
+	// Used by retention policies to protect series covered by other rules
+	Exclude [][]Matcher
+}
+
+// Matches reports whether a metric falls within the deletion criteria
+func (o DeleteOptions) Matches(m metrics.Metric) bool {
+	if !m.Timestamp.Before(o.Before) {
+		return false
+	}
+	if !o.From.IsZero() && m.Timestamp.Before(o.From) {
+		return false
+	}
+	if o.Resolution != nil {
+		resolution := "" // Default for raw metrics
+		if m.Labels != nil {
+			resolution = m.Labels["__resolution__"]
+		}
+		if resolution != string(*o.Resolution) {
+			return false
+		}
+	}
+	if !MatchesSeries(o.Matchers, m.Name, m.Labels) {
+		return false
+	}
+	for _, exclude := range o.Exclude {
+		if MatchesSeries(exclude, m.Name, m.Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tombstone marks series data as deleted without rewriting it.
+// Tombstoned data is hidden from queries immediately; the samples
+// themselves are purged later by CleanTombstones.
+type Tombstone struct {
+	ID        string    `json:"id"`
+	Matchers  []Matcher `json:"matchers"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeleteOptions returns the deletion criteria that purge this tombstone's data
+func (t Tombstone) DeleteOptions() DeleteOptions {
+	return DeleteOptions{
+		From:     t.Start,
+		Before:   t.End,
+		Matchers: t.Matchers,
+	}
+}
+
+// Covers reports whether a metric is hidden by this tombstone
+func (t Tombstone) Covers(m metrics.Metric) bool {
+	return t.DeleteOptions().Matches(m)
+}
+
+// SeriesDeleter is implemented by backends that support selector-based
+// series deletion (e.g. removing PII labels or a misbehaving job).
+// Callers type-assert for it: not every backend needs tombstones.
+type SeriesDeleter interface {
+	// DeleteSeries hides all samples in [start, end) of series matching
+	// the matchers and records a tombstone for background purging
+	DeleteSeries(ctx context.Context, matchers []Matcher, start, end time.Time) (*Tombstone, error)
+
+	// Tombstones lists tombstones that have not been purged yet
+	Tombstones() []Tombstone
+
+	// CleanTombstones purges tombstoned samples from disk and drops the
+	// tombstones. Returns the number of tombstones cleaned.
+	CleanTombstones(ctx context.Context) (int, error)
 }
 
 // Stats provides storage health and usage info
@@ -80,4 +249,70 @@ type Stats struct {
 
 	// Newest metric timestamp
 	NewestMetric time.Time
+
+	// Per metric name breakdown (set with AddSeries)
+	Metrics map[string]MetricStats
+
+	// Per resolution breakdown (set with AddSeries), raw samples under
+	// ResolutionRaw
+	Resolutions map[Resolution]MetricStats
+}
+
+// MarshalJSON renders the stats with MetricCounts, the samples stored per
+// metric name, derived from Metrics for clients of the older format
+func (s Stats) MarshalJSON() ([]byte, error) {
+	type plain Stats // Without this method
+	counts := make(map[string]uint64, len(s.Metrics))
+	for name, ms := range s.Metrics {
+		counts[name] = ms.Samples
+	}
+	return json.Marshal(struct {
+		plain
+		MetricCounts map[string]uint64
+	}{plain(s), counts})
+}
+
+// MetricStats summarizes the series stored under one metric name (or at
+// one resolution)
+type MetricStats struct {
+	Series  uint64
+	Samples uint64
+	Oldest  time.Time
+	Newest  time.Time
+
+	// Per resolution breakdown of a metric name's series
+	Resolutions map[Resolution]MetricStats
+}
+
+// AddSeries folds a summary of (some of) a metric name's series at one
+// resolution into Metrics and Resolutions
+func (s *Stats) AddSeries(name string, res Resolution, ms MetricStats) {
+	if s.Metrics == nil {
+		s.Metrics = make(map[string]MetricStats)
+	}
+	cur := s.Metrics[name].merge(ms)
+	if cur.Resolutions == nil {
+		cur.Resolutions = make(map[Resolution]MetricStats)
+	}
+	cur.Resolutions[res] = cur.Resolutions[res].merge(ms)
+	s.Metrics[name] = cur
+
+	if s.Resolutions == nil {
+		s.Resolutions = make(map[Resolution]MetricStats)
+	}
+	s.Resolutions[res] = s.Resolutions[res].merge(ms)
+}
+
+// merge returns the summary of the series of both ms and other (keeping
+// ms's per resolution breakdown)
+func (ms MetricStats) merge(other MetricStats) MetricStats {
+	ms.Series += other.Series
+	ms.Samples += other.Samples
+	if !other.Oldest.IsZero() && (ms.Oldest.IsZero() || other.Oldest.Before(ms.Oldest)) {
+		ms.Oldest = other.Oldest
+	}
+	if other.Newest.After(ms.Newest) {
+		ms.Newest = other.Newest
+	}
+	return ms
 }
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStats_MarshalJSON(t *testing.T) {
+	b := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	stats := Stats{TotalMetrics: 3, TotalSeries: 2}
+	stats.AddSeries("cpu", ResolutionRaw, MetricStats{Series: 1, Samples: 2, Oldest: b, Newest: b.Add(time.Minute)})
+	stats.AddSeries("cpu", Resolution5m, MetricStats{Series: 1, Samples: 1, Oldest: b, Newest: b})
+
+	data, err := json.Marshal(&stats)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var got struct {
+		TotalMetrics uint64
+		MetricCounts map[string]uint64
+		Metrics      map[string]MetricStats
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	// MetricCounts is derived from Metrics for older clients
+	if got.TotalMetrics != 3 || len(got.MetricCounts) != 1 || got.MetricCounts["cpu"] != 3 {
+		t.Errorf("Rendered %s, want MetricCounts {cpu: 3}", data)
+	}
+	if cpu := got.Metrics["cpu"]; cpu.Samples != 3 || cpu.Resolutions[Resolution5m].Samples != 1 {
+		t.Errorf("Rendered cpu stats %+v", cpu)
+	}
+}
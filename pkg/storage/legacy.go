@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+)
+
+// legacyAggregateLabels carried the statistics of compacted aggregates,
+// formatted with %f, before they moved to Metric.Aggregate. Every bucket
+// of a series was its own label set.
+var legacyAggregateLabels = []string{"__sum__", "__count__", "__min__", "__max__", "__first__", "__last__", "__increase__", "__sketch__"}
+
+// IsLegacyAggregate reports whether a stored metric is an aggregate in the
+// legacy label encoding, to be rewritten by UpgradeLegacyAggregate
+func IsLegacyAggregate(m metrics.Metric) bool {
+	_, isAggregate := m.Labels["__resolution__"]
+	_, hasSum := m.Labels["__sum__"]
+	return isAggregate && hasSum && m.Aggregate == nil
+}
+
+// UpgradeLegacyAggregate converts an aggregate in the legacy label encoding
+// to Metric.Aggregate, keeping every other label. Precision the labels
+// already lost stays lost.
+func UpgradeLegacyAggregate(m metrics.Metric) (metrics.Metric, error) {
+	float := func(label string) (float64, error) {
+		v, err := strconv.ParseFloat(m.Labels[label], 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s=%q is not a number", label, m.Labels[label])
+		}
+		return v, nil
+	}
+
+	stats := &metrics.AggregateStats{}
+	var err error
+	if stats.Sum, err = float("__sum__"); err != nil {
+		return m, err
+	}
+	if stats.Count, err = strconv.ParseUint(m.Labels["__count__"], 10, 64); err != nil {
+		return m, fmt.Errorf("__count__=%q is not a count", m.Labels["__count__"])
+	}
+	if stats.Min, err = float("__min__"); err != nil {
+		return m, err
+	}
+	if stats.Max, err = float("__max__"); err != nil {
+		return m, err
+	}
+	if m.Type == metrics.CounterType {
+		if stats.First, err = float("__first__"); err != nil {
+			return m, err
+		}
+		if stats.Last, err = float("__last__"); err != nil {
+			return m, err
+		}
+		if stats.Increase, err = float("__increase__"); err != nil {
+			return m, err
+		}
+	}
+	if encoded, ok := m.Labels["__sketch__"]; ok {
+		sketch, err := base64.RawStdEncoding.DecodeString(encoded)
+		if err == nil {
+			_, err = DecodeSketch(sketch)
+		}
+		if err != nil {
+			return m, fmt.Errorf("__sketch__: %w", err)
+		}
+		stats.Sketch = sketch
+	}
+
+	labels := make(map[string]string, len(m.Labels))
+	for k, v := range m.Labels {
+		labels[k] = v
+	}
+	for _, k := range legacyAggregateLabels {
+		delete(labels, k)
+	}
+	m.Labels = labels
+	m.Aggregate = stats
+	return m, nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// MetricNameLabel is the pseudo-label matchers use to select on metric name
+const MetricNameLabel = "__name__"
+
+// MatchType is the comparison a Matcher applies to a label value
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="  // label == value
+	MatchNotEqual  MatchType = "!=" // label != value
+	MatchRegexp    MatchType = "=~" // label matches regex (fully anchored)
+	MatchNotRegexp MatchType = "!~" // label does not match regex (fully anchored)
+)
+
+// Matcher selects series by comparing one label against a value.
+// A missing label is treated as the empty string, same as Prometheus.
+type Matcher struct {
+	Type  MatchType `json:"type"`
+	Name  string    `json:"name"`
+	Value string    `json:"value"`
+
+	re *regexp.Regexp // set by Compile for regex matchers
+}
+
+// NewMatcher creates a matcher and validates regex values up front
+func NewMatcher(t MatchType, name, value string) (Matcher, error) {
+	m := Matcher{Type: t, Name: name, Value: value}
+	if err := m.Compile(); err != nil {
+		return Matcher{}, err
+	}
+	return m, nil
+}
+
+// Compile validates the match type and compiles regex values.
+// Call it on matchers decoded from JSON before sharing them between goroutines.
+func (m *Matcher) Compile() error {
+	switch m.Type {
+	case MatchEqual, MatchNotEqual:
+		return nil
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid regex %q for label %q: %w", m.Value, m.Name, err)
+		}
+		m.re = re
+		return nil
+	default:
+		return fmt.Errorf("unknown match type %q", m.Type)
+	}
+}
+
+// Matches reports whether a label value satisfies the matcher
+func (m Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp, MatchNotRegexp:
+		re := m.re
+		if re == nil {
+			// Not compiled (e.g. built as a literal) - compile without caching
+			var err error
+			if re, err = regexp.Compile("^(?:" + m.Value + ")$"); err != nil {
+				return false
+			}
+		}
+		return re.MatchString(value) == (m.Type == MatchRegexp)
+	default:
+		return false
+	}
+}
+
+// String formats the matcher in selector syntax, e.g. job=~"api.*"
+func (m Matcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
+
+// MatchesSeries reports whether a series satisfies every matcher.
+// The metric name is exposed to matchers as the __name__ label.
+func MatchesSeries(matchers []Matcher, name string, labels map[string]string) bool {
+	for _, m := range matchers {
+		value := labels[m.Name]
+		if m.Name == MetricNameLabel {
+			value = name
+		}
+		if !m.Matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// MetricNameFromMatchers returns the metric name if the matchers pin it with
+// an equality matcher on __name__. Backends use this to narrow scans.
+func MetricNameFromMatchers(matchers []Matcher) (string, bool) {
+	for _, m := range matchers {
+		if m.Name == MetricNameLabel && m.Type == MatchEqual {
+			return m.Value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,48 @@
+package storage
+
+import "testing"
+
+func TestMatchesSeries(t *testing.T) {
+	mustMatcher := func(mt MatchType, name, value string) Matcher {
+		m, err := NewMatcher(mt, name, value)
+		if err != nil {
+			t.Fatalf("NewMatcher(%s, %s, %s) failed: %v", mt, name, value, err)
+		}
+		return m
+	}
+
+	labels := map[string]string{"job": "api", "env": "prod"}
+
+	tests := []struct {
+		name     string
+		matchers []Matcher
+		expected bool
+	}{
+		{"no matchers", nil, true},
+		{"metric name", []Matcher{mustMatcher(MatchEqual, MetricNameLabel, "http_requests")}, true},
+		{"wrong name", []Matcher{mustMatcher(MatchEqual, MetricNameLabel, "cpu")}, false},
+		{"name regex", []Matcher{mustMatcher(MatchRegexp, MetricNameLabel, "http_.*")}, true},
+		{"regex is anchored", []Matcher{mustMatcher(MatchRegexp, "job", "ap")}, false},
+		{"not equal", []Matcher{mustMatcher(MatchNotEqual, "env", "dev")}, true},
+		{"not regex", []Matcher{mustMatcher(MatchNotRegexp, "env", "prod|staging")}, false},
+		{"missing label is empty", []Matcher{mustMatcher(MatchEqual, "region", "")}, true},
+		{"all must match", []Matcher{
+			mustMatcher(MatchEqual, "job", "api"),
+			mustMatcher(MatchEqual, "env", "dev"),
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchesSeries(tt.matchers, "http_requests", labels); got != tt.expected {
+				t.Errorf("MatchesSeries() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewMatcher_InvalidRegex(t *testing.T) {
+	if _, err := NewMatcher(MatchRegexp, "job", "("); err == nil {
+		t.Error("Expected error for invalid regex")
+	}
+}
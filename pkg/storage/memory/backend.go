@@ -0,0 +1,19 @@
+package memory
+
+import (
+	"github.com/nicktill/tinyobs/pkg/config"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// Params: max_metrics (default config.DefaultMaxMetrics)
+func init() {
+	storage.RegisterBackend("memory", func(opts storage.BackendOptions) (storage.Storage, error) {
+		maxMetrics, err := opts.Int("max_metrics", config.DefaultMaxMetrics)
+		if err != nil {
+			return nil, err
+		}
+		s := New()
+		s.MaxMetrics = maxMetrics
+		return s, nil
+	})
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/nicktill/tinyobs/pkg/config"
 	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
@@ -21,6 +23,7 @@ var (
 // SAFETY: Bounded by MaxMetrics to prevent unbounded memory growth
 type Storage struct {
 	metrics    []metrics.Metric
+	index      map[string]int // sampleKey -> position in metrics (samples are unique per series and timestamp)
 	mu         sync.RWMutex
 	MaxMetrics int // Maximum number of metrics to store (0 = use default)
 }
@@ -29,13 +32,19 @@ type Storage struct {
 func New() *Storage {
 	return &Storage{
 		metrics:    make([]metrics.Metric, 0, 10000),
+		index:      make(map[string]int),
 		MaxMetrics: config.DefaultMaxMetrics,
 	}
 }
 
-// Write stores metrics in memory
+// Write stores metrics in memory. A sample whose series and timestamp are
+// already stored replaces it, like in the other backends.
 // Returns error if adding metrics would exceed MaxMetrics limit
 func (s *Storage) Write(ctx context.Context, metrics []metrics.Metric) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -44,21 +53,42 @@ func (s *Storage) Write(ctx context.Context, metrics []metrics.Metric) error {
 		maxMetrics = config.DefaultMaxMetrics
 	}
 
+	// Only new samples count towards the limit (overwrites don't)
+	keys := make([]string, len(metrics))
+	added := make(map[string]bool)
+	for i, m := range metrics {
+		keys[i] = sampleKey(m)
+		if _, ok := s.index[keys[i]]; !ok {
+			added[keys[i]] = true
+		}
+	}
+
 	// Check if adding these metrics would exceed limit
-	newTotal := len(s.metrics) + len(metrics)
+	newTotal := len(s.metrics) + len(added)
 	if newTotal > maxMetrics {
 		return fmt.Errorf("cannot write %d metrics: would exceed limit (%d + %d > %d). "+
 			"Current: %d metrics (~%.1f MB). Consider using Delete() to remove old data or increase MaxMetrics",
-			len(metrics), len(s.metrics), len(metrics), maxMetrics,
+			len(metrics), len(s.metrics), len(added), maxMetrics,
 			len(s.metrics), float64(len(s.metrics))*100/1024/1024)
 	}
 
-	s.metrics = append(s.metrics, metrics...)
+	for i, m := range metrics {
+		if pos, ok := s.index[keys[i]]; ok {
+			s.metrics[pos] = m
+			continue
+		}
+		s.index[keys[i]] = len(s.metrics)
+		s.metrics = append(s.metrics, m)
+	}
 	return nil
 }
 
 // Query retrieves metrics matching the request
 func (s *Storage) Query(ctx context.Context, req storage.QueryRequest) ([]metrics.Metric, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -111,38 +141,84 @@ func (s *Storage) Query(ctx context.Context, req storage.QueryRequest) ([]metric
 
 // Delete removes metrics matching the deletion criteria
 func (s *Storage) Delete(ctx context.Context, opts storage.DeleteOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Filter out metrics that should be deleted
+	s.deleteLocked(opts)
+	return nil
+}
+
+// DeleteCounted removes metrics matching the deletion criteria and returns
+// how many were removed
+func (s *Storage) DeleteCounted(ctx context.Context, opts storage.DeleteOptions) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.deleteLocked(opts), nil
+}
+
+// deleteLocked filters out metrics matching the deletion criteria and
+// returns how many were removed
+// MUST be called with lock held
+func (s *Storage) deleteLocked(opts storage.DeleteOptions) int {
 	filtered := make([]metrics.Metric, 0, len(s.metrics))
+	index := make(map[string]int, len(s.metrics))
 	for _, m := range s.metrics {
-		// Keep if timestamp is after cutoff
-		if !m.Timestamp.Before(opts.Before) {
+		if !opts.Matches(m) {
+			index[sampleKey(m)] = len(filtered)
 			filtered = append(filtered, m)
-			continue
 		}
+	}
+	deleted := len(s.metrics) - len(filtered)
+	s.metrics = filtered
+	s.index = index
+	return deleted
+}
 
-		// If resolution filter is specified, only delete matching resolution
-		if opts.Resolution != nil {
-			resolution := "" // Default for raw metrics
-			if m.Labels != nil {
-				resolution = m.Labels["__resolution__"]
-			}
+// DeleteSeries removes samples of matching series in [start, end).
+// Memory storage deletes immediately, so the returned tombstone is never
+// persisted and there is nothing left for CleanTombstones to purge.
+func (s *Storage) DeleteSeries(ctx context.Context, matchers []storage.Matcher, start, end time.Time) (*storage.Tombstone, error) {
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("at least one matcher is required")
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("invalid time range: end (%v) must be after start (%v)", end, start)
+	}
 
-			// Keep if resolution doesn't match filter
-			if resolution != string(*opts.Resolution) {
-				filtered = append(filtered, m)
-			}
-			// Otherwise delete (don't append)
-		}
-		// If no resolution filter, delete all old metrics (don't append)
+	t := storage.Tombstone{
+		ID:        strconv.FormatInt(time.Now().UnixNano(), 36),
+		Matchers:  matchers,
+		Start:     start,
+		End:       end,
+		CreatedAt: time.Now(),
 	}
 
-	s.metrics = filtered
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteLocked(t.DeleteOptions())
+
+	return &t, nil
+}
+
+// Tombstones always returns nil: memory storage deletes immediately
+func (s *Storage) Tombstones() []storage.Tombstone {
 	return nil
 }
 
+// CleanTombstones is a no-op for memory storage
+func (s *Storage) CleanTombstones(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
 // Close is a no-op for memory storage
 func (s *Storage) Close() error {
 	return nil
@@ -150,11 +226,16 @@ func (s *Storage) Close() error {
 
 // Stats returns storage statistics
 func (s *Storage) Stats(ctx context.Context) (*storage.Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	stats := &storage.Stats{
 		TotalMetrics: uint64(len(s.metrics)),
+		Metrics:      make(map[string]storage.MetricStats),
 	}
 
 	if len(s.metrics) == 0 {
@@ -169,7 +250,12 @@ func (s *Storage) Stats(ctx context.Context) (*storage.Stats, error) {
 	for _, m := range s.metrics {
 		// Track unique series
 		key := seriesKey(m.Name, m.Labels)
-		seriesMap[key] = true
+		ms := storage.MetricStats{Samples: 1, Oldest: m.Timestamp, Newest: m.Timestamp}
+		if !seriesMap[key] {
+			seriesMap[key] = true
+			ms.Series = 1
+		}
+		stats.AddSeries(m.Name, storage.ResolutionOf(m.Labels), ms)
 
 		// Track min/max timestamps
 		if m.Timestamp.Before(oldest) {
@@ -190,6 +276,11 @@ func (s *Storage) Stats(ctx context.Context) (*storage.Stats, error) {
 	return stats, nil
 }
 
+// sampleKey identifies a sample by series and timestamp
+func sampleKey(m metrics.Metric) string {
+	return seriesKey(m.Name, m.Labels) + "@" + strconv.FormatInt(m.Timestamp.UnixNano(), 10)
+}
+
 // seriesKey creates a unique key for a time series
 func seriesKey(name string, labels map[string]string) string {
 	// Simple approach: concatenate sorted labels
@@ -7,6 +7,7 @@ import (
 
 	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
 	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/storagetest"
 )
 
 func TestMemoryStorage_WriteAndQuery(t *testing.T) {
@@ -299,7 +300,7 @@ func TestMemoryStorage_ConcurrentWrites(t *testing.T) {
 				{
 					Name:      "concurrent_metric",
 					Value:     float64(id),
-					Timestamp: now,
+					Timestamp: now.Add(time.Duration(id) * time.Millisecond), // One sample per timestamp: same timestamps overwrite (see storagetest)
 				},
 			}
 			store.Write(ctx, metrics)
@@ -356,3 +357,9 @@ func TestMemoryStorage_EmptyQuery(t *testing.T) {
 		t.Errorf("Expected 0 total metrics, got %d", stats.TotalMetrics)
 	}
 }
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return New()
+	})
+}
@@ -0,0 +1,414 @@
+package objectstore
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// blockVersion is bumped when the block layout changes. Version 2 stores
+// the statistics of aggregates in their chunks (seriesEntry.Aggregates);
+// version 1 blocks kept them in labels.
+const blockVersion = 2
+
+// Object names within a block: "<block id>/<file>"
+const (
+	metaFile   = "meta.json"
+	indexFile  = "index.json"
+	chunksFile = "chunks"
+)
+
+// BlockMeta describes a sealed block. It is uploaded last, so a block
+// without meta.json is an interrupted upload and is ignored.
+type BlockMeta struct {
+	ID           string         `json:"id"`
+	Version      int            `json:"version"`
+	MinTime      time.Time      `json:"min_time"` // Oldest sample
+	MaxTime      time.Time      `json:"max_time"` // Newest sample
+	Samples      int            `json:"samples"`
+	Series       int            `json:"series"`
+	MetricCounts map[string]int `json:"metric_counts"`
+	SeriesCounts map[string]int `json:"series_counts,omitempty"` // Series per metric name
+	SizeBytes    int64          `json:"size_bytes"`
+	CreatedAt    time.Time      `json:"created_at"`
+
+	// Per metric name and resolution ("" for raw) breakdown. Missing in
+	// blocks sealed before it was recorded.
+	Resolutions map[string]map[storage.Resolution]ResolutionMeta `json:"resolutions,omitempty"`
+}
+
+// ResolutionMeta describes the samples of one metric name at one
+// resolution in a block
+type ResolutionMeta struct {
+	Series  int       `json:"series"`
+	Samples int       `json:"samples"`
+	MinTime time.Time `json:"min_time"`
+	MaxTime time.Time `json:"max_time"`
+}
+
+// add folds a sample into the breakdown
+func (r ResolutionMeta) add(ts time.Time, newSeries bool) ResolutionMeta {
+	if r.Samples == 0 || ts.Before(r.MinTime) {
+		r.MinTime = ts
+	}
+	if r.Samples == 0 || ts.After(r.MaxTime) {
+		r.MaxTime = ts
+	}
+	r.Samples++
+	if newSeries {
+		r.Series++
+	}
+	return r
+}
+
+// overlaps reports whether the block may hold samples in [start, end]
+func (m BlockMeta) overlaps(start, end time.Time) bool {
+	return !m.MaxTime.Before(start) && !m.MinTime.After(end)
+}
+
+// seriesEntry is one series in a block index. Each series' samples are
+// stored as one compressed chunk, so a query reads only the chunks of the
+// series it matches (a ranged GET against an object store).
+type seriesEntry struct {
+	Name    string             `json:"name"`
+	Type    metrics.MetricType `json:"type,omitempty"`
+	Labels  map[string]string  `json:"labels,omitempty"`
+	MinTime int64              `json:"min_time"` // Unix nanos
+	MaxTime int64              `json:"max_time"` // Unix nanos
+	Samples int                `json:"samples"`
+	Offset  int64              `json:"offset"`
+	Length  int64              `json:"length"`
+
+	// Aggregates is set when the series' samples carry aggregate statistics
+	Aggregates bool `json:"aggregates,omitempty"`
+}
+
+// metric returns a sample of the series
+func (e seriesEntry) metric(smp sample) metrics.Metric {
+	return metrics.Metric{
+		Name:      e.Name,
+		Type:      e.Type,
+		Value:     smp.value,
+		Labels:    e.Labels,
+		Timestamp: time.Unix(0, smp.ts),
+		Aggregate: smp.agg,
+	}
+}
+
+// sample is a single (timestamp, value) point within a chunk, with the
+// statistics of aggregates
+type sample struct {
+	ts    int64
+	value float64
+	agg   *metrics.AggregateStats
+}
+
+// encodedBlock is a block ready for upload
+type encodedBlock struct {
+	meta   BlockMeta
+	index  []byte
+	chunks []byte
+}
+
+// newBlockID returns a block ID that sorts by the block's time range
+func newBlockID(minTime time.Time) string {
+	return fmt.Sprintf("%020d-%s", minTime.UnixNano(), strconv.FormatInt(time.Now().UnixNano(), 36))
+}
+
+// encodeBlock groups samples into series and encodes the block files.
+// Chunk format (flate-compressed): [count uvarint] then per sample
+// [timestamp delta varint][value float64 bits, 8 bytes].
+func encodeBlock(samples []metrics.Metric) (*encodedBlock, error) {
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("cannot encode an empty block")
+	}
+
+	type seriesData struct {
+		entry   seriesEntry
+		samples []sample
+	}
+	bySeries := make(map[string]*seriesData)
+	meta := BlockMeta{
+		Version:      blockVersion,
+		MinTime:      samples[0].Timestamp,
+		MaxTime:      samples[0].Timestamp,
+		Samples:      len(samples),
+		MetricCounts: make(map[string]int),
+		SeriesCounts: make(map[string]int),
+		CreatedAt:    time.Now(),
+		Resolutions:  make(map[string]map[storage.Resolution]ResolutionMeta),
+	}
+
+	for _, m := range samples {
+		key := seriesKey(m.Name, m.Labels)
+		sd, ok := bySeries[key]
+		if !ok {
+			sd = &seriesData{entry: seriesEntry{Name: m.Name, Type: m.Type, Labels: m.Labels}}
+			bySeries[key] = sd
+			meta.SeriesCounts[m.Name]++
+		}
+		tiers := meta.Resolutions[m.Name]
+		if tiers == nil {
+			tiers = make(map[storage.Resolution]ResolutionMeta)
+			meta.Resolutions[m.Name] = tiers
+		}
+		res := storage.ResolutionOf(m.Labels)
+		tiers[res] = tiers[res].add(m.Timestamp, !ok)
+		sd.samples = append(sd.samples, sample{ts: m.Timestamp.UnixNano(), value: m.Value, agg: m.Aggregate})
+		if m.Aggregate != nil {
+			sd.entry.Aggregates = true
+		}
+		meta.MetricCounts[m.Name]++
+
+		if m.Timestamp.Before(meta.MinTime) {
+			meta.MinTime = m.Timestamp
+		}
+		if m.Timestamp.After(meta.MaxTime) {
+			meta.MaxTime = m.Timestamp
+		}
+	}
+
+	// Deterministic series order (sorted by series key)
+	keys := make([]string, 0, len(bySeries))
+	for k := range bySeries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var chunks bytes.Buffer
+	index := make([]seriesEntry, 0, len(keys))
+	for _, k := range keys {
+		sd := bySeries[k]
+		sort.Slice(sd.samples, func(i, j int) bool { return sd.samples[i].ts < sd.samples[j].ts })
+
+		chunk, err := encodeChunk(sd.samples, sd.entry.Aggregates)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := sd.entry
+		entry.MinTime = sd.samples[0].ts
+		entry.MaxTime = sd.samples[len(sd.samples)-1].ts
+		entry.Samples = len(sd.samples)
+		entry.Offset = int64(chunks.Len())
+		entry.Length = int64(len(chunk))
+		index = append(index, entry)
+		chunks.Write(chunk)
+	}
+
+	indexData, err := json.Marshal(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode block index: %w", err)
+	}
+
+	meta.ID = newBlockID(meta.MinTime)
+	meta.Series = len(index)
+	meta.SizeBytes = int64(len(indexData) + chunks.Len())
+
+	return &encodedBlock{meta: meta, index: indexData, chunks: chunks.Bytes()}, nil
+}
+
+// encodeChunk encodes and compresses one series' samples (sorted by time).
+// Samples of aggregate series are followed by their statistics:
+// [sum, min, max, first, last, increase: float64 bits each][count uvarint]
+// [sketch length uvarint][sketch].
+func encodeChunk(samples []sample, aggregates bool) ([]byte, error) {
+	raw := make([]byte, 0, binary.MaxVarintLen64+len(samples)*(binary.MaxVarintLen64+8))
+	raw = binary.AppendUvarint(raw, uint64(len(samples)))
+
+	var prev int64
+	for _, s := range samples {
+		raw = binary.AppendVarint(raw, s.ts-prev)
+		raw = binary.BigEndian.AppendUint64(raw, math.Float64bits(s.value))
+		prev = s.ts
+		if aggregates {
+			raw = appendStats(raw, s.agg)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return nil, fmt.Errorf("failed to compress chunk: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress chunk: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// appendStats encodes aggregate statistics (zeros for a sample without)
+func appendStats(raw []byte, agg *metrics.AggregateStats) []byte {
+	if agg == nil {
+		agg = &metrics.AggregateStats{}
+	}
+	for _, v := range []float64{agg.Sum, agg.Min, agg.Max, agg.First, agg.Last, agg.Increase} {
+		raw = binary.BigEndian.AppendUint64(raw, math.Float64bits(v))
+	}
+	raw = binary.AppendUvarint(raw, agg.Count)
+	raw = binary.AppendUvarint(raw, uint64(len(agg.Sketch)))
+	return append(raw, agg.Sketch...)
+}
+
+// readStats decodes statistics written by appendStats, returning the rest
+func readStats(raw []byte) (*metrics.AggregateStats, []byte, error) {
+	if len(raw) < 6*8 {
+		return nil, nil, fmt.Errorf("truncated aggregate statistics")
+	}
+	var v [6]float64
+	for i := range v {
+		v[i] = math.Float64frombits(binary.BigEndian.Uint64(raw[i*8:]))
+	}
+	raw = raw[6*8:]
+	agg := &metrics.AggregateStats{Sum: v[0], Min: v[1], Max: v[2], First: v[3], Last: v[4], Increase: v[5]}
+
+	count, n := binary.Uvarint(raw)
+	if n <= 0 {
+		return nil, nil, fmt.Errorf("corrupt aggregate count")
+	}
+	raw = raw[n:]
+	agg.Count = count
+
+	size, n := binary.Uvarint(raw)
+	if n <= 0 || size > uint64(len(raw)-n) {
+		return nil, nil, fmt.Errorf("corrupt aggregate sketch")
+	}
+	raw = raw[n:]
+	if size > 0 {
+		agg.Sketch = append([]byte(nil), raw[:size]...)
+	}
+	return agg, raw[size:], nil
+}
+
+// decodeChunk decompresses and decodes one series' samples
+func decodeChunk(data []byte, aggregates bool) ([]sample, error) {
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk: %w", err)
+	}
+
+	count, n := binary.Uvarint(raw)
+	if n <= 0 {
+		return nil, fmt.Errorf("corrupt chunk header")
+	}
+	raw = raw[n:]
+
+	// Every sample takes at least 9 bytes; don't trust count beyond that
+	if count > uint64(len(raw)/9) {
+		return nil, fmt.Errorf("corrupt chunk: %d samples in %d bytes", count, len(raw))
+	}
+
+	samples := make([]sample, 0, count)
+	var ts int64
+	for i := uint64(0); i < count; i++ {
+		delta, n := binary.Varint(raw)
+		if n <= 0 || len(raw) < n+8 {
+			return nil, fmt.Errorf("corrupt chunk at sample %d", i)
+		}
+		ts += delta
+		smp := sample{ts: ts, value: math.Float64frombits(binary.BigEndian.Uint64(raw[n : n+8]))}
+		raw = raw[n+8:]
+		if aggregates {
+			if smp.agg, raw, err = readStats(raw); err != nil {
+				return nil, fmt.Errorf("corrupt chunk at sample %d: %w", i, err)
+			}
+		}
+		samples = append(samples, smp)
+	}
+	return samples, nil
+}
+
+// uploadBlock writes a block to the bucket. meta.json goes last: readers
+// only see the block once it is complete.
+func uploadBlock(ctx context.Context, bucket Bucket, b *encodedBlock) error {
+	metaData, err := json.Marshal(b.meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode block meta: %w", err)
+	}
+
+	for _, obj := range []struct {
+		file string
+		data []byte
+	}{
+		{chunksFile, b.chunks},
+		{indexFile, b.index},
+		{metaFile, metaData},
+	} {
+		if err := bucket.Upload(ctx, b.meta.ID+"/"+obj.file, bytes.NewReader(obj.data)); err != nil {
+			return fmt.Errorf("failed to upload block %s: %w", b.meta.ID, err)
+		}
+	}
+	return nil
+}
+
+// deleteBlock removes a block's objects. meta.json goes first, so a
+// partially deleted block is already invisible to readers.
+func deleteBlock(ctx context.Context, bucket Bucket, id string) error {
+	for _, file := range []string{metaFile, indexFile, chunksFile} {
+		if err := bucket.Delete(ctx, id+"/"+file); err != nil {
+			return fmt.Errorf("failed to delete block %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// readJSON downloads and decodes a JSON object
+func readJSON(ctx context.Context, bucket Bucket, name string, v interface{}) error {
+	rc, err := bucket.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", name, err)
+	}
+	return nil
+}
+
+// readChunk downloads and decodes one series' chunk
+func readChunk(ctx context.Context, bucket Bucket, blockID string, e seriesEntry) ([]sample, error) {
+	rc, err := bucket.GetRange(ctx, blockID+"/"+chunksFile, e.Offset, e.Length)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk of %s in block %s: %w", e.Name, blockID, err)
+	}
+	samples, err := decodeChunk(data, e.Aggregates)
+	if err != nil {
+		return nil, fmt.Errorf("block %s, series %s: %w", blockID, e.Name, err)
+	}
+	return samples, nil
+}
+
+// seriesKey creates a unique key for a time series (metric name + sorted labels)
+func seriesKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	key := name
+	for _, k := range keys {
+		key += "," + k + "=" + labels[k]
+	}
+	return key
+}
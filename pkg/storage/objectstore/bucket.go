@@ -0,0 +1,180 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrObjectNotFound is returned when a bucket object doesn't exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// Bucket is the minimal object store API blocks are stored through.
+// It maps onto S3/GCS/MinIO (PUT, GET with Range, LIST, DELETE), so adding
+// a cloud implementation doesn't touch the block logic.
+// Object names use "/" as separator regardless of platform.
+type Bucket interface {
+	// Upload stores an object, replacing any existing object with the same name
+	Upload(ctx context.Context, name string, r io.Reader) error
+
+	// Get returns the full contents of an object
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// GetRange returns length bytes of an object starting at offset
+	GetRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error)
+
+	// Iter calls fn for every object whose name starts with prefix
+	Iter(ctx context.Context, prefix string, fn func(name string) error) error
+
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, name string) error
+}
+
+// FSBucket is a Bucket backed by a local directory.
+// Uploads are atomic (write to a temp file, then rename), so readers never
+// see partially written objects.
+type FSBucket struct {
+	dir string
+}
+
+// tmpPrefix marks in-flight uploads, which Iter skips
+const tmpPrefix = ".tmp-"
+
+// NewFSBucket creates a filesystem bucket rooted at dir
+func NewFSBucket(dir string) (*FSBucket, error) {
+	dir = filepath.Clean(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create bucket directory: %w", err)
+	}
+	return &FSBucket{dir: dir}, nil
+}
+
+// path maps an object name to a file path, rejecting names that escape the bucket
+func (b *FSBucket) path(name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." || strings.HasPrefix(part, tmpPrefix) {
+			return "", fmt.Errorf("invalid object name %q", name)
+		}
+	}
+	return filepath.Join(b.dir, filepath.FromSlash(name)), nil
+}
+
+// Upload writes an object atomically
+func (b *FSBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create object directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), tmpPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write object %s: %w", name, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync object %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close object %s: %w", name, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to commit object %s: %w", name, err)
+	}
+	return nil
+}
+
+// Get opens an object for reading
+func (b *FSBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	path, err := b.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	}
+	return f, err
+}
+
+// GetRange opens a byte range of an object for reading
+func (b *FSBucket) GetRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := b.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	f := rc.(*os.File)
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, offset, length), f}, nil
+}
+
+// Iter lists objects under prefix in lexical order
+func (b *FSBucket) Iter(ctx context.Context, prefix string, fn func(name string) error) error {
+	return filepath.WalkDir(b.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tmpPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		return fn(name)
+	})
+}
+
+// Delete removes an object and its directory once empty
+func (b *FSBucket) Delete(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete object %s: %w", name, err)
+	}
+
+	// Best effort: drop empty parent directories (object stores have no directories)
+	for dir := filepath.Dir(path); dir != b.dir && strings.HasPrefix(dir, b.dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,768 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// Defaults for sealing hot data into cold blocks
+const (
+	DefaultBlockDuration = 24 * time.Hour     // Each block covers one day
+	DefaultSealAfter     = 8 * 24 * time.Hour // After compaction and 5m retention (7d) are done with the data
+	indexCacheSize       = 64                 // Block indexes kept in memory
+)
+
+// Config holds tiered storage configuration
+type Config struct {
+	// Bucket stores the sealed blocks (e.g. NewFSBucket)
+	Bucket Bucket
+
+	// BlockDuration is the time range covered by one block (default: 24h)
+	BlockDuration time.Duration
+
+	// SealAfter is how old data must be before it moves to the cold tier (default: 8 days)
+	SealAfter time.Duration
+}
+
+// Storage is a two-tier storage.Storage: recent data lives in a hot backend
+// (BadgerDB), older data is sealed into immutable, compressed block files in
+// a Bucket. Writes always go to the hot tier; queries fan out to both.
+//
+// Sealed blocks are never modified. Deletes that hit part of a block
+// rewrite it as a new block and remove the old one.
+type Storage struct {
+	hot           storage.Storage
+	bucket        Bucket
+	blockDuration time.Duration
+	sealAfter     time.Duration
+
+	mu         sync.RWMutex
+	blocks     []BlockMeta // Sorted by MinTime
+	indexCache map[string][]seriesEntry
+
+	// Serializes Seal and cold deletes (both replace blocks)
+	sealMu sync.Mutex
+
+	// Held by writes, and exclusively by Seal while it removes a sealed
+	// window from the hot tier, so no write lands in between
+	writeMu sync.RWMutex
+}
+
+// New wraps a hot storage backend with a cold block tier.
+// Existing blocks in the bucket are loaded on startup.
+func New(hot storage.Storage, cfg Config) (*Storage, error) {
+	if cfg.Bucket == nil {
+		return nil, fmt.Errorf("objectstore: bucket is required")
+	}
+	if cfg.BlockDuration <= 0 {
+		cfg.BlockDuration = DefaultBlockDuration
+	}
+	if cfg.SealAfter <= 0 {
+		cfg.SealAfter = DefaultSealAfter
+	}
+
+	s := &Storage{
+		hot:           hot,
+		bucket:        cfg.Bucket,
+		blockDuration: cfg.BlockDuration,
+		sealAfter:     cfg.SealAfter,
+		indexCache:    make(map[string][]seriesEntry),
+	}
+	if err := s.loadBlocks(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := s.migrateBlocks(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Hot returns the hot tier backend (for backend-specific maintenance like GC)
+func (s *Storage) Hot() storage.Storage {
+	return s.hot
+}
+
+// Blocks returns the metadata of all sealed blocks, oldest first
+func (s *Storage) Blocks() []BlockMeta {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]BlockMeta(nil), s.blocks...)
+}
+
+// loadBlocks reads every complete block's meta.json from the bucket
+func (s *Storage) loadBlocks(ctx context.Context) error {
+	var blocks []BlockMeta
+	err := s.bucket.Iter(ctx, "", func(name string) error {
+		if !strings.HasSuffix(name, "/"+metaFile) {
+			return nil
+		}
+		var meta BlockMeta
+		if err := readJSON(ctx, s.bucket, name, &meta); err != nil {
+			return err
+		}
+		if meta.Version > blockVersion {
+			return fmt.Errorf("block %s has unsupported version %d", meta.ID, meta.Version)
+		}
+		blocks = append(blocks, meta)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load blocks: %w", err)
+	}
+
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].MinTime.Before(blocks[j].MinTime) })
+
+	s.mu.Lock()
+	s.blocks = blocks
+	s.mu.Unlock()
+	return nil
+}
+
+// Write stores metrics in the hot tier
+func (s *Storage) Write(ctx context.Context, metrics []metrics.Metric) error {
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	return s.hot.Write(ctx, metrics)
+}
+
+// WriteWithPolicy stores metrics in the hot tier, resolving duplicates there.
+// Sealed samples aren't checked: a hot sample already shadows its cold copy.
+func (s *Storage) WriteWithPolicy(ctx context.Context, metrics []metrics.Metric, policy storage.DuplicatePolicy) (storage.WriteResult, error) {
+	writer, ok := s.hot.(storage.PolicyWriter)
+	if !ok {
+		return storage.WriteResult{}, fmt.Errorf("hot tier does not support duplicate policies")
+	}
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	return writer.WriteWithPolicy(ctx, metrics, policy)
+}
+
+// Query retrieves metrics from cold blocks and the hot tier.
+// Cold results come first (they are older). A sample present in both tiers
+// (written to a window after it was sealed) is returned once.
+func (s *Storage) Query(ctx context.Context, req storage.QueryRequest) ([]metrics.Metric, error) {
+	hotResults, err := s.hot.Query(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := s.overlappingBlocks(req.Start, req.End)
+	if len(blocks) == 0 {
+		return hotResults, nil
+	}
+
+	// Samples in the hot tier win over sealed copies
+	seen := make(map[string]bool, len(hotResults))
+	for _, m := range hotResults {
+		seen[sampleKey(m)] = true
+	}
+
+	var results []metrics.Metric
+	for _, b := range blocks {
+		if req.Limit > 0 && len(results) >= req.Limit {
+			break
+		}
+		if err := s.queryBlock(ctx, b, req, seen, &results); err != nil {
+			return nil, err
+		}
+	}
+
+	results = append(results, hotResults...)
+	if req.Limit > 0 && len(results) > req.Limit {
+		results = results[:req.Limit]
+	}
+	return results, nil
+}
+
+// ScanSeries streams the series matching req from both tiers, one at a
+// time. Without overlapping blocks it is the hot tier's scan; otherwise
+// series are read one metric name at a time, their sealed samples merged
+// with the hot tier's (which win where both hold a timestamp).
+func (s *Storage) ScanSeries(ctx context.Context, req storage.QueryRequest, fn func(series []metrics.Metric) error) error {
+	blocks := s.overlappingBlocks(req.Start, req.End)
+	if len(blocks) == 0 {
+		return storage.ScanSeries(ctx, s.hot, req, fn)
+	}
+
+	names := req.MetricNames
+	if len(names) == 0 {
+		stats, err := s.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		names = stats.NamesBetween(req.Start, req.End)
+	}
+
+	for _, name := range names {
+		one := req
+		one.MetricNames, one.Limit = []string{name}, 0
+
+		var sealed []metrics.Metric
+		for _, b := range blocks {
+			if err := s.queryBlock(ctx, b, one, nil, &sealed); err != nil {
+				return err
+			}
+		}
+		cold := make(map[string][]metrics.Metric)
+		for _, m := range sealed {
+			key := seriesKey(m.Name, m.Labels)
+			cold[key] = append(cold[key], m)
+		}
+
+		err := storage.ScanSeries(ctx, s.hot, one, func(series []metrics.Metric) error {
+			key := seriesKey(series[0].Name, series[0].Labels)
+			older, ok := cold[key]
+			if !ok {
+				return fn(series)
+			}
+			delete(cold, key)
+			return fn(mergeSeries(older, series))
+		})
+		if err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(cold))
+		for key := range cold {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			series := cold[key]
+			sort.Slice(series, func(i, j int) bool { return series[i].Timestamp.Before(series[j].Timestamp) })
+			if err := fn(series); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeSeries merges the sealed and hot samples of a series in time order,
+// dropping sealed samples the hot tier also holds
+func mergeSeries(sealed, hot []metrics.Metric) []metrics.Metric {
+	inHot := make(map[int64]bool, len(hot))
+	for _, m := range hot {
+		inHot[m.Timestamp.UnixNano()] = true
+	}
+	merged := make([]metrics.Metric, 0, len(sealed)+len(hot))
+	for _, m := range sealed {
+		if !inHot[m.Timestamp.UnixNano()] {
+			merged = append(merged, m)
+		}
+	}
+	merged = append(merged, hot...)
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Timestamp.Before(merged[j].Timestamp) })
+	return merged
+}
+
+// QueryDownsampled is Query with req.Step and req.Aggregation applied.
+// Without overlapping blocks the hot tier downsamples; otherwise both
+// tiers are scanned into one downsampler, so a series spanning them fills
+// its steps from both.
+func (s *Storage) QueryDownsampled(ctx context.Context, req storage.QueryRequest) ([]metrics.Metric, error) {
+	if len(s.overlappingBlocks(req.Start, req.End)) == 0 {
+		return storage.QueryDownsampled(ctx, s.hot, req)
+	}
+
+	d := storage.NewDownsampler(req)
+	raw := req
+	raw.Step, raw.Aggregation, raw.Limit = 0, "", 0 // Limit applies to the steps
+	err := s.ScanSeries(ctx, raw, func(series []metrics.Metric) error {
+		for _, m := range series {
+			d.Add(m)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.Result(), nil
+}
+
+// queryBlock appends the samples of a block matching the request
+func (s *Storage) queryBlock(ctx context.Context, b BlockMeta, req storage.QueryRequest, seen map[string]bool, results *[]metrics.Metric) error {
+	index, err := s.blockIndex(ctx, b.ID)
+	if err != nil {
+		return err
+	}
+
+	start, end := req.Start.UnixNano(), req.End.UnixNano()
+	for _, e := range index {
+		if e.MaxTime < start || e.MinTime > end || !matchesSeries(e, req) {
+			continue
+		}
+
+		samples, err := readChunk(ctx, s.bucket, b.ID, e)
+		if err != nil {
+			return err
+		}
+		for _, smp := range samples {
+			if smp.ts < start || smp.ts > end {
+				continue
+			}
+			m := e.metric(smp)
+			if seen[sampleKey(m)] {
+				continue
+			}
+			*results = append(*results, m)
+			if req.Limit > 0 && len(*results) >= req.Limit {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// overlappingBlocks returns blocks that may hold samples in [start, end]
+func (s *Storage) overlappingBlocks(start, end time.Time) []BlockMeta {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []BlockMeta
+	for _, b := range s.blocks {
+		if b.overlaps(start, end) {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// blockIndex returns a block's series index, cached after the first read
+func (s *Storage) blockIndex(ctx context.Context, id string) ([]seriesEntry, error) {
+	s.mu.RLock()
+	index, ok := s.indexCache[id]
+	s.mu.RUnlock()
+	if ok {
+		return index, nil
+	}
+
+	if err := readJSON(ctx, s.bucket, id+"/"+indexFile, &index); err != nil {
+		return nil, fmt.Errorf("failed to read index of block %s: %w", id, err)
+	}
+
+	s.mu.Lock()
+	if len(s.indexCache) >= indexCacheSize {
+		for k := range s.indexCache {
+			delete(s.indexCache, k) // Evict an arbitrary entry
+			break
+		}
+	}
+	s.indexCache[id] = index
+	s.mu.Unlock()
+	return index, nil
+}
+
+// Seal moves complete BlockDuration windows older than SealAfter from the
+// hot tier into cold blocks. Each window is uploaded before it is removed
+// from the hot tier, so a crash in between leaves a duplicate, not a gap.
+// Only the samples that were sealed are removed: samples written into the
+// window while it was being uploaded stay in the hot tier.
+// Returns the number of blocks created.
+func (s *Storage) Seal(ctx context.Context, now time.Time) (int, error) {
+	s.sealMu.Lock()
+	defer s.sealMu.Unlock()
+
+	stats, err := s.hot.Stats(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get hot tier stats: %w", err)
+	}
+	if stats.TotalMetrics == 0 {
+		return 0, nil
+	}
+
+	cutoff := now.Add(-s.sealAfter).Truncate(s.blockDuration)
+	sealed := 0
+	for start := stats.OldestMetric.Truncate(s.blockDuration); start.Before(cutoff); start = start.Add(s.blockDuration) {
+		end := start.Add(s.blockDuration)
+
+		samples, err := s.hot.Query(ctx, storage.QueryRequest{Start: start, End: end.Add(-time.Nanosecond)})
+		if err != nil {
+			return sealed, fmt.Errorf("failed to read window %v: %w", start, err)
+		}
+		if len(samples) == 0 {
+			continue
+		}
+
+		block, err := encodeBlock(samples)
+		if err != nil {
+			return sealed, err
+		}
+		if err := uploadBlock(ctx, s.bucket, block); err != nil {
+			return sealed, err
+		}
+		s.addBlock(block.meta)
+
+		if err := s.removeSealed(ctx, start, end, samples); err != nil {
+			return sealed, fmt.Errorf("failed to remove sealed window %v from hot tier: %w", start, err)
+		}
+
+		sealed++
+		log.Printf("Sealed block %s: %d samples, %d series (%s - %s)",
+			block.meta.ID, block.meta.Samples, block.meta.Series,
+			block.meta.MinTime.Format(time.RFC3339), block.meta.MaxTime.Format(time.RFC3339))
+	}
+	return sealed, nil
+}
+
+// removeSealed deletes the window [start, end) from the hot tier, keeping
+// samples that were written or overwritten since sealed was read. Writes
+// are held off meanwhile, so none is lost between the read and the delete.
+func (s *Storage) removeSealed(ctx context.Context, start, end time.Time, sealed []metrics.Metric) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	current, err := s.hot.Query(ctx, storage.QueryRequest{Start: start, End: end.Add(-time.Nanosecond)})
+	if err != nil {
+		return err
+	}
+	inBlock := make(map[string]metrics.Metric, len(sealed))
+	for _, m := range sealed {
+		inBlock[sampleKey(m)] = m
+	}
+	var newer []metrics.Metric
+	for _, m := range current {
+		if old, ok := inBlock[sampleKey(m)]; !ok || !reflect.DeepEqual(old, m) {
+			newer = append(newer, m)
+		}
+	}
+
+	if err := s.hot.Delete(ctx, storage.DeleteOptions{From: start, Before: end}); err != nil {
+		return err
+	}
+	if len(newer) == 0 {
+		return nil
+	}
+	return s.hot.Write(ctx, newer)
+}
+
+// addBlock registers an uploaded block
+func (s *Storage) addBlock(meta BlockMeta) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blocks = append(s.blocks, meta)
+	sort.Slice(s.blocks, func(i, j int) bool { return s.blocks[i].MinTime.Before(s.blocks[j].MinTime) })
+}
+
+// removeBlock unregisters a block (before its objects are deleted)
+func (s *Storage) removeBlock(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, b := range s.blocks {
+		if b.ID == id {
+			s.blocks = append(s.blocks[:i], s.blocks[i+1:]...)
+			break
+		}
+	}
+	delete(s.indexCache, id)
+}
+
+// Delete removes matching metrics from both tiers
+func (s *Storage) Delete(ctx context.Context, opts storage.DeleteOptions) error {
+	_, err := s.DeleteCounted(ctx, opts)
+	return err
+}
+
+// DeleteCounted removes matching metrics from both tiers and returns how
+// many samples were removed. A sample sealed while a copy remained in the
+// hot tier counts twice.
+func (s *Storage) DeleteCounted(ctx context.Context, opts storage.DeleteOptions) (int, error) {
+	deleted, err := storage.DeleteCounted(ctx, s.hot, opts)
+	if err != nil {
+		return deleted, err
+	}
+	cold, err := s.deleteCold(ctx, opts)
+	return deleted + cold, err
+}
+
+// deleteCold applies a deletion to sealed blocks and returns the number of
+// samples removed. Blocks entirely covered by a plain time-based delete are
+// dropped without reading them; blocks partially affected are rewritten
+// without the deleted samples.
+func (s *Storage) deleteCold(ctx context.Context, opts storage.DeleteOptions) (int, error) {
+	s.sealMu.Lock()
+	defer s.sealMu.Unlock()
+
+	timeOnly := opts.Resolution == nil && len(opts.Matchers) == 0 && len(opts.Exclude) == 0
+
+	deleted := 0
+	for _, b := range s.Blocks() {
+		if !b.MinTime.Before(opts.Before) || (!opts.From.IsZero() && b.MaxTime.Before(opts.From)) {
+			continue // No sample of the block is in the time range
+		}
+
+		if timeOnly && b.MaxTime.Before(opts.Before) && (opts.From.IsZero() || !b.MinTime.Before(opts.From)) {
+			s.removeBlock(b.ID)
+			if err := deleteBlock(ctx, s.bucket, b.ID); err != nil {
+				return deleted, err
+			}
+			deleted += b.Samples
+			continue
+		}
+
+		n, err := s.rewriteBlock(ctx, b, opts)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// rewriteBlock replaces a block with a copy that excludes deleted samples,
+// returning how many were deleted
+func (s *Storage) rewriteBlock(ctx context.Context, b BlockMeta, opts storage.DeleteOptions) (int, error) {
+	return s.replaceBlock(ctx, b, func(m metrics.Metric) (metrics.Metric, bool, bool) {
+		deleted := opts.Matches(m)
+		return m, !deleted, deleted
+	})
+}
+
+// replaceBlock replaces a block with a copy whose samples went through
+// rewrite, which returns the sample to keep, whether to keep it, and
+// whether that changed anything. Unchanged blocks are left alone.
+// Returns the number of samples changed.
+func (s *Storage) replaceBlock(ctx context.Context, b BlockMeta, rewrite func(metrics.Metric) (metrics.Metric, bool, bool)) (int, error) {
+	index, err := s.blockIndex(ctx, b.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	var kept []metrics.Metric
+	changed := 0
+	for _, e := range index {
+		samples, err := readChunk(ctx, s.bucket, b.ID, e)
+		if err != nil {
+			return 0, err
+		}
+		for _, smp := range samples {
+			m, keep, modified := rewrite(e.metric(smp))
+			if modified {
+				changed++
+			}
+			if keep {
+				kept = append(kept, m)
+			}
+		}
+	}
+
+	if changed == 0 {
+		return 0, nil
+	}
+
+	if len(kept) > 0 {
+		block, err := encodeBlock(kept)
+		if err != nil {
+			return 0, err
+		}
+		if err := uploadBlock(ctx, s.bucket, block); err != nil {
+			return 0, err
+		}
+		s.addBlock(block.meta)
+	}
+
+	s.removeBlock(b.ID)
+	return changed, deleteBlock(ctx, s.bucket, b.ID)
+}
+
+// migrateBlocks rewrites version 1 blocks holding label-encoded aggregates
+// with the statistics in their chunks. Uploading the copy before removing
+// the original makes an interrupted migration resume on the next start.
+func (s *Storage) migrateBlocks(ctx context.Context) error {
+	for _, b := range s.Blocks() {
+		if b.Version >= 2 {
+			continue
+		}
+		index, err := s.blockIndex(ctx, b.ID)
+		if err != nil {
+			return err
+		}
+		legacy := false
+		for _, e := range index {
+			legacy = legacy || storage.IsLegacyAggregate(metrics.Metric{Labels: e.Labels})
+		}
+		if !legacy {
+			continue
+		}
+
+		upgraded := 0
+		_, err = s.replaceBlock(ctx, b, func(m metrics.Metric) (metrics.Metric, bool, bool) {
+			if !storage.IsLegacyAggregate(m) {
+				return m, true, false
+			}
+			up, err := storage.UpgradeLegacyAggregate(m)
+			if err != nil {
+				log.Printf("Block %s: leaving malformed aggregate of %s as is: %v", b.ID, m.Name, err)
+				return m, true, false
+			}
+			upgraded++
+			return up, true, true
+		})
+		if err != nil {
+			return fmt.Errorf("failed to migrate block %s: %w", b.ID, err)
+		}
+		log.Printf("Migrated %d aggregates of block %s", upgraded, b.ID)
+	}
+	return nil
+}
+
+// Close shuts down the hot tier (blocks need no cleanup)
+func (s *Storage) Close() error {
+	return s.hot.Close()
+}
+
+// Stats combines hot tier stats with sealed block metadata.
+// TotalSeries and per-metric series counts are upper bounds: a series
+// spanning several blocks is counted once per block. Blocks sealed before
+// per-metric series counts were recorded add samples but no series.
+func (s *Storage) Stats(ctx context.Context) (*storage.Stats, error) {
+	stats, err := s.hot.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range s.Blocks() {
+		stats.TotalMetrics += uint64(b.Samples)
+		stats.TotalSeries += uint64(b.Series)
+		stats.SizeBytes += uint64(b.SizeBytes)
+		for name, tiers := range b.Resolutions {
+			for res, r := range tiers {
+				stats.AddSeries(name, res, storage.MetricStats{
+					Series:  uint64(r.Series),
+					Samples: uint64(r.Samples),
+					Oldest:  r.MinTime,
+					Newest:  r.MaxTime,
+				})
+			}
+		}
+		if b.Resolutions == nil {
+			// Sealed before the breakdown was recorded: only the block's
+			// overall time range is known, and its data counts as raw
+			for name, count := range b.MetricCounts {
+				stats.AddSeries(name, storage.ResolutionRaw, storage.MetricStats{
+					Series:  uint64(b.SeriesCounts[name]),
+					Samples: uint64(count),
+					Oldest:  b.MinTime,
+					Newest:  b.MaxTime,
+				})
+			}
+		}
+		if stats.OldestMetric.IsZero() || b.MinTime.Before(stats.OldestMetric) {
+			stats.OldestMetric = b.MinTime
+		}
+		if b.MaxTime.After(stats.NewestMetric) {
+			stats.NewestMetric = b.MaxTime
+		}
+	}
+	return stats, nil
+}
+
+// DeleteSeries tombstones matching series in the hot tier and removes them
+// from cold blocks right away (blocks have no tombstones of their own).
+func (s *Storage) DeleteSeries(ctx context.Context, matchers []storage.Matcher, start, end time.Time) (*storage.Tombstone, error) {
+	deleter, ok := s.hot.(storage.SeriesDeleter)
+	if !ok {
+		return nil, errors.New("hot tier does not support series deletion")
+	}
+	t, err := deleter.DeleteSeries(ctx, matchers, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.deleteCold(ctx, t.DeleteOptions()); err != nil {
+		return nil, fmt.Errorf("failed to delete series from cold blocks: %w", err)
+	}
+	return t, nil
+}
+
+// Tombstones returns the hot tier's pending tombstones
+func (s *Storage) Tombstones() []storage.Tombstone {
+	if deleter, ok := s.hot.(storage.SeriesDeleter); ok {
+		return deleter.Tombstones()
+	}
+	return nil
+}
+
+// CleanTombstones purges tombstoned data from the hot tier
+func (s *Storage) CleanTombstones(ctx context.Context) (int, error) {
+	if deleter, ok := s.hot.(storage.SeriesDeleter); ok {
+		return deleter.CleanTombstones(ctx)
+	}
+	return 0, nil
+}
+
+// ReclaimSpace reclaims disk space in the hot tier
+func (s *Storage) ReclaimSpace(ctx context.Context) error {
+	if reclaimer, ok := s.hot.(storage.SpaceReclaimer); ok {
+		return reclaimer.ReclaimSpace(ctx)
+	}
+	return nil
+}
+
+// Check verifies the hot tier. Sealed blocks are checked when they are read
+// (chunk decoding validates sample counts and lengths).
+func (s *Storage) Check(ctx context.Context, opts storage.CheckOptions) (*storage.CheckReport, error) {
+	checker, ok := s.hot.(storage.Checker)
+	if !ok {
+		return nil, fmt.Errorf("hot tier does not support consistency checks")
+	}
+	return checker.Check(ctx, opts)
+}
+
+// Snapshot backs up the hot tier. Sealed blocks are immutable objects and
+// are backed up by copying the bucket.
+func (s *Storage) Snapshot(ctx context.Context, w io.Writer, since uint64) (uint64, error) {
+	snapshotter, ok := s.hot.(storage.Snapshotter)
+	if !ok {
+		return 0, fmt.Errorf("hot tier does not support snapshots")
+	}
+	return snapshotter.Snapshot(ctx, w, since)
+}
+
+// Restore loads a hot tier snapshot
+func (s *Storage) Restore(ctx context.Context, r io.Reader) error {
+	snapshotter, ok := s.hot.(storage.Snapshotter)
+	if !ok {
+		return fmt.Errorf("hot tier does not support snapshots")
+	}
+	return snapshotter.Restore(ctx, r)
+}
+
+// matchesSeries applies the request's name and label filters to a series
+func matchesSeries(e seriesEntry, req storage.QueryRequest) bool {
+	if len(req.MetricNames) > 0 {
+		found := false
+		for _, name := range req.MetricNames {
+			if e.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, v := range req.Labels {
+		if e.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// sampleKey identifies a sample across tiers (series + timestamp)
+func sampleKey(m metrics.Metric) string {
+	return seriesKey(m.Name, m.Labels) + "@" + fmt.Sprint(m.Timestamp.UnixNano())
+}
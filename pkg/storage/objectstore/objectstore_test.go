@@ -0,0 +1,479 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/memory"
+	"github.com/nicktill/tinyobs/pkg/storage/ring"
+	"github.com/nicktill/tinyobs/pkg/storage/storagetest"
+)
+
+func TestFSBucket(t *testing.T) {
+	ctx := context.Background()
+	bucket, err := NewFSBucket(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFSBucket failed: %v", err)
+	}
+
+	if err := bucket.Upload(ctx, "block-1/chunks", bytes.NewReader([]byte("0123456789"))); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if err := bucket.Upload(ctx, "block-1/meta.json", bytes.NewReader([]byte("{}"))); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	rc, err := bucket.GetRange(ctx, "block-1/chunks", 3, 4)
+	if err != nil {
+		t.Fatalf("GetRange failed: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "3456" {
+		t.Errorf("GetRange returned %q, want %q", data, "3456")
+	}
+
+	var names []string
+	if err := bucket.Iter(ctx, "block-1/", func(name string) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatalf("Iter failed: %v", err)
+	}
+	if len(names) != 2 || names[0] != "block-1/chunks" || names[1] != "block-1/meta.json" {
+		t.Errorf("Unexpected object list: %v", names)
+	}
+
+	if err := bucket.Delete(ctx, "block-1/chunks"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := bucket.Delete(ctx, "block-1/chunks"); err != nil {
+		t.Errorf("Deleting a missing object should succeed, got %v", err)
+	}
+	if _, err := bucket.Get(ctx, "block-1/chunks"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound, got %v", err)
+	}
+
+	for _, name := range []string{"", "/abs", "../escape", "a//b", "a/./b"} {
+		if err := bucket.Upload(ctx, name, bytes.NewReader(nil)); err == nil {
+			t.Errorf("Expected invalid object name %q to be rejected", name)
+		}
+	}
+}
+
+func TestChunkRoundTrip(t *testing.T) {
+	samples := []sample{
+		{ts: 1000, value: 1.5},
+		{ts: 2000, value: -3},
+		{ts: 2000 + int64(time.Hour), value: math.Inf(1)},
+		{ts: 5000 + int64(time.Hour), value: 0},
+	}
+
+	data, err := encodeChunk(samples, false)
+	if err != nil {
+		t.Fatalf("encodeChunk failed: %v", err)
+	}
+	decoded, err := decodeChunk(data, false)
+	if err != nil {
+		t.Fatalf("decodeChunk failed: %v", err)
+	}
+
+	if len(decoded) != len(samples) {
+		t.Fatalf("Expected %d samples, got %d", len(samples), len(decoded))
+	}
+	for i := range samples {
+		if decoded[i] != samples[i] {
+			t.Errorf("Sample %d: got %+v, want %+v", i, decoded[i], samples[i])
+		}
+	}
+
+	if _, err := decodeChunk([]byte("garbage"), false); err == nil {
+		t.Error("Expected error decoding a corrupt chunk")
+	}
+}
+
+// newTiered creates tiered storage over a memory hot tier with 1h blocks sealed after 2h
+func newTiered(t *testing.T, dir string, hot storage.Storage) *Storage {
+	t.Helper()
+	bucket, err := NewFSBucket(dir)
+	if err != nil {
+		t.Fatalf("NewFSBucket failed: %v", err)
+	}
+	s, err := New(hot, Config{Bucket: bucket, BlockDuration: time.Hour, SealAfter: 2 * time.Hour})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return s
+}
+
+func TestStorage_SealAndQuery(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	hot := memory.New()
+	s := newTiered(t, dir, hot)
+	defer s.Close()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	host := map[string]string{"host": "a"}
+	var batch []metrics.Metric
+	for i := 0; i < 6; i++ {
+		ts := now.Add(-time.Duration(i)*time.Hour - 10*time.Minute)
+		batch = append(batch,
+			metrics.Metric{Name: "cpu", Labels: host, Type: metrics.GaugeType, Value: float64(i), Timestamp: ts},
+			metrics.Metric{Name: "mem", Value: float64(100 + i), Timestamp: ts},
+		)
+	}
+	if err := s.Write(ctx, batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Windows ending at or before 10:00 are sealed: samples at 09:50, 08:50, 07:50, 06:50
+	sealed, err := s.Seal(ctx, now)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if sealed != 4 {
+		t.Errorf("Expected 4 sealed blocks, got %d", sealed)
+	}
+
+	hotStats, _ := hot.Stats(ctx)
+	if hotStats.TotalMetrics != 4 {
+		t.Errorf("Expected 4 samples left in hot tier, got %d", hotStats.TotalMetrics)
+	}
+
+	// Query spans both tiers
+	results, err := s.Query(ctx, storage.QueryRequest{
+		Start:       now.Add(-24 * time.Hour),
+		End:         now,
+		MetricNames: []string{"cpu"},
+	})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 6 {
+		t.Fatalf("Expected 6 cpu samples across tiers, got %d", len(results))
+	}
+	for _, m := range results {
+		if m.Labels["host"] != "a" || m.Type != metrics.GaugeType {
+			t.Errorf("Series identity lost in cold tier: %+v", m)
+		}
+	}
+
+	// Label filters and time bounds apply to cold blocks
+	results, err = s.Query(ctx, storage.QueryRequest{
+		Start:  now.Add(-4 * time.Hour),
+		End:    now.Add(-2 * time.Hour),
+		Labels: map[string]string{"host": "a"},
+	})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("Expected 2 samples in [-4h, -2h] for host=a, got %d", len(results))
+	}
+
+	// A sample re-written into a sealed window is returned once
+	if err := s.Write(ctx, batch[4:5]); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	results, _ = s.Query(ctx, storage.QueryRequest{Start: now.Add(-24 * time.Hour), End: now, MetricNames: []string{"cpu"}})
+	if len(results) != 6 {
+		t.Errorf("Expected duplicate across tiers to be returned once, got %d samples", len(results))
+	}
+
+	// Blocks survive a restart
+	reopened := newTiered(t, dir, memory.New())
+	if len(reopened.Blocks()) != 4 {
+		t.Errorf("Expected 4 blocks after reopen, got %d", len(reopened.Blocks()))
+	}
+	stats, err := reopened.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TotalMetrics != 8 || stats.Metrics["cpu"].Samples != 4 {
+		t.Errorf("Expected 8 cold samples (4 cpu), got %d (%v)", stats.TotalMetrics, stats.Metrics)
+	}
+}
+
+func TestStorage_Aggregates(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := newTiered(t, dir, memory.New())
+	defer s.Close()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	sketch := storage.NewSketch()
+	sketch.Add(0.1)
+	sketch.Add(0.2)
+	aggregate := metrics.Metric{
+		Name:      "cpu",
+		Type:      metrics.GaugeType,
+		Value:     0.15000000000000002,
+		Labels:    map[string]string{"host": "a", "__resolution__": "5m"},
+		Timestamp: now.Add(-5 * time.Hour),
+		Aggregate: &metrics.AggregateStats{Sum: 0.1 + 0.2, Count: 2, Min: 0.1, Max: 0.2, Sketch: sketch.Encode()},
+	}
+	if err := s.Write(ctx, []metrics.Metric{aggregate, {Name: "cpu", Value: 1, Labels: map[string]string{"host": "a"}, Timestamp: now.Add(-5 * time.Hour)}}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := s.Seal(ctx, now); err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	results, err := s.Query(ctx, storage.QueryRequest{Start: now.Add(-24 * time.Hour), End: now})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 sealed samples, got %d", len(results))
+	}
+	for _, m := range results {
+		if m.Labels["__resolution__"] == "" {
+			if m.Aggregate != nil {
+				t.Errorf("Raw sample came back with statistics %+v", m.Aggregate)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(m.Aggregate, aggregate.Aggregate) {
+			t.Errorf("Sealed statistics = %+v, want %+v at full precision", m.Aggregate, aggregate.Aggregate)
+		}
+	}
+
+	// Blocks record their samples per resolution
+	stats, err := s.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	for _, res := range []storage.Resolution{storage.ResolutionRaw, storage.Resolution5m} {
+		if got := stats.Resolutions[res]; got.Samples != 1 || got.Series != 1 || !got.Oldest.Equal(now.Add(-5*time.Hour)) {
+			t.Errorf("Stats for resolution %q = %+v, want the sealed sample", res, got)
+		}
+	}
+}
+
+func TestStorage_MigrateLegacyAggregates(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bucket, err := NewFSBucket(dir)
+	if err != nil {
+		t.Fatalf("NewFSBucket failed: %v", err)
+	}
+
+	// A version 1 block with statistics in labels
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	block, err := encodeBlock([]metrics.Metric{
+		{Name: "cpu", Type: metrics.GaugeType, Value: 1.5, Labels: map[string]string{"host": "a", "__resolution__": "1h", "__sum__": "3.000000", "__count__": "2", "__min__": "1.000000", "__max__": "2.000000"}, Timestamp: now},
+		{Name: "cpu", Type: metrics.GaugeType, Value: 7, Labels: map[string]string{"host": "a"}, Timestamp: now},
+	})
+	if err != nil {
+		t.Fatalf("encodeBlock failed: %v", err)
+	}
+	block.meta.Version = 1
+	if err := uploadBlock(ctx, bucket, block); err != nil {
+		t.Fatalf("uploadBlock failed: %v", err)
+	}
+
+	s := newTiered(t, dir, memory.New())
+	defer s.Close()
+
+	blocks := s.Blocks()
+	if len(blocks) != 1 || blocks[0].Version != blockVersion {
+		t.Fatalf("Expected the block rewritten at version %d, got %+v", blockVersion, blocks)
+	}
+	results, err := s.Query(ctx, storage.QueryRequest{Start: now.Add(-time.Hour), End: now.Add(time.Hour), Labels: map[string]string{"__resolution__": "1h"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 aggregate, got %d", len(results))
+	}
+	want := &metrics.AggregateStats{Sum: 3, Count: 2, Min: 1, Max: 2}
+	if m := results[0]; !reflect.DeepEqual(m.Aggregate, want) || len(m.Labels) != 2 {
+		t.Errorf("Migrated aggregate = %v %+v, want labels host and __resolution__ with %+v", m.Labels, m.Aggregate, want)
+	}
+}
+
+func TestStorage_DeleteCold(t *testing.T) {
+	ctx := context.Background()
+	s := newTiered(t, t.TempDir(), memory.New())
+	defer s.Close()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	agg := map[string]string{"__resolution__": "5m"}
+	base := now.Add(-5 * time.Hour)
+	if err := s.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Timestamp: base},
+		{Name: "cpu", Value: 2, Labels: agg, Timestamp: base.Add(time.Minute)},
+		{Name: "cpu", Value: 3, Timestamp: base.Add(time.Hour)},
+	}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := s.Seal(ctx, now); err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if len(s.Blocks()) != 2 {
+		t.Fatalf("Expected 2 blocks, got %d", len(s.Blocks()))
+	}
+
+	// Partial delete rewrites the first block without raw data
+	raw := storage.ResolutionRaw
+	if err := s.Delete(ctx, storage.DeleteOptions{Before: base.Add(30 * time.Minute), Resolution: &raw}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	results, _ := s.Query(ctx, storage.QueryRequest{Start: base.Add(-time.Hour), End: now})
+	if len(results) != 2 {
+		t.Errorf("Expected 2 samples after partial delete, got %d", len(results))
+	}
+	if len(s.Blocks()) != 2 {
+		t.Errorf("Expected rewritten block to replace the old one, got %d blocks", len(s.Blocks()))
+	}
+
+	// Time-only delete drops whole blocks
+	if err := s.Delete(ctx, storage.DeleteOptions{Before: now}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if len(s.Blocks()) != 0 {
+		t.Errorf("Expected all blocks dropped, got %d", len(s.Blocks()))
+	}
+}
+
+func TestStorage_DeleteSeries(t *testing.T) {
+	ctx := context.Background()
+	s := newTiered(t, t.TempDir(), memory.New())
+	defer s.Close()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-5 * time.Hour)
+	if err := s.Write(ctx, []metrics.Metric{
+		{Name: "secret", Value: 1, Timestamp: old},
+		{Name: "cpu", Value: 2, Timestamp: old},
+	}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := s.Seal(ctx, now); err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	matchers := []storage.Matcher{{Type: storage.MatchEqual, Name: storage.MetricNameLabel, Value: "secret"}}
+	if _, err := s.DeleteSeries(ctx, matchers, time.Unix(0, 0), now); err != nil {
+		t.Fatalf("DeleteSeries failed: %v", err)
+	}
+
+	results, _ := s.Query(ctx, storage.QueryRequest{Start: old.Add(-time.Hour), End: now})
+	if len(results) != 1 || results[0].Name != "cpu" {
+		t.Errorf("Expected only cpu to remain, got %+v", results)
+	}
+}
+
+func TestStorage_SealKeepsNewerWrites(t *testing.T) {
+	ctx := context.Background()
+	hot := memory.New()
+	s := newTiered(t, t.TempDir(), hot)
+	defer s.Close()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	start := now.Add(-5 * time.Hour).Truncate(time.Hour)
+	sealed := []metrics.Metric{
+		{Name: "cpu", Value: 1, Timestamp: start},
+		{Name: "cpu", Value: 2, Timestamp: start.Add(time.Minute)},
+	}
+	if err := s.Write(ctx, sealed); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Written into the window after it was read for sealing: a new sample
+	// and an overwrite
+	if err := s.Write(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 3, Timestamp: start.Add(2 * time.Minute)},
+		{Name: "cpu", Value: 20, Timestamp: start.Add(time.Minute)},
+	}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := s.removeSealed(ctx, start, start.Add(time.Hour), sealed); err != nil {
+		t.Fatalf("removeSealed failed: %v", err)
+	}
+
+	results, _ := hot.Query(ctx, storage.QueryRequest{Start: start, End: start.Add(time.Hour)})
+	values := map[float64]bool{}
+	for _, m := range results {
+		values[m.Value] = true
+	}
+	if len(results) != 2 || !values[3] || !values[20] {
+		t.Errorf("Hot tier kept %+v, want only the samples written after the read", results)
+	}
+}
+
+func TestStorage_ScanAcrossTiers(t *testing.T) {
+	ctx := context.Background()
+	s := newTiered(t, t.TempDir(), memory.New())
+	defer s.Close()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	host := map[string]string{"host": "a"}
+	var batch []metrics.Metric
+	for i := 0; i < 6; i++ {
+		batch = append(batch, metrics.Metric{Name: "cpu", Labels: host, Value: float64(i), Timestamp: now.Add(-time.Duration(6-i) * time.Hour)})
+	}
+	if err := s.Write(ctx, batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if n, err := s.Seal(ctx, now); err != nil || n != 4 {
+		t.Fatalf("Seal = %d, %v, want 4 blocks", n, err)
+	}
+
+	// One series, its sealed and hot samples in time order
+	req := storage.QueryRequest{Start: now.Add(-24 * time.Hour), End: now}
+	var scanned [][]metrics.Metric
+	if err := s.ScanSeries(ctx, req, func(series []metrics.Metric) error {
+		scanned = append(scanned, series)
+		return nil
+	}); err != nil {
+		t.Fatalf("ScanSeries failed: %v", err)
+	}
+	if len(scanned) != 1 || len(scanned[0]) != 6 {
+		t.Fatalf("Scanned %+v, want one series of 6 samples", scanned)
+	}
+	for i, m := range scanned[0] {
+		if m.Value != float64(i) {
+			t.Errorf("Sample %d = %v, want samples in time order", i, m.Value)
+		}
+	}
+
+	// A step spanning both tiers folds samples from both
+	req.Start, req.Step, req.Aggregation = now.Add(-6*time.Hour), 6*time.Hour, storage.AggregateSum
+	results, err := s.QueryDownsampled(ctx, req)
+	if err != nil {
+		t.Fatalf("QueryDownsampled failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Value != 15 {
+		t.Errorf("Downsampled = %+v, want one step summing all 6 samples (15)", results)
+	}
+
+	// Deletes count the samples removed from both tiers
+	deleted, err := s.DeleteCounted(ctx, storage.DeleteOptions{Before: now})
+	if err != nil {
+		t.Fatalf("DeleteCounted failed: %v", err)
+	}
+	if deleted != 6 {
+		t.Errorf("DeleteCounted = %d, want 6", deleted)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		// Ring as the hot tier: in memory, and supports duplicate policies
+		hot, err := ring.New(ring.Config{})
+		if err != nil {
+			t.Fatalf("Failed to create hot tier: %v", err)
+		}
+		return newTiered(t, t.TempDir(), hot)
+	})
+}
@@ -0,0 +1,25 @@
+package ring
+
+import "github.com/nicktill/tinyobs/pkg/storage"
+
+// Params: retention, chunk_samples, max_chunks, snapshot (file path, unset =
+// no snapshots) and snapshot_interval. BackendOptions.Path is not used.
+func init() {
+	storage.RegisterBackend("ring", func(opts storage.BackendOptions) (storage.Storage, error) {
+		cfg := Config{SnapshotPath: opts.String("snapshot", "")}
+		var err error
+		if cfg.Retention, err = opts.Duration("retention", DefaultRetention); err != nil {
+			return nil, err
+		}
+		if cfg.ChunkSamples, err = opts.Int("chunk_samples", DefaultChunkSamples); err != nil {
+			return nil, err
+		}
+		if cfg.MaxChunksPerSeries, err = opts.Int("max_chunks", DefaultMaxChunksPerSeries); err != nil {
+			return nil, err
+		}
+		if cfg.SnapshotInterval, err = opts.Duration("snapshot_interval", DefaultSnapshotInterval); err != nil {
+			return nil, err
+		}
+		return New(cfg)
+	})
+}
@@ -0,0 +1,129 @@
+package ring
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// sample is a single (timestamp, value) point of a series
+type sample struct {
+	ts    int64 // Unix nanos
+	value float64
+}
+
+// chunk is an immutable, compressed run of one series' samples.
+// Chunks are replaced, never modified, so queries and snapshots can hold
+// on to one without copying.
+type chunk struct {
+	minTs int64
+	maxTs int64
+	count int
+	data  []byte
+}
+
+// overlaps reports whether the chunk may hold samples in [start, end]
+func (c chunk) overlaps(start, end int64) bool {
+	return c.maxTs >= start && c.minTs <= end
+}
+
+// flateWriters reuses compressors: flate.NewWriter allocates ~1 MB of state
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// encodeChunk compresses samples (sorted by time).
+// Format (flate-compressed): [count uvarint][first ts varint][first value bits, 8 bytes]
+// then per sample [timestamp delta-of-delta varint][value XOR previous value, 8 bytes].
+// Regular scrape intervals make most delta-of-deltas 0 and slowly changing
+// values leave most XOR bytes 0, which flate then squeezes out.
+func encodeChunk(samples []sample) (chunk, error) {
+	if len(samples) == 0 {
+		return chunk{}, fmt.Errorf("cannot encode an empty chunk")
+	}
+
+	raw := make([]byte, 0, 2*binary.MaxVarintLen64+len(samples)*(binary.MaxVarintLen64+8))
+	raw = binary.AppendUvarint(raw, uint64(len(samples)))
+
+	var prevTs, prevDelta int64
+	var prevBits uint64
+	for i, s := range samples {
+		bits := math.Float64bits(s.value)
+		if i == 0 {
+			raw = binary.AppendVarint(raw, s.ts)
+			raw = binary.BigEndian.AppendUint64(raw, bits)
+		} else {
+			delta := s.ts - prevTs
+			raw = binary.AppendVarint(raw, delta-prevDelta)
+			raw = binary.BigEndian.AppendUint64(raw, bits^prevBits)
+			prevDelta = delta
+		}
+		prevTs, prevBits = s.ts, bits
+	}
+
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+
+	var buf bytes.Buffer
+	w.Reset(&buf)
+	if _, err := w.Write(raw); err != nil {
+		return chunk{}, fmt.Errorf("failed to compress chunk: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return chunk{}, fmt.Errorf("failed to compress chunk: %w", err)
+	}
+
+	return chunk{
+		minTs: samples[0].ts,
+		maxTs: samples[len(samples)-1].ts,
+		count: len(samples),
+		data:  bytes.Clone(buf.Bytes()),
+	}, nil
+}
+
+// decode decompresses the chunk's samples
+func (c chunk) decode() ([]sample, error) {
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(c.data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk: %w", err)
+	}
+
+	count, n := binary.Uvarint(raw)
+	if n <= 0 {
+		return nil, fmt.Errorf("corrupt chunk header")
+	}
+	raw = raw[n:]
+
+	// Every sample takes at least 9 bytes; don't trust count beyond that
+	if count > uint64(len(raw)/9) {
+		return nil, fmt.Errorf("corrupt chunk: %d samples in %d bytes", count, len(raw))
+	}
+
+	samples := make([]sample, 0, count)
+	var ts, delta int64
+	var bits uint64
+	for i := uint64(0); i < count; i++ {
+		v, n := binary.Varint(raw)
+		if n <= 0 || len(raw) < n+8 {
+			return nil, fmt.Errorf("corrupt chunk at sample %d", i)
+		}
+		if i == 0 {
+			ts = v
+			bits = binary.BigEndian.Uint64(raw[n : n+8])
+		} else {
+			delta += v
+			ts += delta
+			bits ^= binary.BigEndian.Uint64(raw[n : n+8])
+		}
+		raw = raw[n+8:]
+		samples = append(samples, sample{ts: ts, value: math.Float64frombits(bits)})
+	}
+	return samples, nil
+}
@@ -0,0 +1,437 @@
+// Package ring implements a bounded in-memory storage backend for edge
+// deployments: each series keeps a ring buffer of compressed chunks covering
+// a configurable duration, optionally snapshotted to disk periodically.
+package ring
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// Defaults for the ring buffers
+const (
+	DefaultRetention          = 2 * time.Hour   // History kept per series
+	DefaultChunkSamples       = 120             // Samples per compressed chunk (30 min at 15s)
+	DefaultMaxChunksPerSeries = 64              // Hard cap per series, whatever the sample rate
+	DefaultSnapshotInterval   = 5 * time.Minute // When SnapshotPath is set
+	sweepInterval             = 1 * time.Minute // Drops series that stopped reporting
+	checkEvery                = 1000            // Samples between context checks
+)
+
+// Config holds ring buffer storage configuration
+type Config struct {
+	// Retention is how far back from its newest sample each series is kept (default: 2h).
+	// Series that receive nothing for Retention are dropped.
+	Retention time.Duration
+
+	// ChunkSamples is the number of samples compressed into one chunk (default: 120)
+	ChunkSamples int
+
+	// MaxChunksPerSeries bounds each series' ring; when full the oldest
+	// chunk is overwritten even if it is within Retention (default: 64)
+	MaxChunksPerSeries int
+
+	// SnapshotPath is a file the data is periodically written to and
+	// loaded from on startup (optional, "" = data is lost on restart)
+	SnapshotPath string
+
+	// SnapshotInterval is how often the snapshot is written (default: 5m)
+	SnapshotInterval time.Duration
+}
+
+// Storage keeps recent metrics in memory, bounded per series by duration
+// and chunk count. Unlike the memory backend it never rejects writes: old
+// data ages out instead.
+type Storage struct {
+	cfg Config
+
+	mu     sync.RWMutex
+	series map[string]*series // seriesKey -> series
+
+	// Serializes snapshot writes (periodic and on Close)
+	snapshotMu sync.Mutex
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New creates a ring buffer storage backend. If cfg.SnapshotPath exists it
+// is loaded first.
+func New(cfg Config) (*Storage, error) {
+	if cfg.Retention <= 0 {
+		cfg.Retention = DefaultRetention
+	}
+	if cfg.ChunkSamples <= 0 {
+		cfg.ChunkSamples = DefaultChunkSamples
+	}
+	if cfg.MaxChunksPerSeries <= 0 {
+		cfg.MaxChunksPerSeries = DefaultMaxChunksPerSeries
+	}
+	if cfg.SnapshotInterval <= 0 {
+		cfg.SnapshotInterval = DefaultSnapshotInterval
+	}
+
+	s := &Storage{
+		cfg:    cfg,
+		series: make(map[string]*series),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if cfg.SnapshotPath != "" {
+		loaded, err := s.loadSnapshot()
+		if err != nil {
+			return nil, err
+		}
+		if loaded > 0 {
+			log.Printf("Ring storage: loaded %d series from %s", loaded, cfg.SnapshotPath)
+		}
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// run sweeps idle series and writes periodic snapshots until Close
+func (s *Storage) run() {
+	defer close(s.done)
+
+	sweep := time.NewTicker(sweepInterval)
+	defer sweep.Stop()
+
+	var snapshots <-chan time.Time
+	if s.cfg.SnapshotPath != "" {
+		ticker := time.NewTicker(s.cfg.SnapshotInterval)
+		defer ticker.Stop()
+		snapshots = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-sweep.C:
+			s.sweep(now)
+		case <-snapshots:
+			if err := s.WriteSnapshot(); err != nil {
+				log.Printf("Ring storage snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+// sweep drops series whose newest sample is older than the retention
+func (s *Storage) sweep(now time.Time) {
+	cutoff := now.Add(-s.cfg.Retention).UnixNano()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, sr := range s.series {
+		if sr.newest < cutoff {
+			delete(s.series, key)
+		}
+	}
+}
+
+// Write stores metrics (last write wins for duplicates)
+func (s *Storage) Write(ctx context.Context, metrics []metrics.Metric) error {
+	_, err := s.WriteWithPolicy(ctx, metrics, storage.LastWriteWins)
+	return err
+}
+
+// WriteWithPolicy stores metrics, resolving samples whose series and
+// timestamp are already stored with the duplicate policy
+func (s *Storage) WriteWithPolicy(ctx context.Context, metrics []metrics.Metric, policy storage.DuplicatePolicy) (storage.WriteResult, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.WriteResult{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result storage.WriteResult
+	for i, m := range metrics {
+		if i%checkEvery == 0 && i > 0 {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+		}
+
+		key := seriesKey(m.Name, m.Labels)
+		sr, ok := s.series[key]
+		if !ok {
+			sr = &series{name: m.Name, labels: copyLabels(m.Labels)}
+			s.series[key] = sr
+		}
+		sr.typ = m.Type
+
+		ts := m.Timestamp.UnixNano()
+		r, err := sr.insert(sample{ts: ts, value: m.Value}, policy, s.cfg)
+		if err != nil {
+			return result, fmt.Errorf("failed to write %s: %w", m.Name, err)
+		}
+		if r.Added+r.Overwritten > 0 {
+			sr.setAggregate(ts, m.Aggregate)
+		}
+		result.Added += r.Added
+		result.Overwritten += r.Overwritten
+		result.Dropped += r.Dropped
+
+		if sr.count == 0 {
+			delete(s.series, key) // Everything aged out of the ring
+		}
+	}
+	return result, nil
+}
+
+// Query retrieves metrics matching the request, ordered by series then time
+func (s *Storage) Query(ctx context.Context, req storage.QueryRequest) ([]metrics.Metric, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.series))
+	for key, sr := range s.series {
+		if matchesSeries(sr, req) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start, end := req.Start.UnixNano(), req.End.UnixNano()
+	var results []metrics.Metric
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		sr := s.series[key]
+		err := sr.samples(start, end, func(smp sample) bool {
+			results = append(results, sr.metric(smp))
+			return req.Limit <= 0 || len(results) < req.Limit
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", sr.name, err)
+		}
+		if req.Limit > 0 && len(results) >= req.Limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+// QueryDownsampled aggregates samples into req.Step steps while reading
+// the chunks, without materializing the raw samples
+func (s *Storage) QueryDownsampled(ctx context.Context, req storage.QueryRequest) ([]metrics.Metric, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d := storage.NewDownsampler(req)
+	start, end := req.Start.UnixNano(), req.End.UnixNano()
+	for _, sr := range s.series {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !matchesSeries(sr, req) {
+			continue
+		}
+		err := sr.samples(start, end, func(smp sample) bool {
+			d.Add(sr.metric(smp))
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", sr.name, err)
+		}
+	}
+	return d.Result(), nil
+}
+
+// matchesSeries checks a series against the query's name and label filters
+func matchesSeries(sr *series, req storage.QueryRequest) bool {
+	if len(req.MetricNames) > 0 {
+		found := false
+		for _, name := range req.MetricNames {
+			if sr.name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, v := range req.Labels {
+		if got, ok := sr.labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Delete removes metrics matching the deletion criteria
+func (s *Storage) Delete(ctx context.Context, opts storage.DeleteOptions) error {
+	_, err := s.DeleteCounted(ctx, opts)
+	return err
+}
+
+// DeleteCounted removes metrics matching the deletion criteria and returns
+// how many samples were removed
+func (s *Storage) DeleteCounted(ctx context.Context, opts storage.DeleteOptions) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteLocked(ctx, opts)
+}
+
+// deleteLocked removes matching samples series by series and returns how
+// many were removed
+// MUST be called with lock held
+func (s *Storage) deleteLocked(ctx context.Context, opts storage.DeleteOptions) (int, error) {
+	name, pinned := storage.MetricNameFromMatchers(opts.Matchers)
+	var deleted int
+	for key, sr := range s.series {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		if pinned && sr.name != name {
+			continue
+		}
+		n, err := sr.deleteMatching(opts)
+		deleted += n
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete from %s: %w", sr.name, err)
+		}
+		if sr.count == 0 {
+			delete(s.series, key)
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteSeries removes samples of matching series in [start, end).
+// Ring storage deletes immediately, so the returned tombstone is never
+// persisted and there is nothing left for CleanTombstones to purge.
+func (s *Storage) DeleteSeries(ctx context.Context, matchers []storage.Matcher, start, end time.Time) (*storage.Tombstone, error) {
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("at least one matcher is required")
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("invalid time range: end (%v) must be after start (%v)", end, start)
+	}
+
+	t := storage.Tombstone{
+		ID:        strconv.FormatInt(time.Now().UnixNano(), 36),
+		Matchers:  matchers,
+		Start:     start,
+		End:       end,
+		CreatedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.deleteLocked(ctx, t.DeleteOptions()); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Tombstones always returns nil: ring storage deletes immediately
+func (s *Storage) Tombstones() []storage.Tombstone {
+	return nil
+}
+
+// CleanTombstones is a no-op for ring storage
+func (s *Storage) CleanTombstones(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+// Stats returns storage statistics. SizeBytes is the in-memory footprint
+// of the compressed chunks and heads.
+func (s *Storage) Stats(ctx context.Context) (*storage.Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := &storage.Stats{
+		TotalSeries: uint64(len(s.series)),
+		Metrics:     make(map[string]storage.MetricStats),
+	}
+	first := true
+	for _, sr := range s.series {
+		stats.TotalMetrics += uint64(sr.count)
+		stats.SizeBytes += sr.sizeBytes()
+
+		oldest, newest := time.Unix(0, sr.oldest()), time.Unix(0, sr.newest)
+		ms := storage.MetricStats{Series: 1, Samples: uint64(sr.count), Oldest: oldest, Newest: newest}
+		stats.AddSeries(sr.name, storage.ResolutionOf(sr.labels), ms)
+		if first || oldest.Before(stats.OldestMetric) {
+			stats.OldestMetric = oldest
+		}
+		if first || newest.After(stats.NewestMetric) {
+			stats.NewestMetric = newest
+		}
+		first = false
+	}
+	return stats, nil
+}
+
+// Close stops the background loop and writes a final snapshot
+func (s *Storage) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+		if s.cfg.SnapshotPath != "" {
+			err = s.WriteSnapshot()
+		}
+	})
+	return err
+}
+
+// seriesKey creates a unique key for a time series (metric name + sorted labels)
+func seriesKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	key := name
+	for _, k := range keys {
+		key += "," + k + "=" + labels[k]
+	}
+	return key
+}
+
+// copyLabels copies labels so callers can't mutate a stored series
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	cp := make(map[string]string, len(labels))
+	for k, v := range labels {
+		cp[k] = v
+	}
+	return cp
+}
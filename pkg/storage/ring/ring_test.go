@@ -0,0 +1,273 @@
+package ring
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/storagetest"
+)
+
+func newStore(t *testing.T, cfg Config) *Storage {
+	t.Helper()
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// writeSeries writes n samples of one series, interval apart, starting at start
+func writeSeries(t *testing.T, store *Storage, name string, start time.Time, interval time.Duration, n int) {
+	t.Helper()
+	batch := make([]metrics.Metric, n)
+	for i := range batch {
+		batch[i] = metrics.Metric{Name: name, Value: float64(i), Timestamp: start.Add(time.Duration(i) * interval)}
+	}
+	if err := store.Write(context.Background(), batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+}
+
+func TestChunkRoundTrip(t *testing.T) {
+	samples := []sample{
+		{ts: 1000, value: 1.5},
+		{ts: 16000, value: 1.5},
+		{ts: 31000, value: -2},
+		{ts: 47000, value: math.Inf(1)},
+		{ts: 47001, value: 0},
+	}
+	c, err := encodeChunk(samples)
+	if err != nil {
+		t.Fatalf("encodeChunk failed: %v", err)
+	}
+	if c.minTs != 1000 || c.maxTs != 47001 || c.count != 5 {
+		t.Errorf("Unexpected chunk bounds: %+v", c)
+	}
+
+	decoded, err := c.decode()
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if len(decoded) != len(samples) {
+		t.Fatalf("Expected %d samples, got %d", len(samples), len(decoded))
+	}
+	for i := range samples {
+		if decoded[i] != samples[i] {
+			t.Errorf("Sample %d: expected %+v, got %+v", i, samples[i], decoded[i])
+		}
+	}
+}
+
+func TestChunkCompression(t *testing.T) {
+	// A regularly scraped, slowly changing series compresses far below 16 bytes/sample
+	samples := make([]sample, 120)
+	for i := range samples {
+		samples[i] = sample{ts: int64(i) * int64(15*time.Second), value: float64(100 + i%3)}
+	}
+	c, err := encodeChunk(samples)
+	if err != nil {
+		t.Fatalf("encodeChunk failed: %v", err)
+	}
+	if len(c.data) > len(samples)*4 {
+		t.Errorf("Expected under 4 bytes/sample, got %d bytes for %d samples", len(c.data), len(samples))
+	}
+}
+
+func TestStorage_WriteQueryAcrossChunks(t *testing.T) {
+	store := newStore(t, Config{ChunkSamples: 10})
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+
+	writeSeries(t, store, "cpu", start, time.Second, 35)
+
+	results, err := store.Query(ctx, storage.QueryRequest{Start: start, End: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 35 {
+		t.Fatalf("Expected 35 samples, got %d", len(results))
+	}
+	for i, m := range results {
+		if m.Value != float64(i) || !m.Timestamp.Equal(start.Add(time.Duration(i)*time.Second)) {
+			t.Fatalf("Sample %d out of order: %+v", i, m)
+		}
+	}
+
+	// Time range spanning a chunk boundary, and limits
+	results, _ = store.Query(ctx, storage.QueryRequest{Start: start.Add(8 * time.Second), End: start.Add(12 * time.Second)})
+	if len(results) != 5 {
+		t.Errorf("Expected 5 samples in range, got %d", len(results))
+	}
+	results, _ = store.Query(ctx, storage.QueryRequest{Start: start, End: start.Add(time.Hour), Limit: 3})
+	if len(results) != 3 {
+		t.Errorf("Expected limit of 3, got %d", len(results))
+	}
+}
+
+func TestStorage_LateSamplesAndDuplicates(t *testing.T) {
+	store := newStore(t, Config{ChunkSamples: 10})
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+
+	writeSeries(t, store, "cpu", start, time.Second, 20)
+
+	// A late sample lands in a sealed chunk, a duplicate overwrites
+	result, err := store.WriteWithPolicy(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 100, Timestamp: start.Add(2500 * time.Millisecond)},
+		{Name: "cpu", Value: 200, Timestamp: start.Add(3 * time.Second)},
+	}, storage.LastWriteWins)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if result.Added != 1 || result.Overwritten != 1 {
+		t.Errorf("Expected 1 added and 1 overwritten, got %+v", result)
+	}
+
+	result, _ = store.WriteWithPolicy(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 300, Timestamp: start.Add(3 * time.Second)},
+	}, storage.FirstWriteWins)
+	if result.Dropped != 1 {
+		t.Errorf("Expected first write to win, got %+v", result)
+	}
+
+	results, _ := store.Query(ctx, storage.QueryRequest{Start: start.Add(2 * time.Second), End: start.Add(3 * time.Second)})
+	if len(results) != 3 || results[1].Value != 100 || results[2].Value != 200 {
+		t.Errorf("Unexpected samples after late writes: %+v", results)
+	}
+
+	stats, _ := store.Stats(ctx)
+	if stats.TotalMetrics != 21 || stats.TotalSeries != 1 {
+		t.Errorf("Expected 21 samples in 1 series, got %d in %d", stats.TotalMetrics, stats.TotalSeries)
+	}
+}
+
+func TestStorage_RetentionBound(t *testing.T) {
+	store := newStore(t, Config{Retention: time.Minute, ChunkSamples: 10})
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+
+	// 5 minutes of data: only the last minute (plus the open chunk) survives
+	writeSeries(t, store, "cpu", start, time.Second, 300)
+
+	stats, _ := store.Stats(ctx)
+	if stats.TotalMetrics > 70 || stats.TotalMetrics < 60 {
+		t.Errorf("Expected about 60 samples retained, got %d", stats.TotalMetrics)
+	}
+	cutoff := start.Add(299*time.Second - time.Minute - 10*time.Second)
+	if stats.OldestMetric.Before(cutoff) {
+		t.Errorf("Oldest sample %v is outside retention (cutoff %v)", stats.OldestMetric, cutoff)
+	}
+}
+
+func TestStorage_MaxChunksBound(t *testing.T) {
+	store := newStore(t, Config{ChunkSamples: 10, MaxChunksPerSeries: 3})
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+
+	writeSeries(t, store, "cpu", start, time.Millisecond, 1000)
+
+	stats, _ := store.Stats(ctx)
+	if stats.TotalMetrics != 30 {
+		t.Errorf("Expected 3 full chunks retained, got %d samples", stats.TotalMetrics)
+	}
+	results, _ := store.Query(ctx, storage.QueryRequest{Start: start, End: start.Add(time.Hour)})
+	if len(results) != 30 || results[0].Value != 970 {
+		t.Errorf("Expected the newest 30 samples, got %d starting at %v", len(results), results[0].Value)
+	}
+}
+
+func TestStorage_Delete(t *testing.T) {
+	store := newStore(t, Config{ChunkSamples: 10})
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+
+	writeSeries(t, store, "cpu", start, time.Second, 25)
+	writeSeries(t, store, "mem", start, time.Second, 5)
+
+	// Delete the first 12 cpu samples (one full chunk, part of another)
+	if err := store.Delete(ctx, storage.DeleteOptions{
+		Before:   start.Add(12 * time.Second),
+		Matchers: []storage.Matcher{{Type: storage.MatchEqual, Name: storage.MetricNameLabel, Value: "cpu"}},
+	}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	results, _ := store.Query(ctx, storage.QueryRequest{Start: start, End: start.Add(time.Hour), MetricNames: []string{"cpu"}})
+	if len(results) != 13 || results[0].Value != 12 {
+		t.Errorf("Expected 13 cpu samples from 12 on, got %d", len(results))
+	}
+
+	// Deleting everything of a series removes it
+	if _, err := store.DeleteSeries(ctx, []storage.Matcher{{Type: storage.MatchEqual, Name: storage.MetricNameLabel, Value: "mem"}}, start, start.Add(time.Hour)); err != nil {
+		t.Fatalf("DeleteSeries failed: %v", err)
+	}
+	stats, _ := store.Stats(ctx)
+	if stats.TotalSeries != 1 || stats.TotalMetrics != 13 {
+		t.Errorf("Expected 1 series with 13 samples, got %d with %d", stats.TotalSeries, stats.TotalMetrics)
+	}
+}
+
+func TestStorage_Sweep(t *testing.T) {
+	store := newStore(t, Config{Retention: time.Hour})
+	ctx := context.Background()
+	now := time.Now()
+
+	writeSeries(t, store, "stale", now.Add(-2*time.Hour), time.Second, 5)
+	writeSeries(t, store, "live", now, time.Second, 5)
+
+	store.sweep(now)
+
+	stats, _ := store.Stats(ctx)
+	if stats.TotalSeries != 1 || stats.Metrics["live"].Samples != 5 {
+		t.Errorf("Expected only the live series to survive, got %+v", stats.Metrics)
+	}
+}
+
+func TestStorage_SnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ring.snap")
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+
+	store, err := New(Config{SnapshotPath: path, ChunkSamples: 10})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	writeSeries(t, store, "cpu", start, time.Second, 25) // 2 chunks + 5 in the head
+	store.Write(ctx, []metrics.Metric{{Name: "mem", Type: metrics.GaugeType, Value: 7, Labels: map[string]string{"host": "a"}, Timestamp: start}})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	reopened := newStore(t, Config{SnapshotPath: path, ChunkSamples: 10})
+	stats, _ := reopened.Stats(ctx)
+	if stats.TotalSeries != 2 || stats.TotalMetrics != 26 {
+		t.Fatalf("Expected 2 series and 26 samples after reload, got %d and %d", stats.TotalSeries, stats.TotalMetrics)
+	}
+
+	results, _ := reopened.Query(ctx, storage.QueryRequest{Start: start, End: start.Add(time.Hour), Labels: map[string]string{"host": "a"}})
+	if len(results) != 1 || results[0].Value != 7 || results[0].Type != metrics.GaugeType {
+		t.Errorf("Unexpected mem sample after reload: %+v", results)
+	}
+
+	// Reloaded chunks accept new and late samples like any other
+	writeSeries(t, reopened, "cpu", start.Add(25*time.Second), time.Second, 10)
+	results, _ = reopened.Query(ctx, storage.QueryRequest{Start: start, End: start.Add(time.Hour), MetricNames: []string{"cpu"}})
+	if len(results) != 35 {
+		t.Errorf("Expected 35 cpu samples, got %d", len(results))
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		store, err := New(Config{ChunkSamples: 4}) // Small chunks so tests span several
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		return store
+	})
+}
@@ -0,0 +1,303 @@
+package ring
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// series holds one time series: a ring of sealed chunks (oldest first) and
+// an uncompressed head that new samples are appended to
+type series struct {
+	name   string
+	typ    metrics.MetricType
+	labels map[string]string
+
+	// Ring buffer: chunks[(start+i) % len(chunks)] is the i-th oldest chunk.
+	// It grows up to maxChunks, then the oldest chunk is overwritten.
+	chunks []chunk
+	start  int
+	n      int
+
+	head   []sample // Sorted by time
+	count  int      // Samples in chunks and head
+	newest int64    // Newest sample (Unix nanos)
+
+	// Statistics of compacted samples by timestamp (aggregate series only)
+	aggregates map[int64]*metrics.AggregateStats
+}
+
+// at returns the i-th oldest chunk
+func (s *series) at(i int) *chunk {
+	return &s.chunks[(s.start+i)%len(s.chunks)]
+}
+
+// push appends a sealed chunk, overwriting the oldest one if the ring is
+// full. Returns the number of samples dropped.
+func (s *series) push(c chunk, maxChunks int) int {
+	if s.n < len(s.chunks) {
+		*s.at(s.n) = c
+		s.n++
+		return 0
+	}
+	if len(s.chunks) < maxChunks {
+		if s.start != 0 { // Unwrap before growing
+			s.chunks = append(s.chunks[s.start:], s.chunks[:s.start]...)
+			s.start = 0
+		}
+		s.chunks = append(s.chunks, c)
+		s.n++
+		return 0
+	}
+	oldest := s.at(0)
+	dropped := oldest.count
+	*oldest = c
+	s.start = (s.start + 1) % len(s.chunks)
+	return dropped
+}
+
+// dropOldest removes the oldest chunk
+func (s *series) dropOldest() {
+	s.count -= s.at(0).count
+	*s.at(0) = chunk{}
+	s.start = (s.start + 1) % len(s.chunks)
+	s.n--
+	if s.n == 0 {
+		s.chunks, s.start = nil, 0
+	}
+}
+
+// expire drops chunks that fall entirely outside the retention window,
+// measured back from the series' newest sample
+func (s *series) expire(retention time.Duration) {
+	cutoff := s.newest - int64(retention)
+	for s.n > 0 && s.at(0).maxTs < cutoff {
+		s.dropOldest()
+	}
+}
+
+// insert adds a sample, resolving an existing sample at the same timestamp
+// with the duplicate policy. Late samples older than the head are merged
+// into the sealed chunk covering their timestamp.
+func (s *series) insert(smp sample, policy storage.DuplicatePolicy, cfg Config) (storage.WriteResult, error) {
+	var result storage.WriteResult
+
+	if s.n == 0 || smp.ts > s.at(s.n-1).maxTs {
+		s.head, result = insertSorted(s.head, smp, policy)
+	} else {
+		// Newest chunk starting at or before the sample (or the oldest chunk)
+		i := s.n - 1
+		for i > 0 && s.at(i).minTs > smp.ts {
+			i--
+		}
+		samples, err := s.at(i).decode()
+		if err != nil {
+			return result, err
+		}
+		samples, result = insertSorted(samples, smp, policy)
+		if result.Added+result.Overwritten > 0 {
+			c, err := encodeChunk(samples)
+			if err != nil {
+				return storage.WriteResult{}, err
+			}
+			*s.at(i) = c
+		}
+	}
+
+	if result.Added > 0 {
+		s.count++
+		if smp.ts > s.newest || s.count == 1 {
+			s.newest = smp.ts
+		}
+	}
+
+	if len(s.head) >= cfg.ChunkSamples {
+		c, err := encodeChunk(s.head)
+		if err != nil {
+			return result, err
+		}
+		s.count -= s.push(c, cfg.MaxChunksPerSeries)
+		s.head = nil
+	}
+	s.expire(cfg.Retention)
+	s.pruneAggregates()
+	return result, nil
+}
+
+// setAggregate records the statistics of the sample written at ts
+func (s *series) setAggregate(ts int64, agg *metrics.AggregateStats) {
+	if agg == nil {
+		delete(s.aggregates, ts)
+		return
+	}
+	if s.aggregates == nil {
+		s.aggregates = make(map[int64]*metrics.AggregateStats)
+	}
+	s.aggregates[ts] = agg
+}
+
+// pruneAggregates drops the statistics of samples that aged out
+func (s *series) pruneAggregates() {
+	if len(s.aggregates) == 0 {
+		return
+	}
+	oldest := s.oldest()
+	for ts := range s.aggregates {
+		if ts < oldest {
+			delete(s.aggregates, ts)
+		}
+	}
+}
+
+// insertSorted inserts a sample into a time-sorted slice
+func insertSorted(samples []sample, smp sample, policy storage.DuplicatePolicy) ([]sample, storage.WriteResult) {
+	i := sort.Search(len(samples), func(i int) bool { return samples[i].ts >= smp.ts })
+	if i < len(samples) && samples[i].ts == smp.ts {
+		if policy == storage.FirstWriteWins {
+			return samples, storage.WriteResult{Dropped: 1}
+		}
+		samples[i] = smp
+		return samples, storage.WriteResult{Overwritten: 1}
+	}
+
+	samples = append(samples, sample{})
+	copy(samples[i+1:], samples[i:])
+	samples[i] = smp
+	return samples, storage.WriteResult{Added: 1}
+}
+
+// metric returns a sample of the series
+func (s *series) metric(smp sample) metrics.Metric {
+	return metrics.Metric{
+		Name:      s.name,
+		Type:      s.typ,
+		Value:     smp.value,
+		Labels:    s.labels,
+		Timestamp: time.Unix(0, smp.ts),
+		Aggregate: s.aggregates[smp.ts],
+	}
+}
+
+// samples calls fn with every sample in [start, end], oldest first
+func (s *series) samples(start, end int64, fn func(sample) bool) error {
+	for i := 0; i < s.n; i++ {
+		c := s.at(i)
+		if !c.overlaps(start, end) {
+			continue
+		}
+		decoded, err := c.decode()
+		if err != nil {
+			return err
+		}
+		for _, smp := range decoded {
+			if smp.ts >= start && smp.ts <= end && !fn(smp) {
+				return nil
+			}
+		}
+	}
+	for _, smp := range s.head {
+		if smp.ts >= start && smp.ts <= end && !fn(smp) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// oldest returns the oldest sample's timestamp
+func (s *series) oldest() int64 {
+	if s.n > 0 {
+		return s.at(0).minTs
+	}
+	if len(s.head) > 0 {
+		return s.head[0].ts
+	}
+	return s.newest
+}
+
+// deleteMatching removes samples matching the deletion criteria.
+// Returns the number of samples removed.
+func (s *series) deleteMatching(opts storage.DeleteOptions) (int, error) {
+	from := int64(0)
+	if !opts.From.IsZero() {
+		from = opts.From.UnixNano()
+	}
+	before := opts.Before.UnixNano()
+
+	filter := func(samples []sample) []sample {
+		kept := samples[:0]
+		for _, smp := range samples {
+			if !opts.Matches(s.metric(smp)) {
+				kept = append(kept, smp)
+			} else {
+				delete(s.aggregates, smp.ts)
+			}
+		}
+		return kept
+	}
+
+	removed := 0
+	var chunks []chunk
+	for i := 0; i < s.n; i++ {
+		c := *s.at(i)
+		if c.maxTs < from || c.minTs >= before {
+			chunks = append(chunks, c)
+			continue
+		}
+		decoded, err := c.decode()
+		if err != nil {
+			return removed, err
+		}
+		kept := filter(decoded)
+		removed += len(decoded) - len(kept)
+		if len(kept) == len(decoded) {
+			chunks = append(chunks, c)
+		} else if len(kept) > 0 {
+			rewritten, err := encodeChunk(kept)
+			if err != nil {
+				return removed, err
+			}
+			chunks = append(chunks, rewritten)
+		}
+	}
+
+	headBefore := len(s.head)
+	s.head = filter(s.head)
+	removed += headBefore - len(s.head)
+
+	// Rebuild the ring without emptied chunks
+	s.chunks, s.start, s.n = chunks, 0, len(chunks)
+	s.count -= removed
+
+	if removed > 0 && s.count > 0 {
+		s.newest = s.newestSample()
+	}
+	return removed, nil
+}
+
+// newestSample finds the newest sample (after a delete)
+func (s *series) newestSample() int64 {
+	if len(s.head) > 0 {
+		return s.head[len(s.head)-1].ts
+	}
+	if s.n > 0 {
+		return s.at(s.n - 1).maxTs
+	}
+	return 0
+}
+
+// sizeBytes estimates the series' memory footprint
+func (s *series) sizeBytes() uint64 {
+	size := uint64(len(s.name)) + uint64(len(s.head))*16
+	for k, v := range s.labels {
+		size += uint64(len(k) + len(v))
+	}
+	for i := 0; i < s.n; i++ {
+		size += uint64(len(s.at(i).data))
+	}
+	for _, agg := range s.aggregates {
+		size += 64 + uint64(len(agg.Sketch))
+	}
+	return size
+}
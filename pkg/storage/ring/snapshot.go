@@ -0,0 +1,155 @@
+package ring
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+)
+
+// snapshotVersion is bumped when the snapshot layout changes
+const snapshotVersion = 1
+
+// persistedSnapshot is the on-disk form of the ring buffers (gob-encoded).
+// Chunks are written as-is; heads are compressed into one more chunk.
+type persistedSnapshot struct {
+	Version int
+	Series  []persistedSeries
+}
+
+type persistedSeries struct {
+	Name   string
+	Type   metrics.MetricType
+	Labels map[string]string
+	Chunks []persistedChunk // Oldest first, head last
+
+	// Statistics of compacted samples by timestamp (absent in older snapshots)
+	Aggregates map[int64]*metrics.AggregateStats
+}
+
+type persistedChunk struct {
+	MinTs int64
+	MaxTs int64
+	Count int
+	Data  []byte
+}
+
+// WriteSnapshot writes every series to cfg.SnapshotPath. The file is
+// written under a temp name and renamed, so a crash never leaves a torn
+// snapshot behind.
+func (s *Storage) WriteSnapshot() error {
+	if s.cfg.SnapshotPath == "" {
+		return fmt.Errorf("ring storage: no snapshot path configured")
+	}
+
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	// Chunks are immutable, so holding the lock only to collect them is enough
+	snap, err := s.collectSnapshot()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.cfg.SnapshotPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".ring-*")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	err = gob.NewEncoder(tmp).Encode(snap)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.cfg.SnapshotPath); err != nil {
+		return fmt.Errorf("failed to commit snapshot: %w", err)
+	}
+	return nil
+}
+
+// collectSnapshot gathers the chunks of every series under the read lock.
+// Aggregate statistics are copied: writers keep updating them.
+func (s *Storage) collectSnapshot() (*persistedSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snap := &persistedSnapshot{
+		Version: snapshotVersion,
+		Series:  make([]persistedSeries, 0, len(s.series)),
+	}
+	for _, sr := range s.series {
+		ps := persistedSeries{Name: sr.name, Type: sr.typ, Labels: sr.labels, Aggregates: maps.Clone(sr.aggregates)}
+		for i := 0; i < sr.n; i++ {
+			ps.Chunks = append(ps.Chunks, persistChunk(*sr.at(i)))
+		}
+		if len(sr.head) > 0 {
+			head, err := encodeChunk(sr.head)
+			if err != nil {
+				return nil, err
+			}
+			ps.Chunks = append(ps.Chunks, persistChunk(head))
+		}
+		snap.Series = append(snap.Series, ps)
+	}
+	return snap, nil
+}
+
+func persistChunk(c chunk) persistedChunk {
+	return persistedChunk{MinTs: c.minTs, MaxTs: c.maxTs, Count: c.count, Data: c.data}
+}
+
+// loadSnapshot restores series from cfg.SnapshotPath. A missing file is
+// not an error. Returns the number of series loaded.
+func (s *Storage) loadSnapshot() (int, error) {
+	f, err := os.Open(s.cfg.SnapshotPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	var snap persistedSnapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return 0, fmt.Errorf("failed to decode snapshot %s: %w", s.cfg.SnapshotPath, err)
+	}
+	if snap.Version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d in %s", snap.Version, s.cfg.SnapshotPath)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ps := range snap.Series {
+		sr := &series{name: ps.Name, typ: ps.Type, labels: ps.Labels, aggregates: ps.Aggregates}
+		for _, pc := range ps.Chunks {
+			sr.count += pc.Count
+			sr.count -= sr.push(chunk{minTs: pc.MinTs, maxTs: pc.MaxTs, count: pc.Count, data: pc.Data}, s.cfg.MaxChunksPerSeries)
+			if pc.MaxTs > sr.newest || sr.n == 1 {
+				sr.newest = pc.MaxTs
+			}
+		}
+		if sr.count == 0 {
+			continue
+		}
+		sr.expire(s.cfg.Retention)
+		sr.pruneAggregates()
+		s.series[seriesKey(sr.name, sr.labels)] = sr
+	}
+	return len(s.series), nil
+}
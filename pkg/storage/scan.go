@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+)
+
+// SeriesScanner is implemented by backends that can stream a query one
+// series at a time, so callers walking a large range never hold more than
+// one series' samples
+type SeriesScanner interface {
+	// ScanSeries calls fn with the samples of each series matching req,
+	// in time order, until fn returns an error. req.Limit is ignored.
+	// The slice passed to fn is not reused after fn returns.
+	ScanSeries(ctx context.Context, req QueryRequest, fn func(series []metrics.Metric) error) error
+}
+
+// ScanSeries streams the series matching req to fn, one series at a time
+// with its samples in time order. Backends implementing SeriesScanner
+// stream from their scan; for the rest the range is queried one metric
+// name at a time, so memory is bounded by the largest metric name rather
+// than everything stored. An error from fn stops the scan and is returned.
+func ScanSeries(ctx context.Context, s Storage, req QueryRequest, fn func(series []metrics.Metric) error) error {
+	if ss, ok := s.(SeriesScanner); ok {
+		return ss.ScanSeries(ctx, req, fn)
+	}
+
+	names := req.MetricNames
+	if len(names) == 0 {
+		stats, err := s.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		names = stats.NamesBetween(req.Start, req.End)
+	}
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		one := req
+		one.MetricNames, one.Limit = []string{name}, 0
+		results, err := s.Query(ctx, one)
+		if err != nil {
+			return err
+		}
+
+		series := make(map[string][]metrics.Metric)
+		for _, m := range results {
+			key := downsampleKey(m.Name, m.Labels)
+			series[key] = append(series[key], m)
+		}
+		keys := make([]string, 0, len(series))
+		for key := range series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := fn(sortByTime(series[key])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// NamesBetween lists the metric names that may have samples in [start,
+// end], sorted. Names whose stored span misses the range are skipped; a
+// zero end means no upper bound.
+func (s *Stats) NamesBetween(start, end time.Time) []string {
+	var names []string
+	for name, ms := range s.Metrics {
+		if !ms.Newest.IsZero() && (ms.Newest.Before(start) || (!end.IsZero() && ms.Oldest.After(end))) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// SketchAccuracy is the relative accuracy of sketch quantiles: a quantile
+// is within 1% of the true value at that rank
+const SketchAccuracy = 0.01
+
+// sketchMaxBins bounds a sketch's size. Values from 1e-9 to 1e9 fit in
+// about 2,100 bins; past the limit, the lowest bins are collapsed.
+const sketchMaxBins = 2048
+
+// sketchMinValue is the smallest magnitude given its own bins; smaller
+// values count as zero
+const sketchMinValue = 1e-9
+
+var (
+	sketchGamma    = (1 + SketchAccuracy) / (1 - SketchAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// Sketch is a mergeable quantile sketch (DDSketch). Values are counted in
+// logarithmically sized bins, so any quantile is estimated within
+// SketchAccuracy of the true value, and sketches of adjacent buckets merge
+// exactly into the sketch of the whole range.
+type Sketch struct {
+	positive map[int]uint64 // Bin index -> count, for values > 0
+	negative map[int]uint64 // Bin index of -value -> count, for values < 0
+	zero     uint64
+	count    uint64
+	min, max float64
+}
+
+// NewSketch returns an empty sketch
+func NewSketch() *Sketch {
+	return &Sketch{positive: make(map[int]uint64), negative: make(map[int]uint64)}
+}
+
+// sketchIndex is the bin of a positive value: bin i holds (γ^(i-1), γ^i]
+func sketchIndex(v float64) int {
+	return int(math.Ceil(math.Log(v) / sketchLogGamma))
+}
+
+// sketchValue is the value standing in for bin i, within SketchAccuracy
+// of every value in the bin
+func sketchValue(i int) float64 {
+	return 2 * math.Pow(sketchGamma, float64(i)) / (sketchGamma + 1)
+}
+
+// Add counts a value
+func (s *Sketch) Add(v float64) {
+	s.addN(v, 1)
+}
+
+func (s *Sketch) addN(v float64, n uint64) {
+	if n == 0 || math.IsNaN(v) {
+		return
+	}
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count += n
+	switch {
+	case v > sketchMinValue:
+		s.positive[sketchIndex(v)] += n
+		collapse(s.positive)
+	case v < -sketchMinValue:
+		s.negative[sketchIndex(-v)] += n
+		collapse(s.negative)
+	default:
+		s.zero += n
+	}
+}
+
+// Merge adds another sketch's counts
+func (s *Sketch) Merge(o *Sketch) {
+	if o == nil || o.count == 0 {
+		return
+	}
+	if s.count == 0 || o.min < s.min {
+		s.min = o.min
+	}
+	if s.count == 0 || o.max > s.max {
+		s.max = o.max
+	}
+	s.count += o.count
+	s.zero += o.zero
+	for i, n := range o.positive {
+		s.positive[i] += n
+	}
+	for i, n := range o.negative {
+		s.negative[i] += n
+	}
+	collapse(s.positive)
+	collapse(s.negative)
+}
+
+// collapse merges the lowest bins until at most sketchMaxBins are left,
+// giving up accuracy for the smallest magnitudes only
+func collapse(bins map[int]uint64) {
+	if len(bins) <= sketchMaxBins {
+		return
+	}
+	indexes := sortedBins(bins)
+	keep := indexes[len(indexes)-sketchMaxBins]
+	for _, i := range indexes[:len(indexes)-sketchMaxBins] {
+		bins[keep] += bins[i]
+		delete(bins, i)
+	}
+}
+
+func sortedBins(bins map[int]uint64) []int {
+	indexes := make([]int, 0, len(bins))
+	for i := range bins {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// Count returns the number of values counted
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1) of the values counted.
+// It is NaN for an empty sketch.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+
+	return math.Max(s.min, math.Min(s.max, s.valueAt(uint64(q*float64(s.count-1)))))
+}
+
+// valueAt returns the value of the bin holding the rank-th smallest value
+func (s *Sketch) valueAt(rank uint64) float64 {
+	seen := uint64(0)
+	negatives := sortedBins(s.negative)
+	for j := len(negatives) - 1; j >= 0; j-- { // Most negative first
+		if seen += s.negative[negatives[j]]; seen > rank {
+			return -sketchValue(negatives[j])
+		}
+	}
+	if seen += s.zero; seen > rank {
+		return 0
+	}
+	for _, i := range sortedBins(s.positive) {
+		if seen += s.positive[i]; seen > rank {
+			return sketchValue(i)
+		}
+	}
+	return s.max
+}
+
+// sketchVersion is the first byte of an encoded sketch
+const sketchVersion = 1
+
+// Encode returns the sketch in a compact binary form (see DecodeSketch)
+func (s *Sketch) Encode() []byte {
+	buf := []byte{sketchVersion}
+	buf = binary.AppendUvarint(buf, s.zero)
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.min))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.max))
+	for _, bins := range []map[int]uint64{s.positive, s.negative} {
+		// Indexes are delta-encoded: neighbouring bins take a byte each
+		buf = binary.AppendUvarint(buf, uint64(len(bins)))
+		prev := 0
+		for _, i := range sortedBins(bins) {
+			buf = binary.AppendVarint(buf, int64(i-prev))
+			buf = binary.AppendUvarint(buf, bins[i])
+			prev = i
+		}
+	}
+	return buf
+}
+
+// DecodeSketch parses a sketch written by Encode
+func DecodeSketch(buf []byte) (*Sketch, error) {
+	errMalformed := errors.New("malformed sketch")
+	if len(buf) == 0 || buf[0] != sketchVersion {
+		return nil, errMalformed
+	}
+	buf = buf[1:]
+
+	uvarint := func() (uint64, bool) {
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return 0, false
+		}
+		buf = buf[n:]
+		return v, true
+	}
+	float := func() (float64, bool) {
+		if len(buf) < 8 {
+			return 0, false
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(buf))
+		buf = buf[8:]
+		return v, true
+	}
+
+	s := NewSketch()
+	var ok bool
+	if s.zero, ok = uvarint(); !ok {
+		return nil, errMalformed
+	}
+	if s.min, ok = float(); !ok {
+		return nil, errMalformed
+	}
+	if s.max, ok = float(); !ok {
+		return nil, errMalformed
+	}
+	s.count = s.zero
+	for _, bins := range []map[int]uint64{s.positive, s.negative} {
+		n, ok := uvarint()
+		if !ok || n > sketchMaxBins {
+			return nil, errMalformed
+		}
+		prev := 0
+		for j := uint64(0); j < n; j++ {
+			delta, size := binary.Varint(buf)
+			if size <= 0 {
+				return nil, errMalformed
+			}
+			buf = buf[size:]
+			count, ok := uvarint()
+			if !ok {
+				return nil, errMalformed
+			}
+			prev += int(delta)
+			bins[prev] += count
+			s.count += count
+		}
+	}
+	if len(buf) != 0 {
+		return nil, errMalformed
+	}
+	return s, nil
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// sketchValues returns n deterministic values spread over several orders
+// of magnitude, including zero and negatives
+func sketchValues(n int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = math.Exp(float64(i%97)/10) - 2 // -1 to ~15,000
+	}
+	values[0] = 0
+	return values
+}
+
+func TestSketch_Quantile(t *testing.T) {
+	values := sketchValues(5000)
+	s := NewSketch()
+	for _, v := range values {
+		s.Add(v)
+	}
+
+	if s.Count() != uint64(len(values)) {
+		t.Fatalf("Count() = %d, want %d", s.Count(), len(values))
+	}
+	for _, q := range []float64{0, 0.01, 0.25, 0.5, 0.9, 0.99, 1} {
+		// The sketch returns a value at the nearest rank below, without
+		// interpolating
+		want := Percentile(values, math.Floor(q*float64(len(values)-1))/float64(len(values)-1))
+		got := s.Quantile(q)
+		if math.Abs(got-want) > SketchAccuracy*math.Abs(want)+1e-9 {
+			t.Errorf("Quantile(%v) = %v, want %v within %v%%", q, got, want, SketchAccuracy*100)
+		}
+	}
+	if !math.IsNaN(NewSketch().Quantile(0.5)) {
+		t.Error("Quantile of an empty sketch should be NaN")
+	}
+}
+
+func TestSketch_MergeAndEncode(t *testing.T) {
+	values := sketchValues(1000)
+	whole, first, second := NewSketch(), NewSketch(), NewSketch()
+	for i, v := range values {
+		whole.Add(v)
+		if i < 400 {
+			first.Add(v)
+		} else {
+			second.Add(v)
+		}
+	}
+
+	decoded, err := DecodeSketch(first.Encode())
+	if err != nil {
+		t.Fatalf("DecodeSketch failed: %v", err)
+	}
+	decoded.Merge(second)
+
+	if !bytes.Equal(decoded.Encode(), whole.Encode()) {
+		t.Error("Merged sketch differs from the sketch of all values")
+	}
+	for _, q := range []float64{0, 0.5, 0.99, 1} {
+		if got, want := decoded.Quantile(q), whole.Quantile(q); got != want {
+			t.Errorf("Quantile(%v) = %v after merge, want %v", q, got, want)
+		}
+	}
+}
+
+func TestDecodeSketch_Malformed(t *testing.T) {
+	valid := NewSketch()
+	valid.Add(1)
+	encoded := valid.Encode()
+
+	for _, b := range [][]byte{nil, {0}, {sketchVersion}, encoded[:len(encoded)-2], append(encoded, 0)} {
+		if _, err := DecodeSketch(b); err == nil {
+			t.Errorf("DecodeSketch(%v) succeeded, want error", b)
+		}
+	}
+}
+
+func TestAggregate_Percentile(t *testing.T) {
+	gauge := &Aggregate{Resolution: Resolution5m}
+	for i := 1; i <= 100; i++ {
+		gauge.Add(float64(i))
+	}
+
+	// Survives being stored and merged into a coarser aggregate
+	parsed := ParseAggregate(gauge.ToMetric())
+	if parsed == nil || parsed.Sketch == nil {
+		t.Fatalf("Expected a parsed aggregate with a sketch, got %+v", parsed)
+	}
+	hour := &Aggregate{Resolution: Resolution1h}
+	hour.Merge(parsed)
+
+	if got := hour.Percentile(0.9); math.Abs(got-90) > 90*SketchAccuracy {
+		t.Errorf("Percentile(0.9) = %v, want ~90", got)
+	}
+
+	// Aggregates compacted before sketches existed count as their average
+	old := &Aggregate{Resolution: Resolution5m, Sum: 500, Count: 2, Min: 200, Max: 300}
+	if !math.IsNaN(old.Percentile(0.5)) {
+		t.Errorf("Percentile without a sketch = %v, want NaN", old.Percentile(0.5))
+	}
+	hour.Merge(old)
+	if got := hour.Percentile(1); got != 250 {
+		t.Errorf("Percentile(1) after merging an old aggregate = %v, want its average 250", got)
+	}
+}
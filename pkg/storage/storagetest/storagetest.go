@@ -0,0 +1,678 @@
+// Package storagetest is the conformance suite every storage.Storage
+// implementation must pass. Backends run it from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//	    storagetest.Run(t, func(t *testing.T) storage.Storage {
+//	        return memory.New()
+//	    })
+//	}
+//
+// Samples are keyed by series (name and labels) and timestamp. Writing a
+// sample whose series and timestamp are already stored replaces it: the
+// last write wins, whether the writes are sequential or concurrent, and
+// the store holds one sample. Backends that keep every write (an
+// append-only log) don't conform.
+//
+// Optional capabilities (storage.PolicyWriter, storage.SeriesDeleter) are
+// tested when the backend implements them.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// Factory opens an empty store for one test. Run closes it.
+type Factory func(t *testing.T) storage.Storage
+
+// Run runs the conformance suite, each test against a fresh store
+func Run(t *testing.T, open Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store storage.Storage)
+	}{
+		{"WriteAndQuery", testWriteAndQuery},
+		{"QueryTimeRange", testQueryTimeRange},
+		{"QueryMetricNames", testQueryMetricNames},
+		{"QueryLabels", testQueryLabels},
+		{"QueryLimit", testQueryLimit},
+		{"QueryDownsampled", testQueryDownsampled},
+		{"ScanSeries", testScanSeries},
+		{"Aggregates", testAggregates},
+		{"Overwrite", testOverwrite},
+		{"DeleteBefore", testDeleteBefore},
+		{"DeleteResolution", testDeleteResolution},
+		{"DeleteMatchers", testDeleteMatchers},
+		{"Stats", testStats},
+		{"ResolutionStats", testResolutionStats},
+		{"CancelledContext", testCancelledContext},
+		{"ConcurrentWrites", testConcurrentWrites},
+		{"PolicyWriter", testPolicyWriter},
+		{"SeriesDeleter", testSeriesDeleter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := open(t)
+			defer store.Close()
+			tt.fn(t, store)
+		})
+	}
+}
+
+// base is a recent timestamp, so backends with wall-clock retention keep the data
+func base() time.Time {
+	return time.Now().Add(-30 * time.Minute).Truncate(time.Second)
+}
+
+func write(t *testing.T, store storage.Storage, batch ...metrics.Metric) {
+	t.Helper()
+	if err := store.Write(context.Background(), batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+}
+
+// query runs a query and sorts the results by series, then time
+func query(t *testing.T, store storage.Storage, req storage.QueryRequest) []metrics.Metric {
+	t.Helper()
+	results, err := store.Query(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		ki, kj := seriesKey(results[i]), seriesKey(results[j])
+		if ki != kj {
+			return ki < kj
+		}
+		return results[i].Timestamp.Before(results[j].Timestamp)
+	})
+	return results
+}
+
+// around queries everything within an hour of base
+func around(b time.Time) storage.QueryRequest {
+	return storage.QueryRequest{Start: b.Add(-time.Hour), End: b.Add(time.Hour)}
+}
+
+func seriesKey(m metrics.Metric) string {
+	keys := make([]string, 0, len(m.Labels))
+	for k := range m.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	key := m.Name
+	for _, k := range keys {
+		key += "," + k + "=" + m.Labels[k]
+	}
+	return key
+}
+
+func stats(t *testing.T, store storage.Storage) *storage.Stats {
+	t.Helper()
+	s, err := store.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	return s
+}
+
+func testWriteAndQuery(t *testing.T, store storage.Storage) {
+	b := base()
+	write(t, store,
+		metrics.Metric{Name: "cpu", Type: metrics.GaugeType, Value: 75.5, Labels: map[string]string{"host": "a"}, Timestamp: b},
+		metrics.Metric{Name: "cpu", Type: metrics.GaugeType, Value: 82.1, Labels: map[string]string{"host": "b"}, Timestamp: b},
+		metrics.Metric{Name: "requests", Type: metrics.CounterType, Value: 10, Timestamp: b.Add(time.Second)},
+	)
+
+	results := query(t, store, around(b))
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 samples, got %d: %+v", len(results), results)
+	}
+	got := results[0]
+	if got.Name != "cpu" || got.Value != 75.5 || got.Labels["host"] != "a" || got.Type != metrics.GaugeType || !got.Timestamp.Equal(b) {
+		t.Errorf("Sample didn't round-trip: %+v", got)
+	}
+	if got := results[2]; got.Name != "requests" || got.Type != metrics.CounterType || len(got.Labels) != 0 {
+		t.Errorf("Unlabelled sample didn't round-trip: %+v", got)
+	}
+}
+
+func testQueryTimeRange(t *testing.T, store storage.Storage) {
+	b := base()
+	for i := -1; i <= 2; i++ {
+		write(t, store, metrics.Metric{Name: "cpu", Value: float64(i), Timestamp: b.Add(time.Duration(i) * time.Minute)})
+	}
+
+	// Both ends are inclusive
+	results := query(t, store, storage.QueryRequest{Start: b, End: b.Add(time.Minute)})
+	if len(results) != 2 || results[0].Value != 0 || results[1].Value != 1 {
+		t.Errorf("Expected samples 0 and 1, got %+v", results)
+	}
+}
+
+func testQueryMetricNames(t *testing.T, store storage.Storage) {
+	b := base()
+	write(t, store,
+		metrics.Metric{Name: "cpu", Value: 1, Timestamp: b},
+		metrics.Metric{Name: "mem", Value: 2, Timestamp: b},
+		metrics.Metric{Name: "disk", Value: 3, Timestamp: b},
+	)
+
+	req := around(b)
+	req.MetricNames = []string{"cpu", "disk"}
+	results := query(t, store, req)
+	if len(results) != 2 || results[0].Name != "cpu" || results[1].Name != "disk" {
+		t.Errorf("Expected cpu and disk, got %+v", results)
+	}
+
+	req.MetricNames = []string{"missing"}
+	if results := query(t, store, req); len(results) != 0 {
+		t.Errorf("Expected no samples for an unknown name, got %+v", results)
+	}
+}
+
+func testQueryLabels(t *testing.T, store storage.Storage) {
+	b := base()
+	write(t, store,
+		metrics.Metric{Name: "http", Value: 1, Labels: map[string]string{"path": "/api", "code": "200"}, Timestamp: b},
+		metrics.Metric{Name: "http", Value: 2, Labels: map[string]string{"path": "/api", "code": "500"}, Timestamp: b},
+		metrics.Metric{Name: "http", Value: 3, Labels: map[string]string{"path": "/home", "code": "200"}, Timestamp: b},
+		metrics.Metric{Name: "http", Value: 4, Timestamp: b},
+	)
+
+	req := around(b)
+	req.Labels = map[string]string{"path": "/api", "code": "200"}
+	if results := query(t, store, req); len(results) != 1 || results[0].Value != 1 {
+		t.Errorf("Expected only path=/api code=200, got %+v", results)
+	}
+
+	req.Labels = map[string]string{"code": "200"}
+	if results := query(t, store, req); len(results) != 2 {
+		t.Errorf("Expected 2 samples with code=200, got %+v", results)
+	}
+}
+
+func testQueryLimit(t *testing.T, store storage.Storage) {
+	b := base()
+	for i := 0; i < 10; i++ {
+		write(t, store, metrics.Metric{Name: "cpu", Value: float64(i), Timestamp: b.Add(time.Duration(i) * time.Second)})
+	}
+
+	req := around(b)
+	req.Limit = 3
+	if results := query(t, store, req); len(results) != 3 {
+		t.Errorf("Expected 3 samples with limit 3, got %d", len(results))
+	}
+}
+
+func testQueryDownsampled(t *testing.T, store storage.Storage) {
+	b := base()
+	for i := 0; i < 10; i++ {
+		ts := b.Add(time.Duration(i) * 10 * time.Second)
+		write(t, store,
+			metrics.Metric{Name: "cpu", Value: float64(i), Labels: map[string]string{"host": "a"}, Timestamp: ts},
+			metrics.Metric{Name: "cpu", Value: float64(10 * i), Labels: map[string]string{"host": "b"}, Timestamp: ts},
+		)
+	}
+
+	// One minute steps from b: samples 0-5 and 6-9 of each series
+	req := storage.QueryRequest{Start: b, End: b.Add(time.Hour), Labels: map[string]string{"host": "a"}, Step: time.Minute}
+	want := map[storage.Aggregation][2]float64{
+		storage.AggregateAvg:  {2.5, 7.5},
+		storage.AggregateMin:  {0, 6},
+		storage.AggregateMax:  {5, 9},
+		storage.AggregateSum:  {15, 30},
+		storage.AggregateLast: {5, 9},
+	}
+	for agg, values := range want {
+		req.Aggregation = agg
+		results, err := storage.QueryDownsampled(context.Background(), store, req)
+		if err != nil {
+			t.Fatalf("QueryDownsampled(%s) failed: %v", agg, err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("%s: expected 2 steps, got %+v", agg, results)
+		}
+		for i, m := range results {
+			if m.Value != values[i] || !m.Timestamp.Equal(b.Add(time.Duration(i)*time.Minute)) || m.Labels["host"] != "a" {
+				t.Errorf("%s step %d: expected %v at %v, got %+v", agg, i, values[i], b.Add(time.Duration(i)*time.Minute), m)
+			}
+		}
+	}
+
+	// Series stay apart, the limit counts steps, and no step means raw samples
+	req = storage.QueryRequest{Start: b, End: b.Add(time.Hour), Step: time.Hour}
+	results, err := storage.QueryDownsampled(context.Background(), store, req)
+	if err != nil {
+		t.Fatalf("QueryDownsampled failed: %v", err)
+	}
+	if len(results) != 2 || results[0].Value == results[1].Value {
+		t.Errorf("Expected one step per series, got %+v", results)
+	}
+	req.Limit = 1
+	if results, _ := storage.QueryDownsampled(context.Background(), store, req); len(results) != 1 {
+		t.Errorf("Expected 1 step with limit 1, got %+v", results)
+	}
+	req.Step, req.Limit = 0, 0
+	if results, _ := storage.QueryDownsampled(context.Background(), store, req); len(results) != 20 {
+		t.Errorf("Expected 20 raw samples without a step, got %d", len(results))
+	}
+}
+
+func testOverwrite(t *testing.T, store storage.Storage) {
+	b := base()
+	labels := map[string]string{"host": "a"}
+	write(t, store, metrics.Metric{Name: "cpu", Value: 1, Labels: labels, Timestamp: b})
+	write(t, store, metrics.Metric{Name: "cpu", Value: 2, Labels: map[string]string{"host": "a"}, Timestamp: b})
+
+	// Same series and timestamp: the last write wins
+	results := query(t, store, around(b))
+	if len(results) != 1 || results[0].Value != 2 {
+		t.Fatalf("Expected one overwritten sample with value 2, got %+v", results)
+	}
+	if s := stats(t, store); s.TotalMetrics != 1 || s.TotalSeries != 1 {
+		t.Errorf("Expected 1 sample in 1 series, got %d in %d", s.TotalMetrics, s.TotalSeries)
+	}
+
+	// Same timestamp, different labels: a separate series
+	write(t, store, metrics.Metric{Name: "cpu", Value: 3, Labels: map[string]string{"host": "b"}, Timestamp: b})
+	if results := query(t, store, around(b)); len(results) != 2 {
+		t.Errorf("Expected 2 series, got %+v", results)
+	}
+
+	// Concurrent writes of one series and timestamp: one of them wins
+	const writers = 8
+	at := b.Add(time.Minute)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			store.Write(context.Background(), []metrics.Metric{{Name: "mem", Value: float64(w), Timestamp: at}})
+		}(w)
+	}
+	wg.Wait()
+	results = query(t, store, storage.QueryRequest{MetricNames: []string{"mem"}, Start: at, End: at})
+	if len(results) != 1 || results[0].Value < 0 || results[0].Value >= writers {
+		t.Errorf("Expected one of the concurrent writes to win, got %+v", results)
+	}
+}
+
+func testDeleteBefore(t *testing.T, store storage.Storage) {
+	b := base()
+	for i := 0; i < 5; i++ {
+		write(t, store, metrics.Metric{Name: "cpu", Value: float64(i), Timestamp: b.Add(time.Duration(i) * time.Minute)})
+	}
+
+	// Before is exclusive
+	if err := store.Delete(context.Background(), storage.DeleteOptions{Before: b.Add(2 * time.Minute)}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	results := query(t, store, around(b))
+	if len(results) != 3 || results[0].Value != 2 {
+		t.Errorf("Expected samples 2-4 to remain, got %+v", results)
+	}
+	if s := stats(t, store); s.TotalMetrics != 3 {
+		t.Errorf("Expected stats to drop to 3 samples, got %d", s.TotalMetrics)
+	}
+
+	// Counted deletes report what they removed
+	deleted, err := storage.DeleteCounted(context.Background(), store, storage.DeleteOptions{Before: b.Add(4 * time.Minute)})
+	if err != nil {
+		t.Fatalf("DeleteCounted failed: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("DeleteCounted removed %d samples, want 2", deleted)
+	}
+}
+
+func testAggregates(t *testing.T, store storage.Storage) {
+	b := base()
+	labels := map[string]string{"_team": "infra", "__resolution__": "5m"}
+	stats := []*metrics.AggregateStats{
+		{Sum: 0.1 + 0.2, Count: 2, Min: 0.1, Max: 0.2, Sketch: []byte{1, 2, 3}},
+		{Sum: 1e-7, Count: 1, Min: 1e-7, Max: 1e-7},
+	}
+	write(t, store,
+		metrics.Metric{Name: "cpu", Type: metrics.GaugeType, Value: 0.15, Labels: labels, Timestamp: b, Aggregate: stats[0]},
+		metrics.Metric{Name: "cpu", Type: metrics.GaugeType, Value: 1e-7, Labels: labels, Timestamp: b.Add(5 * time.Minute), Aggregate: stats[1]},
+		metrics.Metric{Name: "cpu", Type: metrics.GaugeType, Value: 1, Labels: map[string]string{"_team": "infra"}, Timestamp: b},
+	)
+
+	// Statistics come back at full precision, and the aggregates of a
+	// series are one series with the user's labels intact
+	results := query(t, store, storage.QueryRequest{Start: b.Add(-time.Hour), End: b.Add(time.Hour), Labels: map[string]string{"__resolution__": "5m"}})
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 aggregates, got %+v", results)
+	}
+	for i, m := range results {
+		if seriesKey(m) != "cpu,__resolution__=5m,_team=infra" {
+			t.Errorf("Aggregate labels = %v, want them as written", m.Labels)
+		}
+		if !reflect.DeepEqual(m.Aggregate, stats[i]) {
+			t.Errorf("Aggregate statistics = %+v, want %+v", m.Aggregate, stats[i])
+		}
+	}
+
+	results = query(t, store, storage.QueryRequest{Start: b.Add(-time.Hour), End: b.Add(time.Hour), Labels: map[string]string{"_team": "infra"}})
+	for _, m := range results {
+		if m.Labels["__resolution__"] == "" && m.Aggregate != nil {
+			t.Errorf("Raw sample came back with statistics %+v", m.Aggregate)
+		}
+	}
+}
+
+func testDeleteResolution(t *testing.T, store storage.Storage) {
+	b := base()
+	write(t, store,
+		metrics.Metric{Name: "cpu", Value: 1, Timestamp: b},
+		metrics.Metric{Name: "cpu", Value: 2, Labels: map[string]string{"__resolution__": "5m"}, Timestamp: b},
+		metrics.Metric{Name: "cpu", Value: 3, Labels: map[string]string{"__resolution__": "1h"}, Timestamp: b},
+	)
+
+	raw := storage.ResolutionRaw
+	if err := store.Delete(context.Background(), storage.DeleteOptions{Before: b.Add(time.Minute), Resolution: &raw}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	results := query(t, store, around(b))
+	if len(results) != 2 {
+		t.Fatalf("Expected only aggregates to remain, got %+v", results)
+	}
+	for _, m := range results {
+		if m.Labels["__resolution__"] == "" {
+			t.Errorf("Raw sample survived: %+v", m)
+		}
+	}
+}
+
+func testDeleteMatchers(t *testing.T, store storage.Storage) {
+	b := base()
+	for i := 0; i < 4; i++ {
+		ts := b.Add(time.Duration(i) * time.Minute)
+		write(t, store,
+			metrics.Metric{Name: "http", Value: float64(i), Labels: map[string]string{"job": "api"}, Timestamp: ts},
+			metrics.Metric{Name: "http", Value: float64(i), Labels: map[string]string{"job": "batch"}, Timestamp: ts},
+			metrics.Metric{Name: "cpu", Value: float64(i), Labels: map[string]string{"job": "batch"}, Timestamp: ts},
+		)
+	}
+
+	// Only http{job="batch"} in [b+1m, b+3m)
+	if err := store.Delete(context.Background(), storage.DeleteOptions{
+		From:   b.Add(time.Minute),
+		Before: b.Add(3 * time.Minute),
+		Matchers: []storage.Matcher{
+			{Type: storage.MatchEqual, Name: storage.MetricNameLabel, Value: "http"},
+			{Type: storage.MatchEqual, Name: "job", Value: "batch"},
+		},
+	}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if results := query(t, store, around(b)); len(results) != 10 {
+		t.Errorf("Expected 10 samples after deleting 2, got %d", len(results))
+	}
+
+	// Exclude protects matching series from an otherwise broad delete
+	if err := store.Delete(context.Background(), storage.DeleteOptions{
+		Before:  b.Add(time.Hour),
+		Exclude: [][]storage.Matcher{{{Type: storage.MatchEqual, Name: "job", Value: "api"}}},
+	}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	results := query(t, store, around(b))
+	if len(results) != 4 {
+		t.Fatalf("Expected the 4 excluded samples to remain, got %+v", results)
+	}
+	for _, m := range results {
+		if m.Labels["job"] != "api" {
+			t.Errorf("Unexpected sample after delete: %+v", m)
+		}
+	}
+}
+
+func testScanSeries(t *testing.T, store storage.Storage) {
+	b := base()
+	// Written newest first and interleaved across series
+	for i := 9; i >= 0; i-- {
+		ts := b.Add(time.Duration(i) * time.Minute)
+		write(t, store,
+			metrics.Metric{Name: "cpu", Value: float64(i), Labels: map[string]string{"host": "a"}, Timestamp: ts},
+			metrics.Metric{Name: "mem", Value: float64(i), Timestamp: ts},
+			metrics.Metric{Name: "cpu", Value: float64(i), Labels: map[string]string{"host": "b"}, Timestamp: ts},
+		)
+	}
+	write(t, store, metrics.Metric{Name: "disk", Value: 1, Timestamp: b.Add(-2 * time.Hour)})
+
+	// Each series arrives once, whole and in time order
+	req := storage.QueryRequest{Start: b, End: b.Add(time.Hour)}
+	seen := make(map[string]int)
+	err := storage.ScanSeries(context.Background(), store, req, func(series []metrics.Metric) error {
+		key := seriesKey(series[0])
+		for i, m := range series {
+			if seriesKey(m) != key {
+				t.Errorf("Series %s mixed with %s", key, seriesKey(m))
+			}
+			if i > 0 && !m.Timestamp.After(series[i-1].Timestamp) {
+				t.Errorf("Series %s out of time order at %d", key, i)
+			}
+		}
+		seen[key] += len(series)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ScanSeries failed: %v", err)
+	}
+	want := map[string]int{"cpu,host=a": 10, "cpu,host=b": 10, "mem": 10}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("Scanned %v, want %v", seen, want)
+	}
+
+	// An error from fn stops the scan
+	stop := fmt.Errorf("stop")
+	calls := 0
+	err = storage.ScanSeries(context.Background(), store, req, func([]metrics.Metric) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("ScanSeries = %v after %d calls, want the callback's error after 1", err, calls)
+	}
+}
+
+func testStats(t *testing.T, store storage.Storage) {
+	if s := stats(t, store); s.TotalMetrics != 0 || s.TotalSeries != 0 {
+		t.Errorf("Expected an empty store, got %+v", s)
+	}
+
+	b := base()
+	write(t, store,
+		metrics.Metric{Name: "cpu", Value: 1, Labels: map[string]string{"host": "a"}, Timestamp: b},
+		metrics.Metric{Name: "cpu", Value: 2, Labels: map[string]string{"host": "a"}, Timestamp: b.Add(time.Minute)},
+		metrics.Metric{Name: "cpu", Value: 3, Labels: map[string]string{"host": "b"}, Timestamp: b.Add(2 * time.Minute)},
+		metrics.Metric{Name: "mem", Value: 4, Timestamp: b.Add(-time.Minute)},
+	)
+
+	s := stats(t, store)
+	if s.TotalMetrics != 4 || s.TotalSeries != 3 {
+		t.Errorf("Expected 4 samples in 3 series, got %d in %d", s.TotalMetrics, s.TotalSeries)
+	}
+	if s.Metrics["cpu"].Samples != 3 || s.Metrics["mem"].Samples != 1 {
+		t.Errorf("Unexpected metric counts: %v", s.Metrics)
+	}
+	cpu := storage.MetricStats{Series: 2, Samples: 3, Oldest: b, Newest: b.Add(2 * time.Minute)}
+	mem := storage.MetricStats{Series: 1, Samples: 1, Oldest: b.Add(-time.Minute), Newest: b.Add(-time.Minute)}
+	for name, want := range map[string]storage.MetricStats{"cpu": cpu, "mem": mem} {
+		got := s.Metrics[name]
+		if got.Series != want.Series || got.Samples != want.Samples || !got.Oldest.Equal(want.Oldest) || !got.Newest.Equal(want.Newest) {
+			t.Errorf("Unexpected stats for %s: got %+v, want %+v", name, got, want)
+		}
+	}
+	if !s.OldestMetric.Equal(b.Add(-time.Minute)) || !s.NewestMetric.Equal(b.Add(2*time.Minute)) {
+		t.Errorf("Unexpected time bounds: %v - %v", s.OldestMetric, s.NewestMetric)
+	}
+}
+
+func testResolutionStats(t *testing.T, store storage.Storage) {
+	b := base()
+	agg := map[string]string{"host": "a", "__resolution__": "5m"}
+	write(t, store,
+		metrics.Metric{Name: "cpu", Value: 1, Labels: map[string]string{"host": "a"}, Timestamp: b},
+		metrics.Metric{Name: "cpu", Value: 2, Labels: map[string]string{"host": "a"}, Timestamp: b.Add(time.Minute)},
+		metrics.Metric{Name: "mem", Value: 3, Timestamp: b.Add(2 * time.Minute)},
+		metrics.Metric{Name: "cpu", Value: 4, Labels: agg, Timestamp: b.Add(-time.Hour), Aggregate: &metrics.AggregateStats{Sum: 4, Count: 1, Min: 4, Max: 4}},
+		metrics.Metric{Name: "cpu", Value: 5, Labels: agg, Timestamp: b.Add(-55 * time.Minute), Aggregate: &metrics.AggregateStats{Sum: 5, Count: 1, Min: 5, Max: 5}},
+	)
+
+	check := func(res storage.Resolution, want storage.MetricStats) {
+		t.Helper()
+		got := stats(t, store).Resolutions[res]
+		if got.Series != want.Series || got.Samples != want.Samples || !got.Oldest.Equal(want.Oldest) || !got.Newest.Equal(want.Newest) {
+			t.Errorf("Unexpected stats for resolution %q: got %+v, want %+v", res, got, want)
+		}
+	}
+	check(storage.ResolutionRaw, storage.MetricStats{Series: 2, Samples: 3, Oldest: b, Newest: b.Add(2 * time.Minute)})
+	check(storage.Resolution5m, storage.MetricStats{Series: 1, Samples: 2, Oldest: b.Add(-time.Hour), Newest: b.Add(-55 * time.Minute)})
+
+	// Deleting the oldest raw sample moves only the raw tier's bounds
+	raw := storage.ResolutionRaw
+	if err := store.Delete(context.Background(), storage.DeleteOptions{Before: b.Add(time.Second), Resolution: &raw}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	check(storage.ResolutionRaw, storage.MetricStats{Series: 2, Samples: 2, Oldest: b.Add(time.Minute), Newest: b.Add(2 * time.Minute)})
+	check(storage.Resolution5m, storage.MetricStats{Series: 1, Samples: 2, Oldest: b.Add(-time.Hour), Newest: b.Add(-55 * time.Minute)})
+}
+
+func testCancelledContext(t *testing.T, store storage.Storage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	b := base()
+	if err := store.Write(ctx, []metrics.Metric{{Name: "cpu", Value: 1, Timestamp: b}}); err == nil {
+		t.Error("Expected Write to fail with a cancelled context")
+	}
+	if _, err := store.Query(ctx, around(b)); err == nil {
+		t.Error("Expected Query to fail with a cancelled context")
+	}
+	if err := store.Delete(ctx, storage.DeleteOptions{Before: b}); err == nil {
+		t.Error("Expected Delete to fail with a cancelled context")
+	}
+	if _, err := store.Stats(ctx); err == nil {
+		t.Error("Expected Stats to fail with a cancelled context")
+	}
+}
+
+func testConcurrentWrites(t *testing.T, store storage.Storage) {
+	const writers, perWriter = 8, 50
+	b := base()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			batch := make([]metrics.Metric, perWriter)
+			for i := range batch {
+				batch[i] = metrics.Metric{
+					Name:      "concurrent",
+					Value:     float64(i),
+					Labels:    map[string]string{"writer": fmt.Sprint(w)},
+					Timestamp: b.Add(time.Duration(i) * time.Second),
+				}
+			}
+			errs <- store.Write(context.Background(), batch)
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Concurrent write failed: %v", err)
+		}
+	}
+
+	if results := query(t, store, around(b)); len(results) != writers*perWriter {
+		t.Errorf("Expected %d samples, got %d", writers*perWriter, len(results))
+	}
+	if s := stats(t, store); s.TotalMetrics != writers*perWriter || s.TotalSeries != writers {
+		t.Errorf("Expected %d samples in %d series, got %d in %d", writers*perWriter, writers, s.TotalMetrics, s.TotalSeries)
+	}
+}
+
+func testPolicyWriter(t *testing.T, store storage.Storage) {
+	pw, ok := store.(storage.PolicyWriter)
+	if !ok {
+		t.Skip("backend doesn't implement storage.PolicyWriter")
+	}
+	ctx := context.Background()
+	b := base()
+
+	result, err := pw.WriteWithPolicy(ctx, []metrics.Metric{
+		{Name: "cpu", Value: 1, Timestamp: b},
+		{Name: "cpu", Value: 2, Timestamp: b.Add(time.Second)},
+	}, storage.FirstWriteWins)
+	if err != nil {
+		t.Fatalf("WriteWithPolicy failed: %v", err)
+	}
+	if result.Added != 2 {
+		t.Errorf("Expected 2 added, got %+v", result)
+	}
+
+	result, _ = pw.WriteWithPolicy(ctx, []metrics.Metric{{Name: "cpu", Value: 10, Timestamp: b}}, storage.FirstWriteWins)
+	if result.Dropped != 1 {
+		t.Errorf("Expected the duplicate dropped, got %+v", result)
+	}
+	result, _ = pw.WriteWithPolicy(ctx, []metrics.Metric{{Name: "cpu", Value: 20, Timestamp: b.Add(time.Second)}}, storage.LastWriteWins)
+	if result.Overwritten != 1 {
+		t.Errorf("Expected the duplicate overwritten, got %+v", result)
+	}
+
+	results := query(t, store, around(b))
+	if len(results) != 2 || results[0].Value != 1 || results[1].Value != 20 {
+		t.Errorf("Unexpected samples after policy writes: %+v", results)
+	}
+	if s := stats(t, store); s.TotalMetrics != 2 {
+		t.Errorf("Expected duplicates not to be counted, got %d samples", s.TotalMetrics)
+	}
+}
+
+func testSeriesDeleter(t *testing.T, store storage.Storage) {
+	sd, ok := store.(storage.SeriesDeleter)
+	if !ok {
+		t.Skip("backend doesn't implement storage.SeriesDeleter")
+	}
+	ctx := context.Background()
+	b := base()
+	write(t, store,
+		metrics.Metric{Name: "cpu", Value: 1, Labels: map[string]string{"pii": "yes"}, Timestamp: b},
+		metrics.Metric{Name: "cpu", Value: 2, Labels: map[string]string{"pii": "no"}, Timestamp: b},
+	)
+
+	matchers := []storage.Matcher{{Type: storage.MatchEqual, Name: "pii", Value: "yes"}}
+	if _, err := sd.DeleteSeries(ctx, nil, b, b.Add(time.Minute)); err == nil {
+		t.Error("Expected DeleteSeries without matchers to fail")
+	}
+	if _, err := sd.DeleteSeries(ctx, matchers, b, b.Add(time.Minute)); err != nil {
+		t.Fatalf("DeleteSeries failed: %v", err)
+	}
+
+	// Hidden immediately, and still gone once tombstones are purged
+	for _, phase := range []string{"before clean", "after clean"} {
+		results := query(t, store, around(b))
+		if len(results) != 1 || results[0].Labels["pii"] != "no" {
+			t.Errorf("%s: expected only pii=no, got %+v", phase, results)
+		}
+		if _, err := sd.CleanTombstones(ctx); err != nil {
+			t.Fatalf("CleanTombstones failed: %v", err)
+		}
+	}
+	if tombstones := sd.Tombstones(); len(tombstones) != 0 {
+		t.Errorf("Expected no tombstones after clean, got %+v", tombstones)
+	}
+}
@@ -0,0 +1,82 @@
+package tenant
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nicktill/tinyobs/pkg/httpx"
+)
+
+// ParseAPIKeys parses "key=tenant" pairs separated by commas
+// (e.g. "k1=team-a,k2=team-b,k3=default")
+func ParseAPIKeys(s string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, id, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid API key entry %q (expected key=tenant)", pair)
+		}
+		if err := Validate(id); err != nil {
+			return nil, err
+		}
+		keys[key] = id
+	}
+	return keys, nil
+}
+
+// Middleware resolves each request's tenant and stores it in the request context.
+//
+// With API keys configured, every request needs a known key as a Bearer
+// token and belongs to the key's tenant; an X-Scope-OrgID header naming a
+// different tenant is rejected. Without keys, the header picks the tenant
+// (Default if absent), which suits trusted networks and auth proxies.
+func Middleware(keys map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get(Header)
+
+			var id string
+			if len(keys) > 0 {
+				token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+				id = keys[token]
+				if !ok || id == "" {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+					httpx.RespondErrorString(w, http.StatusUnauthorized, "missing or invalid API key")
+					return
+				}
+				if header != "" && header != id {
+					httpx.RespondErrorString(w, http.StatusForbidden, fmt.Sprintf("API key does not belong to tenant %q", header))
+					return
+				}
+			} else {
+				id = header
+				if id == "" {
+					id = Default
+				}
+				if err := Validate(id); err != nil {
+					httpx.RespondError(w, http.StatusBadRequest, err)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithID(r.Context(), id)))
+		})
+	}
+}
+
+// AdminOnly restricts a handler to the Default tenant. Admin endpoints
+// work on the whole store, across tenants.
+func AdminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if id := FromContext(r.Context()); id != Default {
+			httpx.RespondErrorString(w, http.StatusForbidden, fmt.Sprintf("tenant %q cannot use admin endpoints", id))
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,81 @@
+package tenant
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(FromContext(r.Context())))
+	})
+
+	tests := []struct {
+		name   string
+		keys   map[string]string
+		auth   string
+		header string
+		code   int
+		tenant string
+	}{
+		{name: "no keys, no header", code: http.StatusOK, tenant: Default},
+		{name: "no keys, header", header: "team-a", code: http.StatusOK, tenant: "team-a"},
+		{name: "no keys, invalid header", header: "team a", code: http.StatusBadRequest},
+		{name: "key", keys: map[string]string{"k1": "team-a"}, auth: "Bearer k1", code: http.StatusOK, tenant: "team-a"},
+		{name: "key, matching header", keys: map[string]string{"k1": "team-a"}, auth: "Bearer k1", header: "team-a", code: http.StatusOK, tenant: "team-a"},
+		{name: "key, other tenant", keys: map[string]string{"k1": "team-a"}, auth: "Bearer k1", header: "team-b", code: http.StatusForbidden},
+		{name: "unknown key", keys: map[string]string{"k1": "team-a"}, auth: "Bearer k2", code: http.StatusUnauthorized},
+		{name: "missing key", keys: map[string]string{"k1": "team-a"}, header: "team-a", code: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/query", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			if tt.header != "" {
+				req.Header.Set(Header, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			Middleware(tt.keys)(echo).ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("Expected status %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			if tt.code == http.StatusOK && rr.Body.String() != tt.tenant {
+				t.Errorf("Expected tenant %q, got %q", tt.tenant, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminOnly(t *testing.T) {
+	handler := Middleware(nil)(AdminOnly(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/snapshot", nil)
+	req.Header.Set(Header, "team-a")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a tenant, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/admin/snapshot", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for the default tenant, got %d", rr.Code)
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := ParseAPIKeys("k1=team-a, k2=default,")
+	if err != nil || len(keys) != 2 || keys["k1"] != "team-a" || keys["k2"] != Default {
+		t.Errorf("Unexpected keys %v (err %v)", keys, err)
+	}
+	for _, bad := range []string{"k1", "=team-a", "k1=team a"} {
+		if _, err := ParseAPIKeys(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
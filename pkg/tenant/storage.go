@@ -0,0 +1,262 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+)
+
+// Storage is a tenant-scoped view of a store. Metric names are prefixed
+// with the tenant ID on write and stripped on read, so each tenant's series
+// live under their own key prefix and tenants never see each other's data.
+// The Default tenant reads and writes unprefixed names.
+//
+// Background jobs (compaction, retention, self-metrics) use the underlying
+// store and see every tenant's series under their prefixed names.
+type Storage struct {
+	base  storage.Storage
+	fixed string // Tenant for every call; "" = from the context
+}
+
+// NewStorage returns a view that scopes each call to the tenant in its context
+func NewStorage(base storage.Storage) *Storage {
+	return &Storage{base: base}
+}
+
+// Scope returns a view pinned to one tenant, whatever the context says
+func Scope(base storage.Storage, id string) *Storage {
+	return &Storage{base: base, fixed: id}
+}
+
+// Base returns the underlying store
+func (s *Storage) Base() storage.Storage {
+	return s.base
+}
+
+func (s *Storage) tenant(ctx context.Context) string {
+	if s.fixed != "" {
+		return s.fixed
+	}
+	return FromContext(ctx)
+}
+
+// Write stores metrics under the tenant's prefix
+func (s *Storage) Write(ctx context.Context, batch []metrics.Metric) error {
+	scoped, err := scopeMetrics(s.tenant(ctx), batch)
+	if err != nil {
+		return err
+	}
+	return s.base.Write(ctx, scoped)
+}
+
+// WriteWithPolicy stores metrics under the tenant's prefix, resolving
+// duplicates with the policy if the underlying store supports it
+func (s *Storage) WriteWithPolicy(ctx context.Context, batch []metrics.Metric, policy storage.DuplicatePolicy) (storage.WriteResult, error) {
+	scoped, err := scopeMetrics(s.tenant(ctx), batch)
+	if err != nil {
+		return storage.WriteResult{}, err
+	}
+	if writer, ok := s.base.(storage.PolicyWriter); ok {
+		return writer.WriteWithPolicy(ctx, scoped, policy)
+	}
+	// Backend can't detect duplicates: every sample counts as added
+	if err := s.base.Write(ctx, scoped); err != nil {
+		return storage.WriteResult{}, err
+	}
+	return storage.WriteResult{Added: len(scoped)}, nil
+}
+
+// Query returns the tenant's metrics matching the request
+func (s *Storage) Query(ctx context.Context, req storage.QueryRequest) ([]metrics.Metric, error) {
+	id := s.tenant(ctx)
+	scopedReq, filter := scopeQuery(id, req)
+	results, err := s.base.Query(ctx, scopedReq)
+	if err != nil {
+		return nil, err
+	}
+	results = unscopeMetrics(id, results, filter)
+	if filter && req.Limit > 0 && len(results) > req.Limit {
+		results = results[:req.Limit]
+	}
+	return results, nil
+}
+
+// QueryDownsampled is Query with the request's step and aggregation applied
+func (s *Storage) QueryDownsampled(ctx context.Context, req storage.QueryRequest) ([]metrics.Metric, error) {
+	id := s.tenant(ctx)
+	scopedReq, filter := scopeQuery(id, req)
+	results, err := storage.QueryDownsampled(ctx, s.base, scopedReq)
+	if err != nil {
+		return nil, err
+	}
+	results = unscopeMetrics(id, results, filter)
+	if req.Limit > 0 && len(results) > req.Limit {
+		results = results[:req.Limit]
+	}
+	return results, nil
+}
+
+// scopeQuery prefixes the request's metric names. Without names the
+// underlying store is scanned in full and filter reports that results must
+// be filtered by tenant; the limit is then applied afterwards, as other
+// tenants' samples would count against it.
+func scopeQuery(id string, req storage.QueryRequest) (storage.QueryRequest, bool) {
+	if len(req.MetricNames) == 0 {
+		req.Limit = 0
+		return req, true
+	}
+	names := make([]string, len(req.MetricNames))
+	for i, name := range req.MetricNames {
+		names[i] = MetricName(id, name)
+	}
+	req.MetricNames = names
+	return req, false
+}
+
+// Delete removes the tenant's metrics matching the deletion criteria
+func (s *Storage) Delete(ctx context.Context, opts storage.DeleteOptions) error {
+	return s.base.Delete(ctx, scopeDelete(s.tenant(ctx), opts))
+}
+
+// DeleteCounted removes the tenant's metrics matching the deletion criteria
+// and returns how many samples were removed
+func (s *Storage) DeleteCounted(ctx context.Context, opts storage.DeleteOptions) (int, error) {
+	return storage.DeleteCounted(ctx, s.base, scopeDelete(s.tenant(ctx), opts))
+}
+
+// scopeDelete restricts deletion criteria to a tenant's series
+func scopeDelete(id string, opts storage.DeleteOptions) storage.DeleteOptions {
+	opts.Matchers = scopeMatchers(id, opts.Matchers)
+	if len(opts.Exclude) > 0 {
+		exclude := make([][]storage.Matcher, len(opts.Exclude))
+		for i, set := range opts.Exclude {
+			exclude[i] = scopeMatchers(id, set)[1:] // Already restricted to the tenant
+		}
+		opts.Exclude = exclude
+	}
+	return opts
+}
+
+// scopeMatchers prepends a matcher selecting the tenant's series and
+// prefixes the metric name in __name__ matchers
+func scopeMatchers(id string, matchers []storage.Matcher) []storage.Matcher {
+	owner := storage.Matcher{Type: storage.MatchRegexp, Name: storage.MetricNameLabel, Value: regexp.QuoteMeta(id+Separator) + ".*"}
+	if id == Default {
+		owner = storage.Matcher{Type: storage.MatchNotRegexp, Name: storage.MetricNameLabel, Value: ".*" + regexp.QuoteMeta(Separator) + ".*"}
+	}
+	owner.Compile() // Both patterns are valid
+
+	scoped := append(make([]storage.Matcher, 0, len(matchers)+1), owner)
+	for _, m := range matchers {
+		if m.Name == storage.MetricNameLabel && id != Default {
+			switch m.Type {
+			case storage.MatchEqual, storage.MatchNotEqual:
+				m.Value = MetricName(id, m.Value)
+			default:
+				m.Value = regexp.QuoteMeta(id+Separator) + "(?:" + m.Value + ")"
+			}
+			if err := m.Compile(); err != nil {
+				continue // Invalid regexes never compiled in the first place
+			}
+		}
+		scoped = append(scoped, m)
+	}
+	return scoped
+}
+
+// AnyTenant rewrites matchers to select the series of every tenant: __name__
+// matchers match the metric name without its tenant prefix. Rules that
+// apply to the whole underlying store (retention) match through it.
+func AnyTenant(matchers []storage.Matcher) []storage.Matcher {
+	prefix := "(?:[^" + regexp.QuoteMeta(Separator) + "]+" + regexp.QuoteMeta(Separator) + ")?"
+	out := make([]storage.Matcher, 0, len(matchers))
+	for _, m := range matchers {
+		if m.Name == storage.MetricNameLabel {
+			unprefixed := storage.Matcher{Type: m.Type, Name: m.Name, Value: prefix + "(?:" + m.Value + ")"}
+			switch m.Type {
+			case storage.MatchEqual:
+				unprefixed = storage.Matcher{Type: storage.MatchRegexp, Name: m.Name, Value: prefix + regexp.QuoteMeta(m.Value)}
+			case storage.MatchNotEqual:
+				unprefixed = storage.Matcher{Type: storage.MatchNotRegexp, Name: m.Name, Value: prefix + regexp.QuoteMeta(m.Value)}
+			}
+			if err := unprefixed.Compile(); err == nil {
+				m = unprefixed // Invalid regexes never compiled in the first place: kept as they are
+			}
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// Close closes the underlying store
+func (s *Storage) Close() error {
+	return s.base.Close()
+}
+
+// Stats returns the tenant's share of the underlying store's statistics,
+// built from its per-metric breakdown. SizeBytes is estimated from the
+// tenant's share of samples.
+func (s *Storage) Stats(ctx context.Context) (*storage.Stats, error) {
+	id := s.tenant(ctx)
+	all, err := s.base.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &storage.Stats{
+		Metrics: make(map[string]storage.MetricStats),
+	}
+	for stored, ms := range all.Metrics {
+		owner, name := SplitMetricName(stored)
+		if owner != id {
+			continue
+		}
+		for res, tier := range ms.Resolutions {
+			stats.AddSeries(name, res, tier)
+		}
+		stats.TotalMetrics += ms.Samples
+		stats.TotalSeries += ms.Series
+		if !ms.Oldest.IsZero() && (stats.OldestMetric.IsZero() || ms.Oldest.Before(stats.OldestMetric)) {
+			stats.OldestMetric = ms.Oldest
+		}
+		if ms.Newest.After(stats.NewestMetric) {
+			stats.NewestMetric = ms.Newest
+		}
+	}
+	if all.TotalMetrics > 0 {
+		stats.SizeBytes = uint64(float64(all.SizeBytes) * float64(stats.TotalMetrics) / float64(all.TotalMetrics))
+	}
+	return stats, nil
+}
+
+// scopeMetrics copies a batch with metric names prefixed by the tenant
+func scopeMetrics(id string, batch []metrics.Metric) ([]metrics.Metric, error) {
+	scoped := make([]metrics.Metric, len(batch))
+	for i, m := range batch {
+		if strings.Contains(m.Name, Separator) {
+			return nil, fmt.Errorf("metric name %q contains %q, which separates tenant IDs", m.Name, Separator)
+		}
+		m.Name = MetricName(id, m.Name)
+		scoped[i] = m
+	}
+	return scoped, nil
+}
+
+// unscopeMetrics strips the tenant prefix from metric names in place. With
+// filter set, metrics of other tenants are dropped.
+func unscopeMetrics(id string, results []metrics.Metric, filter bool) []metrics.Metric {
+	kept := results[:0]
+	for _, m := range results {
+		owner, name := SplitMetricName(m.Name)
+		if filter && owner != id {
+			continue
+		}
+		m.Name = name
+		kept = append(kept, m)
+	}
+	return kept
+}
@@ -0,0 +1,106 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nicktill/tinyobs/pkg/sdk/metrics"
+	"github.com/nicktill/tinyobs/pkg/storage"
+	"github.com/nicktill/tinyobs/pkg/storage/memory"
+	"github.com/nicktill/tinyobs/pkg/storage/ring"
+	"github.com/nicktill/tinyobs/pkg/storage/storagetest"
+)
+
+// newRing opens a ring store: in memory, and supports duplicate policies
+func newRing(t *testing.T) storage.Storage {
+	t.Helper()
+	store, err := ring.New(ring.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	return store
+}
+
+func TestConformance(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		storagetest.Run(t, func(t *testing.T) storage.Storage {
+			return Scope(newRing(t), Default)
+		})
+	})
+	t.Run("Tenant", func(t *testing.T) {
+		storagetest.Run(t, func(t *testing.T) storage.Storage {
+			base := newRing(t)
+			// Another tenant's data must stay invisible to every test
+			other := metrics.Metric{Name: "cpu", Value: 99, Labels: map[string]string{"host": "a"}, Timestamp: time.Now().Add(-30 * time.Minute)}
+			if err := Scope(base, "other").Write(context.Background(), []metrics.Metric{other}); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			return Scope(base, "team-a")
+		})
+	})
+}
+
+func TestStorage_Isolation(t *testing.T) {
+	base := memory.New()
+	store := NewStorage(base)
+	now := time.Now()
+	ctxA := WithID(context.Background(), "team-a")
+	ctxB := WithID(context.Background(), "team-b")
+
+	write := func(ctx context.Context, value float64) {
+		t.Helper()
+		if err := store.Write(ctx, []metrics.Metric{{Name: "cpu", Value: value, Timestamp: now}}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	write(ctxA, 1)
+	write(ctxB, 2)
+	write(context.Background(), 3) // Default tenant
+
+	// Same metric name, separate series under separate key prefixes
+	raw, _ := base.Query(context.Background(), storage.QueryRequest{Start: now.Add(-time.Minute), End: now})
+	names := map[string]bool{}
+	for _, m := range raw {
+		names[m.Name] = true
+	}
+	if len(names) != 3 || !names["team-a/cpu"] || !names["team-b/cpu"] || !names["cpu"] {
+		t.Fatalf("Expected prefixed names in storage, got %v", names)
+	}
+
+	for ctx, want := range map[context.Context]float64{ctxA: 1, ctxB: 2, context.Background(): 3} {
+		for _, req := range []storage.QueryRequest{
+			{Start: now.Add(-time.Minute), End: now, MetricNames: []string{"cpu"}},
+			{Start: now.Add(-time.Minute), End: now},
+		} {
+			results, err := store.Query(ctx, req)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if len(results) != 1 || results[0].Value != want || results[0].Name != "cpu" {
+				t.Errorf("Tenant %s: expected only its own cpu=%v, got %+v", FromContext(ctx), want, results)
+			}
+		}
+		stats, _ := store.Stats(ctx)
+		if stats.TotalMetrics != 1 || stats.TotalSeries != 1 || stats.Metrics["cpu"].Samples != 1 {
+			t.Errorf("Tenant %s: expected 1 sample in 1 series, got %+v", FromContext(ctx), stats)
+		}
+	}
+
+	// Deleting in one tenant leaves the others alone
+	err := store.Delete(ctxA, storage.DeleteOptions{
+		Before:   now.Add(time.Second),
+		Matchers: []storage.Matcher{{Type: storage.MatchRegexp, Name: storage.MetricNameLabel, Value: "c.*"}},
+	})
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if stats, _ := base.Stats(context.Background()); stats.TotalMetrics != 2 || stats.Metrics["team-a/cpu"].Samples != 0 {
+		t.Errorf("Expected only team-a's sample deleted, got %v", stats.Metrics)
+	}
+
+	// The separator can't be smuggled in through a metric name
+	if err := store.Write(context.Background(), []metrics.Metric{{Name: "team-b/cpu", Timestamp: now}}); err == nil {
+		t.Error("Expected a metric name containing the separator to be rejected")
+	}
+}
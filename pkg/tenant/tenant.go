@@ -0,0 +1,72 @@
+// Package tenant isolates teams sharing one TinyObs server. Each request
+// carries a tenant ID, derived from its API key or the X-Scope-OrgID
+// header, and a tenant-scoped view of storage keeps every tenant's series
+// under its own key prefix.
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Default owns requests without a tenant, and all data written before
+	// tenancy was enabled. Its series are stored unprefixed.
+	Default = "default"
+
+	// Header selects the tenant when no API keys are configured (same header as Cortex/Mimir)
+	Header = "X-Scope-OrgID"
+
+	// Separator joins tenant ID and metric name in storage ("team-a/http_requests_total").
+	// Metric names can't contain it.
+	Separator = "/"
+
+	maxIDLength = 64
+)
+
+type contextKey struct{}
+
+// WithID returns a context carrying the tenant ID
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request's tenant ID, or Default if there is none
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKey{}).(string); ok && id != "" {
+		return id
+	}
+	return Default
+}
+
+// Validate checks a tenant ID: 1-64 letters, digits, '-', '_' or '.'
+func Validate(id string) error {
+	if id == "" || len(id) > maxIDLength {
+		return fmt.Errorf("tenant ID must be 1-%d characters", maxIDLength)
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return fmt.Errorf("invalid tenant ID %q: only letters, digits, '-', '_' and '.' are allowed", id)
+		}
+	}
+	return nil
+}
+
+// MetricName returns the name a tenant's metric is stored under
+func MetricName(id, name string) string {
+	if id == Default {
+		return name
+	}
+	return id + Separator + name
+}
+
+// SplitMetricName splits a stored metric name into tenant ID and metric name
+func SplitMetricName(stored string) (id, name string) {
+	if id, name, ok := strings.Cut(stored, Separator); ok {
+		return id, name
+	}
+	return Default, stored
+}
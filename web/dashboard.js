@@ -1,4 +1,5 @@
         let metricsData = [];
+        let metricMetadata = {}; // name -> {type, unit, help} from /api/v1/metadata
         let selectedMetrics = new Set();
         let currentRange = '24h';
         let charts = {};
@@ -236,6 +237,26 @@
             return value.toFixed(2);
         }
 
+        // Formats a value in a metric's unit (from its metadata)
+        function formatUnit(value, unit) {
+            if (typeof value !== 'number') return value;
+            switch (unit) {
+                case 'bytes':
+                    return value < 0 ? '-' + formatBytes(-value) : formatBytes(value);
+                case 'seconds':
+                    if (Math.abs(value) < 0.001) return (value * 1000000).toFixed(0) + 'µs';
+                    if (Math.abs(value) < 1) return (value * 1000).toFixed(1) + 'ms';
+                    return value.toFixed(2) + 's';
+                case 'ratio':
+                    return (value * 100).toFixed(1) + '%';
+                case '':
+                case undefined:
+                    return formatValue(value);
+                default:
+                    return formatValue(value) + ' ' + unit;
+            }
+        }
+
         async function loadStats() {
             try {
                 const [statsRes, storageRes] = await Promise.all([
@@ -1075,12 +1096,24 @@
                 const start = new Date(now - hours * 60 * 60 * 1000).toISOString();
                 const end = new Date(now).toISOString();
 
-                const response = await fetch(`/v1/query?start=${start}&end=${end}`);
+                const [response, metadataRes] = await Promise.all([
+                    fetch(`/v1/query?start=${start}&end=${end}`),
+                    fetch('/api/v1/metadata').catch(() => null)
+                ]);
                 if (!response.ok) throw new Error('Query failed');
 
                 const data = await response.json();
                 metricsData = data.metrics || [];
 
+                // Metadata is optional: without it types are guessed from names
+                metricMetadata = {};
+                if (metadataRes && metadataRes.ok) {
+                    const metadata = await metadataRes.json();
+                    Object.entries(metadata.data || {}).forEach(([name, entries]) => {
+                        if (entries.length > 0) metricMetadata[name] = entries[0];
+                    });
+                }
+
                 renderMetricsBrowser();
             } catch (error) {
                 console.error('Load metrics error:', error);
@@ -1117,7 +1150,7 @@
             // Filter
             const filtered = Object.entries(grouped).filter(([key, data]) => {
                 const matchesSearch = data.name.toLowerCase().includes(searchTerm);
-                const matchesType = typeFilter === 'all' || inferMetricType(data.name) === typeFilter;
+                const matchesType = typeFilter === 'all' || metricType(data.name) === typeFilter;
                 const service = data.labels?.service || data.labels?.__service || 'default';
                 const matchesService = serviceFilter === 'all' || service === serviceFilter;
                 return matchesSearch && matchesType && matchesService;
@@ -1130,6 +1163,8 @@
 
             browser.innerHTML = filtered.map(([key, data]) => {
                 const latest = data.values[data.values.length - 1];
+                const metadata = lookupMetadata(data.name);
+                const helpHtml = metadata?.help ? `<div class="metric-help">${escapeHtml(metadata.help)}</div>` : '';
                 const isSelected = selectedMetrics.has(key);
                 const escapedKey = key.replace(/"/g, '&quot;');
 
@@ -1144,9 +1179,11 @@
                     <div class="metric-row ${isSelected ? 'selected' : ''}" data-key="${escapedKey}" onclick="toggleMetric(this, '${escapedKey}')">
                         <div class="metric-content">
                             <div class="metric-name">${data.name}</div>
+                            ${helpHtml}
                             <div class="metric-labels">${labelsHtml}</div>
                             <div class="metric-value">
-                                <div><span class="value-label">Latest:</span>${formatValue(latest.value)}</div>
+                                <div><span class="value-label">Latest:</span>${formatUnit(latest.value, metadata?.unit)}</div>
+                                <div><span class="value-label">Type:</span>${metricType(data.name)}</div>
                                 <div><span class="value-label">Samples:</span>${data.values.length}</div>
                             </div>
                         </div>
@@ -1155,11 +1192,44 @@
             }).join('');
         }
 
+        // Help text comes from clients: never render it as HTML
+        function escapeHtml(text) {
+            return String(text).replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;').replace(/"/g, '&quot;');
+        }
+
         function seriesKey(metric) {
             const labels = metric.labels ? JSON.stringify(metric.labels) : '';
             return `${metric.name}${labels}`;
         }
 
+        // Metadata of a metric; histogram series (_bucket, _sum, _count)
+        // share their histogram's
+        function lookupMetadata(name) {
+            if (metricMetadata[name]) return metricMetadata[name];
+            const base = name.replace(/_(bucket|sum|count)$/, '');
+            const metadata = metricMetadata[base];
+            return metadata?.type === 'histogram' ? metadata : null;
+        }
+
+        function metricType(name) {
+            return lookupMetadata(name)?.type || inferMetricType(name);
+        }
+
+        // Per-second rate of a counter's points, skipping over resets
+        // (a drop means the counter restarted from zero)
+        function counterRate(points) {
+            const rates = [];
+            for (let i = 1; i < points.length; i++) {
+                const dt = (new Date(points[i].t) - new Date(points[i - 1].t)) / 1000;
+                if (dt <= 0) continue;
+                const prev = points[i - 1].v;
+                const cur = points[i].v;
+                const increase = cur >= prev ? cur - prev : cur;
+                rates.push({ x: points[i].t, y: increase / dt });
+            }
+            return rates;
+        }
+
         function inferMetricType(name) {
             if (name.includes('_total') || name.includes('_count')) return 'counter';
             if (name.includes('_bucket') || name.includes('_duration')) return 'histogram';
@@ -1227,13 +1297,17 @@
                         if (!selectedMetrics.has(key)) return;
 
                         const color = getStableColor(series.metric, series.labels);
+                        // Counters only ever grow: plot how fast instead
+                        const isCounter = metricType(series.metric) === 'counter';
+                        const name = isCounter ? `rate(${series.metric})` : series.metric;
                         const label = series.labels && Object.keys(series.labels).length > 0
-                            ? `${series.metric}{${Object.entries(series.labels).filter(([k]) => !k.startsWith('__')).map(([k, v]) => `${k}="${v}"`).join(', ')}}`
-                            : series.metric;
+                            ? `${name}{${Object.entries(series.labels).filter(([k]) => !k.startsWith('__')).map(([k, v]) => `${k}="${v}"`).join(', ')}}`
+                            : name;
 
                         datasets.push({
                             label: label,
-                            data: series.points.map(p => ({ x: p.t, y: p.v })),
+                            data: isCounter ? counterRate(series.points) : series.points.map(p => ({ x: p.t, y: p.v })),
+                            unit: lookupMetadata(series.metric)?.unit,
                             borderColor: color,
                             backgroundColor: color + '20',
                             borderWidth: 2,
@@ -1272,7 +1346,14 @@
                             },
                             y: {
                                 grid: { color: '#30363d' },
-                                ticks: { color: '#8b949e' }
+                                ticks: {
+                                    color: '#8b949e',
+                                    // Format in the unit when all plotted metrics share one
+                                    callback: value => {
+                                        const units = new Set(datasets.map(d => d.unit));
+                                        return units.size === 1 ? formatUnit(value, datasets[0].unit) : value;
+                                    }
+                                }
                             }
                         }
                     }